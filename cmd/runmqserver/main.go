@@ -36,6 +36,11 @@ func doMain() error {
 	var infoFlag = flag.Bool("info", false, "Display debug info, then exit")
 	var noLogRuntimeFlag = flag.Bool("nologruntime", false, "used when running this program from another program, to control log output")
 	var devFlag = flag.Bool("dev", false, "used when running this program from runmqdevserver to control how TLS is configured")
+	var listMetricsFlag = flag.Bool("list-metrics", false, "List the metrics available from the running queue manager, then exit")
+	var checkMetricsFlag = flag.Bool("check-metrics", false, "Check the metrics configuration and display the effective settings, then exit")
+	var checkMetricKeysFlag = flag.Bool("check-metric-keys", false, "Check that the metrics available from the running queue manager have unique keys and names, then exit")
+	var dumpMetricsFlag = flag.Bool("dump-metrics", false, "Collect the metrics from the running queue manager once and print them, then exit")
+	var dumpMetricsWaitFlag = flag.Duration("dump-metrics-wait", metrics.DefaultDumpWait, "Time to receive published metrics before collecting them with -dump-metrics")
 	flag.Parse()
 
 	name, nameErr := name.GetQueueManagerName()
@@ -55,6 +60,38 @@ func doMain() error {
 		return nil
 	}
 
+	// Check whether they only want to check the metrics configuration, which does not need a queue manager
+	if *checkMetricsFlag {
+		return checkMetrics()
+	}
+
+	// Check whether they only want to list the available metrics
+	if *listMetricsFlag {
+		if nameErr != nil {
+			log.Error(nameErr)
+			return nameErr
+		}
+		return listMetrics(name)
+	}
+
+	// Check whether they only want to check the keys and names of the available metrics
+	if *checkMetricKeysFlag {
+		if nameErr != nil {
+			log.Error(nameErr)
+			return nameErr
+		}
+		return checkMetricKeys(name)
+	}
+
+	// Check whether they only want to collect the metrics once
+	if *dumpMetricsFlag {
+		if nameErr != nil {
+			log.Error(nameErr)
+			return nameErr
+		}
+		return dumpMetrics(name, *dumpMetricsWaitFlag)
+	}
+
 	err = verifySingleProcess()
 	if err != nil {
 		// We don't do the normal termination here as it would create a termination file.
@@ -230,6 +267,8 @@ func doMain() error {
 	enableMetrics := os.Getenv("MQ_ENABLE_METRICS")
 	if enableMetrics == "true" || enableMetrics == "1" {
 		go metrics.GatherMetrics(name, log)
+		// Reload the metrics configuration when sent a SIGHUP signal
+		signalControl <- reloadMetrics
 	} else {
 		log.Println("Metrics are disabled")
 	}
@@ -246,8 +285,15 @@ func doMain() error {
 		logTermination(err)
 		return err
 	}
-	// Wait for terminate signal
-	<-signalControl
+	// Wait for terminate signal, or for metrics gathering to give up connecting to the queue manager
+	select {
+	case <-signalControl:
+	case err = <-metrics.Failed():
+		logTermination(err)
+		// #nosec G104
+		stopQueueManager(name)
+		return err
+	}
 	return nil
 }
 
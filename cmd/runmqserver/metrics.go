@@ -0,0 +1,85 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/internal/metrics"
+)
+
+// listMetrics prints the metrics available from the running queue manager, with the current metrics configuration
+func listMetrics(qmName string) error {
+	list, err := metrics.ListMetrics(qmName, log)
+	if err != nil {
+		log.Errorf("Error listing metrics: %v", err)
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tUNIT\tDESCRIPTION")
+	for _, metric := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", metric.Name, metric.Type, metric.Unit, metric.Description)
+	}
+	return w.Flush()
+}
+
+// checkMetrics validates the metrics configuration, and prints the effective value of each setting
+func checkMetrics() error {
+	settings, err := metrics.CheckConfig(log)
+	if err != nil {
+		log.Errorf("Error checking metrics configuration: %v", err)
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE")
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s\t%s\n", setting.Name, setting.Value)
+	}
+	return w.Flush()
+}
+
+// checkMetricKeys prints each collision between the keys or names of the metrics available from the running queue
+// manager, whatever the metrics configuration, and fails if there are any
+func checkMetricKeys(qmName string) error {
+	collisions, err := metrics.CheckMetricKeys(qmName)
+	if err != nil {
+		log.Errorf("Error checking metric keys: %v", err)
+		return err
+	}
+	for _, collision := range collisions {
+		fmt.Println(collision)
+	}
+	if len(collisions) > 0 {
+		err = fmt.Errorf("Found %d metric key collisions", len(collisions))
+		log.Error(err)
+		return err
+	}
+	fmt.Println("No metric key collisions found")
+	return nil
+}
+
+// dumpMetrics collects the metrics from the running queue manager once, with the current metrics configuration, and
+// prints them in the Prometheus text format
+func dumpMetrics(qmName string, wait time.Duration) error {
+	err := metrics.DumpMetrics(qmName, os.Stdout, wait, log)
+	if err != nil {
+		log.Errorf("Error collecting metrics: %v", err)
+	}
+	return err
+}
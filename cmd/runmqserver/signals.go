@@ -20,13 +20,15 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/ibm-messaging/mq-container/internal/command"
 	"github.com/ibm-messaging/mq-container/internal/metrics"
 	"golang.org/x/sys/unix"
 )
 
 const (
-	startReaping = iota
-	reapNow      = iota
+	startReaping  = iota
+	reapNow       = iota
+	reloadMetrics = iota
 )
 
 func signalHandler(qmgr string) chan int {
@@ -35,6 +37,7 @@ func signalHandler(qmgr string) chan int {
 	// the buffer, and preventing other signals.
 	stopSignals := make(chan os.Signal)
 	reapSignals := make(chan os.Signal)
+	reloadSignals := make(chan os.Signal, 1)
 	signal.Notify(stopSignals, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
 		for {
@@ -42,6 +45,7 @@ func signalHandler(qmgr string) chan int {
 			case sig := <-stopSignals:
 				log.Printf("Signal received: %v", sig)
 				signal.Stop(reapSignals)
+				signal.Stop(reloadSignals)
 				signal.Stop(stopSignals)
 				metrics.StopMetricsGathering(log)
 				// #nosec G104
@@ -54,6 +58,15 @@ func signalHandler(qmgr string) chan int {
 			case <-reapSignals:
 				log.Debug("Received SIGCHLD signal")
 				reapZombies()
+			case <-reloadSignals:
+				log.Println("Received SIGHUP signal, reloading metrics")
+				// Reload in the background, as reconnecting to the queue manager may take some time
+				go func() {
+					err := metrics.Reload(log)
+					if err != nil {
+						log.Errorf("Metrics Error: Failed to reload metrics: %v", err)
+					}
+				}()
 			case job := <-control:
 				switch {
 				case job == startReaping:
@@ -62,6 +75,10 @@ func signalHandler(qmgr string) chan int {
 					signal.Notify(reapSignals, syscall.SIGCHLD)
 				case job == reapNow:
 					reapZombies()
+				case job == reloadMetrics:
+					// Add SIGHUP to the list of signals we're listening to
+					log.Debug("Listening for SIGHUP signals")
+					signal.Notify(reloadSignals, syscall.SIGHUP)
 				}
 			}
 		}
@@ -71,14 +88,18 @@ func signalHandler(qmgr string) chan int {
 
 // reapZombies reaps any zombie (terminated) processes now.
 // This function should be called before exiting.
+// It waits for any commands which are running to complete, as they wait for
+// their own processes, such as dspmq run by the metrics exporter.
 func reapZombies() {
-	for {
-		var ws unix.WaitStatus
-		pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
-		// If err or pid indicate "no child processes"
-		if pid == 0 || err == unix.ECHILD {
-			return
+	command.ExclusiveWait(func() {
+		for {
+			var ws unix.WaitStatus
+			pid, err := unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+			// If err or pid indicate "no child processes"
+			if pid == 0 || err == unix.ECHILD {
+				return
+			}
+			log.Debugf("Reaped PID %v", pid)
 		}
-		log.Debugf("Reaped PID %v", pid)
-	}
+	})
 }
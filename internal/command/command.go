@@ -20,13 +20,19 @@ package command
 import (
 	"fmt"
 	"os/exec"
+	"sync"
 )
 
+// waitLock is held for reading while commands run, and for writing while terminated child processes are reaped
+var waitLock sync.RWMutex
+
 // Run runs an OS command.  On Linux it waits for the command to
 // complete and returns the exit status (return code).
 // Do not use this function to run shell built-ins (like "cd"), because
 // the error handling works differently
 func Run(name string, arg ...string) (string, int, error) {
+	waitLock.RLock()
+	defer waitLock.RUnlock()
 	// Run the command and wait for completion
 	// #nosec G204
 	cmd := exec.Command(name, arg...)
@@ -37,3 +43,12 @@ func Run(name string, arg ...string) (string, int, error) {
 	}
 	return string(out), rc, nil
 }
+
+// ExclusiveWait calls f while no commands started by Run are running.  Use it to
+// reap terminated child processes, so that the exit status of a command is not
+// collected before Run waits for it, which would make Run fail with ECHILD.
+func ExclusiveWait(f func()) {
+	waitLock.Lock()
+	defer waitLock.Unlock()
+	f()
+}
@@ -18,6 +18,9 @@ package command
 import (
 	"runtime"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 var commandTests = []struct {
@@ -45,3 +48,34 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestExclusiveWait(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping tests for package which only works on Linux")
+	}
+	// Reap child processes continually while a command runs, which would otherwise
+	// collect its exit status before Run waits for it
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			ExclusiveWait(func() {
+				var ws unix.WaitStatus
+				// #nosec G104
+				unix.Wait4(-1, &ws, unix.WNOHANG, nil)
+			})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	defer close(done)
+	for i := 0; i < 5; i++ {
+		_, rc, err := Run("bash", "-c", "sleep 0.05; exit 3")
+		if rc != 3 || err == nil {
+			t.Errorf("Run - expected rc=3 with an error, got rc=%v, %v", rc, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+const (
+	commandQueue = "SYSTEM.ADMIN.COMMAND.QUEUE"
+	// adminTopic is the topic object for the $SYS/MQ topics which metrics are published on
+	adminTopic = "SYSTEM.ADMIN.TOPIC"
+)
+
+// authority is an authority needed to gather metrics, as granted by setmqaut
+type authority struct {
+	objectType string
+	objectName string
+	authority  string
+}
+
+func (a authority) String() string {
+	return a.authority + " on " + a.objectType + " " + a.objectName
+}
+
+// authorityError is returned when the user is missing authorities needed to gather metrics
+type authorityError struct {
+	qmName  string
+	missing []authority
+}
+
+func (e *authorityError) Error() string {
+	missing := make([]string, len(e.missing))
+	for i, a := range e.missing {
+		missing[i] = a.String()
+	}
+	return fmt.Sprintf("Not authorized to gather metrics from queue manager %s - missing authorities: %s", e.qmName, strings.Join(missing, ", "))
+}
+
+// authorityCheck checks one of the authorities needed to gather metrics, by making the MQ call which needs it
+type authorityCheck struct {
+	authority authority
+	check     func() error
+}
+
+// doCheckAuthorities connects to the queue manager, and makes the MQ calls used to subscribe to the published metrics,
+// to check that the user has the authorities which they need. An error listing the missing authorities is returned
+// if any calls fail with MQRC_NOT_AUTHORIZED. Other errors are left to be reported when connecting.
+func doCheckAuthorities(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error {
+
+	qMgr, err := ibmmq.Connx(qmName, newConnectOptions(connConfig))
+	if err != nil {
+		if reasonCode(err) == ibmmq.MQRC_NOT_AUTHORIZED {
+			return &authorityError{qmName: qmName, missing: []authority{{"qmgr", qmName, "+connect"}}}
+		}
+		return nil
+	}
+	// #nosec G104
+	defer qMgr.Disc()
+	if qmName == "" {
+		qmName = strings.TrimSpace(qMgr.Name)
+	}
+
+	var replyQ ibmmq.MQObject
+	checks := []authorityCheck{
+		{authority{"queue", commandQueue, "+put"}, func() error {
+			return openAndClose(&qMgr, ibmmq.MQOT_Q, commandQueue, ibmmq.MQOO_OUTPUT)
+		}},
+		{authority{"queue", cfg.modelQueue, "+get"}, func() error {
+			replyQ, err = qMgr.Open(newReplyQueueOD(cfg), ibmmq.MQOO_INPUT_AS_Q_DEF|ibmmq.MQOO_FAIL_IF_QUIESCING)
+			return err
+		}},
+		{authority{"topic", adminTopic, "+sub"}, func() error {
+			if replyQ.Name == "" {
+				// Subscribing cannot be checked without a reply queue
+				return nil
+			}
+			mqsd := ibmmq.NewMQSD()
+			mqsd.Options = ibmmq.MQSO_CREATE | ibmmq.MQSO_NON_DURABLE | ibmmq.MQSO_FAIL_IF_QUIESCING
+			mqsd.ObjectString = "$SYS/MQ/INFO/QMGR/" + qmName + "/Monitor/METADATA/CLASSES"
+			sub, err := qMgr.Sub(mqsd, &replyQ)
+			if err == nil {
+				// #nosec G104
+				sub.Close(0)
+			}
+			return err
+		}},
+		{authority{"qmgr", qmName, "+inq"}, func() error {
+			return openAndClose(&qMgr, ibmmq.MQOT_Q_MGR, "", ibmmq.MQOO_INQUIRE)
+		}},
+	}
+	err = getAuthorityError(qmName, checks)
+	if replyQ.Name != "" {
+		// #nosec G104
+		replyQ.Close(0)
+	}
+	return err
+}
+
+// getAuthorityError runs the authority checks, and returns an error listing the authorities which are missing, if any
+func getAuthorityError(qmName string, checks []authorityCheck) error {
+	var missing []authority
+	for _, check := range checks {
+		if err := check.check(); err != nil && reasonCode(err) == ibmmq.MQRC_NOT_AUTHORIZED {
+			missing = append(missing, check.authority)
+		}
+	}
+	if len(missing) > 0 {
+		return &authorityError{qmName: qmName, missing: missing}
+	}
+	return nil
+}
+
+// openAndClose opens an object with the given options, and closes it again
+func openAndClose(qMgr *ibmmq.MQQueueManager, objectType int32, objectName string, options int32) error {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = objectType
+	mqod.ObjectName = objectName
+	object, err := qMgr.Open(mqod, options|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return err
+	}
+	// #nosec G104
+	object.Close(0)
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetAuthorityError(t *testing.T) {
+
+	notAuthorized := func() error {
+		return fmt.Errorf("MQOPEN: MQCC = MQCC_FAILED [2] MQRC = MQRC_NOT_AUTHORIZED [2035]")
+	}
+	checks := []authorityCheck{
+		{authority{"queue", commandQueue, "+put"}, notAuthorized},
+		{authority{"queue", defaultModelQueue, "+get"}, func() error { return nil }},
+		// Other errors are reported when connecting
+		{authority{"topic", adminTopic, "+sub"}, func() error { return fmt.Errorf("MQSUB: MQCC = MQCC_FAILED [2] MQRC = MQRC_RESOURCE_PROBLEM [2102]") }},
+		{authority{"qmgr", "QM1", "+inq"}, notAuthorized},
+	}
+
+	err := getAuthorityError("QM1", checks)
+	expected := "Not authorized to gather metrics from queue manager QM1 - missing authorities: +put on queue SYSTEM.ADMIN.COMMAND.QUEUE, +inq on qmgr QM1"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error=%s; actual %v", expected, err)
+	}
+	if category, reason := classifyError(err); category != categoryAuthorization || reason != 2035 {
+		t.Errorf("Expected category=%s, reason=%d; actual %s, %d", categoryAuthorization, 2035, category, reason)
+	}
+
+	err = getAuthorityError("QM1", checks[1:3])
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+}
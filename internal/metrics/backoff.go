@@ -0,0 +1,62 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff calculates exponentially increasing delays between reconnect attempts, with random
+// jitter so that many containers don't all reconnect to a restarted queue manager at once
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	attempts uint
+	random   *rand.Rand
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{
+		base: base,
+		max:  max,
+		// #nosec G404 - the jitter does not need to be cryptographically secure
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the delay before the next reconnect attempt. The delay doubles with each attempt
+// up to the maximum, and is randomised to between half and all of that value.
+func (b *backoff) next() time.Duration {
+	delay := b.base
+	for i := uint(0); i < b.attempts && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	b.attempts++
+
+	half := delay / 2
+	return half + time.Duration(b.random.Int63n(int64(delay-half)+1))
+}
+
+// reset returns the delay to the base value, after a successful connect
+func (b *backoff) reset() {
+	b.attempts = 0
+}
@@ -0,0 +1,41 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+
+	b := newBackoff(10*time.Second, 60*time.Second)
+	expected := []time.Duration{10, 20, 40, 60, 60}
+
+	for i, ceiling := range expected {
+		ceiling = ceiling * time.Second
+		delay := b.next()
+		if delay < ceiling/2 || delay > ceiling {
+			t.Errorf("Expected attempt %d delay between %v and %v; actual %v", i+1, ceiling/2, ceiling, delay)
+		}
+	}
+
+	b.reset()
+	delay := b.next()
+	if delay < 5*time.Second || delay > 10*time.Second {
+		t.Errorf("Expected delay between %v and %v after reset; actual %v", 5*time.Second, 10*time.Second, delay)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	containerKeyPrefix = "CONTAINER/Limits/"
+
+	// cgroup v1 reports an unlimited amount of memory as the largest page-aligned 64-bit value,
+	// so any limit above this is treated as unlimited
+	unlimitedMemory = 1 << 62
+)
+
+// containerMetric describes a metric derived from the cgroup limits of the container
+type containerMetric struct {
+	key         string
+	name        string
+	description string
+	unit        string
+	value       func() (float64, bool)
+}
+
+// containerMetrics are the metrics available for the limits of the container, to compare with the usage
+// reported by the queue manager
+var containerMetrics = []containerMetric{
+	{"CPU limit", "container_cpu_limit_cores", "Number of CPU cores which the container is limited to", "", cpuLimit},
+	{"Memory limit", "container_memory_limit_bytes", "Amount of memory which the container is limited to", "bytes", memoryLimit},
+}
+
+// cgroupRoot is the directory where the cgroup file system is mounted, which can be replaced in tests
+var cgroupRoot = "/sys/fs/cgroup"
+
+// initialiseContainerMetrics adds the selected container metrics to the metrics map
+func initialiseContainerMetrics(metrics map[string]*metricData, cfg *metricsConfig) {
+	for _, containerMetric := range containerMetrics {
+		key := containerKeyPrefix + containerMetric.key
+		if !cfg.isSelected(key) {
+			continue
+		}
+		metrics[key] = &metricData{
+			name:        containerMetric.name,
+			description: containerMetric.description,
+			unit:        containerMetric.unit,
+		}
+	}
+}
+
+// updateContainerMetrics updates values for the container metrics from the current cgroup limits.
+// Limits which are unlimited, or cannot be read, have no value.
+func updateContainerMetrics(metrics map[string]*metricData) {
+	now := time.Now()
+	for _, containerMetric := range containerMetrics {
+		metric, ok := metrics[containerKeyPrefix+containerMetric.key]
+		if !ok {
+			continue
+		}
+		metric.values = make(map[string]float64)
+		metric.lastUpdate = now
+		if value, limited := containerMetric.value(); limited {
+			metric.values[qmgrLabelValue] = value
+		}
+	}
+}
+
+// cpuLimit returns the number of CPU cores which the container is limited to, and false if it is not limited
+func cpuLimit() (float64, bool) {
+
+	// cgroup v2 gives the quota and period in one file, with a quota of "max" if unlimited
+	fields, err := readCgroupFile("cpu.max")
+	if err == nil {
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return cpuQuota(fields[0], fields[1])
+	}
+
+	// cgroup v1 gives a quota of -1 if unlimited
+	quota, err := readCgroupFile("cpu/cpu.cfs_quota_us")
+	if err != nil || len(quota) != 1 {
+		return 0, false
+	}
+	period, err := readCgroupFile("cpu/cpu.cfs_period_us")
+	if err != nil || len(period) != 1 {
+		return 0, false
+	}
+	return cpuQuota(quota[0], period[0])
+}
+
+// cpuQuota returns the number of CPU cores given by a quota of CPU time in each period
+func cpuQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// memoryLimit returns the number of bytes of memory which the container is limited to, and false if it is not limited
+func memoryLimit() (float64, bool) {
+
+	// cgroup v2 gives a limit of "max" if unlimited, and cgroup v1 gives a very large limit
+	fields, err := readCgroupFile("memory.max")
+	if err != nil {
+		fields, err = readCgroupFile("memory/memory.limit_in_bytes")
+	}
+	if err != nil || len(fields) != 1 || fields[0] == "max" {
+		return 0, false
+	}
+	limit, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil || limit >= unlimitedMemory {
+		return 0, false
+	}
+	return float64(limit), true
+}
+
+// readCgroupFile returns the whitespace-separated fields of a file in the cgroup file system
+func readCgroupFile(name string) ([]string, error) {
+	// #nosec G304 - the file names are fixed
+	data, err := ioutil.ReadFile(filepath.Join(cgroupRoot, name))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
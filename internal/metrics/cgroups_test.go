@@ -0,0 +1,81 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerLimits(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		cpu    float64
+		memory float64
+	}{
+		{"v2", map[string]string{"cpu.max": "150000 100000\n", "memory.max": "1073741824\n"}, 1.5, 1073741824},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000\n", "memory.max": "max\n"}, 0, 0},
+		{"v1", map[string]string{"cpu/cpu.cfs_quota_us": "200000\n", "cpu/cpu.cfs_period_us": "100000\n", "memory/memory.limit_in_bytes": "536870912\n"}, 2, 536870912},
+		{"v1 unlimited", map[string]string{"cpu/cpu.cfs_quota_us": "-1\n", "cpu/cpu.cfs_period_us": "100000\n", "memory/memory.limit_in_bytes": "9223372036854771712\n"}, 0, 0},
+		{"missing", map[string]string{}, 0, 0},
+	}
+	defer func() { cgroupRoot = "/sys/fs/cgroup" }()
+
+	for _, test := range tests {
+		cgroupRoot = writeTestCgroupFiles(t, test.files)
+		defer os.RemoveAll(cgroupRoot)
+
+		metrics := make(map[string]*metricData)
+		initialiseContainerMetrics(metrics, &metricsConfig{})
+		updateContainerMetrics(metrics)
+
+		expected := map[string]float64{"CPU limit": test.cpu, "Memory limit": test.memory}
+		for key, value := range expected {
+			metric := metrics[containerKeyPrefix+key]
+			actual, ok := metric.values[qmgrLabelValue]
+			if value == 0 && ok {
+				t.Errorf("Expected no value for %s with %s limits; actual %f", key, test.name, actual)
+			} else if value != 0 && actual != value {
+				t.Errorf("Expected %s=%f with %s limits; actual %f", key, value, test.name, actual)
+			}
+			if metric.lastUpdate.IsZero() {
+				t.Errorf("Expected last update time to be set for %s", key)
+			}
+		}
+	}
+}
+
+func writeTestCgroupFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+		if err == nil {
+			err = ioutil.WriteFile(file, []byte(content), 0600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,199 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+const (
+	channelPrefix    = "channel"
+	channelLabel     = "channel"
+	connNameLabel    = "conname"
+	channelKeyPrefix = "CHANNEL/Status/"
+)
+
+// channelStatus holds the status of an instance of a channel
+type channelStatus struct {
+	name          string
+	connName      string
+	status        int64
+	inDoubt       int64
+	bytesSent     int64
+	bytesReceived int64
+}
+
+// channelMetric describes a metric derived from the status of a channel
+type channelMetric struct {
+	key         string
+	name        string
+	description string
+	unit        string
+	value       func(*channelStatus) int64
+	// cumulative metrics have values which increase until the channel instance restarts
+	cumulative bool
+}
+
+// channelMetrics are the metrics available for each channel
+var channelMetrics = []channelMetric{
+	{"Status", "status", "Status of the channel instance, or 0 if the channel is inactive", "", func(s *channelStatus) int64 { return s.status }, false},
+	{"In-doubt messages", "indoubt_messages", "Number of in-doubt messages for the channel instance", "", func(s *channelStatus) int64 { return s.inDoubt }, false},
+	{"Bytes sent", "sent_bytes", "Number of bytes sent by the channel instance since it started", "bytes", func(s *channelStatus) int64 { return s.bytesSent }, true},
+	{"Bytes received", "received_bytes", "Number of bytes received by the channel instance since it started", "bytes", func(s *channelStatus) int64 { return s.bytesReceived }, true},
+}
+
+// Function used to inquire the status of channels, which can be replaced in tests
+var inquireChannels = doInquireChannels
+
+// initialiseChannelMetrics adds the selected channel metrics to the metrics map
+func initialiseChannelMetrics(metrics map[string]*metricData, cfg *metricsConfig) {
+	for _, channelMetric := range channelMetrics {
+		key := channelKeyPrefix + channelMetric.key
+		if !cfg.isSelected(key) {
+			continue
+		}
+		metric := metricData{
+			name:         channelMetric.name,
+			description:  channelMetric.description,
+			objectType:   true,
+			objectPrefix: channelPrefix,
+			objectLabels: []string{channelLabel, connNameLabel},
+			unit:         channelMetric.unit,
+		}
+		// Cumulative values can be exported as counters, which are increased by the change in value on each update
+		if channelMetric.cumulative && cfg.counters {
+			metric.name += "_total"
+			metric.isDelta = true
+			metric.cumulative = true
+		}
+		metrics[key] = &metric
+	}
+}
+
+// updateChannelMetrics updates values for the channel metrics from the status of each channel
+func updateChannelMetrics(metrics map[string]*metricData, statuses []channelStatus) {
+	now := time.Now()
+	for _, channelMetric := range channelMetrics {
+		metric, ok := metrics[channelKeyPrefix+channelMetric.key]
+		if !ok {
+			continue
+		}
+		metric.values = make(map[string]float64)
+		metric.lastUpdate = now
+		previous := metric.previous
+		if metric.cumulative {
+			metric.previous = make(map[string]float64)
+		}
+		for i := range statuses {
+			label := statuses[i].name + labelSeparator + statuses[i].connName
+			value := float64(channelMetric.value(&statuses[i]))
+			if metric.cumulative {
+				// The counter is increased by the whole value for a new channel instance, or if the value
+				// has decreased because the channel instance or queue manager has restarted
+				metric.previous[label] = value
+				if last, ok := previous[label]; ok && value >= last {
+					value -= last
+				}
+			}
+			metric.values[label] = value
+		}
+	}
+}
+
+// clearChannelCounters clears the values of channel metrics exported as counters, so that they are not
+// added to the counters again when the status of the channels cannot be inquired
+func clearChannelCounters(metrics map[string]*metricData) {
+	for _, channelMetric := range channelMetrics {
+		if metric, ok := metrics[channelKeyPrefix+channelMetric.key]; ok && metric.isDelta {
+			metric.values = make(map[string]float64)
+		}
+	}
+}
+
+// doInquireChannels returns the status of each instance of the channels matching the configured names.
+// Channels which are not active are returned with a status of MQCHS_INACTIVE (zero), and no connection name.
+func doInquireChannels(pcfConns *pcfPool, cfg *metricsConfig) ([]channelStatus, error) {
+
+	if pcfConns == nil {
+		return nil, nil
+	}
+
+	// The channels matching each name are inquired concurrently, and returned in the order of the names
+	patterns := strings.Split(cfg.channels, ",")
+	results := make([][]channelStatus, len(patterns))
+	err := pcfConns.forEach(len(patterns), func(conn *pcfConnection, i int) error {
+		pattern := patterns[i]
+		responses, err := conn.command(ibmmq.MQCMD_INQUIRE_CHANNEL_NAMES,
+			stringParameter(ibmmq.MQCACH_CHANNEL_NAME, pattern))
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, response := range responses {
+			names = append(names, response.getStrings(ibmmq.MQCACH_CHANNEL_NAMES)...)
+		}
+
+		// Inquiring the status of channels which are not active fails with MQRCCF_CHL_STATUS_NOT_FOUND
+		responses, err = conn.command(ibmmq.MQCMD_INQUIRE_CHANNEL_STATUS,
+			stringParameter(ibmmq.MQCACH_CHANNEL_NAME, pattern),
+			integerListParameter(ibmmq.MQIACH_CHANNEL_INSTANCE_ATTRS, ibmmq.MQIACF_ALL))
+		if isNotFound(err) {
+			responses, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var statuses []channelStatus
+		active := make(map[string]bool)
+		for _, response := range responses {
+			status := channelStatus{
+				name:          response.getString(ibmmq.MQCACH_CHANNEL_NAME),
+				connName:      response.getString(ibmmq.MQCACH_CONNECTION_NAME),
+				status:        response.getInt(ibmmq.MQIACH_CHANNEL_STATUS),
+				bytesSent:     response.getInt(ibmmq.MQIACH_BYTES_SENT),
+				bytesReceived: response.getInt(ibmmq.MQIACH_BYTES_RECEIVED),
+			}
+			// The current messages of a channel are in doubt while the channel is in doubt
+			if response.getInt(ibmmq.MQIACH_INDOUBT_STATUS) == int64(ibmmq.MQCHIDS_INDOUBT) {
+				status.inDoubt = response.getInt(ibmmq.MQIACH_CURRENT_MSGS)
+			}
+			active[status.name] = true
+			statuses = append(statuses, status)
+		}
+		for _, name := range names {
+			if !active[name] {
+				statuses = append(statuses, channelStatus{name: name, status: int64(ibmmq.MQCHS_INACTIVE)})
+			}
+		}
+		results[i] = statuses
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []channelStatus
+	for _, result := range results {
+		statuses = append(statuses, result...)
+	}
+	return statuses, nil
+}
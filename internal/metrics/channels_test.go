@@ -0,0 +1,180 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInitialiseChannelMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseChannelMetrics(metrics, &metricsConfig{channels: "APP.*", exclude: []string{channelKeyPrefix + "Bytes*"}})
+
+	if len(metrics) != 2 {
+		t.Fatalf("Expected %d channel metrics; actual %d", 2, len(metrics))
+	}
+	metric, ok := metrics[channelKeyPrefix+"Status"]
+	if !ok {
+		t.Fatal("Expected channel status metric not found in map")
+	}
+	prefix, labels := getVecDetails(metric)
+	if prefix != channelPrefix || len(labels) != 3 || labels[0] != channelLabel || labels[1] != connNameLabel || labels[2] != qmgrLabel {
+		t.Errorf("Expected prefix=%s, labels=%v; actual %s, %v", channelPrefix, []string{channelLabel, connNameLabel, qmgrLabel}, prefix, labels)
+	}
+}
+
+func TestUpdateChannelMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseChannelMetrics(metrics, &metricsConfig{channels: "APP.*"})
+
+	updateChannelMetrics(metrics, []channelStatus{
+		{name: "APP.SVRCONN", connName: "10.0.0.1", status: 3, bytesSent: 1024, bytesReceived: 2048},
+		{name: "APP.SVRCONN", connName: "10.0.0.2", status: 3, bytesSent: 10},
+		{name: "APP.TO.QM2"},
+	})
+
+	status := metrics[channelKeyPrefix+"Status"]
+	if status.lastUpdate.IsZero() {
+		t.Error("Expected last update time to be set")
+	}
+	expected := map[string]float64{"APP.SVRCONN|10.0.0.1": 3, "APP.SVRCONN|10.0.0.2": 3, "APP.TO.QM2|": 0}
+	if len(status.values) != len(expected) {
+		t.Errorf("Expected values=%v; actual %v", expected, status.values)
+	}
+	for label, value := range expected {
+		if actual, ok := status.values[label]; !ok || actual != value {
+			t.Errorf("Expected value=%f for label %s; actual %f", value, label, actual)
+		}
+	}
+	if actual := metrics[channelKeyPrefix+"Bytes received"].values["APP.SVRCONN|10.0.0.1"]; actual != 2048 {
+		t.Errorf("Expected bytes received=%d; actual %f", 2048, actual)
+	}
+
+	// Channels which no longer match are removed
+	updateChannelMetrics(metrics, nil)
+	if len(status.values) != 0 {
+		t.Errorf("Expected no values; actual %v", status.values)
+	}
+}
+
+func TestGetLabelValues(t *testing.T) {
+
+	labels := map[string][]string{
+		qmgrLabelValue:         {"QM1"},
+		"APP.IN":               {"APP.IN", "QM1"},
+		"APP.SVRCONN|10.0.0.1": {"APP.SVRCONN", "10.0.0.1", "QM1"},
+		"APP.TO.QM2|":          {"APP.TO.QM2", "", "QM1"},
+		"price|gbp":            {"price|gbp", "QM1"},
+	}
+	for label, expected := range labels {
+		actual, err := getLabelValues(label, "QM1", len(expected))
+		if err != nil {
+			t.Errorf("Expected label values=%v; actual error %v", expected, err)
+			continue
+		}
+		if len(actual) != len(expected) {
+			t.Errorf("Expected label values=%v; actual %v", expected, actual)
+			continue
+		}
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Errorf("Expected label values=%v; actual %v", expected, actual)
+				break
+			}
+		}
+	}
+}
+
+func TestProcessMetrics_Channels(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	inquireChannels = func(pcfConns *pcfPool, cfg *metricsConfig) ([]channelStatus, error) {
+		return []channelStatus{{name: "APP.SVRCONN", connName: "10.0.0.1", status: 3}}, nil
+	}
+	defer func() { inquireChannels = doInquireChannels }()
+
+	cfg := getTestConfig()
+	cfg.channels = "APP.*"
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	select {
+	case <-c.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive start signal from processMetrics")
+	}
+
+	c.requestChannel <- collectRequest
+	metrics := <-c.responseChannel
+	status, ok := metrics[channelKeyPrefix+"Status"]
+	if !ok {
+		t.Fatal("Expected channel status metric not found in map")
+	}
+	if actual := status.values["APP.SVRCONN|10.0.0.1"]; actual != 3 {
+		t.Errorf("Expected channel status=%d; actual %f", 3, actual)
+	}
+}
+
+func TestUpdateChannelMetrics_Counters(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseChannelMetrics(metrics, &metricsConfig{channels: "APP.*", counters: true})
+
+	sent := metrics[channelKeyPrefix+"Bytes sent"]
+	if sent.name != "sent_bytes_total" || !sent.isDelta {
+		t.Fatalf("Expected name=%s, isDelta=%v; actual %s, %v", "sent_bytes_total", true, sent.name, sent.isDelta)
+	}
+	if status := metrics[channelKeyPrefix+"Status"]; status.name != "status" || status.isDelta {
+		t.Errorf("Expected name=%s, isDelta=%v; actual %s, %v", "status", false, status.name, status.isDelta)
+	}
+
+	updates := []struct {
+		bytesSent int64
+		expected  float64
+	}{
+		{1024, 1024},
+		{1536, 512},
+		{1536, 0},
+		// The channel instance restarted
+		{100, 100},
+	}
+	for _, update := range updates {
+		updateChannelMetrics(metrics, []channelStatus{{name: "APP.SVRCONN", connName: "10.0.0.1", status: 3, bytesSent: update.bytesSent}})
+		if actual := sent.values["APP.SVRCONN|10.0.0.1"]; actual != update.expected {
+			t.Errorf("Expected bytes sent=%f after %d; actual %f", update.expected, update.bytesSent, actual)
+		}
+	}
+
+	clearChannelCounters(metrics)
+	if len(sent.values) != 0 {
+		t.Errorf("Expected no values; actual %v", sent.values)
+	}
+	if status := metrics[channelKeyPrefix+"Status"]; len(status.values) != 1 {
+		t.Errorf("Expected status values to be kept; actual %v", status.values)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+// ConfigSetting is a metrics configuration setting, with the environment variable which sets it and its effective value
+type ConfigSetting struct {
+	Name  string
+	Value string
+}
+
+// CheckConfig validates the metrics configuration, without connecting to the queue manager, and returns the effective
+// value of each setting, sorted by name. The user and password files, and the certificates of the metrics server, are
+// read to check that they are available, but the password is not returned. A warning is logged for each metric key
+// pattern which does not match any known metric.
+func CheckConfig(log *logger.Logger) ([]ConfigSetting, error) {
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+	_, _, err = cfg.credentials()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+	_, err = cfg.serverTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+
+	keys := getAllMetricKeys()
+	for _, env := range []struct {
+		name     string
+		patterns []string
+	}{
+		{includeEnv, cfg.include},
+		{excludeEnv, cfg.exclude},
+	} {
+		for _, pattern := range env.patterns {
+			if !matchesAnyKey(pattern, keys) {
+				log.Printf("Metrics Warning: %s contains a metric key pattern which does not match any known metric: '%s'", env.name, pattern)
+			}
+		}
+	}
+	return cfg.settings(), nil
+}
+
+// getAllMetricKeys returns the keys of all the metrics known to the exporter, whatever the configuration. Queue
+// metrics which are not known to the exporter, but are published by the queue manager, are not included.
+func getAllMetricKeys() []string {
+
+	cfg := &metricsConfig{queues: "*", channels: "*", topics: []string{"#"}, subscriptions: "*", depthQueues: "*"}
+	keys := make([]string, 0)
+	for key := range generateMetricNamesMap() {
+		keys = append(keys, key)
+	}
+	for key := range initialiseKnownMetrics(cfg) {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// settings returns the effective value of each setting in the configuration, sorted by the name of its environment
+// variable. The expected metrics, description overrides and scale factors are given by the name of their file, rather
+// than its contents.
+func (cfg *metricsConfig) settings() []ConfigSetting {
+
+	labels := make([]string, 0, len(cfg.labels))
+	for name, value := range cfg.labels {
+		labels = append(labels, name+"="+value)
+	}
+	sort.Strings(labels)
+	targetNames := make([]string, len(cfg.targets))
+	for i, t := range cfg.targets {
+		targetNames[i] = t.String()
+	}
+	sizeBuckets := make([]string, len(cfg.sizeBuckets))
+	for i, bucket := range cfg.sizeBuckets {
+		sizeBuckets[i] = strconv.FormatFloat(bucket, 'f', -1, 64)
+	}
+
+	settings := []ConfigSetting{
+		{clientModeEnv, strconv.FormatBool(cfg.clientMode)},
+		{connNameEnv, cfg.connName},
+		{channelEnv, cfg.channel},
+		{targetsEnv, strings.Join(targetNames, ";")},
+		{targetIntervalEnv, formatSeconds(cfg.targetInterval)},
+		{userFileEnv, cfg.userFile},
+		{passwordFileEnv, cfg.passwordFile},
+		{keyRepositoryEnv, cfg.keyRepository},
+		{cipherEnv, cfg.cipher},
+		{peerNameEnv, cfg.peerName},
+		{certLabelEnv, cfg.certLabel},
+		{modelQueueEnv, cfg.modelQueue},
+		{dynamicPrefixEnv, cfg.dynamicPrefix},
+		{requestTimeoutEnv, formatSeconds(cfg.requestTimeout)},
+		{collectTimeoutEnv, formatSeconds(cfg.collectTimeout)},
+		{maxProcessTimeEnv, formatSeconds(cfg.maxProcessTime)},
+		{reconnectDelayEnv, formatSeconds(cfg.reconnectDelay)},
+		{reconnectMaxDelayEnv, formatSeconds(cfg.reconnectMax)},
+		{maxConnectAttemptsEnv, strconv.Itoa(cfg.maxConnects)},
+		{connectTimeoutEnv, formatSeconds(cfg.connectTimeout)},
+		{staleAfterEnv, formatSeconds(cfg.staleAfter)},
+		{lastKnownEnv, strconv.FormatBool(cfg.lastKnown)},
+		{queuesEnv, cfg.queues},
+		{channelsEnv, cfg.channels},
+		{topicsEnv, strings.Join(cfg.topics, ",")},
+		{subscriptionsEnv, cfg.subscriptions},
+		{depthQueuesEnv, cfg.depthQueues},
+		{omitEmptyAgeEnv, strconv.FormatBool(cfg.omitEmptyAge)},
+		{maxTopicsEnv, strconv.Itoa(cfg.maxTopics)},
+		{pcfConcurrencyEnv, strconv.Itoa(cfg.pcfConcurrency)},
+		{maxLabelValuesEnv, strconv.Itoa(cfg.maxLabelValues)},
+		{includeEnv, strings.Join(cfg.include, ",")},
+		{excludeEnv, strings.Join(cfg.exclude, ",")},
+		{alwaysPresentEnv, strings.Join(cfg.alwaysPresent, ",")},
+		{classesEnv, strings.Join(cfg.classes, ",")},
+		{excludeClassesEnv, strings.Join(cfg.excludeClasses, ",")},
+		{prefixEnv, cfg.prefix},
+		{labelsEnv, strings.Join(labels, ",")},
+		{podLabelsEnv, strconv.FormatBool(cfg.podLabels)},
+		{qmgrLabelNameEnv, cfg.qmgrLabelName()},
+		{qmgrLabelValueEnv, cfg.qmLabelValue},
+		{rawUnitsEnv, strconv.FormatBool(cfg.rawUnits)},
+		{nonFiniteZeroEnv, strconv.FormatBool(cfg.nonFiniteZero)},
+		{logSamplesEnv, strconv.FormatBool(cfg.logSamples)},
+		{logLevelEnv, cfg.logLevel},
+		{onDemandEnv, strconv.FormatBool(cfg.onDemand)},
+		{maxIdleIntervalEnv, formatSeconds(cfg.maxIdle)},
+		{clampIntervalEnv, strconv.FormatBool(cfg.clampInterval)},
+		{countersEnv, strconv.FormatBool(cfg.counters)},
+		{snakeCaseEnv, strconv.FormatBool(cfg.snakeCase)},
+		{expectedFileEnv, strings.TrimSpace(os.Getenv(expectedFileEnv))},
+		{descriptionsFileEnv, strings.TrimSpace(os.Getenv(descriptionsFileEnv))},
+		{scalesFileEnv, strings.TrimSpace(os.Getenv(scalesFileEnv))},
+		{significantDigitsEnv, strconv.Itoa(cfg.digits)},
+		{sizeBucketsEnv, strings.Join(sizeBuckets, ",")},
+		{drainTimeoutEnv, formatSeconds(cfg.drainTimeout)},
+		{listenAddressEnv, cfg.listenAddress},
+		{portEnv, strconv.Itoa(cfg.port)},
+		{pathEnv, cfg.path},
+		{healthPathEnv, cfg.healthPath},
+		{refreshIntervalEnv, formatSeconds(cfg.refresh)},
+		{serverCertFileEnv, cfg.serverCertFile},
+		{serverKeyFileEnv, cfg.serverKeyFile},
+		{serverCAFileEnv, cfg.serverCAFile},
+		{pushURLEnv, cfg.pushURL},
+		{pushIntervalEnv, formatSeconds(cfg.pushInterval)},
+		{pushJobEnv, cfg.pushJob},
+		{otlpEndpointEnv, cfg.otlpEndpoint},
+		{otlpIntervalEnv, formatSeconds(cfg.otlpInterval)},
+		{fileEnv, cfg.file},
+		{fileIntervalEnv, formatSeconds(cfg.fileInterval)},
+		{fileFormatEnv, cfg.fileFormat},
+		{startupJitterEnv, formatSeconds(cfg.startupJitter)},
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings
+}
+
+// formatSeconds returns a duration as a whole number of seconds, as it is given by an environment variable
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
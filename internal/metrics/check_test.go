@@ -0,0 +1,88 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestCheckConfig(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		queuesEnv:      "APP.*",
+		labelsEnv:      "team=payments,region=eu",
+		includeEnv:     "CPU/*/*,Unknown/*/*",
+		sizeBucketsEnv: "1024,1e5",
+	})
+	defer teardownTestEnv()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	settings, err := CheckConfig(log)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	values := make(map[string]string)
+	for i, setting := range settings {
+		if i > 0 && settings[i-1].Name >= setting.Name {
+			t.Errorf("Expected settings sorted by name; actual %s before %s", settings[i-1].Name, setting.Name)
+		}
+		values[setting.Name] = setting.Value
+	}
+	expected := map[string]string{
+		queuesEnv:         "APP.*",
+		labelsEnv:         "region=eu,team=payments",
+		sizeBucketsEnv:    "1024,100000",
+		requestTimeoutEnv: "10",
+		modelQueueEnv:     defaultModelQueue,
+		clientModeEnv:     "false",
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("Expected %s=%s; actual %s", name, value, values[name])
+		}
+	}
+
+	// Patterns which do not match any known metric are only warned about
+	if !strings.Contains(buf.String(), "'Unknown/*/*'") || strings.Contains(buf.String(), "'CPU/*/*'") {
+		t.Errorf("Expected warning for pattern %s only; actual %s", "Unknown/*/*", buf.String())
+	}
+}
+
+func TestCheckConfig_Invalid(t *testing.T) {
+
+	for name, value := range map[string]string{
+		labelsEnv:         "qmgr=QM1",
+		excludeEnv:        "CPU/[",
+		requestTimeoutEnv: "0",
+		userFileEnv:       "/nonexistent/user",
+	} {
+		t.Run(name, func(t *testing.T) {
+			teardownTestEnv := setupTestEnv(map[string]string{name: value})
+			defer teardownTestEnv()
+
+			_, err := CheckConfig(getTestLogger())
+			if err == nil {
+				t.Errorf("Expected error for %s=%s", name, value)
+			}
+		})
+	}
+}
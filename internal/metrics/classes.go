@@ -0,0 +1,82 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+const (
+	classActiveKey         = "CLASS/Status/Active"
+	classActiveName        = "active"
+	classActiveDescription = "Whether the metric class is subscribed to and its metrics have been updated within the staleness window (1) or not (0)"
+	classPrefix            = "class"
+	classLabel             = "class"
+)
+
+// initialiseClassMetric adds the metric reporting whether each metric class is active to the metrics map, if it is
+// selected
+func initialiseClassMetric(metrics map[string]*metricData, cfg *metricsConfig) {
+	if !cfg.isSelected(classActiveKey) {
+		return
+	}
+	metrics[classActiveKey] = &metricData{
+		name:         classActiveName,
+		description:  classActiveDescription,
+		objectType:   true,
+		objectPrefix: classPrefix,
+		objectLabels: []string{classLabel},
+	}
+}
+
+// updateClassMetric updates the value of the class metric for each selected metric class discovered on the current
+// connection. A class is active if it could be subscribed to, and the value of at least one of its metrics has been
+// updated within the staleness window, so a class which is subscribed to but receives no publications is not active.
+func updateClassMetric(metrics map[string]*metricData, cfg *metricsConfig, subscriptions map[string]int) {
+	metric, ok := metrics[classActiveKey]
+	if !ok {
+		return
+	}
+	metric.values = make(map[string]float64)
+	metric.lastUpdate = time.Now()
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		if !cfg.isClassSelected(metricClass.Name) {
+			continue
+		}
+		active := float64(0)
+		if isActiveClass(metricClass, subscriptions) && isUpdatedClass(metrics, metricClass, cfg.staleAfter) {
+			active = 1
+		}
+		metric.values[metricClass.Name] = active
+	}
+}
+
+// isUpdatedClass returns true if the value of any metric of the class has been updated within the staleness window
+func isUpdatedClass(metrics map[string]*metricData, metricClass *mqmetric.MonClass, staleAfter time.Duration) bool {
+	for _, metricType := range metricClass.Types {
+		for _, metricElement := range metricType.Elements {
+			metric, ok := metrics[makeKey(metricElement)]
+			if ok && !metric.lastUpdate.IsZero() && time.Since(metric.lastUpdate) <= staleAfter {
+				return true
+			}
+		}
+	}
+	return false
+}
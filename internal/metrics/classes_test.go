@@ -0,0 +1,88 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+func TestInitialiseClassMetric(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseClassMetric(metrics, &metricsConfig{})
+	metric, ok := metrics[classActiveKey]
+	if !ok {
+		t.Fatalf("Expected metric %s to be added", classActiveKey)
+	}
+	if name := getFullName(namespace, metric); name != "ibmmq_class_active" {
+		t.Errorf("Expected name=%s; actual %s", "ibmmq_class_active", name)
+	}
+	if _, labels := getVecDetails(metric); len(labels) != 2 || labels[0] != classLabel {
+		t.Errorf("Expected labels=%v; actual %v", []string{classLabel, qmgrLabel}, labels)
+	}
+
+	metrics = make(map[string]*metricData)
+	initialiseClassMetric(metrics, &metricsConfig{exclude: []string{classActiveKey}})
+	if _, ok := metrics[classActiveKey]; ok {
+		t.Errorf("Expected metric %s not to be added when it is excluded", classActiveKey)
+	}
+}
+
+func TestUpdateClassMetric(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := getTestConfig()
+	cfg.staleAfter = time.Minute
+	metrics, _ := initialiseMetrics(getTestLogger(), cfg)
+	mqmetric.Metrics.Classes[1] = &mqmetric.MonClass{Name: "STATMQI"}
+
+	// The class is not active until it is subscribed to, and its metrics are updated
+	subscriptions := make(map[string]int)
+	updateClassMetric(metrics, cfg, subscriptions)
+	if values := metrics[classActiveKey].values; len(values) != 2 || values[testClassName] != 0 || values["STATMQI"] != 0 {
+		t.Errorf("Expected both classes to be inactive; actual %v", values)
+	}
+	subscriptions[testTopic1] = 1
+	updateClassMetric(metrics, cfg, subscriptions)
+	if value := metrics[classActiveKey].values[testClassName]; value != 0 {
+		t.Errorf("Expected the class not to be active before its metrics are updated; actual %v", value)
+	}
+	updateMetrics(metrics)
+	updateClassMetric(metrics, cfg, subscriptions)
+	if values := metrics[classActiveKey].values; values[testClassName] != 1 || values["STATMQI"] != 0 {
+		t.Errorf("Expected only %s to be active; actual %v", testClassName, values)
+	}
+
+	// A class whose metrics have not been updated within the staleness window is not active
+	metrics[testKey1].lastUpdate = time.Now().Add(-2 * time.Minute)
+	updateClassMetric(metrics, cfg, subscriptions)
+	if value := metrics[classActiveKey].values[testClassName]; value != 0 {
+		t.Errorf("Expected the class not to be active once its metrics are stale; actual %v", value)
+	}
+
+	// Classes which are not selected are left out
+	cfg.excludeClasses = []string{"STATMQI"}
+	updateClassMetric(metrics, cfg, subscriptions)
+	if _, ok := metrics[classActiveKey].values["STATMQI"]; ok {
+		t.Errorf("Expected class %s not to be reported when it is not selected", "STATMQI")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+const (
+	commandServerKey         = "QMGR/Info/Command server"
+	commandServerName        = "command_server_running"
+	commandServerDescription = "Whether the command server of the queue manager is running (1) or not (0), which is needed for the metrics gathered using PCF commands"
+)
+
+// commandServerState is whether the command server is known to be running
+type commandServerState int
+
+const (
+	commandServerUnknown commandServerState = iota
+	commandServerStopped
+	commandServerRunning
+)
+
+// Functions used to inquire whether the command server is running, which can be replaced in tests
+var (
+	openCommandQueue     = doOpenCommandQueue
+	inquireCommandServer = doInquireCommandServer
+)
+
+// commandQueueInquiry is a connection to the queue manager with the command queue opened for inquiry
+type commandQueueInquiry struct {
+	qMgr ibmmq.MQQueueManager
+	cmdQ ibmmq.MQObject
+}
+
+// doOpenCommandQueue connects to the queue manager, and opens the command queue for inquiry
+func doOpenCommandQueue(qmName string, connConfig *mqmetric.ConnectionConfig) (*commandQueueInquiry, error) {
+
+	qMgr, err := ibmmq.Connx(qmName, newConnectOptions(connConfig))
+	if err != nil {
+		return nil, err
+	}
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = commandQueue
+	cmdQ, err := qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		// #nosec G104
+		qMgr.Disc()
+		return nil, err
+	}
+	return &commandQueueInquiry{qMgr: qMgr, cmdQ: cmdQ}, nil
+}
+
+// close closes the command queue and disconnects from the queue manager
+func (q *commandQueueInquiry) close() {
+	// #nosec G104
+	q.cmdQ.Close(0)
+	// #nosec G104
+	q.qMgr.Disc()
+}
+
+// doInquireCommandServer returns whether the command server is running, from the number of handles open for input on
+// the command queue, as the command server keeps it open while it is running. The state is unknown if the command
+// queue cannot be inquired, for example without +inq authority, when it was not opened.
+func doInquireCommandServer(cmdQInquiry *commandQueueInquiry) commandServerState {
+	if cmdQInquiry == nil {
+		return commandServerUnknown
+	}
+	values, _, err := cmdQInquiry.cmdQ.Inq([]int32{ibmmq.MQIA_OPEN_INPUT_COUNT}, 1, 0)
+	if err != nil || len(values) == 0 {
+		return commandServerUnknown
+	}
+	if values[0] > 0 {
+		return commandServerRunning
+	}
+	return commandServerStopped
+}
+
+// checkCommandServer inquires whether the command server is running, and logs when it stops or starts again, as the
+// metrics gathered using PCF commands are not updated while it is stopped
+func (c *Collector) checkCommandServer() {
+	state := inquireCommandServer(c.conn.cmdQInquiry)
+	if state == commandServerStopped && c.commandServer != commandServerStopped && c.cfg.usesPCF() {
+		c.log.Errorf("Metrics Error: The command server of queue manager %s is not running, so the queue depth, channel, topic and subscription metrics cannot be inquired - start it with 'strmqcsv %s'", c.qmName, c.qmName)
+	} else if state == commandServerRunning && c.commandServer == commandServerStopped && c.cfg.usesPCF() {
+		c.log.Printf("Metrics: The command server of queue manager %s is running again", c.qmName)
+	}
+	c.commandServer = state
+}
+
+// initialiseCommandServerMetric adds the command server metric to the metrics map, if it is selected
+func initialiseCommandServerMetric(metrics map[string]*metricData, cfg *metricsConfig) {
+	if !cfg.isSelected(commandServerKey) {
+		return
+	}
+	metrics[commandServerKey] = &metricData{
+		name:        commandServerName,
+		description: commandServerDescription,
+	}
+}
+
+// updateCommandServerMetric updates the value of the command server metric, which has no value if it is not known
+// whether the command server is running
+func updateCommandServerMetric(metrics map[string]*metricData, state commandServerState) {
+	metric, ok := metrics[commandServerKey]
+	if !ok {
+		return
+	}
+	metric.values = make(map[string]float64)
+	metric.lastUpdate = time.Now()
+	switch state {
+	case commandServerRunning:
+		metric.values[qmgrLabelValue] = 1
+	case commandServerStopped:
+		metric.values[qmgrLabelValue] = 0
+	}
+}
@@ -0,0 +1,93 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestUpdateCommandServerMetric(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseCommandServerMetric(metrics, &metricsConfig{})
+	metric, ok := metrics[commandServerKey]
+	if !ok {
+		t.Fatal("Expected command server metric not found in map")
+	}
+
+	tests := []struct {
+		state    commandServerState
+		expected float64
+		ok       bool
+	}{
+		{commandServerRunning, 1, true},
+		{commandServerStopped, 0, true},
+		{commandServerUnknown, 0, false},
+	}
+	for _, test := range tests {
+		updateCommandServerMetric(metrics, test.state)
+		value, ok := metric.values[qmgrLabelValue]
+		if value != test.expected || ok != test.ok {
+			t.Errorf("Expected value=%f, reported=%v for state %d; actual %f, %v", test.expected, test.ok, test.state, value, ok)
+		}
+	}
+
+	metrics = make(map[string]*metricData)
+	initialiseCommandServerMetric(metrics, &metricsConfig{exclude: []string{commandServerKey}})
+	if _, ok := metrics[commandServerKey]; ok {
+		t.Error("Expected excluded command server metric not to be added")
+	}
+}
+
+func TestCheckCommandServer(t *testing.T) {
+
+	state := commandServerStopped
+	inquireCommandServer = func(cmdQInquiry *commandQueueInquiry) commandServerState { return state }
+	defer func() { inquireCommandServer = doInquireCommandServer }()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := getTestConfig()
+	cfg.channels = "APP.*"
+	c := newCollector("QM1", cfg, log)
+
+	c.checkCommandServer()
+	c.checkCommandServer()
+	if count := strings.Count(buf.String(), "command server of queue manager QM1 is not running"); count != 1 {
+		t.Errorf("Expected one error while the command server is stopped; actual %d in %s", count, buf.String())
+	}
+	if !strings.Contains(buf.String(), "strmqcsv QM1") {
+		t.Errorf("Expected error to say how to start the command server; actual %s", buf.String())
+	}
+
+	// The inquiries are skipped while the command server is stopped
+	inquireChannels = func(pcfConns *pcfPool, cfg *metricsConfig) ([]channelStatus, error) {
+		t.Error("Unexpected inquiry while the command server is stopped")
+		return nil, nil
+	}
+	defer func() { inquireChannels = doInquireChannels }()
+	c.updatePCFMetrics(make(map[string]*metricData))
+
+	state = commandServerRunning
+	c.checkCommandServer()
+	if !strings.Contains(buf.String(), "command server of queue manager QM1 is running again") {
+		t.Errorf("Expected message when the command server is running again; actual %s", buf.String())
+	}
+}
@@ -0,0 +1,1054 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+const (
+	clientModeEnv         = "MQ_METRICS_CLIENT"
+	connNameEnv           = "MQ_METRICS_CONNAME"
+	channelEnv            = "MQ_METRICS_CHANNEL"
+	targetsEnv            = "MQ_METRICS_TARGETS"
+	targetIntervalEnv     = "MQ_METRICS_TARGET_INTERVAL"
+	userFileEnv           = "MQ_METRICS_USER_FILE"
+	passwordFileEnv       = "MQ_METRICS_PASSWORD_FILE"
+	keyRepositoryEnv      = "MQ_METRICS_KEY_REPOSITORY"
+	cipherEnv             = "MQ_METRICS_CIPHER"
+	peerNameEnv           = "MQ_METRICS_PEER_NAME"
+	certLabelEnv          = "MQ_METRICS_CERT_LABEL"
+	requestTimeoutEnv     = "MQ_METRICS_REQUEST_TIMEOUT"
+	collectTimeoutEnv     = "MQ_METRICS_COLLECT_TIMEOUT"
+	maxProcessTimeEnv     = "MQ_METRICS_MAX_PROCESS_TIME"
+	reconnectDelayEnv     = "MQ_METRICS_RECONNECT_DELAY"
+	reconnectMaxDelayEnv  = "MQ_METRICS_RECONNECT_MAX_DELAY"
+	maxConnectAttemptsEnv = "MQ_METRICS_MAX_CONNECT_ATTEMPTS"
+	staleAfterEnv         = "MQ_METRICS_STALE_AFTER"
+	lastKnownEnv          = "MQ_METRICS_SERVE_LAST_KNOWN"
+	queuesEnv             = "MQ_METRICS_QUEUES"
+	channelsEnv           = "MQ_METRICS_CHANNELS"
+	topicsEnv             = "MQ_METRICS_TOPICS"
+	subscriptionsEnv      = "MQ_METRICS_SUBSCRIPTIONS"
+	depthQueuesEnv        = "MQ_METRICS_DEPTH_QUEUES"
+	omitEmptyAgeEnv       = "MQ_METRICS_OMIT_EMPTY_QUEUE_AGE"
+	maxTopicsEnv          = "MQ_METRICS_MAX_TOPICS"
+	pcfConcurrencyEnv     = "MQ_METRICS_PCF_CONCURRENCY"
+	includeEnv            = "MQ_METRICS_INCLUDE"
+	excludeEnv            = "MQ_METRICS_EXCLUDE"
+	alwaysPresentEnv      = "MQ_METRICS_ALWAYS_PRESENT"
+	classesEnv            = "MQ_METRICS_CLASSES"
+	excludeClassesEnv     = "MQ_METRICS_EXCLUDE_CLASSES"
+	prefixEnv             = "MQ_METRICS_PREFIX"
+	labelsEnv             = "MQ_METRICS_LABELS"
+	podLabelsEnv          = "MQ_METRICS_POD_LABELS"
+	podLabelNameEnv       = "MQ_METRICS_POD_LABEL_NAME"
+	nodeLabelNameEnv      = "MQ_METRICS_NODE_LABEL_NAME"
+	qmgrLabelNameEnv      = "MQ_METRICS_QMGR_LABEL_NAME"
+	qmgrLabelValueEnv     = "MQ_METRICS_QMGR_LABEL_VALUE"
+	rawUnitsEnv           = "MQ_METRICS_RAW_UNITS"
+	nonFiniteZeroEnv      = "MQ_METRICS_NON_FINITE_AS_ZERO"
+	logSamplesEnv         = "MQ_METRICS_LOG_SAMPLES"
+	logLevelEnv           = "MQ_METRICS_LOG_LEVEL"
+	onDemandEnv           = "MQ_METRICS_ON_DEMAND"
+	maxIdleIntervalEnv    = "MQ_METRICS_MAX_IDLE_INTERVAL"
+	clampIntervalEnv      = "MQ_METRICS_CLAMP_PROCESS_INTERVAL"
+	countersEnv           = "MQ_METRICS_COUNTERS"
+	expectedFileEnv       = "MQ_METRICS_EXPECTED_FILE"
+	descriptionsFileEnv   = "MQ_METRICS_DESCRIPTIONS_FILE"
+	scalesFileEnv         = "MQ_METRICS_SCALES_FILE"
+	significantDigitsEnv  = "MQ_METRICS_SIGNIFICANT_DIGITS"
+	sizeBucketsEnv        = "MQ_METRICS_SIZE_BUCKETS"
+	drainTimeoutEnv       = "MQ_METRICS_DRAIN_TIMEOUT"
+	snakeCaseEnv          = "MQ_METRICS_SNAKE_CASE"
+	connectTimeoutEnv     = "MQ_METRICS_CONNECT_TIMEOUT"
+	modelQueueEnv         = "MQ_METRICS_MODEL_QUEUE"
+	dynamicPrefixEnv      = "MQ_METRICS_DYNAMIC_QUEUE_PREFIX"
+	maxLabelValuesEnv     = "MQ_METRICS_MAX_LABEL_VALUES"
+	listenAddressEnv      = "MQ_METRICS_LISTEN_ADDRESS"
+	portEnv               = "MQ_METRICS_PORT"
+	pathEnv               = "MQ_METRICS_PATH"
+	healthPathEnv         = "MQ_METRICS_HEALTH_PATH"
+	refreshIntervalEnv    = "MQ_METRICS_REFRESH_INTERVAL"
+	serverCertFileEnv     = "MQ_METRICS_TLS_CERT_FILE"
+	serverKeyFileEnv      = "MQ_METRICS_TLS_KEY_FILE"
+	serverCAFileEnv       = "MQ_METRICS_TLS_CA_FILE"
+	pushURLEnv            = "MQ_METRICS_PUSH_URL"
+	pushIntervalEnv       = "MQ_METRICS_PUSH_INTERVAL"
+	pushJobEnv            = "MQ_METRICS_PUSH_JOB"
+	otlpEndpointEnv       = "MQ_METRICS_OTLP_ENDPOINT"
+	otlpIntervalEnv       = "MQ_METRICS_OTLP_INTERVAL"
+	fileEnv               = "MQ_METRICS_FILE"
+	fileIntervalEnv       = "MQ_METRICS_FILE_INTERVAL"
+	fileFormatEnv         = "MQ_METRICS_FILE_FORMAT"
+	startupJitterEnv      = "MQ_METRICS_STARTUP_JITTER"
+	defaultChannel        = "SYSTEM.DEF.SVRCONN"
+	defaultModelQueue     = "SYSTEM.DEFAULT.MODEL.QUEUE"
+	defaultRequestTimeout = 10
+	defaultCollectTimeout = 8
+	defaultMaxProcessTime = 2
+	defaultReconnectDelay = 10
+	defaultReconnectMax   = 300
+	defaultMaxConnects    = 0
+	defaultStaleAfter     = 60
+	defaultMaxTopics      = 100
+	defaultPCFConcurrency = 2
+	defaultConnectTimeout = 60
+	defaultTargetInterval = 60
+	defaultPort           = 9157
+	defaultPath           = "/metrics"
+	defaultHealthPath     = "/metrics/health"
+	defaultRefresh        = 10
+	defaultPushInterval   = 15
+	defaultPushJob        = namespace
+	defaultOTLPInterval   = 60
+	defaultFileInterval   = 60
+	defaultPodLabelName   = "pod"
+	defaultNodeLabelName  = "node"
+	fileFormatText        = "text"
+	fileFormatJSON        = "json"
+	maxPort               = 65535
+	logLevelDebug         = "debug"
+	logLevelInfo          = "info"
+)
+
+var (
+	// validPrefix matches names which can start a Prometheus metric name
+	// - colons are allowed in metric names, but are reserved for recording rules
+	validPrefix = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// validQueueName matches valid MQ queue names
+	validQueueName = regexp.MustCompile("^[a-zA-Z0-9._/%]{1,48}$")
+
+	// validQueuePrefix matches prefixes of dynamic queue names, to which the queue manager adds a unique suffix
+	validQueuePrefix = regexp.MustCompile("^[a-zA-Z0-9._/%]{1,33}$")
+
+	// validHostName matches host names which the metrics server can listen on
+	validHostName = regexp.MustCompile("^[a-zA-Z0-9]([a-zA-Z0-9.-]{0,251}[a-zA-Z0-9])?$")
+
+	// validPath matches URL paths which metrics can be served on, which are not the root path or end with a slash
+	validPath = regexp.MustCompile("^(/[a-zA-Z0-9._~-]+)+$")
+
+	// validLabelName matches valid Prometheus label names
+	validLabelName = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// reservedLabels are the names of the labels set by the exporter
+	reservedLabels = []string{qmgrLabel, objectLabel, ageLabel, reasonLabel, hostLabel, roleLabel, classLabel, channelLabel, connNameLabel, topicLabel, subscriptionLabel, commandLevelLabel, mqVersionLabel, exporterVersionLabel, bucketLabel, quantileLabel}
+)
+
+// metricsConfig holds the configuration used when gathering metrics
+type metricsConfig struct {
+	clientMode     bool
+	connName       string
+	channel        string
+	targets        []metricsTarget
+	targetInterval time.Duration
+	userFile       string
+	passwordFile   string
+	keyRepository  string
+	cipher         string
+	peerName       string
+	certLabel      string
+	modelQueue     string
+	dynamicPrefix  string
+	requestTimeout time.Duration
+	collectTimeout time.Duration
+	maxProcessTime time.Duration
+	reconnectDelay time.Duration
+	reconnectMax   time.Duration
+	maxConnects    int
+	connectTimeout time.Duration
+	staleAfter     time.Duration
+	lastKnown      bool
+	queues         string
+	channels       string
+	topics         []string
+	subscriptions  string
+	depthQueues    string
+	omitEmptyAge   bool
+	maxTopics      int
+	pcfConcurrency int
+	maxLabelValues int
+	include        []string
+	exclude        []string
+	alwaysPresent  []string
+	classes        []string
+	excludeClasses []string
+	prefix         string
+	labels         map[string]string
+	podLabels      bool
+	qmLabelName    string
+	qmLabelValue   string
+	rawUnits       bool
+	nonFiniteZero  bool
+	logSamples     bool
+	logLevel       string
+	onDemand       bool
+	maxIdle        time.Duration
+	clampInterval  bool
+	counters       bool
+	snakeCase      bool
+	expected       map[int32][]string
+	descriptions   map[string]string
+	scales         map[string]float64
+	digits         int
+	sizeBuckets    []float64
+	drainTimeout   time.Duration
+	startupJitter  time.Duration
+	listenAddress  string
+	port           int
+	path           string
+	healthPath     string
+	refresh        time.Duration
+	serverCertFile string
+	serverKeyFile  string
+	serverCAFile   string
+	pushURL        string
+	pushInterval   time.Duration
+	pushJob        string
+	otlpEndpoint   string
+	otlpInterval   time.Duration
+	file           string
+	fileInterval   time.Duration
+	fileFormat     string
+}
+
+// loadConfig reads the metrics configuration from environment variables
+func loadConfig() (*metricsConfig, error) {
+
+	cfg := metricsConfig{
+		clientMode:   getEnvBool(clientModeEnv),
+		connName:     strings.TrimSpace(os.Getenv(connNameEnv)),
+		channel:      strings.TrimSpace(os.Getenv(channelEnv)),
+		userFile:     strings.TrimSpace(os.Getenv(userFileEnv)),
+		passwordFile: strings.TrimSpace(os.Getenv(passwordFileEnv)),
+		// The key repository is given by its stem, without the .kdb extension
+		keyRepository: strings.TrimSuffix(strings.TrimSpace(os.Getenv(keyRepositoryEnv)), ".kdb"),
+		cipher:        strings.TrimSpace(os.Getenv(cipherEnv)),
+		peerName:      strings.TrimSpace(os.Getenv(peerNameEnv)),
+		certLabel:     strings.TrimSpace(os.Getenv(certLabelEnv)),
+		prefix:        strings.TrimSpace(os.Getenv(prefixEnv)),
+		rawUnits:      getEnvBool(rawUnitsEnv),
+		nonFiniteZero: getEnvBool(nonFiniteZeroEnv),
+		logSamples:    getEnvBool(logSamplesEnv),
+		omitEmptyAge:  getEnvBool(omitEmptyAgeEnv),
+		lastKnown:     getEnvBool(lastKnownEnv),
+		onDemand:      getEnvBool(onDemandEnv),
+		clampInterval: getEnvBool(clampIntervalEnv),
+		counters:      getEnvBool(countersEnv),
+		snakeCase:     getEnvBool(snakeCaseEnv),
+		// TLS for the metrics server is separate from TLS for the connection to the queue manager
+		serverCertFile: strings.TrimSpace(os.Getenv(serverCertFileEnv)),
+		serverKeyFile:  strings.TrimSpace(os.Getenv(serverKeyFileEnv)),
+		serverCAFile:   strings.TrimSpace(os.Getenv(serverCAFileEnv)),
+	}
+
+	cfg.modelQueue = strings.TrimSpace(os.Getenv(modelQueueEnv))
+	if cfg.modelQueue == "" {
+		cfg.modelQueue = defaultModelQueue
+	} else if !validQueueName.MatchString(cfg.modelQueue) {
+		return nil, fmt.Errorf("%s must be a valid queue name: %s", modelQueueEnv, cfg.modelQueue)
+	}
+	cfg.dynamicPrefix = strings.TrimSpace(os.Getenv(dynamicPrefixEnv))
+	if cfg.dynamicPrefix != "" && !validQueuePrefix.MatchString(cfg.dynamicPrefix) {
+		return nil, fmt.Errorf("%s must be a valid queue name of up to 33 characters: %s", dynamicPrefixEnv, cfg.dynamicPrefix)
+	}
+
+	// Names starting with a double underscore are reserved for internal use by Prometheus
+	if cfg.prefix != "" && (!validPrefix.MatchString(cfg.prefix) || strings.HasPrefix(cfg.prefix, "__")) {
+		return nil, fmt.Errorf("%s must only contain letters, digits and underscores, and must not start with a digit or '__': %s", prefixEnv, cfg.prefix)
+	}
+
+	requestTimeout, err := getEnvSeconds(requestTimeoutEnv, defaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cfg.requestTimeout = requestTimeout
+
+	// By default, collect requests time out before a typical Prometheus scrape timeout of 10 seconds, and a value of 0
+	// waits for the response however long it takes
+	cfg.collectTimeout = defaultCollectTimeout * time.Second
+	if strings.TrimSpace(os.Getenv(collectTimeoutEnv)) != "" {
+		cfg.collectTimeout, err = getEnvOptionalSeconds(collectTimeoutEnv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default, requests are answered with the last metrics once processing publications has taken 2 seconds, and a
+	// value of 0 waits for processing to finish however long it takes
+	cfg.maxProcessTime = defaultMaxProcessTime * time.Second
+	if strings.TrimSpace(os.Getenv(maxProcessTimeEnv)) != "" {
+		cfg.maxProcessTime, err = getEnvOptionalSeconds(maxProcessTimeEnv)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.reconnectDelay, err = getEnvSeconds(reconnectDelayEnv, defaultReconnectDelay)
+	if err != nil {
+		return nil, err
+	}
+	cfg.reconnectMax, err = getEnvSeconds(reconnectMaxDelayEnv, defaultReconnectMax)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.reconnectMax < cfg.reconnectDelay {
+		return nil, fmt.Errorf("%s must not be less than %s", reconnectMaxDelayEnv, reconnectDelayEnv)
+	}
+	// By default, the exporter keeps trying to connect to the queue manager
+	cfg.maxConnects, err = getEnvCount(maxConnectAttemptsEnv, defaultMaxConnects)
+	if err != nil {
+		return nil, err
+	}
+	cfg.connectTimeout, err = getEnvSeconds(connectTimeoutEnv, defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cfg.targets, err = getTargets(targetsEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.targetInterval, err = getEnvSeconds(targetIntervalEnv, defaultTargetInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.staleAfter, err = getEnvSeconds(staleAfterEnv, defaultStaleAfter)
+	if err != nil {
+		return nil, err
+	}
+	// By default, pending publications are not processed when stopping
+	cfg.drainTimeout, err = getEnvOptionalSeconds(drainTimeoutEnv)
+	if err != nil {
+		return nil, err
+	}
+	// By default, publications are processed at the same interval whether or not any arrive
+	cfg.maxIdle, err = getEnvOptionalSeconds(maxIdleIntervalEnv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.maxIdle > 0 && cfg.onDemand {
+		return nil, fmt.Errorf("%s must not be set when %s is enabled", maxIdleIntervalEnv, onDemandEnv)
+	}
+	if cfg.maxIdle > 0 && cfg.maxIdle < cfg.requestTimeout {
+		return nil, fmt.Errorf("%s must not be less than %s", maxIdleIntervalEnv, requestTimeoutEnv)
+	}
+	if cfg.clampInterval && cfg.onDemand {
+		return nil, fmt.Errorf("%s must not be set when %s is enabled", clampIntervalEnv, onDemandEnv)
+	}
+
+	// By default, there is no random delay before connecting for the first time
+	cfg.startupJitter, err = getEnvOptionalSeconds(startupJitterEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	// By default, debug messages are logged if they are enabled for the whole container
+	cfg.logLevel = strings.ToLower(strings.TrimSpace(os.Getenv(logLevelEnv)))
+	if cfg.logLevel != "" && cfg.logLevel != logLevelDebug && cfg.logLevel != logLevelInfo {
+		return nil, fmt.Errorf("%s must be %s or %s: %s", logLevelEnv, logLevelDebug, logLevelInfo, os.Getenv(logLevelEnv))
+	}
+
+	cfg.listenAddress, cfg.port, err = getListenAddress(listenAddressEnv, portEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.path, err = getPath(pathEnv, defaultPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg.healthPath, err = getPath(healthPathEnv, defaultHealthPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, metricsPath := range []string{cfg.path, cfg.jsonPath(), cfg.listPath(), cfg.refreshPath()} {
+		if cfg.healthPath == metricsPath {
+			return nil, fmt.Errorf("%s must not be the same as a path used for metrics: %s", healthPathEnv, cfg.healthPath)
+		}
+	}
+	cfg.refresh, err = getEnvSeconds(refreshIntervalEnv, defaultRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if (cfg.serverCertFile == "") != (cfg.serverKeyFile == "") {
+		return nil, fmt.Errorf("%s and %s must both be set to serve metrics over TLS", serverCertFileEnv, serverKeyFileEnv)
+	}
+	if cfg.serverCAFile != "" && cfg.serverCertFile == "" {
+		return nil, fmt.Errorf("%s must only be set when %s is set", serverCAFileEnv, serverCertFileEnv)
+	}
+
+	cfg.labels, err = getLabels(labelsEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.podLabels = getEnvBool(podLabelsEnv)
+	if cfg.podLabels {
+		cfg.labels, err = addPodLabels(cfg.labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.qmLabelName, err = getQMgrLabelName(qmgrLabelNameEnv, cfg.labels)
+	if err != nil {
+		return nil, err
+	}
+	cfg.qmLabelValue = strings.TrimSpace(os.Getenv(qmgrLabelValueEnv))
+	if !utf8.ValidString(cfg.qmLabelValue) {
+		return nil, fmt.Errorf("%s must be valid UTF-8", qmgrLabelValueEnv)
+	}
+
+	// By default, metrics are only served for Prometheus to scrape
+	cfg.pushURL, err = getHTTPURL(pushURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.pushURL != "" {
+		cfg.pushInterval, err = getEnvSeconds(pushIntervalEnv, defaultPushInterval)
+		if err != nil {
+			return nil, err
+		}
+		cfg.pushJob = strings.TrimSpace(os.Getenv(pushJobEnv))
+		if cfg.pushJob == "" {
+			cfg.pushJob = defaultPushJob
+		} else if strings.Contains(cfg.pushJob, "/") {
+			return nil, fmt.Errorf("%s must not contain '/': %s", pushJobEnv, cfg.pushJob)
+		}
+		// The Pushgateway rejects metrics with labels which have the same names as its grouping labels
+		for _, label := range []string{pushJobLabel, pushInstanceLabel} {
+			if _, exists := cfg.labels[label]; exists {
+				return nil, fmt.Errorf("%s must not contain the label name '%s' when %s is set", labelsEnv, label, pushURLEnv)
+			}
+			if cfg.qmgrLabelName() == label {
+				return nil, fmt.Errorf("%s must not be '%s' when %s is set", qmgrLabelNameEnv, label, pushURLEnv)
+			}
+		}
+	}
+
+	// By default, metrics are not exported using OTLP
+	cfg.otlpEndpoint, err = getHTTPURL(otlpEndpointEnv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.otlpEndpoint != "" {
+		cfg.otlpInterval, err = getEnvSeconds(otlpIntervalEnv, defaultOTLPInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// By default, metrics are not written to a file
+	cfg.file = strings.TrimSpace(os.Getenv(fileEnv))
+	if cfg.file != "" {
+		cfg.fileInterval, err = getEnvSeconds(fileIntervalEnv, defaultFileInterval)
+		if err != nil {
+			return nil, err
+		}
+		cfg.fileFormat = strings.ToLower(strings.TrimSpace(os.Getenv(fileFormatEnv)))
+		if cfg.fileFormat == "" {
+			cfg.fileFormat = fileFormatText
+		} else if cfg.fileFormat != fileFormatText && cfg.fileFormat != fileFormatJSON {
+			return nil, fmt.Errorf("%s must be %s or %s: %s", fileFormatEnv, fileFormatText, fileFormatJSON, os.Getenv(fileFormatEnv))
+		}
+		if info, err := os.Stat(filepath.Dir(cfg.file)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s must be in an existing directory: %s", fileEnv, cfg.file)
+		}
+	}
+
+	cfg.queues, err = getNamePatterns(queuesEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.channels, err = getNamePatterns(channelsEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.topics, err = getTopicStrings(topicsEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.subscriptions, err = getNamePatterns(subscriptionsEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.depthQueues, err = getNamePatterns(depthQueuesEnv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.omitEmptyAge && cfg.depthQueues == "" {
+		return nil, fmt.Errorf("%s must only be set when %s is set", omitEmptyAgeEnv, depthQueuesEnv)
+	}
+	cfg.maxTopics, err = getEnvCount(maxTopicsEnv, defaultMaxTopics)
+	if err != nil {
+		return nil, err
+	}
+	cfg.pcfConcurrency, err = getEnvCount(pcfConcurrencyEnv, defaultPCFConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	// By default, the number of label values of object metrics is not limited
+	cfg.maxLabelValues, err = getEnvCount(maxLabelValuesEnv, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.include, err = getKeyPatterns(includeEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.exclude, err = getKeyPatterns(excludeEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.alwaysPresent, err = getMetricKeys(alwaysPresentEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.expected, err = readExpectedMetrics(expectedFileEnv, strings.TrimSpace(os.Getenv(expectedFileEnv)))
+	if err != nil {
+		return nil, err
+	}
+	cfg.descriptions, err = readDescriptions(descriptionsFileEnv, strings.TrimSpace(os.Getenv(descriptionsFileEnv)))
+	if err != nil {
+		return nil, err
+	}
+	cfg.scales, err = readScales(scalesFileEnv, strings.TrimSpace(os.Getenv(scalesFileEnv)))
+	if err != nil {
+		return nil, err
+	}
+	// By default, values are exposed without rounding
+	cfg.digits, err = getEnvCount(significantDigitsEnv, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.digits > maxSignificantDigits {
+		return nil, fmt.Errorf("%s must not be more than %d: %d", significantDigitsEnv, maxSignificantDigits, cfg.digits)
+	}
+
+	cfg.sizeBuckets, err = getSizeBuckets(sizeBucketsEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.classes, err = getClassNames(classesEnv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.excludeClasses, err = getClassNames(excludeClassesEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metrics gathering starts with the first of the targets, and the others are connected to in turn
+	if len(cfg.targets) > 0 {
+		if !cfg.clientMode {
+			return nil, fmt.Errorf("%s must only be set when %s is enabled", targetsEnv, clientModeEnv)
+		}
+		if cfg.connName != "" || cfg.channel != "" {
+			return nil, fmt.Errorf("%s and %s must not be set when %s is set", connNameEnv, channelEnv, targetsEnv)
+		}
+		// The queue manager label is the only label which distinguishes the metrics of each target
+		if cfg.qmLabelValue != "" {
+			return nil, fmt.Errorf("%s must not be set when %s is set", qmgrLabelValueEnv, targetsEnv)
+		}
+		cfg.connName, cfg.channel = cfg.targets[0].connName, cfg.targets[0].channel
+	}
+
+	if cfg.clientMode {
+		if cfg.connName == "" {
+			return nil, fmt.Errorf("%s must be set when %s is enabled", connNameEnv, clientModeEnv)
+		}
+		// The connection name is checked here, as the MQ client only reports a reason code for one which is not valid
+		var connections []connection
+		connections, err = cfg.connections()
+		if err != nil {
+			return nil, err
+		}
+		cfg.connName = formatConnName(connections)
+		if cfg.channel == "" {
+			cfg.channel = defaultChannel
+		}
+	}
+
+	if cfg.usesTLS() {
+		if !cfg.clientMode {
+			return nil, fmt.Errorf("%s must only be set when %s is enabled", cipherEnv, clientModeEnv)
+		}
+		if cfg.keyRepository == "" {
+			return nil, fmt.Errorf("%s must be set when %s is set", keyRepositoryEnv, cipherEnv)
+		}
+	} else if cfg.keyRepository != "" || cfg.peerName != "" || cfg.certLabel != "" {
+		return nil, fmt.Errorf("%s, %s and %s must only be set when %s is set", keyRepositoryEnv, peerNameEnv, certLabelEnv, cipherEnv)
+	}
+
+	return &cfg, nil
+}
+
+// logger returns the logger to use for metrics, which logs debug messages if the log level is debug, and not if it is
+// info, whether or not they are logged for the rest of the container. Messages logged in JSON format have a component
+// field, so that those from metrics can be told apart from the rest of the container.
+func (cfg *metricsConfig) logger(log *logger.Logger) *logger.Logger {
+	switch cfg.logLevel {
+	case logLevelDebug:
+		log = log.WithDebug(true)
+	case logLevelInfo:
+		log = log.WithDebug(false)
+	}
+	return log.WithFields(map[string]interface{}{componentField: componentName})
+}
+
+// metricNamespace returns the namespace of the exported metric names, including the configured prefix
+func (cfg *metricsConfig) metricNamespace() string {
+	if cfg.prefix == "" {
+		return namespace
+	}
+	return cfg.prefix + "_" + namespace
+}
+
+// qmgrLabelName returns the name of the queue manager label of every series, which is qmgr unless another name is
+// configured
+func (cfg *metricsConfig) qmgrLabelName() string {
+	if cfg.qmLabelName == "" {
+		return qmgrLabel
+	}
+	return cfg.qmLabelName
+}
+
+// address returns the address which the metrics server listens on
+func (cfg *metricsConfig) address() string {
+	return net.JoinHostPort(cfg.listenAddress, strconv.Itoa(cfg.port))
+}
+
+// jsonPath returns the path which metrics are served on in JSON format
+func (cfg *metricsConfig) jsonPath() string {
+	return cfg.path + "/json"
+}
+
+// refreshPath returns the path which refresh requests are received on
+func (cfg *metricsConfig) refreshPath() string {
+	return cfg.path + "/refresh"
+}
+
+// listPath returns the path which the list of available metrics is served on
+func (cfg *metricsConfig) listPath() string {
+	return cfg.path + "/list"
+}
+
+// usesPCF returns true if any of the configured metrics are gathered using PCF commands
+func (cfg *metricsConfig) usesPCF() bool {
+	return cfg.channels != "" || len(cfg.topics) > 0 || cfg.subscriptions != "" || cfg.depthQueues != ""
+}
+
+// clientChannelDefinition returns the client channel definition in the format used by MQSERVER
+func (cfg *metricsConfig) clientChannelDefinition() string {
+	return cfg.channel + "/TCP/" + cfg.connName
+}
+
+// credentials reads the user and password for the metrics connection from their files.
+// The files are read on each connect, so that rotated secrets are picked up on reconnect.
+func (cfg *metricsConfig) credentials() (user, password string, err error) {
+
+	if cfg.userFile == "" {
+		return "", "", nil
+	}
+	user, err = readSecretFile(cfg.userFile)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read metrics user from %s: %v", cfg.userFile, err)
+	}
+	if cfg.passwordFile == "" {
+		return "", "", fmt.Errorf("%s must be set when %s is set", passwordFileEnv, userFileEnv)
+	}
+	password, err = readSecretFile(cfg.passwordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to read metrics password for user %s from %s: %v", user, cfg.passwordFile, err)
+	}
+	return user, password, nil
+}
+
+// readSecretFile returns the contents of a mounted secret file, without any trailing newlines
+func readSecretFile(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// isSelected returns true if the metric with the given key should be published, based on the
+// include and exclude patterns. Metrics are included by default if there are no include patterns.
+func (cfg *metricsConfig) isSelected(key string) bool {
+	if len(cfg.include) > 0 && !matchesAny(cfg.include, key) {
+		return false
+	}
+	return !matchesAny(cfg.exclude, key)
+}
+
+// isClassSelected returns true if the metrics of the named class are selected by the enabled and disabled classes
+func (cfg *metricsConfig) isClassSelected(name string) bool {
+	name = strings.ToUpper(name)
+	if len(cfg.classes) > 0 && !containsName(cfg.classes, name) {
+		return false
+	}
+	return !containsName(cfg.excludeClasses, name)
+}
+
+// containsName returns true if the list of names contains the name
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny returns true if the key matches any of the patterns
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		// #nosec G104 - patterns are validated when the configuration is loaded
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// getKeyPatterns returns the comma-separated list of metric key patterns given by the environment variable
+func getKeyPatterns(name string) ([]string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	patterns := strings.Split(value, ",")
+	for i, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+			return nil, fmt.Errorf("%s contains an invalid metric key pattern: '%s'", name, pattern)
+		}
+		patterns[i] = pattern
+	}
+	return patterns, nil
+}
+
+// getMetricKeys returns the comma-separated list of metric keys given by the environment variable. The keys are
+// checked against the metrics available when connecting.
+func getMetricKeys(name string) ([]string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	keys := strings.Split(value, ",")
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("%s contains an empty metric key", name)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// getClassNames returns the comma-separated list of metric class names given by the environment variable, in upper case.
+// The names are checked against the classes published by the queue manager when connecting.
+func getClassNames(name string) ([]string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	names := strings.Split(value, ",")
+	for i, className := range names {
+		className = strings.ToUpper(strings.TrimSpace(className))
+		if className == "" {
+			return nil, fmt.Errorf("%s contains an empty metric class name", name)
+		}
+		names[i] = className
+	}
+	return names, nil
+}
+
+// getLabels returns the comma-separated list of name=value label pairs given by the environment variable.
+// Label names must be valid Prometheus label names, and must not clash with the labels set by the exporter.
+func getLabels(name string) (map[string]string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("%s must be a comma-separated list of name=value pairs: '%s'", name, pair)
+		}
+		labelName, labelValue := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		// Names starting with a double underscore, such as __name__, are reserved for internal use by Prometheus
+		if !validLabelName.MatchString(labelName) || strings.HasPrefix(labelName, "__") {
+			return nil, fmt.Errorf("%s contains an invalid label name: '%s'", name, labelName)
+		}
+		for _, reserved := range reservedLabels {
+			if labelName == reserved {
+				return nil, fmt.Errorf("%s contains a label name which is reserved for metrics: '%s'", name, labelName)
+			}
+		}
+		if _, exists := labels[labelName]; exists {
+			return nil, fmt.Errorf("%s contains a duplicate label name: '%s'", name, labelName)
+		}
+		labels[labelName] = labelValue
+	}
+	return labels, nil
+}
+
+// addPodLabels adds labels with the names of the pod and the node it runs on to the custom labels, from the variables
+// which the Kubernetes downward API usually sets - the pod name is POD_NAME, or the host name, which is the pod name
+// by default, and the node name is NODE_NAME. A label is skipped if its variable is not set, or if the custom labels
+// already have a label with its name.
+func addPodLabels(labels map[string]string) (map[string]string, error) {
+
+	podLabel, err := getPodLabelName(podLabelNameEnv, defaultPodLabelName)
+	if err != nil {
+		return nil, err
+	}
+	nodeLabel, err := getPodLabelName(nodeLabelNameEnv, defaultNodeLabelName)
+	if err != nil {
+		return nil, err
+	}
+	if podLabel == nodeLabel {
+		return nil, fmt.Errorf("%s and %s must not be the same label name: '%s'", podLabelNameEnv, nodeLabelNameEnv, podLabel)
+	}
+
+	podName := strings.TrimSpace(os.Getenv("POD_NAME"))
+	if podName == "" {
+		podName = strings.TrimSpace(os.Getenv("HOSTNAME"))
+	}
+	nodeName := strings.TrimSpace(os.Getenv("NODE_NAME"))
+	for labelName, labelValue := range map[string]string{podLabel: podName, nodeLabel: nodeName} {
+		if _, exists := labels[labelName]; exists || labelValue == "" || !utf8.ValidString(labelValue) {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[labelName] = labelValue
+	}
+	return labels, nil
+}
+
+// getPodLabelName returns the name of a pod label given by the environment variable, or the default if it is not set.
+// It must be a valid Prometheus label name, and must not be the name of a label set by the exporter.
+func getPodLabelName(name, defaultName string) (string, error) {
+	labelName := strings.TrimSpace(os.Getenv(name))
+	if labelName == "" {
+		return defaultName, nil
+	}
+	if !validLabelName.MatchString(labelName) || strings.HasPrefix(labelName, "__") {
+		return "", fmt.Errorf("%s is not a valid label name: '%s'", name, labelName)
+	}
+	for _, reserved := range reservedLabels {
+		if labelName == reserved {
+			return "", fmt.Errorf("%s must not be the name of a label used by the metrics: '%s'", name, labelName)
+		}
+	}
+	return labelName, nil
+}
+
+// getQMgrLabelName returns the name of the queue manager label given by the environment variable, or an empty string
+// if it is not set. It must be a valid Prometheus label name, and must not clash with the other labels set by the
+// exporter or with the custom labels.
+func getQMgrLabelName(name string, labels map[string]string) (string, error) {
+	labelName := strings.TrimSpace(os.Getenv(name))
+	if labelName == "" {
+		return "", nil
+	}
+	if !validLabelName.MatchString(labelName) || strings.HasPrefix(labelName, "__") {
+		return "", fmt.Errorf("%s is not a valid label name: '%s'", name, labelName)
+	}
+	for _, reserved := range reservedLabels {
+		if labelName == reserved && reserved != qmgrLabel {
+			return "", fmt.Errorf("%s must not be the name of another label used by the metrics: '%s'", name, labelName)
+		}
+	}
+	if _, exists := labels[labelName]; exists {
+		return "", fmt.Errorf("%s must not be the name of a label in %s: '%s'", name, labelsEnv, labelName)
+	}
+	return labelName, nil
+}
+
+// getListenAddress returns the host and port given by the environment variables, which the metrics server listens on.
+// The host must be an IP address or a host name, and is empty by default, to listen on all interfaces.
+func getListenAddress(hostName, portName string) (string, int, error) {
+	host := strings.TrimSpace(os.Getenv(hostName))
+	// IPv6 addresses may be given in brackets, as in a URL
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if host != "" && net.ParseIP(host) == nil && !validHostName.MatchString(host) {
+		return "", 0, fmt.Errorf("%s must be an IP address or host name: %s", hostName, host)
+	}
+	port, err := getEnvCount(portName, defaultPort)
+	if err != nil {
+		return "", 0, err
+	}
+	if port > maxPort {
+		return "", 0, fmt.Errorf("%s must be a port number no greater than %d: %d", portName, maxPort, port)
+	}
+	return host, port, nil
+}
+
+// getPath returns the URL path given by the environment variable, or the default if the variable is not set
+func getPath(name, defaultValue string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+	if !validPath.MatchString(value) {
+		return "", fmt.Errorf("%s must be a URL path starting with '/', which is not '/' and does not end with '/': %s", name, value)
+	}
+	return value, nil
+}
+
+// getHTTPURL returns the URL of the service given by the environment variable, without any trailing slash, or an
+// empty string if it is not set
+func getHTTPURL(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%s must be an http or https URL, without a query: %s", name, value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// getNamePatterns returns the comma-separated list of object names given by the environment variable.
+// Names may end with a single '*' wildcard, which is expanded by the queue manager.
+func getNamePatterns(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", nil
+	}
+	patterns := strings.Split(value, ",")
+	for i, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		wildcards := strings.Count(pattern, "*")
+		if pattern == "" || wildcards > 1 || (wildcards == 1 && !strings.HasSuffix(pattern, "*")) {
+			return "", fmt.Errorf("%s contains an invalid object name or pattern: '%s'", name, pattern)
+		}
+		patterns[i] = pattern
+	}
+	return strings.Join(patterns, ","), nil
+}
+
+// getTopicStrings returns the comma-separated list of topic strings given by the environment variable.
+// Topic strings may contain the '#' and '+' wildcards, which are expanded by the queue manager.
+func getTopicStrings(name string) ([]string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	topics := strings.Split(value, ",")
+	for i, topic := range topics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			return nil, fmt.Errorf("%s contains an empty topic string", name)
+		}
+		topics[i] = topic
+	}
+	return topics, nil
+}
+
+// getEnvBool returns true if the environment variable is set to "true" or "1"
+func getEnvBool(name string) bool {
+	value := os.Getenv(name)
+	return value == "true" || value == "1"
+}
+
+// getEnvSeconds returns the duration in whole seconds given by the environment variable,
+// or the default if the variable is not set. The value must be at least one second.
+func getEnvSeconds(name string, defaultSeconds int) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return time.Duration(defaultSeconds) * time.Second, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 1 {
+		return 0, fmt.Errorf("%s must be a whole number of seconds, greater than zero: %s", name, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// getEnvOptionalSeconds returns the duration given by the environment variable, or zero if the variable is not set.
+// The value may be zero.
+func getEnvOptionalSeconds(name string) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%s must be a whole number of seconds: %s", name, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// getSizeBuckets returns the upper bounds of the buckets for histograms of message sizes, in bytes, from a
+// comma-separated list in the environment variable. The bounds must be positive and in increasing order.
+func getSizeBuckets(name string) ([]float64, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsInf(bucket, 0) || math.IsNaN(bucket) || bucket <= 0 || (len(buckets) > 0 && bucket <= buckets[len(buckets)-1]) {
+			return nil, fmt.Errorf("%s must be a comma-separated list of sizes in bytes, greater than zero and in increasing order: %s", name, value)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// getEnvCount returns the number given by the environment variable, or the default if the variable is not set.
+// The value must be at least one.
+func getEnvCount(name string, defaultCount int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultCount, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("%s must be a whole number greater than zero: %s", name, value)
+	}
+	return count, nil
+}
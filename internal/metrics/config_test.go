@@ -0,0 +1,1128 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.clientMode {
+		t.Errorf("Expected clientMode=%v; actual %v", false, cfg.clientMode)
+	}
+	if cfg.requestTimeout != defaultRequestTimeout*time.Second {
+		t.Errorf("Expected requestTimeout=%v; actual %v", defaultRequestTimeout*time.Second, cfg.requestTimeout)
+	}
+	if actual := cfg.metricNamespace(); actual != namespace {
+		t.Errorf("Expected namespace=%s; actual %s", namespace, actual)
+	}
+	if cfg.rawUnits || cfg.nonFiniteZero || cfg.logSamples {
+		t.Errorf("Expected rawUnits=%v, nonFiniteZero=%v, logSamples=%v; actual %v, %v, %v", false, false, false, cfg.rawUnits, cfg.nonFiniteZero, cfg.logSamples)
+	}
+	if cfg.maxConnects != 0 {
+		t.Errorf("Expected unlimited connection attempts; actual maxConnects=%d", cfg.maxConnects)
+	}
+	if cfg.maxTopics != defaultMaxTopics || cfg.usesPCF() {
+		t.Errorf("Expected maxTopics=%d, usesPCF=%v; actual %d, %v", defaultMaxTopics, false, cfg.maxTopics, cfg.usesPCF())
+	}
+	if cfg.staleAfter != defaultStaleAfter*time.Second {
+		t.Errorf("Expected staleAfter=%v; actual %v", defaultStaleAfter*time.Second, cfg.staleAfter)
+	}
+	if cfg.connectTimeout != defaultConnectTimeout*time.Second {
+		t.Errorf("Expected connectTimeout=%v; actual %v", defaultConnectTimeout*time.Second, cfg.connectTimeout)
+	}
+	if cfg.modelQueue != defaultModelQueue {
+		t.Errorf("Expected modelQueue=%s; actual %s", defaultModelQueue, cfg.modelQueue)
+	}
+	if cfg.drainTimeout != 0 || len(cfg.sizeBuckets) != 0 {
+		t.Errorf("Expected drainTimeout=%v, sizeBuckets=%v; actual %v, %v", 0, []float64{}, cfg.drainTimeout, cfg.sizeBuckets)
+	}
+}
+
+func TestLoadConfig_RequestTimeout(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{requestTimeoutEnv: "3"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.requestTimeout != 3*time.Second {
+		t.Errorf("Expected requestTimeout=%v; actual %v", 3*time.Second, cfg.requestTimeout)
+	}
+
+	for _, value := range []string{"0", "-5", "ten", "1.5"} {
+		os.Setenv(requestTimeoutEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", requestTimeoutEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_ClientMode(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		clientModeEnv: "true",
+		connNameEnv:   "mqhost(1414)",
+	})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !cfg.clientMode {
+		t.Errorf("Expected clientMode=%v; actual %v", true, cfg.clientMode)
+	}
+	if cfg.channel != defaultChannel {
+		t.Errorf("Expected channel=%s; actual %s", defaultChannel, cfg.channel)
+	}
+	expected := defaultChannel + "/TCP/mqhost(1414)"
+	if actual := cfg.clientChannelDefinition(); actual != expected {
+		t.Errorf("Expected channel definition=%s; actual %s", expected, actual)
+	}
+}
+
+func TestLoadConfig_ClientModeNoConnName(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		clientModeEnv: "1",
+		channelEnv:    "METRICS.SVRCONN",
+	})
+	defer teardownTestEnv()
+
+	_, err := loadConfig()
+	if err == nil {
+		t.Error("Expected error when client mode is enabled without a connection name")
+	}
+}
+
+func TestLoadConfig_ClientModeConnName(t *testing.T) {
+
+	// IPv6 addresses are passed to the MQ client without brackets
+	cfg, err := loadConfigWithEnv(map[string]string{clientModeEnv: "true", connNameEnv: "[2001:db8::1](1414), mqhost2(1415)"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	expected := defaultChannel + "/TCP/2001:db8::1(1414),mqhost2(1415)"
+	if actual := cfg.clientChannelDefinition(); actual != expected {
+		t.Errorf("Expected channel definition=%s; actual %s", expected, actual)
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{clientModeEnv: "true", connNameEnv: "mqhost(1414"})
+	if err == nil || !strings.Contains(err.Error(), "'mqhost(1414'") {
+		t.Errorf("Expected error for the invalid connection name; actual %v", err)
+	}
+}
+
+func TestLoadConfig_TLS(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		clientModeEnv:    "true",
+		connNameEnv:      "mqhost(1414)",
+		cipherEnv:        "ANY_TLS12",
+		keyRepositoryEnv: "/etc/mqm/metrics/key.kdb",
+		peerNameEnv:      "CN=mqhost",
+	})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !cfg.usesTLS() || cfg.cipher != "ANY_TLS12" || cfg.peerName != "CN=mqhost" {
+		t.Errorf("Expected cipher=%s, peerName=%s; actual %s, %s", "ANY_TLS12", "CN=mqhost", cfg.cipher, cfg.peerName)
+	}
+	if cfg.keyRepository != "/etc/mqm/metrics/key" {
+		t.Errorf("Expected keyRepository=%s; actual %s", "/etc/mqm/metrics/key", cfg.keyRepository)
+	}
+
+	os.Unsetenv(keyRepositoryEnv)
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error when %s is set without %s", cipherEnv, keyRepositoryEnv)
+	}
+
+	os.Setenv(keyRepositoryEnv, "/etc/mqm/metrics/key")
+	os.Unsetenv(clientModeEnv)
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error when %s is set without %s", cipherEnv, clientModeEnv)
+	}
+
+	os.Unsetenv(cipherEnv)
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error when %s is set without %s", keyRepositoryEnv, cipherEnv)
+	}
+}
+
+func TestLoadConfig_ReconnectDelay(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{reconnectDelayEnv: "5", reconnectMaxDelayEnv: "120"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.reconnectDelay != 5*time.Second || cfg.reconnectMax != 120*time.Second {
+		t.Errorf("Expected reconnect delay=%v, max=%v; actual %v, %v", 5*time.Second, 120*time.Second, cfg.reconnectDelay, cfg.reconnectMax)
+	}
+
+	os.Setenv(reconnectMaxDelayEnv, "2")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error when maximum reconnect delay is less than the base delay")
+	}
+}
+
+func TestLoadConfig_DrainTimeout(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{drainTimeoutEnv: "3"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.drainTimeout != 3*time.Second {
+		t.Errorf("Expected drainTimeout=%v; actual %v", 3*time.Second, cfg.drainTimeout)
+	}
+
+	os.Setenv(drainTimeoutEnv, "-1")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", drainTimeoutEnv, "-1")
+	}
+}
+
+func TestLoadConfig_CollectTimeout(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.collectTimeout != defaultCollectTimeout*time.Second {
+		t.Errorf("Expected collectTimeout=%v; actual %v", defaultCollectTimeout*time.Second, cfg.collectTimeout)
+	}
+
+	// A timeout of 0 waits for collect requests however long they take
+	cfg, err = loadConfigWithEnv(map[string]string{collectTimeoutEnv: "0"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.collectTimeout != 0 {
+		t.Errorf("Expected collectTimeout=%v; actual %v", time.Duration(0), cfg.collectTimeout)
+	}
+
+	for _, value := range []string{"-1", "fast"} {
+		_, err = loadConfigWithEnv(map[string]string{collectTimeoutEnv: value})
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", collectTimeoutEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_MaxProcessTime(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxProcessTime != defaultMaxProcessTime*time.Second {
+		t.Errorf("Expected maxProcessTime=%v; actual %v", defaultMaxProcessTime*time.Second, cfg.maxProcessTime)
+	}
+
+	// A cap of 0 waits for processing to finish however long it takes
+	cfg, err = loadConfigWithEnv(map[string]string{maxProcessTimeEnv: "0"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxProcessTime != 0 {
+		t.Errorf("Expected maxProcessTime=%v; actual %v", time.Duration(0), cfg.maxProcessTime)
+	}
+
+	for _, value := range []string{"-1", "fast"} {
+		_, err = loadConfigWithEnv(map[string]string{maxProcessTimeEnv: value})
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", maxProcessTimeEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_SignificantDigits(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.digits != 0 {
+		t.Errorf("Expected digits=%d; actual %d", 0, cfg.digits)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{significantDigitsEnv: "4"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.digits != 4 {
+		t.Errorf("Expected digits=%d; actual %d", 4, cfg.digits)
+	}
+
+	for _, value := range []string{"0", "18", "two"} {
+		_, err = loadConfigWithEnv(map[string]string{significantDigitsEnv: value})
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", significantDigitsEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_StartupJitter(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.startupJitter != 0 {
+		t.Errorf("Expected startupJitter=%v; actual %v", time.Duration(0), cfg.startupJitter)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{startupJitterEnv: "30"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.startupJitter != 30*time.Second {
+		t.Errorf("Expected startupJitter=%v; actual %v", 30*time.Second, cfg.startupJitter)
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{startupJitterEnv: "-1"})
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", startupJitterEnv, "-1")
+	}
+}
+
+func TestLoadConfig_ConnectTimeout(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{connectTimeoutEnv: "20"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.connectTimeout != 20*time.Second {
+		t.Errorf("Expected connectTimeout=%v; actual %v", 20*time.Second, cfg.connectTimeout)
+	}
+
+	os.Setenv(connectTimeoutEnv, "0")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", connectTimeoutEnv, "0")
+	}
+}
+
+func TestLoadConfig_ModelQueue(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{modelQueueEnv: " METRICS.MODEL.QUEUE "})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.modelQueue != "METRICS.MODEL.QUEUE" {
+		t.Errorf("Expected modelQueue=%s; actual %s", "METRICS.MODEL.QUEUE", cfg.modelQueue)
+	}
+
+	for _, value := range []string{"METRICS MODEL", "METRICS.MODEL.QUEUE.WITH.A.NAME.LONGER.THAN.48.CHARS"} {
+		os.Setenv(modelQueueEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", modelQueueEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_DynamicQueuePrefix(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{dynamicPrefixEnv: " METRICS.REPLY. "})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.dynamicPrefix != "METRICS.REPLY." {
+		t.Errorf("Expected dynamicPrefix=%s; actual %s", "METRICS.REPLY.", cfg.dynamicPrefix)
+	}
+
+	for _, value := range []string{"METRICS.*", "METRICS.REPLY.PREFIX.LONGER.THAN.33"} {
+		_, err = loadConfigWithEnv(map[string]string{dynamicPrefixEnv: value})
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", dynamicPrefixEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_MaxConnects(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{maxConnectAttemptsEnv: "5"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxConnects != 5 {
+		t.Errorf("Expected maxConnects=%d; actual %d", 5, cfg.maxConnects)
+	}
+
+	os.Setenv(maxConnectAttemptsEnv, "0")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", maxConnectAttemptsEnv, "0")
+	}
+}
+
+func TestLoadConfig_Queues(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{queuesEnv: "APP.IN, APP.OUT.*"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.queues != "APP.IN,APP.OUT.*" {
+		t.Errorf("Expected queues=%s; actual %s", "APP.IN,APP.OUT.*", cfg.queues)
+	}
+	if cfg.channels != "" {
+		t.Errorf("Expected channels=%s; actual %s", "", cfg.channels)
+	}
+
+	for _, value := range []string{"APP.*.IN", "APP**", "APP.IN,,APP.OUT", "*APP"} {
+		os.Setenv(queuesEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", queuesEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_Channels(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{channelsEnv: "APP.SVRCONN, TO.*"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.channels != "APP.SVRCONN,TO.*" {
+		t.Errorf("Expected channels=%s; actual %s", "APP.SVRCONN,TO.*", cfg.channels)
+	}
+
+	os.Setenv(channelsEnv, "*.SVRCONN")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", channelsEnv, "*.SVRCONN")
+	}
+}
+
+func TestLoadConfig_DepthQueues(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{depthQueuesEnv: "APP.IN, APP.*"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.depthQueues != "APP.IN,APP.*" || !cfg.usesPCF() {
+		t.Errorf("Expected depthQueues=%s using PCF; actual %s, %v", "APP.IN,APP.*", cfg.depthQueues, cfg.usesPCF())
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{depthQueuesEnv: "*.IN"})
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", depthQueuesEnv, "*.IN")
+	}
+
+	// The ages of empty queues can only be omitted when the depth of queues is inquired
+	cfg, err = loadConfigWithEnv(map[string]string{depthQueuesEnv: "APP.*", omitEmptyAgeEnv: "true"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !cfg.omitEmptyAge {
+		t.Errorf("Expected omitEmptyAge=%v; actual %v", true, cfg.omitEmptyAge)
+	}
+	_, err = loadConfigWithEnv(map[string]string{omitEmptyAgeEnv: "true"})
+	if err == nil {
+		t.Errorf("Expected error for %s without %s", omitEmptyAgeEnv, depthQueuesEnv)
+	}
+}
+
+func TestLoadConfig_PCFConcurrency(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.pcfConcurrency != defaultPCFConcurrency {
+		t.Errorf("Expected pcfConcurrency=%d; actual %d", defaultPCFConcurrency, cfg.pcfConcurrency)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{pcfConcurrencyEnv: "8"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.pcfConcurrency != 8 {
+		t.Errorf("Expected pcfConcurrency=%d; actual %d", 8, cfg.pcfConcurrency)
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{pcfConcurrencyEnv: "0"})
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", pcfConcurrencyEnv, "0")
+	}
+}
+
+func TestLoadConfig_LogLevel(t *testing.T) {
+
+	tests := []struct {
+		value          string
+		containerDebug bool
+		expected       string
+		debugLogged    bool
+	}{
+		{"", false, "", false},
+		{"", true, "", true},
+		{"DEBUG", false, logLevelDebug, true},
+		{" info ", true, logLevelInfo, false},
+	}
+	for _, test := range tests {
+		cfg, err := loadConfigWithEnv(map[string]string{logLevelEnv: test.value})
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if cfg.logLevel != test.expected {
+			t.Errorf("Expected logLevel=%s for '%s'; actual %s", test.expected, test.value, cfg.logLevel)
+		}
+		var buf bytes.Buffer
+		log, _ := logger.NewLogger(&buf, test.containerDebug, false, "test")
+		cfg.logger(log).Debug("Metrics: debug message")
+		if logged := buf.Len() > 0; logged != test.debugLogged {
+			t.Errorf("Expected debug message logged=%v for '%s'; actual %v", test.debugLogged, test.value, logged)
+		}
+	}
+
+	_, err := loadConfigWithEnv(map[string]string{logLevelEnv: "verbose"})
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", logLevelEnv, "verbose")
+	}
+}
+
+func TestLoadConfig_MaxIdleInterval(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxIdle != 0 {
+		t.Errorf("Expected maxIdle=%v; actual %v", time.Duration(0), cfg.maxIdle)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{maxIdleIntervalEnv: "120"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxIdle != 120*time.Second {
+		t.Errorf("Expected maxIdle=%v; actual %v", 120*time.Second, cfg.maxIdle)
+	}
+
+	for _, env := range []map[string]string{
+		{maxIdleIntervalEnv: "5"},
+		{maxIdleIntervalEnv: "120", onDemandEnv: "true"},
+		{maxIdleIntervalEnv: "-1"},
+	} {
+		_, err = loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_Topics(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		topicsEnv:        "price/#, stock/+/level",
+		subscriptionsEnv: "APP.*",
+		maxTopicsEnv:     "20",
+	})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.topics) != 2 || cfg.topics[0] != "price/#" || cfg.topics[1] != "stock/+/level" {
+		t.Errorf("Expected topics=%v; actual %v", []string{"price/#", "stock/+/level"}, cfg.topics)
+	}
+	if cfg.subscriptions != "APP.*" || cfg.maxTopics != 20 {
+		t.Errorf("Expected subscriptions=%s, maxTopics=%d; actual %s, %d", "APP.*", 20, cfg.subscriptions, cfg.maxTopics)
+	}
+	if !cfg.usesPCF() {
+		t.Error("Expected PCF commands to be used for topic metrics")
+	}
+
+	os.Setenv(topicsEnv, "price/#,,stock")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for empty topic string")
+	}
+	os.Setenv(topicsEnv, "price/#")
+	os.Setenv(maxTopicsEnv, "0")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", maxTopicsEnv, "0")
+	}
+}
+
+func TestLoadConfig_ListenAddress(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.address() != ":9157" || cfg.path != defaultPath || cfg.healthPath != defaultHealthPath {
+		t.Errorf("Expected address=%s, path=%s, health path=%s; actual %s, %s, %s", ":9157", defaultPath, defaultHealthPath, cfg.address(), cfg.path, cfg.healthPath)
+	}
+
+	valid := []map[string]string{
+		{listenAddressEnv: "10.0.0.1", portEnv: "9000", pathEnv: "/mq/metrics", healthPathEnv: "/healthz"},
+		{listenAddressEnv: "[::1]"},
+		{listenAddressEnv: "metrics.example.com"},
+	}
+	expected := []string{"10.0.0.1:9000", "[::1]:9157", "metrics.example.com:9157"}
+	for i, env := range valid {
+		cfg, err := loadConfigWithEnv(env)
+		if err != nil {
+			t.Errorf("Unexpected error %s for %v", err.Error(), env)
+		} else if cfg.address() != expected[i] {
+			t.Errorf("Expected address=%s; actual %s", expected[i], cfg.address())
+		}
+	}
+	if cfg, _ := loadConfigWithEnv(valid[0]); cfg == nil || cfg.path != "/mq/metrics" || cfg.jsonPath() != "/mq/metrics/json" || cfg.healthPath != "/healthz" {
+		t.Errorf("Expected paths=%s, %s, %s; actual %+v", "/mq/metrics", "/mq/metrics/json", "/healthz", cfg)
+	}
+
+	invalid := []map[string]string{
+		{listenAddressEnv: "bad host"},
+		{listenAddressEnv: "-metrics"},
+		{portEnv: "0"},
+		{portEnv: "65536"},
+		{pathEnv: "metrics"},
+		{pathEnv: "/"},
+		{pathEnv: "/metrics/"},
+		{healthPathEnv: "/metrics/health?check"},
+		{healthPathEnv: "/metrics/json"},
+		{pathEnv: "/mq", healthPathEnv: "/mq"},
+	}
+	for _, env := range invalid {
+		_, err := loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_ServerTLS(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{serverCertFileEnv: "/etc/metrics/tls.crt", serverKeyFileEnv: "/etc/metrics/tls.key", serverCAFileEnv: "/etc/metrics/ca.crt"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.serverCertFile != "/etc/metrics/tls.crt" || cfg.serverKeyFile != "/etc/metrics/tls.key" || cfg.serverCAFile != "/etc/metrics/ca.crt" {
+		t.Errorf("Expected server TLS files=%s, %s, %s; actual %s, %s, %s", "/etc/metrics/tls.crt", "/etc/metrics/tls.key", "/etc/metrics/ca.crt", cfg.serverCertFile, cfg.serverKeyFile, cfg.serverCAFile)
+	}
+
+	for _, env := range []map[string]string{
+		{serverCertFileEnv: "/etc/metrics/tls.crt"},
+		{serverKeyFileEnv: "/etc/metrics/tls.key"},
+		{serverCAFileEnv: "/etc/metrics/ca.crt"},
+	} {
+		_, err := loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_Push(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.pushURL != "" {
+		t.Errorf("Expected pushURL=%s by default; actual %s", "", cfg.pushURL)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{pushURLEnv: "http://pushgateway:9091/"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.pushURL != "http://pushgateway:9091" || cfg.pushInterval != defaultPushInterval*time.Second || cfg.pushJob != defaultPushJob {
+		t.Errorf("Expected push settings=%s, %v, %s; actual %s, %v, %s", "http://pushgateway:9091", defaultPushInterval*time.Second, defaultPushJob, cfg.pushURL, cfg.pushInterval, cfg.pushJob)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{pushURLEnv: "https://pushgateway:9091", pushIntervalEnv: "60", pushJobEnv: "batch"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.pushInterval != time.Minute || cfg.pushJob != "batch" {
+		t.Errorf("Expected push settings=%v, %s; actual %v, %s", time.Minute, "batch", cfg.pushInterval, cfg.pushJob)
+	}
+
+	for _, env := range []map[string]string{
+		{pushURLEnv: "pushgateway:9091"},
+		{pushURLEnv: "ftp://pushgateway"},
+		{pushURLEnv: "http://pushgateway:9091?job=ibmmq"},
+		{pushURLEnv: "http://pushgateway:9091", pushIntervalEnv: "0"},
+		{pushURLEnv: "http://pushgateway:9091", pushJobEnv: "batch/1"},
+		{pushURLEnv: "http://pushgateway:9091", labelsEnv: "instance=pod1"},
+	} {
+		_, err := loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_OTLP(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{otlpEndpointEnv: "http://otel-collector:4318"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.otlpEndpoint != "http://otel-collector:4318" || cfg.otlpInterval != defaultOTLPInterval*time.Second {
+		t.Errorf("Expected OTLP settings=%s, %v; actual %s, %v", "http://otel-collector:4318", defaultOTLPInterval*time.Second, cfg.otlpEndpoint, cfg.otlpInterval)
+	}
+
+	for _, env := range []map[string]string{
+		{otlpEndpointEnv: "otel-collector:4318"},
+		{otlpEndpointEnv: "http://otel-collector:4318", otlpIntervalEnv: "0"},
+	} {
+		_, err := loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_ClampInterval(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{clampIntervalEnv: "true"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !cfg.clampInterval {
+		t.Errorf("Expected clampInterval=%t; actual %t", true, cfg.clampInterval)
+	}
+	_, err = loadConfigWithEnv(map[string]string{clampIntervalEnv: "true", onDemandEnv: "true"})
+	if err == nil {
+		t.Errorf("Expected error when clamping the process interval with on-demand processing")
+	}
+}
+
+func TestLoadConfig_File(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics-file")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "metrics.prom")
+
+	cfg, err := loadConfigWithEnv(map[string]string{fileEnv: file})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.file != file || cfg.fileInterval != defaultFileInterval*time.Second || cfg.fileFormat != fileFormatText {
+		t.Errorf("Expected file settings=%s, %v, %s; actual %s, %v, %s", file, defaultFileInterval*time.Second, fileFormatText, cfg.file, cfg.fileInterval, cfg.fileFormat)
+	}
+	cfg, err = loadConfigWithEnv(map[string]string{fileEnv: file, fileFormatEnv: "JSON", fileIntervalEnv: "15"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.fileInterval != 15*time.Second || cfg.fileFormat != fileFormatJSON {
+		t.Errorf("Expected file settings=%v, %s; actual %v, %s", 15*time.Second, fileFormatJSON, cfg.fileInterval, cfg.fileFormat)
+	}
+
+	for _, env := range []map[string]string{
+		{fileEnv: file, fileFormatEnv: "yaml"},
+		{fileEnv: file, fileIntervalEnv: "0"},
+		{fileEnv: filepath.Join(dir, "missing", "metrics.prom")},
+	} {
+		_, err := loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_MaxLabelValues(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{maxLabelValuesEnv: "500"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.maxLabelValues != 500 {
+		t.Errorf("Expected maxLabelValues=%d; actual %d", 500, cfg.maxLabelValues)
+	}
+
+	os.Setenv(maxLabelValuesEnv, "0")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", maxLabelValuesEnv, "0")
+	}
+}
+
+func TestLoadConfig_SizeBuckets(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{sizeBucketsEnv: "1024, 10240,1e5"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.sizeBuckets) != 3 || cfg.sizeBuckets[0] != 1024 || cfg.sizeBuckets[1] != 10240 || cfg.sizeBuckets[2] != 100000 {
+		t.Errorf("Expected sizeBuckets=%v; actual %v", []float64{1024, 10240, 100000}, cfg.sizeBuckets)
+	}
+
+	for _, value := range []string{"1024,512", "0,1024", "1024,,2048", "small", "1024,+Inf"} {
+		os.Setenv(sizeBucketsEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for size buckets %s", value)
+		}
+	}
+}
+
+func TestLoadConfig_Classes(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		classesEnv:        "cpu, DISK,STATMQI",
+		excludeClassesEnv: "STATMQI",
+	})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	selected := map[string]bool{"CPU": true, "DISK": true, "STATMQI": false, "STATQ": false}
+	for name, expected := range selected {
+		if actual := cfg.isClassSelected(name); actual != expected {
+			t.Errorf("Expected class %s selected=%v; actual %v", name, expected, actual)
+		}
+	}
+
+	os.Setenv(classesEnv, "CPU,,DISK")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for empty metric class name")
+	}
+}
+
+func TestLoadConfig_AlwaysPresent(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{alwaysPresentEnv: "QMGR/Info/Uptime, DISK/Log/Log - bytes in use"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	expected := "QMGR/Info/Uptime,DISK/Log/Log - bytes in use"
+	if strings.Join(cfg.alwaysPresent, ",") != expected {
+		t.Errorf("Expected alwaysPresent=%v; actual %v", expected, cfg.alwaysPresent)
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{alwaysPresentEnv: "QMGR/Info/Uptime,,"})
+	if err == nil {
+		t.Errorf("Expected error for empty metric key")
+	}
+}
+
+func TestLoadConfig_IncludeExclude(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{
+		includeEnv: "CPU/*/*, DISK/Log/*",
+		excludeEnv: "CPU/SystemSummary/RAM*",
+	})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	selected := map[string]bool{
+		"CPU/SystemSummary/CPU load - one minute average":         true,
+		"CPU/SystemSummary/RAM free percentage":                   false,
+		"DISK/Log/Log - bytes in use":                             true,
+		"DISK/QMgrSummary/Queue Manager file system - free space": false,
+	}
+	for key, expected := range selected {
+		if actual := cfg.isSelected(key); actual != expected {
+			t.Errorf("Expected isSelected(%s)=%v; actual %v", key, expected, actual)
+		}
+	}
+
+	os.Setenv(excludeEnv, "CPU/[")
+	_, err = loadConfig()
+	if err == nil {
+		t.Errorf("Expected error for invalid pattern")
+	}
+}
+
+func TestLoadConfig_Prefix(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{prefixEnv: "prod"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if actual := cfg.metricNamespace(); actual != "prod_ibmmq" {
+		t.Errorf("Expected namespace=%s; actual %s", "prod_ibmmq", actual)
+	}
+
+	for _, value := range []string{"1prod", "prod-eu", "prod:eu", "__prod"} {
+		os.Setenv(prefixEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", prefixEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_Labels(t *testing.T) {
+
+	teardownTestEnv := setupTestEnv(map[string]string{labelsEnv: "region=eu, team = payments"})
+	defer teardownTestEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.labels) != 2 || cfg.labels["region"] != "eu" || cfg.labels["team"] != "payments" {
+		t.Errorf("Expected labels=%v; actual %v", map[string]string{"region": "eu", "team": "payments"}, cfg.labels)
+	}
+
+	for _, value := range []string{"region", "region=", "1region=eu", "team-name=payments", "__name__=x", "qmgr=QM1", "queue=APP.IN", "le=100", "quantile=0.5", "region=eu,region=us"} {
+		os.Setenv(labelsEnv, value)
+		_, err = loadConfig()
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", labelsEnv, value)
+		}
+	}
+}
+
+func TestLoadConfig_QMgrLabel(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.qmgrLabelName() != qmgrLabel || cfg.qmLabelValue != "" {
+		t.Errorf("Expected label name=%s, value=%s; actual %s, %s", qmgrLabel, "", cfg.qmgrLabelName(), cfg.qmLabelValue)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{qmgrLabelNameEnv: " queue_manager ", qmgrLabelValueEnv: "payments-eu"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if cfg.qmgrLabelName() != "queue_manager" || cfg.qmLabelValue != "payments-eu" {
+		t.Errorf("Expected label name=%s, value=%s; actual %s, %s", "queue_manager", "payments-eu", cfg.qmgrLabelName(), cfg.qmLabelValue)
+	}
+
+	for _, value := range []string{"1qmgr", "queue-manager", "__qmgr", "queue", "reason"} {
+		_, err = loadConfigWithEnv(map[string]string{qmgrLabelNameEnv: value})
+		if err == nil {
+			t.Errorf("Expected error for %s=%s", qmgrLabelNameEnv, value)
+		}
+	}
+	for _, env := range []map[string]string{
+		{qmgrLabelNameEnv: "team", labelsEnv: "team=payments"},
+		{qmgrLabelNameEnv: "instance", pushURLEnv: "http://localhost:9091"},
+		{qmgrLabelValueEnv: "\xff"},
+		{qmgrLabelValueEnv: "payments", clientModeEnv: "true", targetsEnv: "QM1/host1(1414);QM2/host2(1414)"},
+	} {
+		_, err = loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestLoadConfig_PodLabels(t *testing.T) {
+
+	// The variables set by the downward API are restored afterwards, as they are not cleared with the others
+	saved := make(map[string]string)
+	for _, name := range []string{"POD_NAME", "NODE_NAME", "HOSTNAME"} {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = value
+		}
+		defer func(name string) {
+			if value, ok := saved[name]; ok {
+				os.Setenv(name, value)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name)
+	}
+	os.Setenv("HOSTNAME", "qm-0")
+	os.Unsetenv("POD_NAME")
+	os.Unsetenv("NODE_NAME")
+
+	cfg, err := loadConfigWithEnv(map[string]string{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.labels) != 0 {
+		t.Errorf("Expected no labels unless %s is set; actual %v", podLabelsEnv, cfg.labels)
+	}
+
+	// The host name is used when POD_NAME is not set, and the node label is skipped without NODE_NAME
+	cfg, err = loadConfigWithEnv(map[string]string{podLabelsEnv: "true"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.labels) != 1 || cfg.labels[defaultPodLabelName] != "qm-0" {
+		t.Errorf("Expected labels=%v; actual %v", map[string]string{defaultPodLabelName: "qm-0"}, cfg.labels)
+	}
+
+	cfg, err = loadConfigWithEnv(map[string]string{podLabelsEnv: "true", "POD_NAME": "qm-1", "NODE_NAME": "worker-2",
+		podLabelNameEnv: "kubernetes_pod", labelsEnv: "node=edge"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.labels) != 2 || cfg.labels["kubernetes_pod"] != "qm-1" || cfg.labels[defaultNodeLabelName] != "edge" {
+		t.Errorf("Expected the custom label to be kept; actual %v", cfg.labels)
+	}
+
+	for _, env := range []map[string]string{
+		{podLabelsEnv: "true", podLabelNameEnv: "pod-name"},
+		{podLabelsEnv: "true", nodeLabelNameEnv: "host"},
+		{podLabelsEnv: "true", podLabelNameEnv: "node"},
+		{podLabelsEnv: "true", pushURLEnv: "http://localhost:9091", podLabelNameEnv: "instance"},
+	} {
+		_, err = loadConfigWithEnv(env)
+		if err == nil {
+			t.Errorf("Expected error for %v", env)
+		}
+	}
+}
+
+func TestIsSelected_Defaults(t *testing.T) {
+	cfg := metricsConfig{}
+	if !cfg.isSelected(testMappingKey1) {
+		t.Errorf("Expected all metrics to be selected when no patterns are configured")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userFile := filepath.Join(dir, "user")
+	passwordFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(userFile, []byte("metrics\n"), 0600)
+	ioutil.WriteFile(passwordFile, []byte("passw0rd\r\n"), 0600)
+
+	cfg := metricsConfig{userFile: userFile, passwordFile: passwordFile}
+	user, password, err := cfg.credentials()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if user != "metrics" {
+		t.Errorf("Expected user=%s; actual %s", "metrics", user)
+	}
+	if password != "passw0rd" {
+		t.Errorf("Expected password=%s; actual %s", "passw0rd", password)
+	}
+
+	cfg = metricsConfig{userFile: userFile}
+	_, _, err = cfg.credentials()
+	if err == nil {
+		t.Error("Expected error when user is set without a password file")
+	}
+
+	cfg = metricsConfig{userFile: userFile, passwordFile: filepath.Join(dir, "missing")}
+	_, _, err = cfg.credentials()
+	if err == nil {
+		t.Error("Expected error when password file does not exist")
+	}
+
+	cfg = metricsConfig{}
+	user, password, err = cfg.credentials()
+	if err != nil || user != "" || password != "" {
+		t.Errorf("Expected no credentials; actual user=%s, err=%v", user, err)
+	}
+}
+
+// setupTestEnv sets the given environment variables, clearing any other metrics variables
+func loadConfigWithEnv(env map[string]string) (*metricsConfig, error) {
+	teardownTestEnv := setupTestEnv(env)
+	defer teardownTestEnv()
+	return loadConfig()
+}
+
+func setupTestEnv(env map[string]string) func() {
+	clearTestEnv()
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	return clearTestEnv
+}
+
+func clearTestEnv() {
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, "MQ_METRICS_") {
+			os.Unsetenv(strings.SplitN(entry, "=", 2)[0])
+		}
+	}
+}
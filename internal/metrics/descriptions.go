@@ -0,0 +1,69 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// readDescriptions reads the descriptions which replace those of metrics from a JSON file, which maps metric keys
+// to descriptions, in the format {"CPU/SystemSummary/CPU load - one minute average": "CPU load over one minute"}
+func readDescriptions(name, file string) (map[string]string, error) {
+
+	if file == "" {
+		return nil, nil
+	}
+	// #nosec G304 - the file is given by the configuration
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", name, err)
+	}
+	var descriptions map[string]string
+	err = json.Unmarshal(buf, &descriptions)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid JSON map of metric keys to descriptions: %v", name, err)
+	}
+	for key, description := range descriptions {
+		if strings.TrimSpace(key) == "" || strings.TrimSpace(description) == "" {
+			return nil, fmt.Errorf("%s contains an empty metric key or description: '%s'", name, key)
+		}
+	}
+	return descriptions, nil
+}
+
+// applyDescriptions replaces the descriptions of the metrics which have an override, and returns the number of
+// overrides which were applied. A published metric can be given by its metric key, or by the key used to select
+// it, which is looked up in the map of mapping keys. Metrics without an override keep their original description.
+func applyDescriptions(metrics map[string]*metricData, mappingKeys map[string]string, descriptions map[string]string) int {
+
+	applied := 0
+	for key, metric := range metrics {
+		description, ok := descriptions[key]
+		if !ok {
+			description, ok = descriptions[mappingKeys[key]]
+		}
+		if ok {
+			metric.description = description
+			applied++
+		}
+	}
+	return applied
+}
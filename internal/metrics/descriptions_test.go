@@ -0,0 +1,88 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadDescriptions(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "descriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	files := map[string]bool{
+		`{"CPU/SystemSummary/CPU load - five minute average": "CPU load over five minutes"}`: true,
+		`{"CPU/SystemSummary/CPU load - five minute average": ""}`:                           false,
+		`{"": "CPU load over five minutes"}`:                                                 false,
+		`["CPU load over five minutes"]`:                                                     false,
+	}
+	for content, valid := range files {
+		err = ioutil.WriteFile(file.Name(), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		descriptions, err := readDescriptions(descriptionsFileEnv, file.Name())
+		if valid && err != nil {
+			t.Errorf("Unexpected error %s for %s", err.Error(), content)
+		} else if !valid && err == nil {
+			t.Errorf("Expected error for %s", content)
+		}
+		if valid && len(descriptions) != 1 {
+			t.Errorf("Expected %d description; actual %v", 1, descriptions)
+		}
+	}
+
+	_, err = readDescriptions(descriptionsFileEnv, file.Name()+".missing")
+	if err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestInitialiseMetrics_Descriptions(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := &metricsConfig{descriptions: map[string]string{
+		testMappingKey1: "Overridden by mapping key",
+		infoKey:         "Overridden by metric key",
+		"Unknown/Key":   "Not applied",
+	}}
+	metrics, err := initialiseMetrics(getTestLogger(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if actual := metrics[testKey1].description; actual != "Overridden by mapping key" {
+		t.Errorf("Expected description=%s; actual %s", "Overridden by mapping key", actual)
+	}
+	if actual := metrics[infoKey].description; actual != "Overridden by metric key" {
+		t.Errorf("Expected description=%s; actual %s", "Overridden by metric key", actual)
+	}
+	if actual := metrics[uptimeKey].description; actual != uptimeDescription {
+		t.Errorf("Expected description=%s; actual %s", uptimeDescription, actual)
+	}
+
+	known := initialiseKnownMetrics(cfg)
+	if actual, ok := known[testMappingKey1]; !ok || actual.description != "Overridden by mapping key" {
+		t.Errorf("Expected known description=%s; actual %v", "Overridden by mapping key", actual)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultDumpWait is the time to receive publications before collecting the metrics which are dumped, which is longer
+// than the interval at which the queue manager publishes metrics by default
+const DefaultDumpWait = 15 * time.Second
+
+// DumpMetrics connects to the queue manager, collects the metrics once with the current configuration, and writes them
+// to w in the Prometheus text format. Metrics gathering must not be running in the same process, as it uses the same
+// connection.
+func DumpMetrics(qmName string, w io.Writer, wait time.Duration, log *logger.Logger) error {
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+
+	// Give up after the first failed attempt to connect, unless more attempts are configured
+	if cfg.maxConnects == 0 {
+		cfg.maxConnects = 1
+	}
+	return dumpMetrics(newCollector(qmName, cfg, cfg.logger(log)), w, wait)
+}
+
+// dumpMetrics starts the collector, then collects its metrics twice, as when serving them: the first collect skips the
+// values accumulated while connecting, and the second is made after receiving publications for the given time. It
+// then stops the collector, which closes the connection to the queue manager, before returning.
+func dumpMetrics(c *Collector, w io.Writer, wait time.Duration) error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	select {
+	case <-c.started:
+	case <-c.done:
+		return c.err
+	}
+
+	registry := prometheus.NewRegistry()
+	err := registry.Register(c)
+	if err != nil {
+		return fmt.Errorf("Failed to register metrics: %v", err)
+	}
+	_, err = registry.Gather()
+	if err != nil {
+		return fmt.Errorf("Failed to collect metrics: %v", err)
+	}
+
+	select {
+	case <-time.After(wait):
+	case <-c.done:
+		return fmt.Errorf("Metrics gathering stopped before the metrics were collected: %v", c.err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return fmt.Errorf("Failed to collect metrics: %v", err)
+	}
+	if atomic.LoadInt32(&c.status) != 1 {
+		return fmt.Errorf("Lost the connection to queue manager %s before the metrics were collected", c.qmName)
+	}
+	for _, family := range families {
+		_, err = expfmt.MetricFamilyToText(w, family)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDumpMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var ended int32
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { atomic.StoreInt32(&ended, 1) })
+	defer teardownTestConnection()
+
+	var buf bytes.Buffer
+	err := dumpMetrics(newCollector("QM1", getTestConfig(), getTestLogger()), &buf, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if atomic.LoadInt32(&ended) != 1 {
+		t.Error("Expected the connection to be ended before returning")
+	}
+
+	// The published metrics are collected after the values accumulated while connecting are skipped
+	for _, expected := range []string{`ibmmq_qmgr_status{qmgr="QM1"} 1`, `ibmmq_qmgr_cpu_load_five_minute_average_percentage{qmgr="QM1"} 1`} {
+		if !strings.Contains(buf.String(), expected+"\n") {
+			t.Errorf("Expected output containing '%s'; actual %s", expected, buf.String())
+		}
+	}
+}
+
+func TestDumpMetrics_ConnectFailure(t *testing.T) {
+
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		return newQMConnection(), fmt.Errorf("MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_Q_MGR_NOT_AVAILABLE [2059]")
+	}
+
+	cfg := getTestConfig()
+	cfg.maxConnects = 1
+	var buf bytes.Buffer
+	err := dumpMetrics(newCollector("QM1", cfg, getTestLogger()), &buf, 0)
+	if err == nil || buf.Len() != 0 {
+		t.Errorf("Expected error without output; actual %v, %s", err, buf.String())
+	}
+}
@@ -0,0 +1,90 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+// errorCategory classifies the errors from the queue manager, so that they can be distinguished in logs
+type errorCategory string
+
+const (
+	categoryConnection    errorCategory = "connection"
+	categoryAuthorization errorCategory = "authorization"
+	categoryConfiguration errorCategory = "configuration"
+	categoryResource      errorCategory = "resource"
+	categoryUnknown       errorCategory = "unknown"
+)
+
+// reasonPattern matches the reason code in the text of an MQ error
+var reasonPattern = regexp.MustCompile(`MQRC = \S+ \[(\d+)\]`)
+
+// reasonCode returns the MQ reason code of an error, or zero if it does not have one. Errors from mqmetric
+// include the text of the MQ error rather than wrapping it, so the reason code is found in the text.
+func reasonCode(err error) int32 {
+	switch e := err.(type) {
+	case *ibmmq.MQReturn:
+		return e.MQRC
+	case *pcfError:
+		return e.reason
+	case *authorityError:
+		return ibmmq.MQRC_NOT_AUTHORIZED
+	}
+	match := reasonPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	reason, err := strconv.ParseInt(match[1], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(reason)
+}
+
+// classifyError returns the category and MQ reason code of an error
+func classifyError(err error) (errorCategory, int32) {
+	if _, ok := err.(*modelQueueError); ok {
+		return categoryConfiguration, 0
+	}
+	reason := reasonCode(err)
+	switch reason {
+	case ibmmq.MQRC_CONNECTION_BROKEN, ibmmq.MQRC_Q_MGR_NOT_AVAILABLE, ibmmq.MQRC_HOST_NOT_AVAILABLE,
+		ibmmq.MQRC_CHANNEL_NOT_AVAILABLE, ibmmq.MQRC_Q_MGR_QUIESCING, ibmmq.MQRC_Q_MGR_STOPPING,
+		ibmmq.MQRC_CONNECTION_QUIESCING, ibmmq.MQRC_CONNECTION_STOPPING:
+		return categoryConnection, reason
+	case ibmmq.MQRC_NOT_AUTHORIZED, ibmmq.MQRC_SECURITY_ERROR:
+		return categoryAuthorization, reason
+	case ibmmq.MQRC_Q_MGR_NAME_ERROR, ibmmq.MQRC_UNKNOWN_OBJECT_NAME, ibmmq.MQRC_UNKNOWN_CHANNEL_NAME,
+		ibmmq.MQRC_CHANNEL_CONFIG_ERROR, ibmmq.MQRC_KEY_REPOSITORY_ERROR, ibmmq.MQRC_SSL_INITIALIZATION_ERROR,
+		ibmmq.MQRC_SSL_PEER_NAME_MISMATCH:
+		return categoryConfiguration, reason
+	case ibmmq.MQRC_STORAGE_NOT_AVAILABLE, ibmmq.MQRC_RESOURCE_PROBLEM, ibmmq.MQRC_MAX_CONNS_LIMIT_REACHED:
+		return categoryResource, reason
+	}
+	return categoryUnknown, reason
+}
+
+// recoverable returns false for errors which are not fixed by reconnecting, without a change to the
+// configuration of the queue manager or the metrics
+func (category errorCategory) recoverable() bool {
+	return category != categoryAuthorization && category != categoryConfiguration
+}
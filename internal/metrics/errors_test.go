@@ -0,0 +1,45 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+func TestClassifyError(t *testing.T) {
+
+	tests := []struct {
+		err      error
+		category errorCategory
+		reason   int32
+	}{
+		{&ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN}, categoryConnection, 2009},
+		{&pcfError{command: ibmmq.MQCMD_INQUIRE_CHANNEL_STATUS, reason: ibmmq.MQRC_NOT_AUTHORIZED}, categoryAuthorization, 2035},
+		{fmt.Errorf("Cannot access queue manager. Error: MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_Q_MGR_NAME_ERROR [2058]"), categoryConfiguration, 2058},
+		{fmt.Errorf("MQGET: MQCC = MQCC_FAILED [2] MQRC = MQRC_STORAGE_NOT_AVAILABLE [2071]"), categoryResource, 2071},
+		{fmt.Errorf("MQGET: MQCC = MQCC_FAILED [2] MQRC = MQRC_TRUNCATED_MSG_FAILED [2080]"), categoryUnknown, 2080},
+		{fmt.Errorf("Failed to read metrics key repository"), categoryUnknown, 0},
+	}
+	for _, test := range tests {
+		category, reason := classifyError(test.err)
+		if category != test.category || reason != test.reason {
+			t.Errorf("Expected category=%s, reason=%d for %v; actual %s, %d", test.category, test.reason, test.err, category, reason)
+		}
+	}
+}
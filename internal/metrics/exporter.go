@@ -18,6 +18,14 @@ limitations under the License.
 package metrics
 
 import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/ibm-messaging/mq-container/pkg/logger"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -26,166 +34,849 @@ const (
 	namespace    = "ibmmq"
 	qmgrPrefix   = "qmgr"
 	qmgrLabel    = "qmgr"
-	objectPrefix = "object"
-	objectLabel  = "object"
+	objectPrefix = "queue"
+	objectLabel  = "queue"
+
+	statusName        = "status"
+	statusDescription = "Whether the queue manager is connected and publishing metrics (1) or not (0)"
+	ageName           = "publication_age_seconds"
+	ageDescription    = "Time since publication data was last received for the metric"
+	ageLabel          = "metric"
+	errorName         = "error_total"
+	errorDescription  = "Number of errors with each MQ reason code received while connecting to the queue manager or processing publications"
+	reasonLabel       = "reason"
+	haRoleName        = "ha_role"
+	haRoleDescription = "Role of the instance of the queue manager running on the host, for a multi-instance or Native HA queue manager, which is 1 for the current role"
+	hostLabel         = "host"
+	roleLabel         = "role"
+
+	// Labels added by Prometheus to the series of histograms and summaries
+	bucketLabel   = "le"
+	quantileLabel = "quantile"
+
+	// Metrics about the exporter itself
+	exporterPrefix             = "exporter"
+	goroutinesName             = "goroutines"
+	goroutinesDescription      = "Number of goroutines in the metrics exporter"
+	reconnectsName             = "reconnects_total"
+	reconnectsDescription      = "Number of times the exporter has reconnected to the queue manager after an error"
+	lastErrorName              = "last_error_timestamp_seconds"
+	lastErrorDescription       = "Time of the last error in the exporter, in seconds since the epoch, or 0 if no error has occurred"
+	collectDurationName        = "collect_duration_seconds"
+	collectDurationDescription = "Time taken to update the metrics for the last collect request"
+	lastCollectName            = "last_collect_timestamp_seconds"
+	lastCollectDescription     = "Time of the last successful collect request, in seconds since the epoch, or 0 if no collect request has succeeded"
+	collectIntervalName        = "collect_interval_seconds"
+	collectIntervalDescription = "Time between the last two successful collect requests, or 0 until two have succeeded"
+	pcfDurationName            = "pcf_duration_seconds"
+	pcfDurationDescription     = "Time taken to inquire the metrics gathered using PCF commands for the last collect request"
+	processDurationName        = "process_publications_duration_seconds"
+	processDurationDescription = "Time taken to process publications of metric data in the last cycle"
+	processIntervalName        = "process_publications_interval_seconds"
+	processIntervalDescription = "Time to wait for a request before processing publications of metric data again, which is longer while none arrive if the adaptive cadence is enabled, or 0 if they are only processed on demand"
+	processSecondsName         = "process_publications_seconds"
+	processSecondsDescription  = "Time taken to process publications of metric data in each cycle"
+	publishIntervalName        = "publish_interval_seconds"
+	publishIntervalDescription = "Interval at which the queue manager publishes metric data, as detected from its publications on the current connection, or 0 if it is not known"
+	cappedName                 = "process_publications_capped_total"
+	cappedDescription          = "Number of cycles in which processing publications of metric data took longer than the processing cap, so that requests were answered with the metrics of the last collect until it finished"
+	replyDepthName             = "reply_queue_depth"
+	replyDepthDescription      = "Number of messages on the reply queue which the published metrics are put to, at the last collect request"
+	replyMaxDepthName          = "reply_queue_max_depth"
+	replyMaxDepthDescription   = "Maximum number of messages allowed on the reply queue which the published metrics are put to, after which publications are dropped"
+	droppedName                = "dropped_publications_total"
+	droppedDescription         = "Number of times the reply queue which the published metrics are put to was found full before processing publications, after which publications of metric data are dropped"
+	publishedName              = "published_metrics"
+	publishedDescription       = "Number of metrics which the queue manager publishes, discovered when connecting, or 0 if none are available"
+	pushFailuresName           = "push_failures_total"
+	pushFailuresDescription    = "Number of times pushing metrics to the Pushgateway has failed"
+	otlpFailuresName           = "otlp_failures_total"
+	otlpFailuresDescription    = "Number of times exporting metrics using OTLP has failed"
+	fileFailuresName           = "file_failures_total"
+	fileFailuresDescription    = "Number of times writing metrics to the file has failed"
+	subscriptionsName          = "subscriptions"
+	subscriptionsDescription   = "Number of subscriptions to published metrics which the queue manager holds for the exporter's user, or 0 while it is not connected"
+	activeClassesName          = "active_metric_classes"
+	activeClassesDescription   = "Number of classes of published metrics which the exporter gathers, or 0 while it is not connected"
+	classesName                = "discovered_metric_classes"
+	classesDescription         = "Number of classes of published metrics discovered when connecting, whether or not they could be subscribed to"
+	lastKnownName              = "serving_last_known"
+	lastKnownDescription       = "Whether the metrics served are the last-known values from before the connection to the queue manager was lost (1) or not (0)"
+	timedOutName               = "collect_timed_out"
+	timedOutDescription        = "Whether the last collect request timed out, so that the values of the collect before it were served again (1) or not (0)"
 )
 
-type exporter struct {
-	qmName       string
+// selfDescs describe the metrics about the exporter itself
+type selfDescs struct {
+	goroutines      *prometheus.Desc
+	reconnects      *prometheus.Desc
+	lastError       *prometheus.Desc
+	collectDuration *prometheus.Desc
+	lastCollect     *prometheus.Desc
+	collectInterval *prometheus.Desc
+	pcfDuration     *prometheus.Desc
+	processDuration *prometheus.Desc
+	processInterval *prometheus.Desc
+	processSeconds  *prometheus.Desc
+	publishInterval *prometheus.Desc
+	capped          *prometheus.Desc
+	replyDepth      *prometheus.Desc
+	replyMaxDepth   *prometheus.Desc
+	dropped         *prometheus.Desc
+	published       *prometheus.Desc
+	pushFailures    *prometheus.Desc
+	otlpFailures    *prometheus.Desc
+	fileFailures    *prometheus.Desc
+	subscriptions   *prometheus.Desc
+	activeClasses   *prometheus.Desc
+	classes         *prometheus.Desc
+	lastKnown       *prometheus.Desc
+	timedOut        *prometheus.Desc
+}
+
+// Collector processes the metrics for a queue manager, and provides them to Prometheus as a
+// prometheus.Collector, registered with the default registry. Published metrics are held in a
+// Prometheus Gauge or Counter for each key, so that counters accumulate the deltas published
+// by the queue manager between collects. The vendored mqmetric package keeps a single connection
+// to a queue manager, so there can only be one running Collector in a process.
+type Collector struct {
+	// Statistics about the exporter itself, which are maintained by processMetrics
+	// - they are accessed atomically, as they are read while metrics are being processed,
+	// and are first in the struct so that they are 64-bit aligned
+	reconnectCount      int64
+	lastErrorTime       int64 // Unix time in nanoseconds, or zero if no error has occurred
+	lastCollectDuration int64 // Nanoseconds
+	lastPCFDuration     int64 // Nanoseconds
+	lastCollectTime     int64 // Unix time in nanoseconds, or zero if no collect request has succeeded
+	lastCollectInterval int64 // Nanoseconds between the last two successful collect requests
+	lastProcessDuration int64 // Nanoseconds
+	processDuration     int64 // Nanoseconds, in total
+	processInterval     int64 // Nanoseconds, or zero before the first cycle after connecting
+	processCount        int64
+	publishInterval     int64 // Nanoseconds, or zero until it is detected on the current connection
+	cappedCycles        int64
+	replyQueueDepth     int64 // At the last collect request, or -1 if it is not known
+	replyQueueMaxDepth  int64 // At the last collect request, or -1 if it is not known
+	droppedPublications int64
+	publishedMetrics    int64 // Discovered on the latest connection
+	pushFailures        int64
+	otlpFailures        int64
+	fileFailures        int64
+	subscriptions       int64 // Held for the exporter on the latest connection, or -1 if not known
+	activeClasses       int64 // Gathered on the latest connection
+	discoveredClasses   int64 // Discovered on the latest connection
+	servingLastKnown    int64 // 1 while reconnecting, if the last-known metrics are served
+	collectTimeout      int64 // Nanoseconds to wait for a collect request, including for the request lock
+	countersStart       int64 // Unix time in nanoseconds since which the counters have accumulated
+
+	// status is set to 1 while connected to the queue manager and processing publications, haRole is the role of
+	// the local instance of the queue manager, checked before connecting, and timedOut is set to 1 when the last
+	// collect request timed out
+	// - they are accessed atomically, as they are read while metrics are being processed
+	status   int32
+	haRole   int32
+	timedOut int32
+
+	// targetMutex guards qmName, which processMetrics changes when switching queue manager, as it is read by
+	// requests which do not hold the request lock
+	targetMutex sync.RWMutex
+	qmName      string
+
+	cfg    *metricsConfig
+	log    *logger.Logger
+	jitter jitter
+
+	// lastDropWarning is the time of the last warning that publications were dropped, and droppedAtWarning the number
+	// of times the reply queue was found full by then - they are only used by processMetrics
+	lastDropWarning  time.Time
+	droppedAtWarning int64
+	// lastError is the last error which caused a reconnect, errorCount the number of consecutive times it occurred since
+	// firstErrorTime, and loggedErrorCount the number by the time it was last logged at lastErrorLog - they are only
+	// used by processMetrics
+	lastError        string
+	errorCount       int
+	loggedErrorCount int
+	firstErrorTime   time.Time
+	lastErrorLog     time.Time
+	// lastFileWarning is the time of the last warning that the metrics could not be written to the file, and
+	// failuresAtWarning the number of failures counted by then - they are only used by writeMetricsFile
+	lastFileWarning   time.Time
+	failuresAtWarning int64
+	// idleCycles is the number of consecutive cycles in which no publications arrived, since the process interval was
+	// last changed, and received is set when publications arrive - they are only used by processMetrics
+	idleCycles int
+	received   bool
+	// commandServer is whether the command server was running when last checked - it is only used by processMetrics
+	commandServer commandServerState
+
+	// pendingConnect is closed when an attempt to connect which timed out has ended, and anything it opened has been
+	// closed - it is only used by processMetrics
+	pendingConnect chan struct{}
+	// conn is what was opened and inquired when connecting to the queue manager, which is replaced when connecting
+	// again - it is only used by processMetrics
+	conn *qmConnection
+
+	// started is closed when the first connection to the queue manager succeeds, and done is
+	// closed when processing stops, after which err holds the reason, if any
+	started   chan struct{}
+	startOnce sync.Once
+	done      chan struct{}
+	err       error
+
+	requestChannel  chan metricsRequest
+	responseChannel chan map[string]*metricData
+
+	// switchChannel receives the target queue manager to switch to, and reloadChannel a configuration
+	// to reload - switchResult receives the result of connecting after either, and switchMutex is held
+	// until then, so that only one is in progress at a time without holding the request lock
+	switchChannel chan metricsTarget
+	reloadChannel chan *metricsConfig
+	switchResult  chan error
+	switchMutex   sync.Mutex
+
+	// shutdownChannel receives the function which flushes the final metrics when shutting down gracefully
+	shutdownChannel chan func()
+
+	// requestLock must be held from sending a request until finished with the response, as the metrics map is
+	// updated by the next collect request - it is a channel with room for one holder rather than a mutex, so that
+	// a collect can give up waiting for it at the collect timeout
+	requestLock chan struct{}
+
+	namespace    string
+	constLabels  prometheus.Labels
+	qmLabel      string
+	qmAlias      string
 	gaugeMap     map[string]*prometheus.GaugeVec
 	counterMap   map[string]*prometheus.CounterVec
+	statusGauge  *prometheus.GaugeVec
+	ageGauge     *prometheus.GaugeVec
+	errorCounter *prometheus.CounterVec
+	roleGauge    *prometheus.GaugeVec
+	host         string
+	selfDescs    selfDescs
+	units        map[string]string
+	known        map[string]*metricData
+	missing      map[string]bool
+	histograms   []*sizeHistogram
+	staleAfter   time.Duration
 	firstCollect bool
-	log          *logger.Logger
+	digits       int
+
+	// previous are the Prometheus Counters and Gauges sent by the last collect, which are sent again if a collect
+	// does not complete within the collect timeout - they are guarded by previousMutex rather than the request lock,
+	// as they are also sent when the request lock could not be taken in time
+	previousMutex sync.Mutex
+	previous      []prometheus.Collector
 }
 
-func newExporter(qmName string, log *logger.Logger) *exporter {
-	return &exporter{
-		qmName:       qmName,
-		gaugeMap:     make(map[string]*prometheus.GaugeVec),
-		counterMap:   make(map[string]*prometheus.CounterVec),
-		firstCollect: true,
-		log:          log,
+var _ prometheus.Collector = (*Collector)(nil)
+
+func newCollector(qmName string, cfg *metricsConfig, log *logger.Logger) *Collector {
+	metricNamespace := cfg.metricNamespace()
+	qmLabel := cfg.qmgrLabelName()
+	c := &Collector{
+		qmName:          qmName,
+		cfg:             cfg,
+		log:             log,
+		started:         make(chan struct{}),
+		done:            make(chan struct{}),
+		requestChannel:  make(chan metricsRequest),
+		responseChannel: make(chan map[string]*metricData),
+		switchChannel:   make(chan metricsTarget),
+		reloadChannel:   make(chan *metricsConfig),
+		switchResult:    make(chan error),
+		requestLock:     make(chan struct{}, 1),
+		shutdownChannel: make(chan func()),
+		namespace:       metricNamespace,
+		constLabels:     cfg.labels,
+		qmLabel:         qmLabel,
+		qmAlias:         cfg.qmLabelValue,
+		gaugeMap:        make(map[string]*prometheus.GaugeVec),
+		counterMap:      make(map[string]*prometheus.CounterVec),
+		statusGauge:     createGaugeVec(metricNamespace, cfg.labels, qmLabel, &metricData{name: statusName, description: statusDescription}),
+		ageGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   metricNamespace,
+				Name:        qmgrPrefix + "_" + ageName,
+				Help:        ageDescription,
+				ConstLabels: cfg.labels,
+			},
+			[]string{ageLabel, qmLabel},
+		),
+		errorCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   metricNamespace,
+				Name:        errorName,
+				Help:        errorDescription,
+				ConstLabels: cfg.labels,
+			},
+			[]string{reasonLabel, qmLabel},
+		),
+		roleGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   metricNamespace,
+				Name:        haRoleName,
+				Help:        haRoleDescription,
+				ConstLabels: cfg.labels,
+			},
+			[]string{qmLabel, hostLabel, roleLabel},
+		),
+		selfDescs: selfDescs{
+			goroutines:      newSelfDesc(metricNamespace, cfg.labels, qmLabel, goroutinesName, goroutinesDescription),
+			reconnects:      newSelfDesc(metricNamespace, cfg.labels, qmLabel, reconnectsName, reconnectsDescription),
+			lastError:       newSelfDesc(metricNamespace, cfg.labels, qmLabel, lastErrorName, lastErrorDescription),
+			collectDuration: newSelfDesc(metricNamespace, cfg.labels, qmLabel, collectDurationName, collectDurationDescription),
+			lastCollect:     newSelfDesc(metricNamespace, cfg.labels, qmLabel, lastCollectName, lastCollectDescription),
+			collectInterval: newSelfDesc(metricNamespace, cfg.labels, qmLabel, collectIntervalName, collectIntervalDescription),
+			pcfDuration:     newSelfDesc(metricNamespace, cfg.labels, qmLabel, pcfDurationName, pcfDurationDescription),
+			processDuration: newSelfDesc(metricNamespace, cfg.labels, qmLabel, processDurationName, processDurationDescription),
+			processInterval: newSelfDesc(metricNamespace, cfg.labels, qmLabel, processIntervalName, processIntervalDescription),
+			processSeconds:  newSelfDesc(metricNamespace, cfg.labels, qmLabel, processSecondsName, processSecondsDescription),
+			publishInterval: newSelfDesc(metricNamespace, cfg.labels, qmLabel, publishIntervalName, publishIntervalDescription),
+			capped:          newSelfDesc(metricNamespace, cfg.labels, qmLabel, cappedName, cappedDescription),
+			replyDepth:      newSelfDesc(metricNamespace, cfg.labels, qmLabel, replyDepthName, replyDepthDescription),
+			replyMaxDepth:   newSelfDesc(metricNamespace, cfg.labels, qmLabel, replyMaxDepthName, replyMaxDepthDescription),
+			dropped:         newSelfDesc(metricNamespace, cfg.labels, qmLabel, droppedName, droppedDescription),
+			published:       newSelfDesc(metricNamespace, cfg.labels, qmLabel, publishedName, publishedDescription),
+			pushFailures:    newSelfDesc(metricNamespace, cfg.labels, qmLabel, pushFailuresName, pushFailuresDescription),
+			otlpFailures:    newSelfDesc(metricNamespace, cfg.labels, qmLabel, otlpFailuresName, otlpFailuresDescription),
+			fileFailures:    newSelfDesc(metricNamespace, cfg.labels, qmLabel, fileFailuresName, fileFailuresDescription),
+			subscriptions:   newSelfDesc(metricNamespace, cfg.labels, qmLabel, subscriptionsName, subscriptionsDescription),
+			activeClasses:   newSelfDesc(metricNamespace, cfg.labels, qmLabel, activeClassesName, activeClassesDescription),
+			classes:         newSelfDesc(metricNamespace, cfg.labels, qmLabel, classesName, classesDescription),
+			lastKnown:       newSelfDesc(metricNamespace, cfg.labels, qmLabel, lastKnownName, lastKnownDescription),
+			timedOut:        newSelfDesc(metricNamespace, cfg.labels, qmLabel, timedOutName, timedOutDescription),
+		},
+		units: map[string]string{
+			getFullName(metricNamespace, &metricData{name: ageName}):           "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + lastErrorName:       "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + collectDurationName: "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + lastCollectName:     "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + collectIntervalName: "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + pcfDurationName:     "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + processDurationName: "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + processIntervalName: "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + processSecondsName:  "seconds",
+			metricNamespace + "_" + exporterPrefix + "_" + publishIntervalName: "seconds",
+		},
+		jitter:             newJitter(qmName, cfg.startupJitter),
+		known:              initialiseKnownMetrics(cfg),
+		missing:            make(map[string]bool),
+		histograms:         newSizeHistograms(metricNamespace, cfg),
+		staleAfter:         cfg.staleAfter,
+		firstCollect:       true,
+		conn:               newQMConnection(),
+		collectTimeout:     int64(cfg.collectTimeout),
+		countersStart:      time.Now().UnixNano(),
+		replyQueueDepth:    -1,
+		replyQueueMaxDepth: -1,
+		digits:             cfg.digits,
+	}
+	for _, histogram := range c.histograms {
+		c.units[getFullName(metricNamespace, &metricData{name: histogram.name, objectType: histogram.objectType})] = "bytes"
 	}
+	// The host name identifies the instance of the queue manager in the HA role metric
+	// #nosec G104
+	c.host, _ = os.Hostname()
+	return c
 }
 
-// Describe provides details of all available metrics
-func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
+// lockRequests takes the request lock, waiting for as long as another request holds it
+func (c *Collector) lockRequests() {
+	c.requestLock <- struct{}{}
+}
 
-	requestChannel <- false
-	response := <-responseChannel
+// unlockRequests releases the request lock
+func (c *Collector) unlockRequests() {
+	<-c.requestLock
+}
 
-	for key, metric := range response {
+// request sends the request to processMetrics, and returns the response - or no metrics once processing has stopped,
+// so that requests made while shutting down do not wait for a response which will not come. The request lock must
+// be held from sending the request until finished with the response.
+func (c *Collector) request(request metricsRequest) map[string]*metricData {
+	select {
+	case c.requestChannel <- request:
+		return <-c.responseChannel
+	case <-c.done:
+		return map[string]*metricData{}
+	}
+}
 
+// Describe provides details of all available metrics. Before the first connection to the queue manager, the
+// metrics which are expected to be available are described instead, so that the collector can be registered
+// before the queue manager is running.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+
+	c.lockRequests()
+	defer c.unlockRequests()
+	response := c.request(describeRequest)
+
+	if len(response) == 0 {
+		// The keys of the expected metrics are not those of the published metrics, so their Prometheus
+		// Counters and Gauges are allocated when the metrics are first collected
+		for _, metric := range c.known {
+			if metric.isDelta {
+				createCounterVec(c.namespace, c.constLabels, c.qmLabel, metric).Describe(ch)
+			} else {
+				createGaugeVec(c.namespace, c.constLabels, c.qmLabel, metric).Describe(ch)
+			}
+		}
+	}
+
+	for key, metric := range response {
 		if metric.isDelta {
 			// For delta type metrics - allocate a Prometheus Counter
-			counterVec := createCounterVec(metric.name, metric.description, metric.objectType)
-			e.counterMap[key] = counterVec
-
-			// Describe metric
-			counterVec.Describe(ch)
-
+			c.getCounterVec(key, metric).Describe(ch)
 		} else {
 			// For non-delta type metrics - allocate a Prometheus Gauge
-			gaugeVec := createGaugeVec(metric.name, metric.description, metric.objectType)
-			e.gaugeMap[key] = gaugeVec
-
-			// Describe metric
-			gaugeVec.Describe(ch)
+			c.getGaugeVec(key, metric).Describe(ch)
 		}
 	}
+
+	// Describe the queue manager status, which is always available
+	c.statusGauge.Describe(ch)
+	c.ageGauge.Describe(ch)
+	c.errorCounter.Describe(ch)
+	c.roleGauge.Describe(ch)
+
+	// Describe the histograms of message sizes
+	for _, histogram := range c.histograms {
+		ch <- histogram.desc
+	}
+
+	// Describe the metrics about the exporter itself
+	ch <- c.selfDescs.goroutines
+	ch <- c.selfDescs.reconnects
+	ch <- c.selfDescs.lastError
+	ch <- c.selfDescs.collectDuration
+	ch <- c.selfDescs.lastCollect
+	ch <- c.selfDescs.collectInterval
+	ch <- c.selfDescs.pcfDuration
+	ch <- c.selfDescs.processDuration
+	ch <- c.selfDescs.processInterval
+	ch <- c.selfDescs.processSeconds
+	ch <- c.selfDescs.publishInterval
+	ch <- c.selfDescs.capped
+	ch <- c.selfDescs.replyDepth
+	ch <- c.selfDescs.replyMaxDepth
+	ch <- c.selfDescs.dropped
+	ch <- c.selfDescs.published
+	ch <- c.selfDescs.pushFailures
+	ch <- c.selfDescs.otlpFailures
+	ch <- c.selfDescs.fileFailures
+	ch <- c.selfDescs.subscriptions
+	ch <- c.selfDescs.activeClasses
+	ch <- c.selfDescs.classes
+	ch <- c.selfDescs.lastKnown
+	ch <- c.selfDescs.timedOut
 }
 
 // Collect is called at regular intervals to provide the current metric data
-func (e *exporter) Collect(ch chan<- prometheus.Metric) {
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, collectRequest)
+}
 
-	requestChannel <- true
-	response := <-responseChannel
+// collect sends the collect request, and sends the updated metrics on the channel. If the response does not arrive
+// within the collect timeout, including the time waiting for another request to release the request lock, for example
+// because an inquiry to the queue manager has hung, the metrics of the last collect are sent again instead, so that
+// the scrape does not fail. They are also sent again if the response has no metrics map, as the metrics cannot be
+// updated while a long burst of publications is still being processed.
+func (c *Collector) collect(ch chan<- prometheus.Metric, request metricsRequest) {
+
+	timeout := time.Duration(atomic.LoadInt64(&c.collectTimeout))
+	response, ok := c.requestWithin(request, timeout)
+	if !ok {
+		atomic.StoreInt32(&c.timedOut, 1)
+		c.eventLog("collect_timeout").Printf("Metrics Warning: Serving the metrics of the last collect again, as the collect request did not complete within %v", timeout)
+		c.collectPrevious(ch)
+		c.collectStatus(ch, nil)
+		return
+	}
+	defer c.unlockRequests()
+	atomic.StoreInt32(&c.timedOut, 0)
+	if response == nil {
+		c.collectPrevious(ch)
+		c.collectStatus(ch, nil)
+		return
+	}
+	lastKnown := atomic.LoadInt64(&c.servingLastKnown)
+
+	c.ageGauge.Reset()
 
+	collected := make([]prometheus.Collector, 0, len(response))
 	for key, metric := range response {
 
+		// Report the age of the metric data, and skip values which have not been updated within the staleness window
+		// - unless they are the last-known values served while reconnecting, which are reported however old they are
+		stale := true
+		if !metric.lastUpdate.IsZero() {
+			age := time.Since(metric.lastUpdate)
+			c.ageGauge.WithLabelValues(getFullName(c.namespace, metric), c.qmLabelValue()).Set(age.Seconds())
+			stale = age > c.staleAfter && lastKnown == 0
+		}
+
+		_, labels := getVecDetails(metric)
+
 		if metric.isDelta {
 			// For delta type metrics - update their Prometheus Counter
-			counterVec := e.counterMap[key]
+			counterVec := c.getCounterVec(key, metric)
 
 			// Populate Prometheus Counter with metric values
 			// - Skip on first collect to avoid build-up of accumulated values
-			if !e.firstCollect {
+			// - Counters which are always present are created with a count of 0 until they are increased
+			if metric.alwaysPresent {
+				c.ensurePresent(counterVec.MetricVec, labels)
+			}
+			if !c.firstCollect && !stale {
 				for label, value := range metric.values {
-					var err error
-					var counter prometheus.Counter
-
-					if label == qmgrLabelValue {
-						counter, err = counterVec.GetMetricWithLabelValues(e.qmName)
-					} else {
-						counter, err = counterVec.GetMetricWithLabelValues(label, e.qmName)
+					labelValues, err := getLabelValues(label, c.qmLabelValue(), len(labels))
+					if err != nil {
+						c.log.Errorf("Metrics Error: Skipping value of metric %s: %v", getFullName(c.namespace, metric), err)
+						continue
 					}
+					counter, err := counterVec.GetMetricWithLabelValues(labelValues...)
 					if err == nil {
-						counter.Add(value)
+						counter.Add(metric.exposedValue(value))
 					} else {
-						e.log.Errorf("Metrics Error: %s", err.Error())
+						c.log.Errorf("Metrics Error: %s", err.Error())
 					}
 				}
 			}
 
 			// Collect metric
 			counterVec.Collect(ch)
+			collected = append(collected, counterVec)
 
 		} else {
 			// For non-delta type metrics - reset their Prometheus Gauge
-			gaugeVec := e.gaugeMap[key]
+			gaugeVec := c.getGaugeVec(key, metric)
 			gaugeVec.Reset()
 
 			// Populate Prometheus Gauge with metric values
 			// - Skip on first collect to avoid build-up of accumulated values
-			if !e.firstCollect {
+			// - Gauges which are always present are set to 0 first, for when they have no value
+			if metric.alwaysPresent {
+				c.ensurePresent(gaugeVec.MetricVec, labels)
+			}
+			if !c.firstCollect && !stale {
 				for label, value := range metric.values {
-					var err error
-					var gauge prometheus.Gauge
-
-					if label == qmgrLabelValue {
-						gauge, err = gaugeVec.GetMetricWithLabelValues(e.qmName)
-					} else {
-						gauge, err = gaugeVec.GetMetricWithLabelValues(label, e.qmName)
+					labelValues, err := getLabelValues(label, c.qmLabelValue(), len(labels))
+					if err != nil {
+						c.log.Errorf("Metrics Error: Skipping value of metric %s: %v", getFullName(c.namespace, metric), err)
+						continue
 					}
+					gauge, err := gaugeVec.GetMetricWithLabelValues(labelValues...)
 					if err == nil {
-						gauge.Set(value)
+						gauge.Set(roundValue(metric.exposedValue(value), c.digits))
 					} else {
-						e.log.Errorf("Metrics Error: %s", err.Error())
+						c.log.Errorf("Metrics Error: %s", err.Error())
 					}
 				}
 			}
 
 			// Collect metric
 			gaugeVec.Collect(ch)
+			collected = append(collected, gaugeVec)
 		}
 	}
+	c.previousMutex.Lock()
+	c.previous = collected
+	c.previousMutex.Unlock()
 
-	if e.firstCollect {
-		e.firstCollect = false
+	c.collectStatus(ch, response)
+
+	if c.firstCollect {
+		c.firstCollect = false
+	}
+}
+
+// requestWithin takes the request lock, sends the request to processMetrics, and returns the response, or false if
+// the lock could not be taken or the response has not arrived within the timeout, unless it is zero. The request lock
+// is held when the response is returned, and must be released with unlockRequests once finished with it. A response
+// which arrives later is dropped by processMetrics, as no requester receives it.
+func (c *Collector) requestWithin(request metricsRequest, timeout time.Duration) (map[string]*metricData, bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case c.requestLock <- struct{}{}:
+	case <-expired:
+		return nil, false
+	}
+	select {
+	case c.requestChannel <- request:
+	case <-c.done:
+		return map[string]*metricData{}, true
+	case <-expired:
+		c.unlockRequests()
+		return nil, false
+	}
+	select {
+	case response := <-c.responseChannel:
+		return response, true
+	case <-c.done:
+		return map[string]*metricData{}, true
+	case <-expired:
+		c.unlockRequests()
+		return nil, false
 	}
 }
 
-// createCounterVec returns a Prometheus CounterVec populated with metric details
-func createCounterVec(name, description string, objectType bool) *prometheus.CounterVec {
+// collectPrevious sends the metrics of the last collect on the channel again, as they were then. The metric data may
+// still be being updated by processMetrics, so the values are taken from their Prometheus Gauges and Counters.
+func (c *Collector) collectPrevious(ch chan<- prometheus.Metric) {
+	c.previousMutex.Lock()
+	previous := c.previous
+	c.previousMutex.Unlock()
+	for _, collector := range previous {
+		collector.Collect(ch)
+	}
+}
 
-	prefix, labels := getVecDetails(objectType)
+// collectStatus sends the queue manager status, the histograms and the metrics about the exporter itself on the
+// channel. The histograms observe the response, unless it is nil because the collect timed out, in which case the
+// request lock may not be held.
+func (c *Collector) collectStatus(ch chan<- prometheus.Metric, response map[string]*metricData) {
+
+	// Collect the queue manager status
+	c.statusGauge.WithLabelValues(c.qmLabelValue()).Set(float64(atomic.LoadInt32(&c.status)))
+	c.statusGauge.Collect(ch)
+	c.ageGauge.Collect(ch)
+	c.errorCounter.Collect(ch)
+
+	// Collect the HA role, once it is known
+	c.roleGauge.Reset()
+	if role := atomic.LoadInt32(&c.haRole); role != haRoleUnknown {
+		c.roleGauge.WithLabelValues(c.qmLabelValue(), c.host, haRoleNames[role]).Set(1)
+	}
+	c.roleGauge.Collect(ch)
+
+	// Collect the histograms of message sizes
+	// - Skip observations on first collect to avoid build-up of accumulated values
+	for _, histogram := range c.histograms {
+		if response != nil && !c.firstCollect {
+			histogram.observe(response, c.qmLabelValue(), c.staleAfter)
+		}
+		histogram.collect(ch, c.log)
+	}
+
+	// Collect the metrics about the exporter itself
+	lastError, lastCollect := float64(0), float64(0)
+	if t := atomic.LoadInt64(&c.lastErrorTime); t != 0 {
+		lastError = float64(t) / float64(time.Second)
+	}
+	if t := atomic.LoadInt64(&c.lastCollectTime); t != 0 {
+		lastCollect = float64(t) / float64(time.Second)
+	}
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.reconnects, prometheus.CounterValue, float64(atomic.LoadInt64(&c.reconnectCount)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.lastError, prometheus.GaugeValue, lastError, c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.collectDuration, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.lastCollectDuration)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.lastCollect, prometheus.GaugeValue, lastCollect, c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.collectInterval, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.lastCollectInterval)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.pcfDuration, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.lastPCFDuration)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.processDuration, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.lastProcessDuration)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.processInterval, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.processInterval)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstSummary(c.selfDescs.processSeconds, uint64(atomic.LoadInt64(&c.processCount)), time.Duration(atomic.LoadInt64(&c.processDuration)).Seconds(), nil, c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.publishInterval, prometheus.GaugeValue, time.Duration(atomic.LoadInt64(&c.publishInterval)).Seconds(), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.capped, prometheus.CounterValue, float64(atomic.LoadInt64(&c.cappedCycles)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.dropped, prometheus.CounterValue, float64(atomic.LoadInt64(&c.droppedPublications)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.published, prometheus.GaugeValue, float64(atomic.LoadInt64(&c.publishedMetrics)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.pushFailures, prometheus.CounterValue, float64(atomic.LoadInt64(&c.pushFailures)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.otlpFailures, prometheus.CounterValue, float64(atomic.LoadInt64(&c.otlpFailures)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.fileFailures, prometheus.CounterValue, float64(atomic.LoadInt64(&c.fileFailures)), c.qmLabelValue())
+	subscriptions, activeClasses := float64(0), float64(0)
+	if atomic.LoadInt32(&c.status) == 1 {
+		subscriptions = float64(atomic.LoadInt64(&c.subscriptions))
+		activeClasses = float64(atomic.LoadInt64(&c.activeClasses))
+	}
+	// The subscriptions are not reported if they could not be inquired after connecting
+	if subscriptions >= 0 {
+		ch <- prometheus.MustNewConstMetric(c.selfDescs.subscriptions, prometheus.GaugeValue, subscriptions, c.qmLabelValue())
+	}
+	// The depths of the reply queue are only reported while connected, if it could be found and inquired
+	if depth, maxDepth := atomic.LoadInt64(&c.replyQueueDepth), atomic.LoadInt64(&c.replyQueueMaxDepth); atomic.LoadInt32(&c.status) == 1 && depth >= 0 {
+		ch <- prometheus.MustNewConstMetric(c.selfDescs.replyDepth, prometheus.GaugeValue, float64(depth), c.qmLabelValue())
+		ch <- prometheus.MustNewConstMetric(c.selfDescs.replyMaxDepth, prometheus.GaugeValue, float64(maxDepth), c.qmLabelValue())
+	}
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.activeClasses, prometheus.GaugeValue, activeClasses, c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.classes, prometheus.GaugeValue, float64(atomic.LoadInt64(&c.discoveredClasses)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.lastKnown, prometheus.GaugeValue, float64(atomic.LoadInt64(&c.servingLastKnown)), c.qmLabelValue())
+	ch <- prometheus.MustNewConstMetric(c.selfDescs.timedOut, prometheus.GaugeValue, float64(atomic.LoadInt32(&c.timedOut)), c.qmLabelValue())
+}
+
+// getUnit returns the unit of the metric with the given fully-qualified name, or an empty string if it has no unit
+func (c *Collector) getUnit(name string) string {
+	c.lockRequests()
+	defer c.unlockRequests()
+	return c.units[name]
+}
+
+// getCounterVec returns the Prometheus Counter for a delta type metric, allocating it if the metric has not been
+// described, for example because the queue manager was not connected when the collector was registered
+func (c *Collector) getCounterVec(key string, metric *metricData) *prometheus.CounterVec {
+	counterVec, ok := c.counterMap[key]
+	if !ok {
+		c.addUnit(metric)
+		counterVec = createCounterVec(c.namespace, c.constLabels, c.qmLabel, metric)
+		c.counterMap[key] = counterVec
+	}
+	return counterVec
+}
+
+// getGaugeVec returns the Prometheus Gauge for a non-delta type metric, allocating it if the metric has not been described
+func (c *Collector) getGaugeVec(key string, metric *metricData) *prometheus.GaugeVec {
+	gaugeVec, ok := c.gaugeMap[key]
+	if !ok {
+		c.addUnit(metric)
+		gaugeVec = createGaugeVec(c.namespace, c.constLabels, c.qmLabel, metric)
+		c.gaugeMap[key] = gaugeVec
+	}
+	return gaugeVec
+}
+
+// addUnit records the unit of a metric, which is reported in OpenMetrics format
+func (c *Collector) addUnit(metric *metricData) {
+	if metric.unit != "" {
+		c.units[getFullName(c.namespace, metric)] = metric.unit
+	}
+}
+
+// createCounterVec returns a Prometheus CounterVec populated with metric details, the given constant labels, and the
+// queue manager label with the given name
+func createCounterVec(metricNamespace string, constLabels prometheus.Labels, qmLabel string, metric *metricData) *prometheus.CounterVec {
+
+	prefix, labels := getVecDetails(metric)
+	labels = withQMgrLabel(labels, qmLabel)
 
 	counterVec := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Namespace: namespace,
-			Name:      prefix + "_" + name,
-			Help:      description,
+			Namespace:   metricNamespace,
+			Name:        prefix + "_" + metric.name,
+			Help:        getHelp(metric),
+			ConstLabels: constLabels,
 		},
 		labels,
 	)
 	return counterVec
 }
 
-// createGaugeVec returns a Prometheus GaugeVec populated with metric details
-func createGaugeVec(name, description string, objectType bool) *prometheus.GaugeVec {
+// createGaugeVec returns a Prometheus GaugeVec populated with metric details, the given constant labels, and the
+// queue manager label with the given name
+func createGaugeVec(metricNamespace string, constLabels prometheus.Labels, qmLabel string, metric *metricData) *prometheus.GaugeVec {
 
-	prefix, labels := getVecDetails(objectType)
+	prefix, labels := getVecDetails(metric)
+	labels = withQMgrLabel(labels, qmLabel)
 
 	gaugeVec := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Namespace: namespace,
-			Name:      prefix + "_" + name,
-			Help:      description,
+			Namespace:   metricNamespace,
+			Name:        prefix + "_" + metric.name,
+			Help:        getHelp(metric),
+			ConstLabels: constLabels,
 		},
 		labels,
 	)
 	return gaugeVec
 }
 
+// newSelfDesc returns the description of a metric about the exporter itself, with the given constant labels and the
+// queue manager label with the given name
+func newSelfDesc(metricNamespace string, constLabels prometheus.Labels, qmLabel, name, description string) *prometheus.Desc {
+	return prometheus.NewDesc(metricNamespace+"_"+exporterPrefix+"_"+name, description, []string{qmLabel}, constLabels)
+}
+
+// getHelp returns the help text of a metric, including its unit if it has one
+func getHelp(metric *metricData) string {
+	if metric.unit == "" {
+		return metric.description
+	}
+	return metric.description + " (" + metric.unit + ")"
+}
+
+// getFullName returns the fully-qualified name of a metric, as exported to Prometheus
+func getFullName(metricNamespace string, metric *metricData) string {
+	prefix, _ := getVecDetails(metric)
+	return metricNamespace + "_" + prefix + "_" + metric.name
+}
+
 // getVecDetails returns the required prefix and labels for a metric
-func getVecDetails(objectType bool) (prefix string, labels []string) {
+func getVecDetails(metric *metricData) (prefix string, labels []string) {
 
 	prefix = qmgrPrefix
 	labels = []string{qmgrLabel}
 
-	if objectType {
+	if metric.objectType {
 		prefix = objectPrefix
 		labels = []string{objectLabel, qmgrLabel}
+
+		// Metrics for other types of object have their own prefix and labels
+		if metric.objectLabels != nil {
+			prefix = metric.objectPrefix
+			labels = append(append([]string{}, metric.objectLabels...), qmgrLabel)
+		}
 	}
 	return prefix, labels
 }
+
+// withQMgrLabel returns a copy of the labels of a metric, from getVecDetails, with the queue manager label, which is
+// always the last, given the configured name
+func withQMgrLabel(labels []string, qmLabel string) []string {
+	renamed := append([]string{}, labels...)
+	renamed[len(renamed)-1] = qmLabel
+	return renamed
+}
+
+// qmLabelValue returns the value of the queue manager label of every series, which is the configured alias, if set,
+// or the name of the queue manager metrics are being gathered from
+func (c *Collector) qmLabelValue() string {
+	if c.qmAlias != "" {
+		return c.qmAlias
+	}
+	return c.getQMName()
+}
+
+// getQMName returns the name of the queue manager metrics are being gathered from
+func (c *Collector) getQMName() string {
+	c.targetMutex.RLock()
+	defer c.targetMutex.RUnlock()
+	return c.qmName
+}
+
+// ensurePresent creates the value of a queue manager metric which is always present, if it does not already have one.
+// A new gauge has a value of 0, and a new counter a count of 0, until they are set or increased.
+func (c *Collector) ensurePresent(vec *prometheus.MetricVec, labels []string) {
+	labelValues, err := getLabelValues(qmgrLabelValue, c.qmLabelValue(), len(labels))
+	if err != nil {
+		return
+	}
+	// #nosec G104 - the label values always match the labels of the metric
+	vec.GetMetricWithLabelValues(labelValues...)
+}
+
+// getLabelValues returns the values of the labels for a metric value with the given label, for a metric with
+// the given number of labels, including the queue manager label
+// - queue manager metrics only have a value for the queue manager itself, labelled by qmgrLabelValue
+// - values for objects with more than one label have their label values joined by labelSeparator
+// An error is returned for a label which does not match the labels of the metric, rather than reporting the
+// value against the wrong series.
+func getLabelValues(label, qmName string, count int) ([]string, error) {
+	if count == 1 {
+		if label != qmgrLabelValue {
+			return nil, fmt.Errorf("Unexpected object label '%s' for a queue manager metric", label)
+		}
+		return []string{qmName}, nil
+	}
+	if label == qmgrLabelValue {
+		return nil, fmt.Errorf("Unexpected queue manager label for an object metric")
+	}
+	values := strings.SplitN(label, labelSeparator, count-1)
+	if len(values) != count-1 {
+		return nil, fmt.Errorf("Label '%s' has %d values, expected %d", label, len(values), count-1)
+	}
+	return append(values, qmName), nil
+}
@@ -16,9 +16,14 @@ limitations under the License.
 package metrics
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ibm-messaging/mq-container/pkg/logger"
 	"github.com/ibm-messaging/mq-golang/ibmmq"
 	"github.com/ibm-messaging/mq-golang/mqmetric"
 	"github.com/prometheus/client_golang/prometheus"
@@ -39,35 +44,86 @@ func testDescribe(t *testing.T, isDelta bool) {
 	defer teardownTestCase()
 	log := getTestLogger()
 
+	collector := newCollector("qmName", getTestConfig(), log)
 	ch := make(chan *prometheus.Desc)
 	go func() {
-		exporter := newExporter("qmName", log)
-		exporter.Describe(ch)
+		collector.Describe(ch)
+		close(ch)
 	}()
 
-	collect := <-requestChannel
-	if collect {
+	request := <-collector.requestChannel
+	if request.collect {
 		t.Errorf("Received unexpected collect request")
 	}
 
 	if isDelta {
 		mqmetric.Metrics.Classes[0].Types[0].Elements[0].Datatype = ibmmq.MQIAMO_MONITOR_DELTA
 	}
-	metrics, _ := initialiseMetrics(log)
-	responseChannel <- metrics
+	metrics, _ := initialiseMetrics(log, &metricsConfig{})
+	collector.responseChannel <- metrics
 
 	select {
 	case prometheusDesc := <-ch:
+		described := map[string]bool{prometheusDesc.String(): true}
+		// Wait for describe to complete
+		for prometheusDesc := range ch {
+			described[prometheusDesc.String()] = true
+		}
 		expected := "Desc{fqName: \"ibmmq_qmgr_" + testElement1Name + "\", help: \"" + testElement1Description + "\", constLabels: {}, variableLabels: [qmgr]}"
-		actual := prometheusDesc.String()
-		if actual != expected {
-			t.Errorf("Expected value=%s; actual %s", expected, actual)
+		if !described[expected] {
+			t.Errorf("Expected value=%s; actual %v", expected, described)
 		}
 	case <-time.After(1 * time.Second):
 		t.Error("Did not receive channel response from describe")
 	}
 }
 
+func TestDescribe_Disconnected(t *testing.T) {
+
+	log := getTestLogger()
+	collector := newCollector("qmName", getTestConfig(), log)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(ch)
+		close(ch)
+	}()
+
+	// Respond with no metrics, as before the first connection to the queue manager
+	<-collector.requestChannel
+	collector.responseChannel <- map[string]*metricData{}
+
+	described := make(map[string]bool)
+	for prometheusDesc := range ch {
+		described[prometheusDesc.String()] = true
+	}
+	expected := "Desc{fqName: \"ibmmq_qmgr_" + testElement1Name + "\", help: \"" + testElement1Description + "\", constLabels: {}, variableLabels: [qmgr]}"
+	if !described[expected] {
+		t.Errorf("Expected %s to be described; actual %v", expected, described)
+	}
+	for desc := range described {
+		if strings.Contains(desc, "ibmmq_queue_") {
+			t.Errorf("Expected no queue metrics to be described without queues configured; actual %s", desc)
+		}
+	}
+
+	// The metrics are allocated when they are first collected
+	collected := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(collected)
+		close(collected)
+	}()
+	<-collector.requestChannel
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	metrics, _ := initialiseMetrics(log, &metricsConfig{})
+	collector.responseChannel <- metrics
+	for range collected {
+	}
+	if _, ok := collector.gaugeMap[testKey1]; !ok {
+		t.Errorf("Expected a gauge to be allocated for %s", testKey1)
+	}
+}
+
 func TestCollect_Counter(t *testing.T) {
 	testCollect(t, true)
 }
@@ -82,23 +138,23 @@ func testCollect(t *testing.T, isDelta bool) {
 	defer teardownTestCase()
 	log := getTestLogger()
 
-	exporter := newExporter("qmName", log)
+	collector := newCollector("qmName", getTestConfig(), log)
 	if isDelta {
-		exporter.counterMap[testKey1] = createCounterVec(testElement1Name, testElement1Description, false)
+		collector.counterMap[testKey1] = createCounterVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
 	} else {
-		exporter.gaugeMap[testKey1] = createGaugeVec(testElement1Name, testElement1Description, false)
+		collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
 	}
 
 	for i := 1; i <= 3; i++ {
 
 		ch := make(chan prometheus.Metric)
 		go func() {
-			exporter.Collect(ch)
+			collector.Collect(ch)
 			close(ch)
 		}()
 
-		collect := <-requestChannel
-		if !collect {
+		request := <-collector.requestChannel
+		if !request.collect {
 			t.Errorf("Received unexpected describe request")
 		}
 
@@ -106,19 +162,22 @@ func testCollect(t *testing.T, isDelta bool) {
 		if isDelta {
 			mqmetric.Metrics.Classes[0].Types[0].Elements[0].Datatype = ibmmq.MQIAMO_MONITOR_DELTA
 		}
-		metrics, _ := initialiseMetrics(log)
+		metrics, _ := initialiseMetrics(log, &metricsConfig{})
 		updateMetrics(metrics)
-		responseChannel <- metrics
+		collector.responseChannel <- metrics
 
 		select {
 		case <-ch:
+			// Wait for collect to complete
+			for range ch {
+			}
 			var actual float64
 			prometheusMetric := dto.Metric{}
 			if isDelta {
-				exporter.counterMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
+				collector.counterMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
 				actual = prometheusMetric.GetCounter().GetValue()
 			} else {
-				exporter.gaugeMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
+				collector.gaugeMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
 				actual = prometheusMetric.GetGauge().GetValue()
 			}
 
@@ -139,10 +198,418 @@ func testCollect(t *testing.T, isDelta bool) {
 	}
 }
 
+func TestCollect_Status(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	collector := newCollector("qmName", getTestConfig(), getTestLogger())
+
+	for _, status := range []int32{1, 0} {
+		atomic.StoreInt32(&collector.status, status)
+
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		<-collector.requestChannel
+		collector.responseChannel <- map[string]*metricData{}
+
+		collected := 0
+		for range ch {
+			collected++
+		}
+		// The status metric, and the twenty-two metrics about the exporter itself
+		if collected != 23 {
+			t.Errorf("Expected only the status and exporter metrics to be collected; actual %d metrics", collected)
+		}
+
+		prometheusMetric := dto.Metric{}
+		collector.statusGauge.WithLabelValues("qmName").Write(&prometheusMetric)
+		if actual := prometheusMetric.GetGauge().GetValue(); actual != float64(status) {
+			t.Errorf("Expected status=%d; actual %f", status, actual)
+		}
+	}
+}
+
+func TestCollect_ExporterMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	collector := newCollector("qmName", getTestConfig(), getTestLogger())
+	atomic.StoreInt64(&collector.reconnectCount, 3)
+	atomic.StoreInt64(&collector.lastErrorTime, int64(1500*time.Second))
+	atomic.StoreInt64(&collector.lastCollectDuration, int64(250*time.Millisecond))
+	atomic.StoreInt64(&collector.lastCollectTime, int64(1200*time.Second))
+	atomic.StoreInt64(&collector.lastCollectInterval, int64(15*time.Second))
+	atomic.StoreInt64(&collector.lastProcessDuration, int64(500*time.Millisecond))
+	atomic.StoreInt64(&collector.processDuration, int64(2*time.Second))
+	atomic.StoreInt64(&collector.processCount, 8)
+	atomic.StoreInt64(&collector.publishedMetrics, 12)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- map[string]*metricData{}
+
+	values := make(map[*prometheus.Desc]*dto.Metric)
+	for metric := range ch {
+		prometheusMetric := dto.Metric{}
+		metric.Write(&prometheusMetric)
+		values[metric.Desc()] = &prometheusMetric
+	}
+
+	if actual := values[collector.selfDescs.reconnects].GetCounter().GetValue(); actual != 3 {
+		t.Errorf("Expected reconnects=%d; actual %f", 3, actual)
+	}
+	if actual := values[collector.selfDescs.lastError].GetGauge().GetValue(); actual != 1500 {
+		t.Errorf("Expected last error timestamp=%d; actual %f", 1500, actual)
+	}
+	if actual := values[collector.selfDescs.collectDuration].GetGauge().GetValue(); actual != 0.25 {
+		t.Errorf("Expected collect duration=%f; actual %f", 0.25, actual)
+	}
+	if actual := values[collector.selfDescs.lastCollect].GetGauge().GetValue(); actual != 1200 {
+		t.Errorf("Expected last collect timestamp=%d; actual %f", 1200, actual)
+	}
+	if actual := values[collector.selfDescs.collectInterval].GetGauge().GetValue(); actual != 15 {
+		t.Errorf("Expected collect interval=%d; actual %f", 15, actual)
+	}
+	if actual := values[collector.selfDescs.processDuration].GetGauge().GetValue(); actual != 0.5 {
+		t.Errorf("Expected process duration=%f; actual %f", 0.5, actual)
+	}
+	summary := values[collector.selfDescs.processSeconds].GetSummary()
+	if summary.GetSampleCount() != 8 || summary.GetSampleSum() != 2 {
+		t.Errorf("Expected process count=%d, sum=%f; actual %d, %f", 8, 2.0, summary.GetSampleCount(), summary.GetSampleSum())
+	}
+	if actual := values[collector.selfDescs.published].GetGauge().GetValue(); actual != 12 {
+		t.Errorf("Expected published metrics=%d; actual %f", 12, actual)
+	}
+	if actual := values[collector.selfDescs.goroutines].GetGauge().GetValue(); actual < 1 {
+		t.Errorf("Expected goroutines to be at least 1; actual %f", actual)
+	}
+	if actual := collector.getUnit(namespace + "_exporter_collect_duration_seconds"); actual != "seconds" {
+		t.Errorf("Expected unit=%s; actual %s", "seconds", actual)
+	}
+}
+
+func TestCollect_QMgrLabel(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := getTestConfig()
+	cfg.qmLabelName, cfg.qmLabelValue = "queue_manager", "payments"
+	collector := newCollector("QM1", cfg, getTestLogger())
+	collector.firstCollect = false
+	collector.recordError(&ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN})
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- map[string]*metricData{
+		testKey1: {name: testElement1Name, values: map[string]float64{qmgrLabelValue: 1}, lastUpdate: time.Now()},
+		testKey2: {name: testElement2Name, objectType: true, values: map[string]float64{"APP.IN": 2}, lastUpdate: time.Now()},
+	}
+
+	// Every series has the queue manager label with the configured name and value
+	count := 0
+	for metric := range ch {
+		prometheusMetric := dto.Metric{}
+		metric.Write(&prometheusMetric)
+		labels := make(map[string]string)
+		for _, label := range prometheusMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["queue_manager"] != "payments" {
+			t.Errorf("Expected %s label=%s; actual %v", "queue_manager", "payments", labels)
+		}
+		if _, ok := labels[qmgrLabel]; ok {
+			t.Errorf("Expected no %s label; actual %v", qmgrLabel, labels)
+		}
+		count++
+	}
+	if count < 3 {
+		t.Errorf("Expected at least %d series; actual %d", 3, count)
+	}
+}
+
+func TestCollect_Errors(t *testing.T) {
+
+	collector := newCollector("qmName", getTestConfig(), getTestLogger())
+	collector.recordError(&ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN})
+	collector.recordError(&ibmmq.MQReturn{MQCC: ibmmq.MQCC_FAILED, MQRC: ibmmq.MQRC_CONNECTION_BROKEN})
+	collector.recordError(fmt.Errorf("MQCONN: MQCC = MQCC_FAILED [2] MQRC = MQRC_NOT_AUTHORIZED [2035]"))
+	// Errors without a reason code are not counted
+	collector.recordError(fmt.Errorf("connection refused"))
+
+	ch := make(chan prometheus.Metric, 3)
+	collector.errorCounter.Collect(ch)
+	close(ch)
+	counts := make(map[string]float64)
+	for metric := range ch {
+		prometheusMetric := dto.Metric{}
+		metric.Write(&prometheusMetric)
+		labels := make(map[string]string)
+		for _, label := range prometheusMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels[qmgrLabel] != "qmName" {
+			t.Errorf("Expected %s label=%s; actual %s", qmgrLabel, "qmName", labels[qmgrLabel])
+		}
+		counts[labels[reasonLabel]] = prometheusMetric.GetCounter().GetValue()
+	}
+	expected := map[string]float64{"2009": 2, "2035": 1}
+	if len(counts) != len(expected) || counts["2009"] != 2 || counts["2035"] != 1 {
+		t.Errorf("Expected error counts=%v; actual %v", expected, counts)
+	}
+	if atomic.LoadInt64(&collector.lastErrorTime) == 0 {
+		t.Error("Expected the time of the last error to be recorded")
+	}
+}
+
+func TestCollect_Stale(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := getTestConfig()
+	cfg.staleAfter = time.Minute
+	collector := newCollector("qmName", cfg, getTestLogger())
+	collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
+	collector.firstCollect = false
+
+	metrics := map[string]*metricData{
+		testKey1: {
+			name:       testElement1Name,
+			values:     map[string]float64{qmgrLabelValue: 1},
+			lastUpdate: time.Now().Add(-2 * time.Minute),
+		},
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- metrics
+	for range ch {
+	}
+
+	prometheusMetric := dto.Metric{}
+	collector.ageGauge.WithLabelValues("ibmmq_qmgr_"+testElement1Name, "qmName").Write(&prometheusMetric)
+	if age := prometheusMetric.GetGauge().GetValue(); age < 120 {
+		t.Errorf("Expected publication age of at least %d seconds; actual %f", 120, age)
+	}
+
+	collected := make(chan prometheus.Metric, 1)
+	collector.gaugeMap[testKey1].Collect(collected)
+	close(collected)
+	for range collected {
+		t.Error("Expected stale metric values not to be collected")
+	}
+}
+
+func TestCollect_LastKnown(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := getTestConfig()
+	cfg.staleAfter = time.Minute
+	collector := newCollector("qmName", cfg, getTestLogger())
+	collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
+	collector.firstCollect = false
+	atomic.StoreInt64(&collector.servingLastKnown, 1)
+
+	metrics := map[string]*metricData{
+		testKey1: {
+			name:       testElement1Name,
+			values:     map[string]float64{qmgrLabelValue: 1},
+			lastUpdate: time.Now().Add(-2 * time.Minute),
+		},
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- metrics
+	lastKnown := false
+	for m := range ch {
+		if m.Desc() == collector.selfDescs.lastKnown {
+			prometheusMetric := dto.Metric{}
+			m.Write(&prometheusMetric)
+			lastKnown = prometheusMetric.GetGauge().GetValue() == 1
+		}
+	}
+	if !lastKnown {
+		t.Errorf("Expected %s=%d", lastKnownName, 1)
+	}
+
+	// The last-known values are collected however old they are, and their age is still reported
+	prometheusMetric := dto.Metric{}
+	collector.gaugeMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
+	if value := prometheusMetric.GetGauge().GetValue(); value != 1 {
+		t.Errorf("Expected value=%d; actual %f", 1, value)
+	}
+	collector.ageGauge.WithLabelValues("ibmmq_qmgr_"+testElement1Name, "qmName").Write(&prometheusMetric)
+	if age := prometheusMetric.GetGauge().GetValue(); age < 120 {
+		t.Errorf("Expected publication age of at least %d seconds; actual %f", 120, age)
+	}
+}
+
+func TestCollect_Timeout(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := getTestConfig()
+	cfg.collectTimeout = 50 * time.Millisecond
+	collector := newCollector("qmName", cfg, log)
+	collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
+	collector.firstCollect = false
+
+	// collectValues collects the metrics, and returns the value of the test metric and of the timed out marker
+	collectValues := func(respond func()) (float64, float64) {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		respond()
+		value, timedOut := float64(-1), float64(-1)
+		for m := range ch {
+			prometheusMetric := dto.Metric{}
+			m.Write(&prometheusMetric)
+			if m.Desc() == collector.selfDescs.timedOut {
+				timedOut = prometheusMetric.GetGauge().GetValue()
+			} else if strings.Contains(m.Desc().String(), testElement1Name) {
+				value = prometheusMetric.GetGauge().GetValue()
+			}
+		}
+		return value, timedOut
+	}
+
+	value, timedOut := collectValues(func() {
+		<-collector.requestChannel
+		collector.responseChannel <- map[string]*metricData{
+			testKey1: {
+				name:       testElement1Name,
+				values:     map[string]float64{qmgrLabelValue: 1},
+				lastUpdate: time.Now(),
+			},
+		}
+	})
+	if value != 1 || timedOut != 0 {
+		t.Errorf("Expected value=%d, %s=%d; actual %v, %v", 1, timedOutName, 0, value, timedOut)
+	}
+
+	// The request is received, but no response is sent, as when an inquiry has hung
+	value, timedOut = collectValues(func() { <-collector.requestChannel })
+	if value != 1 || timedOut != 1 {
+		t.Errorf("Expected value=%d, %s=%d after the response timed out; actual %v, %v", 1, timedOutName, 1, value, timedOut)
+	}
+	if !strings.Contains(buf.String(), "did not complete within 50ms") {
+		t.Errorf("Expected a warning that the collect timed out; actual log %s", buf.String())
+	}
+
+	// The request is not received, as processMetrics is still busy
+	value, timedOut = collectValues(func() {})
+	if value != 1 || timedOut != 1 {
+		t.Errorf("Expected value=%d, %s=%d after the request timed out; actual %v, %v", 1, timedOutName, 1, value, timedOut)
+	}
+
+	// Another request holds the request lock, for example while waiting for a hung inquiry
+	collector.lockRequests()
+	value, timedOut = collectValues(func() {})
+	collector.unlockRequests()
+	if value != 1 || timedOut != 1 {
+		t.Errorf("Expected value=%d, %s=%d after waiting for the request lock timed out; actual %v, %v", 1, timedOutName, 1, value, timedOut)
+	}
+
+	// The response has no metrics map, as publications are still being processed, which is not a timeout
+	value, timedOut = collectValues(func() {
+		<-collector.requestChannel
+		collector.responseChannel <- nil
+	})
+	if value != 1 || timedOut != 0 {
+		t.Errorf("Expected value=%d, %s=%d while publications are being processed; actual %v, %v", 1, timedOutName, 0, value, timedOut)
+	}
+}
+
+func TestCollect_UnexpectedLabel(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	collector := newCollector("qmName", getTestConfig(), log)
+	collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
+	collector.firstCollect = false
+
+	// A value for an object is not reported against the queue manager
+	metrics := map[string]*metricData{
+		testKey1: {
+			name:       testElement1Name,
+			values:     map[string]float64{qmgrLabelValue: 1, "APP.IN": 5},
+			lastUpdate: time.Now(),
+		},
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- metrics
+	for range ch {
+	}
+
+	prometheusMetric := dto.Metric{}
+	collector.gaugeMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
+	if actual := prometheusMetric.GetGauge().GetValue(); actual != 1 {
+		t.Errorf("Expected value=%d; actual %f", 1, actual)
+	}
+	expected := "Skipping value of metric ibmmq_qmgr_" + testElement1Name + ": Unexpected object label 'APP.IN' for a queue manager metric"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log message containing '%s'; actual %s", expected, buf.String())
+	}
+}
+
+func TestGetLabelValues_Unexpected(t *testing.T) {
+
+	labels := map[string]int{
+		"APP.IN":              1,
+		"APP.TO.QM2|10.0.0.1": 1,
+		qmgrLabelValue:        2,
+		"APP.SVRCONN":         3,
+	}
+	for label, count := range labels {
+		if values, err := getLabelValues(label, "QM1", count); err == nil {
+			t.Errorf("Expected an error for label %s with %d labels; actual values %v", label, count, values)
+		}
+	}
+}
+
 func TestCreateCounterVec(t *testing.T) {
 
 	ch := make(chan *prometheus.Desc)
-	counterVec := createCounterVec("MetricName", "MetricDescription", false)
+	counterVec := createCounterVec(namespace, nil, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription"})
 	go func() {
 		counterVec.Describe(ch)
 	}()
@@ -155,16 +622,65 @@ func TestCreateCounterVec(t *testing.T) {
 	}
 }
 
+func TestCreateCounterVec_Prefix(t *testing.T) {
+
+	ch := make(chan *prometheus.Desc)
+	cfg := metricsConfig{prefix: "prod"}
+	counterVec := createCounterVec(cfg.metricNamespace(), nil, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription"})
+	go func() {
+		counterVec.Describe(ch)
+	}()
+	description := <-ch
+
+	expected := "Desc{fqName: \"prod_ibmmq_qmgr_MetricName\", help: \"MetricDescription\", constLabels: {}, variableLabels: [qmgr]}"
+	actual := description.String()
+	if actual != expected {
+		t.Errorf("Expected value=%s; actual %s", expected, actual)
+	}
+}
+
+func TestCreateGaugeVec_ConstLabels(t *testing.T) {
+
+	ch := make(chan *prometheus.Desc)
+	gaugeVec := createGaugeVec(namespace, prometheus.Labels{"region": "eu"}, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription"})
+	go func() {
+		gaugeVec.Describe(ch)
+	}()
+	description := <-ch
+
+	expected := "Desc{fqName: \"ibmmq_qmgr_MetricName\", help: \"MetricDescription\", constLabels: {region=\"eu\"}, variableLabels: [qmgr]}"
+	actual := description.String()
+	if actual != expected {
+		t.Errorf("Expected value=%s; actual %s", expected, actual)
+	}
+}
+
+func TestCreateGaugeVec_Unit(t *testing.T) {
+
+	ch := make(chan *prometheus.Desc)
+	gaugeVec := createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: "MetricName_seconds", description: "MetricDescription", unit: "seconds"})
+	go func() {
+		gaugeVec.Describe(ch)
+	}()
+	description := <-ch
+
+	expected := "Desc{fqName: \"ibmmq_qmgr_MetricName_seconds\", help: \"MetricDescription (seconds)\", constLabels: {}, variableLabels: [qmgr]}"
+	actual := description.String()
+	if actual != expected {
+		t.Errorf("Expected value=%s; actual %s", expected, actual)
+	}
+}
+
 func TestCreateCounterVec_ObjectLabel(t *testing.T) {
 
 	ch := make(chan *prometheus.Desc)
-	counterVec := createCounterVec("MetricName", "MetricDescription", true)
+	counterVec := createCounterVec(namespace, nil, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription", objectType: true})
 	go func() {
 		counterVec.Describe(ch)
 	}()
 	description := <-ch
 
-	expected := "Desc{fqName: \"ibmmq_object_MetricName\", help: \"MetricDescription\", constLabels: {}, variableLabels: [object qmgr]}"
+	expected := "Desc{fqName: \"ibmmq_queue_MetricName\", help: \"MetricDescription\", constLabels: {}, variableLabels: [queue qmgr]}"
 	actual := description.String()
 	if actual != expected {
 		t.Errorf("Expected value=%s; actual %s", expected, actual)
@@ -174,7 +690,7 @@ func TestCreateCounterVec_ObjectLabel(t *testing.T) {
 func TestCreateGaugeVec(t *testing.T) {
 
 	ch := make(chan *prometheus.Desc)
-	gaugeVec := createGaugeVec("MetricName", "MetricDescription", false)
+	gaugeVec := createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription"})
 	go func() {
 		gaugeVec.Describe(ch)
 	}()
@@ -190,13 +706,13 @@ func TestCreateGaugeVec(t *testing.T) {
 func TestCreateGaugeVec_ObjectLabel(t *testing.T) {
 
 	ch := make(chan *prometheus.Desc)
-	gaugeVec := createGaugeVec("MetricName", "MetricDescription", true)
+	gaugeVec := createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: "MetricName", description: "MetricDescription", objectType: true})
 	go func() {
 		gaugeVec.Describe(ch)
 	}()
 	description := <-ch
 
-	expected := "Desc{fqName: \"ibmmq_object_MetricName\", help: \"MetricDescription\", constLabels: {}, variableLabels: [object qmgr]}"
+	expected := "Desc{fqName: \"ibmmq_queue_MetricName\", help: \"MetricDescription\", constLabels: {}, variableLabels: [queue qmgr]}"
 	actual := description.String()
 	if actual != expected {
 		t.Errorf("Expected value=%s; actual %s", expected, actual)
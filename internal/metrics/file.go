@@ -0,0 +1,117 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// fileWarningInterval is the minimum time between warnings that the metrics could not be written to the file, so that
+// a full or read-only file system does not flood the log - it can be replaced in tests
+var fileWarningInterval = time.Minute
+
+// writeMetricsFile writes the metrics gathered by the given gatherer to the configured file at each interval, until
+// stop is closed, then writes them once more before closing done
+func (c *Collector) writeMetricsFile(cfg *metricsConfig, gatherer prometheus.Gatherer, stop <-chan struct{}, done chan<- struct{}) {
+	sendPeriodically(cfg.fileInterval, c.jitter.offset(cfg.fileInterval), stop, done, func() bool {
+		err := c.writeFile(cfg, gatherer)
+		if err != nil {
+			c.recordFileFailure(cfg, err)
+			return false
+		}
+		return true
+	})
+}
+
+// writeFile gathers the metrics, which makes a collect request as a scrape does, and replaces the file with them in
+// the Prometheus text format, or with the JSON snapshot of the metrics updated by that request. The file is replaced
+// by renaming a temporary file in the same directory, so that it is never read while partly written.
+func (c *Collector) writeFile(cfg *metricsConfig, gatherer prometheus.Gatherer) error {
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("Failed to collect metrics: %v", err)
+	}
+	var buf bytes.Buffer
+	if cfg.fileFormat == fileFormatJSON {
+		timeout := time.Duration(atomic.LoadInt64(&c.collectTimeout))
+		response, ok := c.requestWithin(describeRequest, timeout)
+		if !ok {
+			return fmt.Errorf("The metrics were not available within %v", timeout)
+		}
+		snapshot := makeSnapshot(c.qmLabelValue(), c.namespace, response, c.digits)
+		c.unlockRequests()
+		err = json.NewEncoder(&buf).Encode(snapshot)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, family := range families {
+			_, err = expfmt.MetricFamilyToText(&buf, family)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(cfg.file), "."+filepath.Base(cfg.file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	// The file is readable by the agent which picks it up, which may run as another user
+	if err == nil {
+		// #nosec G302
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), cfg.file)
+	}
+	if err != nil {
+		// #nosec G104
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// recordFileFailure counts a failure to write the metrics to the file, and logs a warning with the number of failures
+// since the last warning, at most once in each fileWarningInterval
+func (c *Collector) recordFileFailure(cfg *metricsConfig, err error) {
+	failures := atomic.AddInt64(&c.fileFailures, 1)
+	if !c.lastFileWarning.IsZero() && time.Since(c.lastFileWarning) < fileWarningInterval {
+		return
+	}
+	c.eventLog("file_error").Printf("Metrics Warning: Failed to write metrics to %s %d times since the last warning: %v", cfg.file, failures-c.failuresAtWarning, err)
+	c.lastFileWarning, c.failuresAtWarning = time.Now(), failures
+}
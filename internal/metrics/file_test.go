@@ -0,0 +1,139 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteMetricsFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics-file")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge"}))
+
+	cfg := getTestConfig()
+	cfg.file, cfg.fileInterval, cfg.fileFormat = filepath.Join(dir, "metrics.prom"), time.Hour, fileFormatText
+	c := newCollector("QM1", cfg, getTestLogger())
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.writeMetricsFile(cfg, registry, stop, done)
+
+	// The final values are written when stopping, without waiting for the interval
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the final write")
+	}
+
+	contents, err := ioutil.ReadFile(cfg.file)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !strings.Contains(string(contents), "test_gauge 0") {
+		t.Errorf("Expected the metrics in the text format; actual %s", contents)
+	}
+	if failures := atomic.LoadInt64(&c.fileFailures); failures != 0 {
+		t.Errorf("Expected fileFailures=%d; actual %d", 0, failures)
+	}
+
+	// The temporary file is renamed, so only the metrics file is left in the directory
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected only the metrics file; actual %v, %v", files, err)
+	}
+}
+
+func TestWriteFile_JSON(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics-file")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := getTestConfig()
+	cfg.file, cfg.fileFormat = filepath.Join(dir, "metrics.json"), fileFormatJSON
+	c := newCollector("QM1", cfg, getTestLogger())
+	go func() {
+		request := <-c.requestChannel
+		if request.collect {
+			t.Errorf("Received unexpected collect request")
+		}
+		c.responseChannel <- map[string]*metricData{
+			testKey1: {name: testElement1Name, description: testElement1Description, values: map[string]float64{qmgrLabelValue: 3}},
+		}
+	}()
+
+	err = c.writeFile(cfg, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	contents, err := ioutil.ReadFile(cfg.file)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	snapshot := map[string]metricSnapshot{}
+	err = json.Unmarshal(contents, &snapshot)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if actual := snapshot[testKey1].Values["QM1"]; actual != 3 {
+		t.Errorf("Expected value=%d for label QM1; actual %v", 3, snapshot[testKey1].Values)
+	}
+}
+
+func TestWriteMetricsFile_Failures(t *testing.T) {
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := getTestConfig()
+	cfg.file, cfg.fileInterval, cfg.fileFormat = filepath.Join("/nonexistent", "metrics.prom"), 10*time.Millisecond, fileFormatText
+	c := newCollector("QM1", cfg, log)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.writeMetricsFile(cfg, prometheus.NewRegistry(), stop, done)
+
+	// Failed writes are retried, and counted, but only the first is logged within the warning interval
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&c.fileFailures) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	if failures := atomic.LoadInt64(&c.fileFailures); failures < 3 {
+		t.Errorf("Expected fileFailures>=%d; actual %d", 3, failures)
+	}
+	if count := strings.Count(buf.String(), "Failed to write metrics"); count != 1 {
+		t.Errorf("Expected one warning within the warning interval; actual %d in %s", count, buf.String())
+	}
+}
@@ -0,0 +1,97 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/ibm-messaging/mq-container/internal/command"
+)
+
+// Roles of the instance of the queue manager which runs locally, for a multi-instance or Native HA queue manager
+const (
+	haRoleUnknown int32 = iota
+	haRoleActive
+	haRoleStandby
+	haRoleReplica
+)
+
+// haRoleNames are the values of the role label of the HA role metric, indexed by role
+var haRoleNames = []string{"unknown", "active", "standby", "replica"}
+
+// inquireRole is replaced in tests
+var inquireRole = doInquireRole
+
+// dspmqStatus matches the status of the queue manager in the output of dspmq
+var dspmqStatus = regexp.MustCompile(`STATUS\(([^)]*)\)`)
+
+// doInquireRole returns the role of the local instance of the queue manager, from its status reported by dspmq, or
+// unknown for a status which does not have a role, such as a queue manager which is starting or has ended. dspmq is run
+// once for each check, and its status parsed for each of the roles.
+func doInquireRole(qmName string) (int32, error) {
+	out, _, err := command.Run("dspmq", "-n", "-m", qmName)
+	if err != nil {
+		return haRoleUnknown, err
+	}
+	return parseRole(out), nil
+}
+
+// parseRole returns the role of the queue manager from its status in the output of dspmq, or unknown if the status
+// is not found or does not have a role
+func parseRole(out string) int32 {
+	match := dspmqStatus.FindStringSubmatch(out)
+	if match == nil {
+		return haRoleUnknown
+	}
+	switch match[1] {
+	case "RUNNING":
+		return haRoleActive
+	case "RUNNING AS STANDBY":
+		return haRoleStandby
+	case "REPLICA":
+		return haRoleReplica
+	}
+	return haRoleUnknown
+}
+
+// checkRole checks the role of the queue manager before connecting to it, and returns an error unless it is running
+// locally as the active instance, as it does not accept connections as a standby or replica instance, or while it is
+// starting, ending or running elsewhere. A change in role is logged. The role is unknown in client mode, as the
+// instance connected to is chosen by the connection name.
+func (c *Collector) checkRole() error {
+
+	if c.cfg.clientMode {
+		return nil
+	}
+	role, err := inquireRole(c.qmName)
+	if err != nil {
+		c.log.Debugf("Metrics: Unable to check the role of queue manager %s: %v", c.qmName, err)
+	}
+	if previous := atomic.SwapInt32(&c.haRole, role); previous != role && role != haRoleUnknown {
+		c.eventLog("ha_role").Printf("Metrics: Queue manager %s is running as the %s instance", c.qmName, haRoleNames[role])
+	}
+	if role == haRoleStandby || role == haRoleReplica {
+		return fmt.Errorf("Queue manager %s is running as the %s instance, so metrics gathering waits for it to become active", c.qmName, haRoleNames[role])
+	}
+	if role != haRoleActive {
+		return fmt.Errorf("Queue manager %s is not running, so metrics gathering waits for it to start", c.qmName)
+	}
+	return nil
+}
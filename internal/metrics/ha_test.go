@@ -0,0 +1,201 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		out  string
+		role int32
+	}{
+		{"QMNAME(QM1)                                               STATUS(RUNNING)\n", haRoleActive},
+		{"QMNAME(QM1)                                               STATUS(RUNNING AS STANDBY)\n", haRoleStandby},
+		{"QMNAME(QM1)                                               STATUS(REPLICA)\n", haRoleReplica},
+		{"QMNAME(QM1)                                               STATUS(RUNNING ELSEWHERE)\n", haRoleUnknown},
+		{"QMNAME(QM1)                                               STATUS(ENDED IMMEDIATELY)\n", haRoleUnknown},
+		{"AMQ7048E: The queue manager name is either not valid or not known.\n", haRoleUnknown},
+	}
+	for _, test := range tests {
+		if role := parseRole(test.out); role != test.role {
+			t.Errorf("Expected role=%s for '%s'; actual %s", haRoleNames[test.role], test.out, haRoleNames[role])
+		}
+	}
+}
+
+func TestProcessMetrics_Standby(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var connects int32
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		atomic.AddInt32(&connects, 1)
+		return newQMConnection(), nil
+	}
+
+	// The queue manager runs as the standby instance until it is made active
+	var active int32
+	inquireRole = func(qmName string) (int32, error) {
+		if atomic.LoadInt32(&active) == 1 {
+			return haRoleActive, nil
+		}
+		return haRoleStandby, nil
+	}
+
+	cfg := getTestConfig()
+	cfg.requestTimeout = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	// Requests are still responded to while waiting, with the role of the instance
+	time.Sleep(50 * time.Millisecond)
+	c.lockRequests()
+	metrics := c.request(collectRequest)
+	c.unlockRequests()
+	if len(metrics) != 0 {
+		t.Errorf("Expected no metrics while standby; actual %d", len(metrics))
+	}
+	if actual := atomic.LoadInt32(&connects); actual != 0 {
+		t.Errorf("Expected no attempts to connect while standby; actual %d", actual)
+	}
+	if actual := atomic.LoadInt64(&c.reconnectCount); actual != 0 {
+		t.Errorf("Expected waiting for the standby not to count as reconnecting; actual %d", actual)
+	}
+	if actual := collectRole(c, func() {}); actual != "standby" {
+		t.Errorf("Expected role=%s; actual %s", "standby", actual)
+	}
+
+	atomic.StoreInt32(&active, 1)
+	select {
+	case <-c.started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected metrics gathering to start once the queue manager is active")
+	}
+	if actual := collectRole(c, func() {}); actual != "active" {
+		t.Errorf("Expected role=%s; actual %s", "active", actual)
+	}
+}
+
+func TestProcessMetrics_Starting(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var connects int32
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		atomic.AddInt32(&connects, 1)
+		return newQMConnection(), nil
+	}
+
+	// The queue manager has no role until it has started, as when dspmq reports it as STARTING
+	var running int32
+	inquireRole = func(qmName string) (int32, error) {
+		if atomic.LoadInt32(&running) == 1 {
+			return haRoleActive, nil
+		}
+		return haRoleUnknown, nil
+	}
+
+	cfg := getTestConfig()
+	cfg.requestTimeout = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	// Waiting for the queue manager to start is not a failed attempt to connect
+	time.Sleep(50 * time.Millisecond)
+	if actual := atomic.LoadInt32(&connects); actual != 0 {
+		t.Errorf("Expected no attempts to connect while starting; actual %d", actual)
+	}
+	if actual := atomic.LoadInt64(&c.reconnectCount); actual != 0 {
+		t.Errorf("Expected waiting for the queue manager to start not to count as reconnecting; actual %d", actual)
+	}
+	if actual := atomic.LoadInt64(&c.lastErrorTime); actual != 0 {
+		t.Errorf("Expected no error while starting; actual lastErrorTime %d", actual)
+	}
+
+	atomic.StoreInt32(&running, 1)
+	select {
+	case <-c.started:
+	case <-time.After(time.Second):
+		t.Fatal("Expected metrics gathering to start once the queue manager is running")
+	}
+}
+
+func TestCollect_UnknownRole(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// The role is not reported until it is known, for example in client mode
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	actual := collectRole(c, func() {
+		<-c.requestChannel
+		c.responseChannel <- map[string]*metricData{}
+	})
+	if actual != "" {
+		t.Errorf("Expected no role; actual %s", actual)
+	}
+}
+
+// collectRole collects the metrics, and returns the role reported by the HA role metric, or an empty string if it
+// is not reported
+func collectRole(c *Collector, respond func()) string {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	respond()
+	role := ""
+	for m := range ch {
+		if !strings.Contains(m.Desc().String(), haRoleName) {
+			continue
+		}
+		prometheusMetric := dto.Metric{}
+		m.Write(&prometheusMetric)
+		for _, label := range prometheusMetric.GetLabel() {
+			if label.GetName() == roleLabel {
+				role = label.GetValue()
+			}
+		}
+	}
+	return role
+}
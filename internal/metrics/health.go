@@ -0,0 +1,107 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// CollectorState is the state of the connection used for gathering metrics
+type CollectorState string
+
+const (
+	// StateNeverConnected is reported until the first connection to the queue manager succeeds
+	StateNeverConnected CollectorState = "never-connected"
+	// StateConnected is reported while connected to the queue manager and processing publications
+	StateConnected CollectorState = "connected"
+	// StateErroring is reported while reconnecting after an error, or once metrics gathering has stopped because of one
+	StateErroring CollectorState = "erroring"
+	// StateStopped is reported once metrics gathering has been stopped
+	StateStopped CollectorState = "stopped"
+)
+
+// CollectorHealth describes the health of metrics gathering, separately from that of the queue manager
+type CollectorHealth struct {
+	State           CollectorState `json:"state"`
+	Ready           bool           `json:"ready"`
+	LastCollectTime time.Time      `json:"lastCollectTime"`
+	LastErrorTime   time.Time      `json:"lastErrorTime"`
+}
+
+// Health returns the health of metrics gathering. It is ready once connected to the queue manager, and at least one
+// request for metrics has succeeded.
+func Health() CollectorHealth {
+
+	stateMutex.Lock()
+	c := collector
+	stateMutex.Unlock()
+	if c == nil {
+		return CollectorHealth{State: StateNeverConnected}
+	}
+	return c.health()
+}
+
+// health returns the health of the collector, from the state maintained by processMetrics
+func (c *Collector) health() CollectorHealth {
+
+	var health CollectorHealth
+	select {
+	case <-c.done:
+		health.State = StateStopped
+		if c.err != nil {
+			health.State = StateErroring
+		}
+	default:
+		select {
+		case <-c.started:
+			health.State = StateErroring
+			if atomic.LoadInt32(&c.status) == 1 {
+				health.State = StateConnected
+			}
+		default:
+			health.State = StateNeverConnected
+		}
+	}
+	if t := atomic.LoadInt64(&c.lastCollectTime); t != 0 {
+		health.LastCollectTime = time.Unix(0, t)
+	}
+	if t := atomic.LoadInt64(&c.lastErrorTime); t != 0 {
+		health.LastErrorTime = time.Unix(0, t)
+	}
+	health.Ready = health.State == StateConnected && !health.LastCollectTime.IsZero()
+	return health
+}
+
+// newHealthHandler returns an HTTP handler which serves the health of metrics gathering as JSON, with an error
+// status until it is ready, so that it can be used by a readiness probe
+func newHealthHandler(c *Collector) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		health := c.health()
+		w.Header().Set("Content-Type", "application/json")
+		if !health.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		// #nosec G104
+		json.NewEncoder(w).Encode(health)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth_States(t *testing.T) {
+
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	checkHealth(t, "Initial", c, StateNeverConnected, false)
+
+	c.signalStarted()
+	c.status = 1
+	checkHealth(t, "Connected", c, StateConnected, false)
+
+	c.lastCollectTime = time.Now().UnixNano()
+	checkHealth(t, "Collected", c, StateConnected, true)
+
+	c.status = 0
+	c.recordError(fmt.Errorf("connection refused"))
+	health := checkHealth(t, "Reconnecting", c, StateErroring, false)
+	if health.LastErrorTime.IsZero() || health.LastCollectTime.IsZero() {
+		t.Errorf("Expected last error and collect times; actual %v, %v", health.LastErrorTime, health.LastCollectTime)
+	}
+
+	c.err = fmt.Errorf("Failed to connect")
+	close(c.done)
+	checkHealth(t, "Failed", c, StateErroring, false)
+
+	c = newCollector("qmName", getTestConfig(), getTestLogger())
+	close(c.done)
+	checkHealth(t, "Stopped", c, StateStopped, false)
+}
+
+func TestHealth_ProcessMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	<-c.started
+
+	// The collector is only ready once a collect request has succeeded
+	c.requestChannel <- describeRequest
+	<-c.responseChannel
+	checkHealth(t, "Described", c, StateConnected, false)
+	c.requestChannel <- collectRequest
+	<-c.responseChannel
+	checkHealth(t, "Collected", c, StateConnected, true)
+
+	cancel()
+	<-c.done
+	checkHealth(t, "Stopped", c, StateStopped, false)
+}
+
+func TestHealthHandler(t *testing.T) {
+
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	recorder := httptest.NewRecorder()
+	newHealthHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status=%d before connecting; actual %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	c.signalStarted()
+	c.status = 1
+	c.lastCollectTime = time.Now().UnixNano()
+	recorder = httptest.NewRecorder()
+	newHealthHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status=%d when ready; actual %d", http.StatusOK, recorder.Code)
+	}
+	var health CollectorHealth
+	err := json.Unmarshal(recorder.Body.Bytes(), &health)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if health.State != StateConnected || !health.Ready {
+		t.Errorf("Expected state=%s, ready=%v; actual %s, %v", StateConnected, true, health.State, health.Ready)
+	}
+}
+
+// checkHealth checks the state and readiness reported by the collector, and returns its health
+func checkHealth(t *testing.T, name string, c *Collector, state CollectorState, ready bool) CollectorHealth {
+	health := c.health()
+	if health.State != state || health.Ready != ready {
+		t.Errorf("%s: Expected state=%s, ready=%v; actual %s, %v", name, state, ready, health.State, health.Ready)
+	}
+	return health
+}
@@ -0,0 +1,174 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sizeHistogramMetric describes a histogram of message sizes, derived from the message and byte counts
+// published by the queue manager for each interval
+type sizeHistogramMetric struct {
+	name        string
+	description string
+	objectType  bool
+	countName   string
+	bytesName   string
+}
+
+// sizeHistogramMetrics are the histograms available for message sizes. The queue manager only publishes the
+// number of messages and the total number of bytes in each interval, rather than the size of each message,
+// so every message in an interval is counted in the bucket for the average size of messages in that interval.
+var sizeHistogramMetrics = []sizeHistogramMetric{
+	{"mqput_message_size_bytes", "Distribution of the size of messages put, estimated from the average size in each interval", false, "mqput_mqput1_total", "mqput_mqput1_bytes_total"},
+	{"destructive_get_message_size_bytes", "Distribution of the size of messages got destructively, estimated from the average size in each interval", false, "destructive_get_total", "destructive_get_bytes_total"},
+	{"mqput_message_size_bytes", "Distribution of the size of messages put, estimated from the average size in each interval", true, "mqput_mqput1_total", "mqput_bytes_total"},
+	{"mqget_message_size_bytes", "Distribution of the size of messages got, estimated from the average size in each interval", true, "mqget_total", "mqget_bytes_total"},
+}
+
+// sizeHistogram accumulates the observations for a histogram of message sizes, for each queue manager label value and
+// each of its other label values, so that the observations of queue managers which metrics were gathered from before
+// a switch are kept. They are guarded by the mutex, as the histogram is also collected by a collect which could not
+// take the request lock in time.
+type sizeHistogram struct {
+	sizeHistogramMetric
+	desc         *prometheus.Desc
+	labels       int
+	buckets      []float64
+	mutex        sync.Mutex
+	observations map[string]map[string]*sizeObservations
+}
+
+// sizeObservations are the accumulated observations of a histogram for one label value
+type sizeObservations struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+// newSizeHistograms returns the histograms of message sizes, or none if no buckets are configured.
+// Histograms for queues are only returned if queues are monitored.
+func newSizeHistograms(metricNamespace string, cfg *metricsConfig) []*sizeHistogram {
+	if len(cfg.sizeBuckets) == 0 {
+		return nil
+	}
+	var histograms []*sizeHistogram
+	for _, histogramMetric := range sizeHistogramMetrics {
+		if histogramMetric.objectType && cfg.queues == "" {
+			continue
+		}
+		metric := &metricData{name: histogramMetric.name, objectType: histogramMetric.objectType}
+		_, labels := getVecDetails(metric)
+		labels = withQMgrLabel(labels, cfg.qmgrLabelName())
+		histograms = append(histograms, &sizeHistogram{
+			sizeHistogramMetric: histogramMetric,
+			desc:                prometheus.NewDesc(getFullName(metricNamespace, metric), histogramMetric.description+" (bytes)", labels, cfg.labels),
+			labels:              len(labels),
+			buckets:             cfg.sizeBuckets,
+			observations:        make(map[string]map[string]*sizeObservations),
+		})
+	}
+	return histograms
+}
+
+// observe adds the messages counted in the latest interval to the histogram of the queue manager, using the message
+// and byte counts in the response. Counts which are missing, or have not been updated within the staleness window, are
+// skipped.
+func (h *sizeHistogram) observe(response map[string]*metricData, qmName string, staleAfter time.Duration) {
+
+	counts := findMetric(response, h.countName, h.objectType)
+	bytes := findMetric(response, h.bytesName, h.objectType)
+	if counts == nil || bytes == nil {
+		return
+	}
+	for _, metric := range []*metricData{counts, bytes} {
+		if metric.lastUpdate.IsZero() || time.Since(metric.lastUpdate) > staleAfter {
+			return
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for label, count := range counts.values {
+		if count < 1 {
+			continue
+		}
+		size, ok := bytes.values[label]
+		if !ok {
+			continue
+		}
+		if h.observations[qmName] == nil {
+			h.observations[qmName] = make(map[string]*sizeObservations)
+		}
+		observations, ok := h.observations[qmName][label]
+		if !ok {
+			observations = &sizeObservations{buckets: make(map[float64]uint64)}
+			h.observations[qmName][label] = observations
+		}
+		// Buckets are cumulative, so the messages are counted in every bucket at least as large as the average
+		average := size / count
+		observations.count += uint64(count)
+		observations.sum += size
+		for _, bucket := range h.buckets {
+			if average <= bucket {
+				observations.buckets[bucket] += uint64(count)
+			}
+		}
+	}
+}
+
+// collect provides the accumulated histogram for each queue manager and label value
+func (h *sizeHistogram) collect(ch chan<- prometheus.Metric, log *logger.Logger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for qmName, qmObservations := range h.observations {
+		for label, observations := range qmObservations {
+			labelValues, err := getLabelValues(label, qmName, h.labels)
+			if err != nil {
+				log.Errorf("Metrics Error: Skipping histogram observations: %v", err)
+				continue
+			}
+			buckets := make(map[float64]uint64, len(h.buckets))
+			for _, bucket := range h.buckets {
+				buckets[bucket] = observations.buckets[bucket]
+			}
+			ch <- prometheus.MustNewConstHistogram(h.desc, observations.count, observations.sum, buckets, labelValues...)
+		}
+	}
+}
+
+// reset removes the accumulated observations
+func (h *sizeHistogram) reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.observations = make(map[string]map[string]*sizeObservations)
+}
+
+// findMetric returns the queue manager or queue metric with the given name from the response, or nil if it is not found
+func findMetric(response map[string]*metricData, name string, objectType bool) *metricData {
+	for _, metric := range response {
+		if metric.name == name && metric.objectType == objectType && metric.objectLabels == nil {
+			return metric
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewSizeHistograms(t *testing.T) {
+
+	histograms := newSizeHistograms(namespace, &metricsConfig{})
+	if len(histograms) != 0 {
+		t.Errorf("Expected histograms-size=%d; actual %d", 0, len(histograms))
+	}
+
+	histograms = newSizeHistograms(namespace, &metricsConfig{sizeBuckets: []float64{1024}})
+	if len(histograms) != 2 {
+		t.Errorf("Expected histograms-size=%d; actual %d", 2, len(histograms))
+	}
+
+	histograms = newSizeHistograms(namespace, &metricsConfig{sizeBuckets: []float64{1024}, queues: "APP.*"})
+	if len(histograms) != len(sizeHistogramMetrics) {
+		t.Errorf("Expected histograms-size=%d; actual %d", len(sizeHistogramMetrics), len(histograms))
+	}
+}
+
+func TestSizeHistogram_Observe(t *testing.T) {
+
+	histogram := newSizeHistograms(namespace, &metricsConfig{sizeBuckets: []float64{100, 1000, 10000}})[0]
+	now := time.Now()
+	response := map[string]*metricData{
+		"count": {name: histogram.countName, values: map[string]float64{qmgrLabelValue: 4}, lastUpdate: now},
+		"bytes": {name: histogram.bytesName, values: map[string]float64{qmgrLabelValue: 2000}, lastUpdate: now},
+	}
+
+	// The four messages have an average size of 500 bytes
+	histogram.observe(response, "qmName", time.Minute)
+	// The ten messages have an average size of 50 bytes
+	response["count"].values[qmgrLabelValue] = 10
+	response["bytes"].values[qmgrLabelValue] = 500
+	histogram.observe(response, "qmName", time.Minute)
+	// No messages are counted in an empty interval
+	response["count"].values[qmgrLabelValue] = 0
+	response["bytes"].values[qmgrLabelValue] = 0
+	histogram.observe(response, "qmName", time.Minute)
+
+	result := collectHistogram(t, histogram)
+	if result.GetSampleCount() != 14 {
+		t.Errorf("Expected count=%d; actual %d", 14, result.GetSampleCount())
+	}
+	if result.GetSampleSum() != 2500 {
+		t.Errorf("Expected sum=%v; actual %v", 2500, result.GetSampleSum())
+	}
+	expected := map[float64]uint64{100: 10, 1000: 14, 10000: 14}
+	for _, bucket := range result.GetBucket() {
+		if bucket.GetCumulativeCount() != expected[bucket.GetUpperBound()] {
+			t.Errorf("Expected bucket %v count=%d; actual %d", bucket.GetUpperBound(), expected[bucket.GetUpperBound()], bucket.GetCumulativeCount())
+		}
+	}
+}
+
+func TestSizeHistogram_ObserveStale(t *testing.T) {
+
+	histogram := newSizeHistograms(namespace, &metricsConfig{sizeBuckets: []float64{100}})[0]
+	response := map[string]*metricData{
+		"count": {name: histogram.countName, values: map[string]float64{qmgrLabelValue: 4}, lastUpdate: time.Now().Add(-time.Hour)},
+		"bytes": {name: histogram.bytesName, values: map[string]float64{qmgrLabelValue: 2000}, lastUpdate: time.Now()},
+	}
+	histogram.observe(response, "qmName", time.Minute)
+	if len(histogram.observations) != 0 {
+		t.Errorf("Expected no observations for stale metric; actual %v", histogram.observations)
+	}
+
+	// Queue metrics are not used for the queue manager histograms
+	response["count"].lastUpdate = time.Now()
+	response["count"].objectType = true
+	histogram.observe(response, "qmName", time.Minute)
+	if len(histogram.observations) != 0 {
+		t.Errorf("Expected no observations for queue metric; actual %v", histogram.observations)
+	}
+}
+
+func TestSizeHistogram_QueueLabels(t *testing.T) {
+
+	histograms := newSizeHistograms(namespace, &metricsConfig{sizeBuckets: []float64{100}, queues: "APP.*"})
+	histogram := histograms[len(histograms)-1]
+	now := time.Now()
+	response := map[string]*metricData{
+		"count": {name: histogram.countName, objectType: true, values: map[string]float64{"APP.IN": 1, "APP.OUT": 2}, lastUpdate: now},
+		"bytes": {name: histogram.bytesName, objectType: true, values: map[string]float64{"APP.IN": 50, "APP.OUT": 400}, lastUpdate: now},
+	}
+	histogram.observe(response, "qmName", time.Minute)
+
+	ch := make(chan prometheus.Metric, 2)
+	histogram.collect(ch, getTestLogger())
+	close(ch)
+	counts := make(map[string]uint64)
+	for metric := range ch {
+		prometheusMetric := dto.Metric{}
+		metric.Write(&prometheusMetric)
+		labels := make(map[string]string)
+		for _, label := range prometheusMetric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels[qmgrLabel] != "qmName" {
+			t.Errorf("Expected %s label=%s; actual %s", qmgrLabel, "qmName", labels[qmgrLabel])
+		}
+		counts[labels[objectLabel]] = prometheusMetric.GetHistogram().GetBucket()[0].GetCumulativeCount()
+	}
+	if len(counts) != 2 || counts["APP.IN"] != 1 || counts["APP.OUT"] != 0 {
+		t.Errorf("Expected bucket counts=%v; actual %v", map[string]uint64{"APP.IN": 1, "APP.OUT": 0}, counts)
+	}
+
+	histogram.reset()
+	if len(histogram.observations) != 0 {
+		t.Errorf("Expected no observations after reset; actual %v", histogram.observations)
+	}
+}
+
+// collectHistogram returns the single histogram collected for the queue manager
+func collectHistogram(t *testing.T, histogram *sizeHistogram) *dto.Histogram {
+	ch := make(chan prometheus.Metric, 1)
+	histogram.collect(ch, getTestLogger())
+	close(ch)
+	metric, ok := <-ch
+	if !ok {
+		t.Fatal("Expected histogram not collected")
+	}
+	prometheusMetric := dto.Metric{}
+	metric.Write(&prometheusMetric)
+	return prometheusMetric.GetHistogram()
+}
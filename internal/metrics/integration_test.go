@@ -0,0 +1,178 @@
+//go:build integration
+// +build integration
+
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/internal/command"
+)
+
+// The integration tests run the collector against a queue manager in a container started from the MQ Advanced for
+// Developers image given by TEST_IMAGE, using the container command given by COMMAND, or docker by default. They
+// need the MQ client, so they are only built with the integration tag.
+const (
+	integrationQMName   = "QM1"
+	integrationPassword = "passw0rd"
+	integrationQueue    = "DEV.QUEUE.1"
+	integrationTimeout  = 3 * time.Minute
+)
+
+func TestIntegration_Collect(t *testing.T) {
+
+	image := os.Getenv("TEST_IMAGE")
+	if image == "" {
+		t.Skip("TEST_IMAGE is not set")
+	}
+	containerCommand := os.Getenv("COMMAND")
+	if containerCommand == "" {
+		containerCommand = "docker"
+	}
+	port, remove := startIntegrationContainer(t, containerCommand, image)
+	defer remove()
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userFile, passwordFile := filepath.Join(dir, "user"), filepath.Join(dir, "password")
+	for file, value := range map[string]string{userFile: "admin", passwordFile: integrationPassword} {
+		err = ioutil.WriteFile(file, []byte(value), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := loadConfigWithEnv(map[string]string{
+		clientModeEnv:   "true",
+		connNameEnv:     "localhost(" + port + ")",
+		channelEnv:      "DEV.ADMIN.SVRCONN",
+		userFileEnv:     userFile,
+		passwordFileEnv: passwordFile,
+		depthQueuesEnv:  "DEV.*",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	// The queue manager is still starting when the container has started, so connecting is retried until it is ready
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector(integrationQMName, cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	select {
+	case <-c.started:
+	case <-time.After(integrationTimeout):
+		t.Fatalf("Timed out connecting to queue manager %s", integrationQMName)
+	}
+
+	// The published metrics have values once the first publications arrive, after the statistics interval
+	var missing []string
+	deadline := time.Now().Add(integrationTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		c.lockRequests()
+		metrics := c.request(collectRequest)
+		missing = checkIntegrationMetrics(t, c.namespace, metrics)
+		c.unlockRequests()
+		if len(missing) == 0 {
+			return
+		}
+	}
+	t.Errorf("Expected metrics with values; missing %s", strings.Join(missing, ", "))
+}
+
+// startIntegrationContainer starts a queue manager in a container from the image, which is removed when the test
+// ends, and returns the host port of its listener and a function to remove the container
+func startIntegrationContainer(t *testing.T, containerCommand, image string) (string, func()) {
+
+	out, _, err := command.Run(containerCommand, "run", "--detach", "--publish-all",
+		"--env", "LICENSE=accept",
+		"--env", "MQ_QMGR_NAME="+integrationQMName,
+		"--env", "MQ_ADMIN_PASSWORD="+integrationPassword,
+		image)
+	if err != nil {
+		t.Fatalf("Failed to start a container from image %s: %v: %s", image, err, out)
+	}
+	id := strings.TrimSpace(out)
+	remove := func() {
+		// #nosec G104
+		command.Run(containerCommand, "rm", "--force", id)
+	}
+
+	// The port is given as ADDRESS:PORT, for each address the listener is published on
+	out, _, err = command.Run(containerCommand, "port", id, "1414/tcp")
+	if err != nil {
+		remove()
+		t.Fatalf("Failed to find the listener port of container %s: %v: %s", id, err, out)
+	}
+	address := strings.Split(strings.TrimSpace(out), "\n")[0]
+	return address[strings.LastIndex(address, ":")+1:], remove
+}
+
+// checkIntegrationMetrics checks the values of a selection of metrics which any queue manager has, and returns the
+// names of those which do not have a value yet. Values which are not plausible are reported as errors.
+func checkIntegrationMetrics(t *testing.T, metricNamespace string, metrics map[string]*metricData) []string {
+
+	checks := []struct {
+		name  string
+		label string
+		valid func(float64) bool
+	}{
+		{"ibmmq_qmgr_user_cpu_time_percentage", qmgrLabelValue, func(v float64) bool { return v >= 0 && v <= 100 }},
+		{"ibmmq_qmgr_ram_free_percentage", qmgrLabelValue, func(v float64) bool { return v > 0 && v <= 100 }},
+		{"ibmmq_qmgr_log_in_use_bytes", qmgrLabelValue, func(v float64) bool { return v > 0 }},
+		{"ibmmq_qmgr_uptime_seconds", qmgrLabelValue, func(v float64) bool { return v > 0 }},
+		{"ibmmq_qmgr_command_server_running", qmgrLabelValue, func(v float64) bool { return v == 1 }},
+		{"ibmmq_class_active", "CPU", func(v float64) bool { return v == 1 }},
+		{"ibmmq_queue_current_depth", integrationQueue, func(v float64) bool { return v == 0 }},
+	}
+	byName := make(map[string]*metricData, len(metrics))
+	for _, metric := range metrics {
+		byName[getFullName(metricNamespace, metric)] = metric
+	}
+
+	var missing []string
+	for _, check := range checks {
+		metric, ok := byName[check.name]
+		if !ok {
+			missing = append(missing, check.name)
+			continue
+		}
+		value, ok := metric.values[check.label]
+		if !ok {
+			missing = append(missing, check.name)
+			continue
+		}
+		if !check.valid(value) {
+			t.Errorf("Expected a plausible value of %s{%s}; actual %v", check.name, check.label, value)
+		}
+	}
+	return missing
+}
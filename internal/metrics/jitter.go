@@ -0,0 +1,92 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// jitter spreads out the activity of many exporters started at the same time, such as the replicas of a
+// StatefulSet, so that they do not all connect, process publications and push metrics at once. The zero value
+// disables it.
+type jitter struct {
+	// startDelay is the delay before connecting for the first time
+	startDelay time.Duration
+	// phase is the fraction of each interval, from 0 up to 1, by which periodic cycles are offset
+	phase float64
+}
+
+// newJitter returns a random start delay of up to max, and a random phase, or no jitter if max is zero. The random
+// values are seeded from the host name and the queue manager name, so that each instance has its own delay and
+// phase, which stay the same when it is restarted.
+func newJitter(qmName string, max time.Duration) jitter {
+	if max <= 0 {
+		return jitter{}
+	}
+	// #nosec G104 - an instance without a host name is still seeded by the queue manager name
+	hostname, _ := os.Hostname()
+	hash := fnv.New64a()
+	// #nosec G104 - writing to a hash cannot fail
+	hash.Write([]byte(hostname + "/" + qmName))
+	// #nosec G404 - the jitter does not need to be cryptographically secure
+	random := rand.New(rand.NewSource(int64(hash.Sum64())))
+	return jitter{
+		startDelay: time.Duration(random.Int63n(int64(max) + 1)),
+		phase:      random.Float64(),
+	}
+}
+
+// offset returns the time by which a cycle with the given interval is offset, from zero up to the interval
+func (j jitter) offset(interval time.Duration) time.Duration {
+	return time.Duration(j.phase * float64(interval))
+}
+
+// waitToStart waits for the start delay before connecting for the first time, and returns false if the context is
+// cancelled meanwhile. Requests for metrics are responded to with no metrics while waiting, so that the queue manager
+// status is still reported. Switching queue manager or reloading the configuration ends the wait, so that it connects
+// straight away, and returns true for switching - the result of connecting must then be sent on switchResult.
+func (c *Collector) waitToStart(ctx context.Context) (started bool, switching bool) {
+
+	if c.jitter.startDelay == 0 {
+		return true, false
+	}
+	c.log.Printf("Metrics: Waiting %v before connecting to queue manager %s", c.jitter.startDelay, c.qmName)
+	start := time.After(c.jitter.startDelay)
+	for {
+		select {
+		case <-c.requestChannel:
+			c.respond(map[string]*metricData{})
+		case t := <-c.switchChannel:
+			c.eventLog("switch").Printf("Switching metrics gathering from queue manager %s to %s", c.qmName, t.qmName)
+			c.setTarget(t)
+			return true, true
+		case cfg := <-c.reloadChannel:
+			c.eventLog("reload").Printf("Reloading metrics configuration for queue manager %s", c.qmName)
+			c.cfg = cfg
+			return true, true
+		case <-start:
+			return true, false
+		case <-ctx.Done():
+			return false, false
+		}
+	}
+}
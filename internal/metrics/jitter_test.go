@@ -0,0 +1,100 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewJitter(t *testing.T) {
+
+	if j := newJitter("QM1", 0); j != (jitter{}) || j.offset(time.Minute) != 0 {
+		t.Errorf("Expected no jitter when disabled; actual %+v", j)
+	}
+
+	// Each instance has its own delay and phase, which are the same each time it starts
+	j := newJitter("QM1", time.Minute)
+	if j.startDelay < 0 || j.startDelay > time.Minute || j.phase < 0 || j.phase >= 1 {
+		t.Errorf("Expected startDelay up to %v, and phase from 0 up to 1; actual %v, %v", time.Minute, j.startDelay, j.phase)
+	}
+	if again := newJitter("QM1", time.Minute); again != j {
+		t.Errorf("Expected jitter=%+v; actual %+v", j, again)
+	}
+	if other := newJitter("QM2", time.Minute); other == j {
+		t.Errorf("Expected different jitter for another queue manager; actual %+v", other)
+	}
+
+	if offset := (jitter{phase: 0.25}).offset(time.Minute); offset != 15*time.Second {
+		t.Errorf("Expected offset=%v; actual %v", 15*time.Second, offset)
+	}
+}
+
+func TestWaitToStart(t *testing.T) {
+
+	c := newCollector("QM1", getTestConfig(), getTestLogger())
+	c.jitter = jitter{startDelay: 100 * time.Millisecond}
+	waited := make(chan bool, 1)
+	go func() {
+		started, _ := c.waitToStart(context.Background())
+		waited <- started
+	}()
+
+	// Requests are responded to with no metrics while waiting
+	c.requestChannel <- collectRequest
+	if response := <-c.responseChannel; len(response) != 0 {
+		t.Errorf("Expected no metrics while waiting to start; actual %d", len(response))
+	}
+	select {
+	case started := <-waited:
+		if !started {
+			t.Error("Expected to start after the delay")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting to start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.jitter = jitter{startDelay: time.Hour}
+	if started, _ := c.waitToStart(ctx); started {
+		t.Error("Expected not to start after the context was cancelled")
+	}
+}
+
+func TestWaitToStart_Switch(t *testing.T) {
+
+	c := newCollector("QM1", getTestConfig(), getTestLogger())
+	c.jitter = jitter{startDelay: time.Hour}
+	type result struct{ started, switching bool }
+	waited := make(chan result, 1)
+	go func() {
+		started, switching := c.waitToStart(context.Background())
+		waited <- result{started, switching}
+	}()
+
+	// Switching queue manager while waiting connects straight away, rather than waiting for the delay
+	c.switchChannel <- metricsTarget{qmName: "QM2"}
+	select {
+	case r := <-waited:
+		if !r.started || !r.switching || c.getQMName() != "QM2" {
+			t.Errorf("Expected started=%t, switching=%t, qmName=%s; actual %t, %t, %s", true, true, "QM2", r.started, r.switching, c.getQMName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting to start after switching")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+// CheckMetricKeys connects to the queue manager, and returns a description of each collision between the metrics
+// which it publishes, including those for objects, whatever the metric selection. Metrics gathering must not be
+// running in the same process, as it uses the same connection.
+func CheckMetricKeys(qmName string) ([]string, error) {
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+	conn, err := connectQueueManager(qmName, cfg)
+	defer endConnection(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return findKeyCollisions(cfg), nil
+}
+
+// findKeyCollisions walks all the metrics discovered on the current connection, with all the object metrics and
+// classes selected, and returns a sorted description of each collision. Metrics collide if they have the same key,
+// which identifies them in the metrics map, or if they have the same exported name, as their series would then be
+// indistinguishable. The series of an object metric are also identified by the object name, which is substituted
+// into its key, so object metrics are unique for every object as long as their keys with %s are unique.
+func findKeyCollisions(cfg *metricsConfig) []string {
+
+	allCfg := *cfg
+	allCfg.queues, allCfg.channels, allCfg.topics, allCfg.subscriptions, allCfg.depthQueues = "*", "*", []string{"#"}, "*", "*"
+	metricNamespace := allCfg.metricNamespace()
+
+	var collisions []string
+	metrics := make(map[string]*metricData)
+	mappingKeys := make(map[string]string)
+	metricNamesMap := generateMetricNamesMap()
+
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		for _, metricType := range metricClass.Types {
+			objectType := strings.Contains(metricType.ObjectTopic, "%s")
+			for _, metricElement := range metricType.Elements {
+
+				key := makeKey(metricElement)
+				mappingKey := makeMappingKey(metricElement)
+				if key == "" || mappingKey == "" {
+					// Elements with a broken parent chain are reported when the metrics are initialised
+					continue
+				}
+				if existing, exists := mappingKeys[key]; exists {
+					pair := []string{existing, mappingKey}
+					sort.Strings(pair)
+					collisions = append(collisions, fmt.Sprintf("Metrics %s and %s have the same key [%s]", pair[0], pair[1], key))
+					continue
+				}
+				mappingKeys[key] = mappingKey
+
+				// Names are found as when the metrics are initialised, and metrics which are not enabled are not exported
+				lookup, found := metricNamesMap[mappingKey]
+				if !found && objectType {
+					lookup, found = metricLookup{metricElement.MetricName, true}, true
+				}
+				if found && lookup.enabled {
+					metrics[key] = &metricData{name: lookup.name, description: metricElement.Description, objectType: objectType}
+				}
+			}
+		}
+	}
+
+	// Metrics which are not published by the queue manager can also collide with those which are
+	initialiseInfoMetric(metrics, &allCfg)
+	initialiseUptimeMetric(metrics, &allCfg)
+	initialiseCommandServerMetric(metrics, &allCfg)
+	initialiseClassMetric(metrics, &allCfg)
+	initialiseContainerMetrics(metrics, &allCfg)
+	initialiseChannelMetrics(metrics, &allCfg)
+	initialiseTopicMetrics(metrics, &allCfg)
+	initialiseQueueMetrics(metrics, &allCfg)
+
+	names := make(map[string][]string)
+	for key, metric := range metrics {
+		name := getFullName(metricNamespace, metric)
+		names[name] = append(names[name], key)
+	}
+	for name, keys := range names {
+		if len(keys) > 1 {
+			sort.Strings(keys)
+			collisions = append(collisions, fmt.Sprintf("Metrics with keys [%s] have the same name %s", strings.Join(keys, "], ["), name))
+		}
+	}
+
+	// Names which only collide when converted to snake case are made unique, but are then not as expected
+	if allCfg.snakeCase {
+		collisions = append(collisions, sanitiseMetricNames(metricNamespace, metrics)...)
+	}
+	sort.Strings(collisions)
+	return collisions
+}
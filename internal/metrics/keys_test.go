@@ -0,0 +1,51 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+func TestFindKeyCollisions(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	if collisions := findKeyCollisions(&metricsConfig{}); len(collisions) != 0 {
+		t.Errorf("Expected no collisions; actual %v", collisions)
+	}
+
+	// An unmapped object metric with the same name as a mapped object metric
+	metricType2 := mqmetric.Metrics.Classes[0].Types[1]
+	unmapped := &mqmetric.MonElement{Parent: metricType2, MetricName: generateMetricNamesMap()[testClassName+"/"+testTypeName+"/"+testElement2Description].name, Description: "Unmapped element"}
+	metricType2.Elements[1] = unmapped
+
+	// A metric of another type with the same object topic and description as an existing metric
+	metricType3 := &mqmetric.MonType{Name: "OtherType", ObjectTopic: testTopic1, Parent: mqmetric.Metrics.Classes[0]}
+	metricType3.Elements = map[int]*mqmetric.MonElement{0: {Parent: metricType3, Description: testElement1Description}}
+	mqmetric.Metrics.Classes[0].Types[2] = metricType3
+
+	expected := []string{
+		"Metrics " + testClassName + "/OtherType/" + testElement1Description + " and " + testMappingKey1 + " have the same key [" + testKey1 + "]",
+		"Metrics with keys [" + testTopic2 + "/" + testElement2Description + "], [" + testTopic2 + "/Unmapped element] have the same name ibmmq_queue_" + unmapped.MetricName,
+	}
+	if actual := findKeyCollisions(&metricsConfig{}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected collisions=%v; actual %v", expected, actual)
+	}
+}
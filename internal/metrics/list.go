@@ -0,0 +1,109 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+// MetricInfo describes a metric which is available from the queue manager, without its values
+type MetricInfo struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unit        string `json:"unit,omitempty"`
+	Type        string `json:"type"`
+	Object      bool   `json:"object"`
+}
+
+// ListMetrics connects to the queue manager, and returns the metrics which it makes available with the
+// current configuration, sorted by key. Metrics gathering must not be running in the same process,
+// as it uses the same connection.
+func ListMetrics(qmName string, log *logger.Logger) ([]MetricInfo, error) {
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+	conn, err := connectQueueManager(qmName, cfg)
+	defer endConnection(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metrics with unexpected keys are logged by initialiseMetrics, and left out of the list
+	// #nosec G104
+	metrics, _ := initialiseMetrics(cfg.logger(log), cfg)
+	return listMetrics(cfg.metricNamespace(), metrics), nil
+}
+
+// listMetrics returns the details of the metrics in the metrics map, sorted by key
+func listMetrics(metricNamespace string, metrics map[string]*metricData) []MetricInfo {
+
+	list := make([]MetricInfo, 0, len(metrics))
+	for key, metric := range metrics {
+		metricType := "gauge"
+		if metric.isDelta {
+			metricType = "counter"
+		}
+		list = append(list, MetricInfo{
+			Key:         key,
+			Name:        getFullName(metricNamespace, metric),
+			Description: metric.description,
+			Unit:        metric.unit,
+			Type:        metricType,
+			Object:      metric.objectType,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// newListHandler returns an HTTP handler which serves the details of the available metrics as JSON.
+// The metrics are only known once connected to the queue manager, so until then it responds with an error.
+func newListHandler(c *Collector) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		timeout := time.Duration(atomic.LoadInt64(&c.collectTimeout))
+		response, ok := c.requestWithin(describeRequest, timeout)
+		if !ok {
+			http.Error(w, fmt.Sprintf("The metrics were not available within %v", timeout), http.StatusServiceUnavailable)
+			return
+		}
+		list := listMetrics(c.namespace, response)
+		c.unlockRequests()
+
+		if len(list) == 0 {
+			http.Error(w, "Metrics are not available until connected to the queue manager", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListMetrics(t *testing.T) {
+
+	metrics := map[string]*metricData{
+		"Class/Type/Queue depth": {
+			name:        "depth",
+			description: "Queue depth",
+			objectType:  true,
+			values:      map[string]float64{"APP.IN": 5},
+		},
+		testKey1: {
+			name:        testElement1Name,
+			description: testElement1Description,
+			unit:        "bytes",
+			isDelta:     true,
+			values:      map[string]float64{qmgrLabelValue: 3},
+		},
+	}
+
+	list := listMetrics(namespace, metrics)
+	if len(list) != 2 {
+		t.Fatalf("Expected %d metrics; actual %d", 2, len(list))
+	}
+	if list[0].Key != "Class/Type/Queue depth" || list[1].Key != testKey1 {
+		t.Errorf("Expected keys=%v; actual %s, %s", []string{"Class/Type/Queue depth", testKey1}, list[0].Key, list[1].Key)
+	}
+	expected := MetricInfo{Key: testKey1, Name: "ibmmq_qmgr_" + testElement1Name, Description: testElement1Description, Unit: "bytes", Type: "counter"}
+	if list[1] != expected {
+		t.Errorf("Expected metric=%+v; actual %+v", expected, list[1])
+	}
+	if list[0].Name != "ibmmq_queue_depth" || list[0].Type != "gauge" || !list[0].Object {
+		t.Errorf("Expected name=%s, type=%s, object=%v; actual %s, %s, %v", "ibmmq_queue_depth", "gauge", true, list[0].Name, list[0].Type, list[0].Object)
+	}
+}
+
+func TestListHandler(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		metrics        map[string]*metricData
+		expectedStatus int
+	}{
+		{"Connected", map[string]*metricData{testKey1: {name: testElement1Name, description: testElement1Description}}, http.StatusOK},
+		{"Disconnected", map[string]*metricData{}, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			c := newCollector("qmName", &metricsConfig{}, getTestLogger())
+			go func() {
+				request := <-c.requestChannel
+				if request.collect {
+					t.Errorf("Received unexpected collect request")
+				}
+				c.responseChannel <- test.metrics
+			}()
+
+			recorder := httptest.NewRecorder()
+			newListHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/list", nil))
+
+			if recorder.Code != test.expectedStatus {
+				t.Fatalf("Expected status=%d; actual %d", test.expectedStatus, recorder.Code)
+			}
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+			var list []MetricInfo
+			err := json.Unmarshal(recorder.Body.Bytes(), &list)
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			}
+			if len(list) != 1 || list[0].Key != testKey1 {
+				t.Errorf("Expected metric key=%s; actual %v", testKey1, list)
+			}
+		})
+	}
+}
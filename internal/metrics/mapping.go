@@ -119,6 +119,43 @@ func generateMetricNamesMap() map[string]metricLookup {
 		"STATMQI/GET/Failed MQCB count":                                           metricLookup{"failed_mqcb_total", true},
 		"STATMQI/SYNCPOINT/Commit count":                                          metricLookup{"commit_total", true},
 		"STATMQI/SYNCPOINT/Rollback count":                                        metricLookup{"rollback_total", true},
+		"STATQ/OPENCLOSE/MQOPEN count":                                            metricLookup{"mqopen_total", true},
+		"STATQ/OPENCLOSE/MQCLOSE count":                                           metricLookup{"mqclose_total", true},
+		"STATQ/INQSET/MQINQ count":                                                metricLookup{"mqinq_total", true},
+		"STATQ/INQSET/MQSET count":                                                metricLookup{"mqset_total", true},
+		"STATQ/PUT/MQPUT/MQPUT1 count":                                            metricLookup{"mqput_mqput1_total", true},
+		"STATQ/PUT/MQPUT byte count":                                              metricLookup{"mqput_bytes_total", true},
+		"STATQ/PUT/MQPUT non-persistent message count":                            metricLookup{"non_persistent_message_mqput_total", true},
+		"STATQ/PUT/MQPUT persistent message count":                                metricLookup{"persistent_message_mqput_total", true},
+		"STATQ/PUT/rolled back MQPUT count":                                       metricLookup{"rolled_back_mqput_total", true},
+		"STATQ/PUT/MQPUT1 non-persistent message count":                           metricLookup{"non_persistent_message_mqput1_total", true},
+		"STATQ/PUT/MQPUT1 persistent message count":                               metricLookup{"persistent_message_mqput1_total", true},
+		"STATQ/PUT/non-persistent byte count":                                     metricLookup{"non_persistent_message_put_bytes_total", true},
+		"STATQ/PUT/persistent byte count":                                         metricLookup{"persistent_message_put_bytes_total", true},
+		"STATQ/PUT/lock contention":                                               metricLookup{"lock_contention_percentage", true},
+		"STATQ/PUT/queue avoided puts":                                            metricLookup{"queue_avoided_puts_percentage", true},
+		"STATQ/PUT/queue avoided bytes":                                           metricLookup{"queue_avoided_bytes_percentage", true},
+		"STATQ/GET/MQGET count":                                                   metricLookup{"mqget_total", true},
+		"STATQ/GET/MQGET byte count":                                              metricLookup{"mqget_bytes_total", true},
+		"STATQ/GET/destructive MQGET non-persistent message count":                metricLookup{"non_persistent_message_destructive_get_total", true},
+		"STATQ/GET/destructive MQGET persistent message count":                    metricLookup{"persistent_message_destructive_get_total", true},
+		"STATQ/GET/destructive MQGET non-persistent byte count":                   metricLookup{"non_persistent_message_destructive_get_bytes_total", true},
+		"STATQ/GET/destructive MQGET persistent byte count":                       metricLookup{"persistent_message_destructive_get_bytes_total", true},
+		"STATQ/GET/MQGET browse non-persistent message count":                     metricLookup{"non_persistent_message_browse_total", true},
+		"STATQ/GET/MQGET browse persistent message count":                         metricLookup{"persistent_message_browse_total", true},
+		"STATQ/GET/MQGET browse non-persistent byte count":                        metricLookup{"non_persistent_message_browse_bytes_total", true},
+		"STATQ/GET/MQGET browse persistent byte count":                            metricLookup{"persistent_message_browse_bytes_total", true},
+		"STATQ/GET/destructive MQGET fails":                                       metricLookup{"failed_mqget_total", true},
+		"STATQ/GET/destructive MQGET fails with MQRC_NO_MSG_AVAILABLE":            metricLookup{"mqget_no_message_available_total", true},
+		"STATQ/GET/destructive MQGET fails with MQRC_TRUNCATED_MSG_FAILED":        metricLookup{"mqget_truncated_message_failed_total", true},
+		"STATQ/GET/MQGET browse fails":                                            metricLookup{"failed_browse_total", true},
+		"STATQ/GET/MQGET browse fails with MQRC_NO_MSG_AVAILABLE":                 metricLookup{"browse_no_message_available_total", true},
+		"STATQ/GET/MQGET browse fails with MQRC_TRUNCATED_MSG_FAILED":             metricLookup{"browse_truncated_message_failed_total", true},
+		"STATQ/GET/rolled back MQGET count":                                       metricLookup{"rolled_back_mqget_total", true},
+		"STATQ/GET/messages expired":                                              metricLookup{"expired_message_total", true},
+		"STATQ/GET/queue purged count":                                            metricLookup{"purged_total", true},
+		"STATQ/GET/average queue time":                                            metricLookup{"average_queue_time_seconds", true},
+		"STATQ/GET/Queue depth":                                                   metricLookup{"depth", true},
 	}
 	return metricNamesMap
 }
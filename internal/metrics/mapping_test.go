@@ -21,17 +21,37 @@ func TestGenerateMetricNamesMap(t *testing.T) {
 
 	metricNamesMap := generateMetricNamesMap()
 
-	if len(metricNamesMap) != 93 {
-		t.Errorf("Expected mapping-size=%d; actual %d", 93, len(metricNamesMap))
+	if len(metricNamesMap) != 130 {
+		t.Errorf("Expected mapping-size=%d; actual %d", 130, len(metricNamesMap))
 	}
 
-	actual, ok := metricNamesMap[testKey1]
+	actual, ok := metricNamesMap[testMappingKey1]
 
 	if !ok {
-		t.Errorf("No metric name mapping found for %s", testKey1)
+		t.Errorf("No metric name mapping found for %s", testMappingKey1)
 	} else {
 		if actual.name != testElement1Name {
 			t.Errorf("Expected metric name=%s; actual %s", testElement1Name, actual.name)
 		}
 	}
 }
+
+func TestGenerateMetricNamesMap_LogMetrics(t *testing.T) {
+
+	// Alerts on the recovery log filling depend on these names, so they must not change
+	expected := map[string]string{
+		"DISK/Log/Log - bytes in use":                      "log_in_use_bytes",
+		"DISK/Log/Log - bytes max":                         "log_max_bytes",
+		"DISK/Log/Log - current primary space in use":      "log_primary_space_in_use_percentage",
+		"DISK/Log/Log file system - bytes in use":          "log_file_system_in_use_bytes",
+		"DISK/Log/Log file system - bytes max":             "log_file_system_max_bytes",
+		"DISK/Log/Log - bytes required for media recovery": "log_required_for_media_recovery_bytes",
+	}
+	metricNamesMap := generateMetricNamesMap()
+	for key, name := range expected {
+		actual, ok := metricNamesMap[key]
+		if !ok || actual.name != name || !actual.enabled {
+			t.Errorf("Expected enabled metric name=%s for %s; actual %+v", name, key, actual)
+		}
+	}
+}
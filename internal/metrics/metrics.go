@@ -19,39 +19,62 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ibm-messaging/mq-container/pkg/logger"
-	"github.com/ibm-messaging/mq-container/internal/ready"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-const (
-	defaultPort = "9157"
-)
-
 var (
 	metricsEnabled = false
-	metricsServer  = &http.Server{Addr: ":" + defaultPort}
+	metricsServer  = &http.Server{}
+
+	// stateMutex guards the state used to stop metrics gathering from another goroutine
+	stateMutex    sync.Mutex
+	cancelMetrics context.CancelFunc
+	metricsDone   chan struct{}
+	collector     *Collector
+
+	// metricsDrainTimeout is the drain timeout of the running collector, which cannot be reloaded
+	metricsDrainTimeout time.Duration
+
+	// stopPushing is closed to stop pushing metrics to the Pushgateway and exporting them using OTLP, if enabled, and
+	// each of pushDone is closed when the final push or export has completed
+	stopPushing chan struct{}
+	pushDone    []chan struct{}
+
+	// failedChannel receives an error if metrics gathering gives up connecting to the queue manager
+	failedChannel = make(chan error, 1)
 )
 
-// GatherMetrics gathers metrics for the queue manager
+// Failed returns a channel which receives an error if metrics gathering stops because the maximum number of
+// attempts to connect to the queue manager has been exceeded, so that the caller can decide whether to exit
+func Failed() <-chan error {
+	return failedChannel
+}
+
+// GatherMetrics gathers metrics for the queue manager. The metrics endpoint is started straight away, even if the
+// queue manager is running locally as a standby instance or is still starting, in which case the metrics are gathered
+// once it is running as the active instance.
 func GatherMetrics(qmName string, log *logger.Logger) {
 
-	// If running in standby mode - wait until the queue manager becomes active
-	for {
-		active, _ := ready.IsRunningAsActiveQM(qmName)
-		if active {
-			break
-		}
-		time.Sleep(requestTimeout * time.Second)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Errorf("Metrics Error: Invalid metrics configuration: %v", err)
+		return
 	}
+	log = cfg.logger(log)
 
+	stateMutex.Lock()
 	metricsEnabled = true
+	stateMutex.Unlock()
 
-	err := startMetricsGathering(qmName, log)
+	err = startMetricsGathering(qmName, cfg, log)
 	if err != nil {
 		log.Errorf("Metrics Error: %s", err.Error())
 		StopMetricsGathering(log)
@@ -59,7 +82,7 @@ func GatherMetrics(qmName string, log *logger.Logger) {
 }
 
 // startMetricsGathering starts gathering metrics for the queue manager
-func startMetricsGathering(qmName string, log *logger.Logger) error {
+func startMetricsGathering(qmName string, cfg *metricsConfig, log *logger.Logger) error {
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -69,29 +92,113 @@ func startMetricsGathering(qmName string, log *logger.Logger) error {
 
 	log.Println("Starting metrics gathering")
 
-	// Start processing metrics
-	go processMetrics(log, qmName)
+	// Listen for requests before starting, so that an address which cannot be used, or a TLS configuration
+	// which cannot be loaded, is reported as an error rather than serving plain HTTP
+	tlsConfig, err := cfg.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", cfg.address())
+	if err != nil {
+		return fmt.Errorf("Failed to listen for metrics requests on %s: %v", cfg.address(), err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 
-	// Wait for metrics to be ready before starting the Prometheus handler
-	<-startChannel
+	// Start processing metrics - metrics are gathered from the targets in turn, if set, rather than the queue manager
+	// in this container
+	if len(cfg.targets) > 0 {
+		qmName = cfg.targets[0].qmName
+	}
+	c := newCollector(qmName, cfg, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	stateMutex.Lock()
+	cancelMetrics = cancel
+	metricsDone = c.done
+	collector = c
+	metricsDrainTimeout = cfg.drainTimeout
+	stateMutex.Unlock()
+	c.Start(ctx)
+	if len(cfg.targets) > 1 {
+		log.Printf("Rotating metrics gathering through %d queue managers every %v", len(cfg.targets), cfg.targetInterval)
+		go c.rotateTargets(ctx, cfg.targets, cfg.targetInterval)
+	}
+	go func() {
+		<-c.done
+		if c.err != nil {
+			log.Errorf("Metrics Error: %v", c.err)
+			StopMetricsGathering(log)
+			select {
+			case failedChannel <- c.err:
+			default:
+			}
+		}
+	}()
 
-	// Register metrics
-	metricsExporter := newExporter(qmName, log)
-	err := prometheus.Register(metricsExporter)
+	// Register metrics - the metrics which are expected to be available are described until
+	// the queue manager is connected, so this does not wait for the first connection
+	err = prometheus.Register(c)
 	if err != nil {
+		// #nosec G104
+		listener.Close()
 		return fmt.Errorf("Failed to register metrics: %v", err)
 	}
+	// Only the metrics of the collector are exported using OTLP, without the Go runtime and process metrics of the
+	// default registry
+	otlpRegistry := prometheus.NewRegistry()
+	if cfg.otlpEndpoint != "" {
+		err = otlpRegistry.Register(c)
+		if err != nil {
+			// #nosec G104
+			listener.Close()
+			return fmt.Errorf("Failed to register metrics for OTLP: %v", err)
+		}
+	}
+
+	// Push metrics to the Pushgateway, export them using OTLP, and write them to a file, if enabled, in addition to
+	// serving them
+	stop := make(chan struct{})
+	var pushing []chan struct{}
+	if cfg.pushURL != "" {
+		done := make(chan struct{})
+		pushing = append(pushing, done)
+		log.Printf("Pushing metrics to the Pushgateway every %v", cfg.pushInterval)
+		go c.pushMetrics(cfg, prometheus.DefaultGatherer, stop, done)
+	}
+	if cfg.otlpEndpoint != "" {
+		done := make(chan struct{})
+		pushing = append(pushing, done)
+		log.Printf("Exporting metrics using OTLP every %v", cfg.otlpInterval)
+		go c.exportMetrics(cfg, otlpRegistry, stop, done)
+	}
+	if cfg.file != "" {
+		done := make(chan struct{})
+		pushing = append(pushing, done)
+		log.Printf("Writing metrics to %s every %v", cfg.file, cfg.fileInterval)
+		go c.writeMetricsFile(cfg, prometheus.DefaultGatherer, stop, done)
+	}
+	if len(pushing) > 0 {
+		stateMutex.Lock()
+		stopPushing, pushDone = stop, pushing
+		stateMutex.Unlock()
+	}
 
 	// Setup HTTP server to handle requests from Prometheus
-	http.Handle("/metrics", prometheus.Handler())
+	http.Handle(cfg.path, newMetricsHandler(c))
+	http.Handle(cfg.jsonPath(), newSnapshotHandler(c))
+	http.Handle(cfg.listPath(), newListHandler(c))
+	http.Handle(cfg.refreshPath(), newRefreshHandler(c, cfg.refresh))
+	http.Handle(cfg.healthPath, newHealthHandler(c))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		// #nosec G104
 		w.Write([]byte("Status: METRICS ACTIVE"))
 	})
 
+	log.Printf("Serving metrics on %s%s", cfg.address(), cfg.path)
 	go func() {
-		err = metricsServer.ListenAndServe()
+		err := metricsServer.Serve(listener)
 		if err != nil && err != http.ErrServerClosed {
 			log.Errorf("Metrics Error: Failed to handle metrics request: %v", err)
 			StopMetricsGathering(log)
@@ -101,17 +208,71 @@ func startMetricsGathering(qmName string, log *logger.Logger) error {
 	return nil
 }
 
-// StopMetricsGathering stops gathering metrics for the queue manager
+// SwitchQueueManager switches metrics gathering to the named queue manager, for hosts running more than one
+func SwitchQueueManager(qmName string) error {
+
+	stateMutex.Lock()
+	c := collector
+	stateMutex.Unlock()
+	if c == nil {
+		return fmt.Errorf("Metrics gathering has not started")
+	}
+	return c.SwitchQueueManager(qmName)
+}
+
+// StopMetricsGathering stops gathering metrics for the queue manager, for example when the container receives
+// SIGTERM. The pending publications are processed, and the final metrics are pushed, while still connected to the
+// queue manager, then the connection is closed, and the HTTP server is shut down once any scrape in progress ends.
 func StopMetricsGathering(log *logger.Logger) {
 
-	if metricsEnabled {
+	stateMutex.Lock()
+	enabled, cancelProcessing, done, c := metricsEnabled, cancelMetrics, metricsDone, collector
+	stopPush, pushed := stopPushing, pushDone
+	metricsEnabled, stopPushing = false, nil
+	drainTimeout := metricsDrainTimeout
+	stateMutex.Unlock()
 
-		// Stop processing metrics
-		stopChannel <- true
+	if enabled {
 
-		// Shutdown HTTP server
-		timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// Allow time for pending publications to be processed before the connection is closed
+		timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second+drainTimeout)
 		defer cancel()
+
+		// Push the final metrics while still connected to the queue manager
+		flush := func() {
+			if stopPush == nil {
+				return
+			}
+			close(stopPush)
+			for _, done := range pushed {
+				select {
+				case <-done:
+				case <-timeout.Done():
+					log.Errorf("Metrics Error: Timed out waiting for the final push of metrics")
+				}
+			}
+		}
+		if c != nil {
+			err := c.Shutdown(timeout, flush)
+			if err != nil {
+				log.Errorf("Metrics Error: Timed out waiting for metrics gathering to stop gracefully")
+			}
+		} else {
+			flush()
+		}
+
+		// Stop processing metrics, if it has not already stopped, and wait for the connection to the queue manager to
+		// be closed
+		if cancelProcessing != nil {
+			cancelProcessing()
+			select {
+			case <-done:
+			case <-timeout.Done():
+				log.Errorf("Metrics Error: Timed out waiting for metrics gathering to stop")
+			}
+		}
+
+		// Shutdown HTTP server
 		err := metricsServer.Shutdown(timeout)
 		if err != nil {
 			log.Errorf("Failed to shutdown metrics server: %v", err)
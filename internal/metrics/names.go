@@ -0,0 +1,100 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const totalSuffix = "_total"
+
+// toSnakeCase returns a metric name in lower snake_case. Words in camel case are separated, characters which are
+// not valid in Prometheus metric names are replaced with underscores, and repeated underscores are collapsed.
+func toSnakeCase(name string) string {
+
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r) && r <= unicode.MaxASCII:
+			// Start a new word at an upper case letter following a lower case letter or digit, or at the
+			// last upper case letter of an acronym followed by a lower case letter, as in "MQPut"
+			if i > 0 && (isLowerOrDigit(runes[i-1]) || (unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case isLowerOrDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	// Collapse repeated underscores, and remove them from the start and end
+	parts := strings.FieldsFunc(b.String(), func(r rune) bool { return r == '_' })
+	if len(parts) == 0 {
+		return "metric"
+	}
+	return strings.Join(parts, "_")
+}
+
+// isLowerOrDigit returns true for lower case ASCII letters and digits
+func isLowerOrDigit(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+}
+
+// sanitiseMetricNames converts the names of the metrics to snake_case. If two metrics would then have the same
+// fully-qualified name, the later one in order of original name and description has a number added to its name,
+// so that the names are the same each time. A message describing each collision is returned.
+func sanitiseMetricNames(metricNamespace string, metrics map[string]*metricData) []string {
+
+	sorted := make([]*metricData, 0, len(metrics))
+	for _, metric := range metrics {
+		sorted = append(sorted, metric)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].name != sorted[j].name {
+			return sorted[i].name < sorted[j].name
+		}
+		return sorted[i].description < sorted[j].description
+	})
+
+	var collisions []string
+	used := make(map[string]bool, len(sorted))
+	for _, metric := range sorted {
+		original := metric.name
+		name := toSnakeCase(original)
+		metric.name = name
+		for n := 2; used[getFullName(metricNamespace, metric)]; n++ {
+			// Counters keep their suffix, so that they are still recognised as counters
+			metric.name = strings.TrimSuffix(name, totalSuffix) + "_" + strconv.Itoa(n)
+			if strings.HasSuffix(name, totalSuffix) {
+				metric.name += totalSuffix
+			}
+		}
+		if metric.name != name {
+			collisions = append(collisions, fmt.Sprintf("Metric %s (%s) has the same name as another metric, and is named %s", original, metric.description, metric.name))
+		}
+		used[getFullName(metricNamespace, metric)] = true
+	}
+	return collisions
+}
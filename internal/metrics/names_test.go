@@ -0,0 +1,98 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+func TestToSnakeCase(t *testing.T) {
+
+	tests := map[string]string{
+		"depth":                  "depth",
+		"mqput_mqput1_total":     "mqput_mqput1_total",
+		"MetricName":             "metric_name",
+		"MQPutCount":             "mq_put_count",
+		"elapsed.time-ms":        "elapsed_time_ms",
+		"__Lock  contention__%":  "lock_contention",
+		"queue avoided__puts":    "queue_avoided_puts",
+		"page set 0 usage":       "page_set_0_usage",
+		"Déjà vu":                "d_j_vu",
+		"%%":                     "metric",
+		"oldestMessageAge_total": "oldest_message_age_total",
+	}
+	for name, expected := range tests {
+		if actual := toSnakeCase(name); actual != expected {
+			t.Errorf("Expected name=%s for %s; actual %s", expected, name, actual)
+		}
+	}
+}
+
+func TestSanitiseMetricNames(t *testing.T) {
+
+	metrics := map[string]*metricData{
+		"key1": {name: "QueueDepth", description: "Queue depth", objectType: true},
+		"key2": {name: "queue_depth", description: "Depth of queue", objectType: true},
+		"key3": {name: "queue_depth", description: "Queue manager depth"},
+		"key4": {name: "Put.Count_total", description: "Put count", isDelta: true},
+		"key5": {name: "put_count_total", description: "Puts", isDelta: true},
+	}
+
+	collisions := sanitiseMetricNames(namespace, metrics)
+
+	// Names are disambiguated in order of original name, which puts upper case first
+	expected := map[string]string{
+		"key1": "queue_depth",
+		"key2": "queue_depth_2",
+		"key3": "queue_depth",
+		"key4": "put_count_total",
+		"key5": "put_count_2_total",
+	}
+	for key, name := range expected {
+		if metrics[key].name != name {
+			t.Errorf("Expected name=%s for %s; actual %s", name, key, metrics[key].name)
+		}
+	}
+	if len(collisions) != 2 {
+		t.Errorf("Expected %d collisions; actual %v", 2, collisions)
+	}
+}
+
+func TestInitialiseMetrics_SnakeCase(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// Object metrics without a mapping use the name generated by mqmetric
+	metricElement := mqmetric.Metrics.Classes[0].Types[1].Elements[0]
+	metricElement.Description = "Unmapped element"
+	key := testTopic2 + "/" + metricElement.Description
+
+	metricsWithout, _ := initialiseMetrics(getTestLogger(), &metricsConfig{queues: "APP.*"})
+	metrics, _ := initialiseMetrics(getTestLogger(), &metricsConfig{queues: "APP.*", snakeCase: true})
+
+	if actual := metricsWithout[key].name; actual != "Element2Name" {
+		t.Errorf("Expected name=%s by default; actual %s", "Element2Name", actual)
+	}
+	if actual := metrics[key].name; actual != "element2_name" {
+		t.Errorf("Expected name=%s; actual %s", "element2_name", actual)
+	}
+	if actual := metrics[testKey1].name; actual != testElement1Name {
+		t.Errorf("Expected name=%s; actual %s", testElement1Name, actual)
+	}
+}
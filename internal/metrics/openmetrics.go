@@ -0,0 +1,217 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	openMetricsMediaType   = "application/openmetrics-text"
+	openMetricsContentType = openMetricsMediaType + "; version=1.0.0; charset=utf-8"
+)
+
+// newMetricsHandler returns an HTTP handler which serves metrics in the OpenMetrics format if it is
+// accepted by the client, and otherwise in the Prometheus text format
+func newMetricsHandler(c *Collector) http.Handler {
+
+	prometheusHandler := prometheus.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !acceptsOpenMetrics(r.Header.Get("Accept")) {
+			prometheusHandler.ServeHTTP(w, r)
+			return
+		}
+
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred during metrics collection:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		writeOpenMetrics(&buf, families, c.getUnit)
+		w.Header().Set("Content-Type", openMetricsContentType)
+		writeBody(w, r, buf.Bytes())
+	})
+}
+
+// writeBody writes the body of a response, compressed with gzip if the request accepts it. The Prometheus text format
+// is compressed in the same way by the Prometheus handler.
+func writeBody(w http.ResponseWriter, r *http.Request, body []byte) {
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		_, err := gz.Write(body)
+		if err == nil {
+			err = gz.Close()
+		}
+		// Fall back to the uncompressed body if compressing fails
+		if err == nil {
+			w.Header().Set("Content-Encoding", "gzip")
+			body = compressed.Bytes()
+		}
+	}
+	// #nosec G104
+	w.Write(body)
+}
+
+// acceptsGzip returns true if the Accept-Encoding header of a request includes gzip, other than with a quality of zero
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				quality, err := strconv.ParseFloat(strings.TrimPrefix(parameter, "q="), 64)
+				accepted = err == nil && quality > 0
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsOpenMetrics returns true if the Accept header of a request includes the OpenMetrics media type
+func acceptsOpenMetrics(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(mediaRange, ";", 2)[0])
+		if mediaType == openMetricsMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOpenMetrics writes the metric families in the OpenMetrics text format, including the unit of each
+// metric returned by getUnit, and the terminating EOF marker
+func writeOpenMetrics(buf *bytes.Buffer, families []*dto.MetricFamily, getUnit func(string) string) {
+
+	for _, family := range families {
+
+		// The family name of a counter does not include the _total suffix, which is added to each sample
+		name := family.GetName()
+		metricType := "unknown"
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metricType = "counter"
+			name = strings.TrimSuffix(name, "_total")
+		case dto.MetricType_GAUGE:
+			metricType = "gauge"
+		case dto.MetricType_SUMMARY:
+			metricType = "summary"
+		case dto.MetricType_HISTOGRAM:
+			metricType = "histogram"
+		}
+
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+		// A unit may only be given if it is the suffix of the family name
+		if unit := getUnit(family.GetName()); unit != "" && strings.HasSuffix(name, "_"+unit) {
+			fmt.Fprintf(buf, "# UNIT %s %s\n", name, unit)
+		}
+		if family.GetHelp() != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", name, escapeOpenMetrics(family.GetHelp()))
+		}
+
+		for _, metric := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				writeSample(buf, name+"_total", metric.GetLabel(), "", "", metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				writeSample(buf, name, metric.GetLabel(), "", "", metric.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					writeSample(buf, name, metric.GetLabel(), "quantile", formatFloat(quantile.GetQuantile()), quantile.GetValue())
+				}
+				writeSample(buf, name+"_sum", metric.GetLabel(), "", "", summary.GetSampleSum())
+				writeSample(buf, name+"_count", metric.GetLabel(), "", "", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infSeen := false
+				for _, bucket := range histogram.GetBucket() {
+					infSeen = infSeen || math.IsInf(bucket.GetUpperBound(), +1)
+					writeSample(buf, name+"_bucket", metric.GetLabel(), "le", formatFloat(bucket.GetUpperBound()), float64(bucket.GetCumulativeCount()))
+				}
+				// The +Inf bucket is required by OpenMetrics
+				if !infSeen {
+					writeSample(buf, name+"_bucket", metric.GetLabel(), "le", "+Inf", float64(histogram.GetSampleCount()))
+				}
+				writeSample(buf, name+"_sum", metric.GetLabel(), "", "", histogram.GetSampleSum())
+				writeSample(buf, name+"_count", metric.GetLabel(), "", "", float64(histogram.GetSampleCount()))
+			default:
+				writeSample(buf, name, metric.GetLabel(), "", "", metric.GetUntyped().GetValue())
+			}
+		}
+	}
+	buf.WriteString("# EOF\n")
+}
+
+// writeSample writes a single sample line, with an optional additional label
+func writeSample(buf *bytes.Buffer, name string, labels []*dto.LabelPair, extraName, extraValue string, value float64) {
+
+	buf.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for _, label := range labels {
+			pairs = append(pairs, label.GetName()+"=\""+escapeOpenMetrics(label.GetValue())+"\"")
+		}
+		if extraName != "" {
+			pairs = append(pairs, extraName+"=\""+extraValue+"\"")
+		}
+		buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buf.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// escapeOpenMetrics escapes backslashes, double quotes and line feeds in label values and help text
+func escapeOpenMetrics(s string) string {
+	return strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats a value as required by OpenMetrics, including its representation of infinity
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
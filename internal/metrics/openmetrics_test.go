@@ -0,0 +1,129 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+
+	accept := map[string]bool{
+		"":           false,
+		"text/plain": false,
+		"application/openmetrics-text; version=1.0.0; charset=utf-8,text/plain;version=0.0.4;q=0.5": true,
+		"text/plain;q=0.5, application/openmetrics-text;q=0.9":                                      true,
+	}
+	for header, expected := range accept {
+		if actual := acceptsOpenMetrics(header); actual != expected {
+			t.Errorf("Expected acceptsOpenMetrics(%s)=%v; actual %v", header, expected, actual)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+
+	acceptEncoding := map[string]bool{
+		"":                      false,
+		"identity":              false,
+		"gzip":                  true,
+		"deflate, GZIP;q=0.5":   true,
+		"gzip;q=0":              false,
+		"gzip;q=0.0, *;q=0.1":   true,
+		"*":                     true,
+		"br;q=1.0, gzip;q=0.00": false,
+	}
+	for header, expected := range acceptEncoding {
+		if actual := acceptsGzip(header); actual != expected {
+			t.Errorf("Expected acceptsGzip(%s)=%v; actual %v", header, expected, actual)
+		}
+	}
+}
+
+func TestMetricsHandler_Gzip(t *testing.T) {
+
+	handler := newMetricsHandler(newCollector("qmName", getTestConfig(), getTestLogger()))
+	for _, accept := range []string{"text/plain", openMetricsContentType} {
+		for _, gzipped := range []bool{false, true} {
+			request := httptest.NewRequest("GET", "/metrics", nil)
+			request.Header.Set("Accept", accept)
+			if gzipped {
+				request.Header.Set("Accept-Encoding", "gzip")
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if actual := recorder.Header().Get("Content-Encoding"); (actual == "gzip") != gzipped {
+				t.Errorf("Expected gzip=%v for %s; actual Content-Encoding %s", gzipped, accept, actual)
+			}
+			body := io.Reader(recorder.Body)
+			if gzipped {
+				reader, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatalf("Unexpected error %s", err.Error())
+				}
+				body = reader
+			}
+			buf, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			}
+			if !strings.Contains(string(buf), "# HELP go_goroutines") {
+				t.Errorf("Expected metrics in the response for %s; actual %s", accept, string(buf))
+			}
+		}
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+
+	registry := prometheus.NewRegistry()
+	counterVec := createCounterVec(namespace, nil, qmgrLabel, &metricData{name: "log_physical_written_bytes_total", description: "Log - physical bytes written"})
+	gaugeVec := createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: "depth", description: "Queue \"depth\"", objectType: true})
+	registry.MustRegister(counterVec, gaugeVec)
+	counterVec.WithLabelValues("QM1").Add(1024)
+	gaugeVec.WithLabelValues("APP.IN", "QM1").Set(5)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	units := map[string]string{"ibmmq_qmgr_log_physical_written_bytes_total": "bytes"}
+
+	var buf bytes.Buffer
+	writeOpenMetrics(&buf, families, func(name string) string { return units[name] })
+
+	expected := `# TYPE ibmmq_qmgr_log_physical_written_bytes counter
+# UNIT ibmmq_qmgr_log_physical_written_bytes bytes
+# HELP ibmmq_qmgr_log_physical_written_bytes Log - physical bytes written
+ibmmq_qmgr_log_physical_written_bytes_total{qmgr="QM1"} 1024
+# TYPE ibmmq_queue_depth gauge
+# HELP ibmmq_queue_depth Queue \"depth\"
+ibmmq_queue_depth{qmgr="QM1",queue="APP.IN"} 5
+# EOF
+`
+	if actual := buf.String(); actual != expected {
+		t.Errorf("Expected output=%s; actual %s", expected, actual)
+	}
+}
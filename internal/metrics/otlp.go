@@ -0,0 +1,338 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	otlpMetricsPath = "/v1/metrics"
+	otlpScopeName   = "github.com/ibm-messaging/mq-container/internal/metrics"
+
+	// otlpCumulative is the aggregation temporality of sums and histograms which are accumulated since the start
+	otlpCumulative = 2
+
+	// Resource attributes identifying the source of the exported metrics
+	otlpServiceNameAttribute = "service.name"
+	otlpQMgrAttribute        = "ibmmq.qmgr"
+)
+
+// otlpUnits maps the units of metrics to the units used by OTLP, which are UCUM codes
+var otlpUnits = map[string]string{
+	"seconds": "s",
+	"bytes":   "By",
+}
+
+// otlpClient is the HTTP client used to export metrics, which must not wait longer than the interval between exports
+var otlpClient = &http.Client{Timeout: 30 * time.Second}
+
+// The following types are the JSON encoding of an OTLP ExportMetricsServiceRequest, with only the fields which are used
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+// Times and counts are 64-bit integers, which are encoded as strings
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpKeyValue      `json:"attributes,omitempty"`
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []otlpQuantileValue `json:"quantileValues,omitempty"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// exportMetrics exports the metrics gathered by the given gatherer to the OTLP endpoint at each interval, until stop
+// is closed, then exports them once more before closing done. Cumulative values start from when the counters were
+// last reset, by reloading or switching queue manager with a fixed queue manager label value, so that consumers do not
+// see them decrease.
+func (c *Collector) exportMetrics(cfg *metricsConfig, gatherer prometheus.Gatherer, stop <-chan struct{}, done chan<- struct{}) {
+	sendPeriodically(cfg.otlpInterval, c.jitter.offset(cfg.otlpInterval), stop, done, func() bool {
+		err := c.export(cfg, gatherer)
+		if err != nil {
+			atomic.AddInt64(&c.otlpFailures, 1)
+			c.log.Errorf("Metrics Error: Failed to export metrics using OTLP: %v", err)
+			return false
+		}
+		return true
+	})
+}
+
+// export gathers the metrics, and sends them to the OTLP endpoint using OTLP/HTTP with JSON encoding, with the queue
+// manager currently gathered from as a resource attribute. The start time is read once they have been gathered, so
+// that it is not older than counters which were reset meanwhile.
+func (c *Collector) export(cfg *metricsConfig, gatherer prometheus.Gatherer) error {
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	start := time.Unix(0, atomic.LoadInt64(&c.countersStart))
+	request := newOTLPRequest(families, c.qmLabelValue(), cfg.labels, c.getUnit, start, time.Now())
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := otlpClient.Post(cfg.otlpEndpoint+otlpMetricsPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// #nosec G104
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status from %s: %s", cfg.otlpEndpoint+otlpMetricsPath, resp.Status)
+	}
+	return nil
+}
+
+// newOTLPRequest converts gathered metric families to an OTLP request. The queue manager and custom labels are
+// given as resource attributes, and the custom labels are left out of the attributes of each data point. Counters,
+// histograms and summaries are cumulative from the given start time. Values which are not finite are left out.
+func newOTLPRequest(families []*dto.MetricFamily, qmName string, labels map[string]string, getUnit func(string) string, start, now time.Time) otlpMetricsRequest {
+
+	attributes := []otlpKeyValue{
+		{otlpServiceNameAttribute, otlpAnyValue{namespace}},
+		{otlpQMgrAttribute, otlpAnyValue{qmName}},
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attributes = append(attributes, otlpKeyValue{name, otlpAnyValue{labels[name]}})
+	}
+
+	startTime := formatUnixNano(start)
+	nowTime := formatUnixNano(now)
+	metrics := make([]otlpMetric, 0, len(families))
+
+	for _, family := range families {
+		metric := otlpMetric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+			Unit:        getOTLPUnit(getUnit(family.GetName())),
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, m := range family.GetMetric() {
+				if value := m.GetCounter().GetValue(); isFinite(value) {
+					metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberDataPoint{getOTLPAttributes(m, labels), startTime, nowTime, value})
+				}
+			}
+		case dto.MetricType_HISTOGRAM:
+			metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, m := range family.GetMetric() {
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, getOTLPHistogram(m.GetHistogram(), getOTLPAttributes(m, labels), startTime, nowTime))
+			}
+		case dto.MetricType_SUMMARY:
+			metric.Summary = &otlpSummary{}
+			for _, m := range family.GetMetric() {
+				summary := m.GetSummary()
+				point := otlpSummaryDataPoint{
+					Attributes:        getOTLPAttributes(m, labels),
+					StartTimeUnixNano: startTime,
+					TimeUnixNano:      nowTime,
+					Count:             strconv.FormatUint(summary.GetSampleCount(), 10),
+					Sum:               summary.GetSampleSum(),
+				}
+				for _, quantile := range summary.GetQuantile() {
+					if isFinite(quantile.GetValue()) {
+						point.QuantileValues = append(point.QuantileValues, otlpQuantileValue{quantile.GetQuantile(), quantile.GetValue()})
+					}
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, point)
+			}
+		default:
+			// Gauges, and untyped metrics which are treated as gauges
+			metric.Gauge = &otlpGauge{}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				if isFinite(value) {
+					metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberDataPoint{getOTLPAttributes(m, labels), "", nowTime, value})
+				}
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return otlpMetricsRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: attributes},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: otlpScopeName, Version: exporterVersion},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// getOTLPHistogram converts a histogram, with cumulative bucket counts, to an OTLP data point, which has the count for
+// each bucket, and the count above the largest bound last
+func getOTLPHistogram(histogram *dto.Histogram, attributes []otlpKeyValue, startTime, nowTime string) otlpHistogramDataPoint {
+
+	point := otlpHistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: startTime,
+		TimeUnixNano:      nowTime,
+		Count:             strconv.FormatUint(histogram.GetSampleCount(), 10),
+		Sum:               histogram.GetSampleSum(),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []float64{},
+	}
+	previous := uint64(0)
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(histogram.GetSampleCount()-previous, 10))
+	return point
+}
+
+// getOTLPAttributes returns the labels of a metric as OTLP attributes, except for the custom labels
+func getOTLPAttributes(m *dto.Metric, customLabels map[string]string) []otlpKeyValue {
+	var attributes []otlpKeyValue
+	for _, label := range m.GetLabel() {
+		if _, custom := customLabels[label.GetName()]; !custom {
+			attributes = append(attributes, otlpKeyValue{label.GetName(), otlpAnyValue{label.GetValue()}})
+		}
+	}
+	return attributes
+}
+
+// getOTLPUnit returns the OTLP unit for the unit of a metric
+func getOTLPUnit(unit string) string {
+	if otlpUnit, ok := otlpUnits[unit]; ok {
+		return otlpUnit
+	}
+	return unit
+}
+
+// formatUnixNano returns a time as the number of nanoseconds since the epoch
+func formatUnixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// isFinite returns whether a value can be encoded in JSON
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
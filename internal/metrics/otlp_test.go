@@ -0,0 +1,187 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func getTestOTLPRegistry() *prometheus.Registry {
+
+	labels := prometheus.Labels{"region": "eu"}
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ibmmq_queue_depth", Help: "Queue depth", ConstLabels: labels}, []string{objectLabel, qmgrLabel})
+	gauge.WithLabelValues("APP.IN", "QM1").Set(5)
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ibmmq_qmgr_commit_total", Help: "Commit count", ConstLabels: labels}, []string{qmgrLabel})
+	counter.WithLabelValues("QM1").Add(3)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ibmmq_queue_message_size_bytes", Help: "Message sizes", ConstLabels: labels, Buckets: []float64{100, 1000}})
+	for _, size := range []float64{50, 500, 5000, 5000} {
+		histogram.Observe(size)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge, counter, histogram)
+	return registry
+}
+
+func TestNewOTLPRequest(t *testing.T) {
+
+	families, err := getTestOTLPRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	start, now := time.Unix(100, 0), time.Unix(160, 0)
+	units := map[string]string{"ibmmq_queue_message_size_bytes": "bytes"}
+	request := newOTLPRequest(families, "QM1", map[string]string{"region": "eu"}, func(name string) string { return units[name] }, start, now)
+
+	if len(request.ResourceMetrics) != 1 || len(request.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("Expected one resource and scope; actual %+v", request)
+	}
+	attributes := []otlpKeyValue{{otlpServiceNameAttribute, otlpAnyValue{namespace}}, {otlpQMgrAttribute, otlpAnyValue{"QM1"}}, {"region", otlpAnyValue{"eu"}}}
+	if actual := request.ResourceMetrics[0].Resource.Attributes; !reflect.DeepEqual(actual, attributes) {
+		t.Errorf("Expected resource attributes=%v; actual %v", attributes, actual)
+	}
+
+	metrics := make(map[string]otlpMetric)
+	for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+
+	gauge := metrics["ibmmq_queue_depth"].Gauge
+	expectedGauge := &otlpGauge{DataPoints: []otlpNumberDataPoint{{
+		Attributes:   []otlpKeyValue{{qmgrLabel, otlpAnyValue{"QM1"}}, {objectLabel, otlpAnyValue{"APP.IN"}}},
+		TimeUnixNano: "160000000000",
+		AsDouble:     5,
+	}}}
+	if !reflect.DeepEqual(gauge, expectedGauge) {
+		t.Errorf("Expected gauge=%+v; actual %+v", expectedGauge, gauge)
+	}
+
+	sum := metrics["ibmmq_qmgr_commit_total"].Sum
+	expectedSum := &otlpSum{
+		DataPoints: []otlpNumberDataPoint{{
+			Attributes:        []otlpKeyValue{{qmgrLabel, otlpAnyValue{"QM1"}}},
+			StartTimeUnixNano: "100000000000",
+			TimeUnixNano:      "160000000000",
+			AsDouble:          3,
+		}},
+		AggregationTemporality: otlpCumulative,
+		IsMonotonic:            true,
+	}
+	if !reflect.DeepEqual(sum, expectedSum) {
+		t.Errorf("Expected sum=%+v; actual %+v", expectedSum, sum)
+	}
+
+	histogram := metrics["ibmmq_queue_message_size_bytes"]
+	if histogram.Unit != "By" {
+		t.Errorf("Expected unit=%s; actual %s", "By", histogram.Unit)
+	}
+	if histogram.Histogram == nil || len(histogram.Histogram.DataPoints) != 1 {
+		t.Fatalf("Expected one histogram data point; actual %+v", histogram.Histogram)
+	}
+	point := histogram.Histogram.DataPoints[0]
+	if point.Count != "4" || point.Sum != 10550 || !reflect.DeepEqual(point.BucketCounts, []string{"1", "1", "2"}) || !reflect.DeepEqual(point.ExplicitBounds, []float64{100, 1000}) {
+		t.Errorf("Expected histogram count=%s, sum=%d, buckets=%v, bounds=%v; actual %s, %f, %v, %v", "4", 10550, []string{"1", "1", "2"}, []float64{100, 1000}, point.Count, point.Sum, point.BucketCounts, point.ExplicitBounds)
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+
+	requests := make(chan otlpMetricsRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != otlpMetricsPath || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected request=%s %s with JSON body; actual %s %s with %s", http.MethodPost, otlpMetricsPath, r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var request otlpMetricsRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			t.Errorf("Unexpected error decoding request: %v", err)
+		}
+		requests <- request
+	}))
+	defer server.Close()
+
+	cfg := getTestConfig()
+	cfg.otlpEndpoint, cfg.otlpInterval = server.URL, time.Hour
+	c := newCollector("QM1", cfg, getTestLogger())
+	// The counters were reset, as when switching queue manager, after the exporter started
+	atomic.StoreInt64(&c.countersStart, time.Unix(100, 0).UnixNano())
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.exportMetrics(cfg, getTestOTLPRegistry(), stop, done)
+
+	// The final values are exported when stopping, without waiting for the interval, for the queue manager gathered
+	// from by then
+	c.setTarget(metricsTarget{qmName: "QM2"})
+	close(stop)
+	<-done
+
+	select {
+	case request := <-requests:
+		if len(request.ResourceMetrics) != 1 || len(request.ResourceMetrics[0].ScopeMetrics[0].Metrics) != 3 {
+			t.Errorf("Expected %d metrics to be exported; actual %+v", 3, request)
+		}
+		if attributes := request.ResourceMetrics[0].Resource.Attributes; !containsOTLPAttribute(attributes, otlpKeyValue{otlpQMgrAttribute, otlpAnyValue{"QM2"}}) {
+			t.Errorf("Expected resource attribute %s=%s; actual %+v", otlpQMgrAttribute, "QM2", attributes)
+		}
+		for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			if metric.Sum != nil && metric.Sum.DataPoints[0].StartTimeUnixNano != "100000000000" {
+				t.Errorf("Expected startTimeUnixNano=%s for %s; actual %s", "100000000000", metric.Name, metric.Sum.DataPoints[0].StartTimeUnixNano)
+			}
+		}
+	default:
+		t.Error("Expected metrics to be exported")
+	}
+	if failures := atomic.LoadInt64(&c.otlpFailures); failures != 0 {
+		t.Errorf("Expected otlpFailures=%d; actual %d", 0, failures)
+	}
+}
+
+func TestExportMetrics_Failure(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := getTestConfig()
+	cfg.otlpEndpoint, cfg.otlpInterval = server.URL, time.Hour
+	c := newCollector("QM1", cfg, getTestLogger())
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.exportMetrics(cfg, prometheus.NewRegistry(), stop, done)
+	close(stop)
+	<-done
+
+	if failures := atomic.LoadInt64(&c.otlpFailures); failures != 1 {
+		t.Errorf("Expected otlpFailures=%d; actual %d", 1, failures)
+	}
+}
+
+// containsOTLPAttribute returns true if the attributes contain the given attribute
+func containsOTLPAttribute(attributes []otlpKeyValue, attribute otlpKeyValue) bool {
+	for _, a := range attributes {
+		if a == attribute {
+			return true
+		}
+	}
+	return false
+}
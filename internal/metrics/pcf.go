@@ -0,0 +1,336 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+const (
+	pcfWaitInterval = 30 * 1000
+	pcfBufferSize   = 65536
+)
+
+// pcfConnection is a connection to the queue manager used to send PCF commands,
+// for metrics which are not available from the statistics publications
+type pcfConnection struct {
+	qMgr   ibmmq.MQQueueManager
+	cmdQ   ibmmq.MQObject
+	replyQ ibmmq.MQObject
+	buf    []byte
+	// userID is the user that the connection runs as, which the queue manager sets in the context of each command
+	userID string
+}
+
+// pcfError is returned when the queue manager fails a PCF command
+type pcfError struct {
+	command int32
+	reason  int32
+}
+
+func (e *pcfError) Error() string {
+	return fmt.Sprintf("PCF command %d failed with reason %d", e.command, e.reason)
+}
+
+// isNotFound returns true if a PCF command failed because no matching objects were found
+func isNotFound(err error) bool {
+	e, ok := err.(*pcfError)
+	if !ok {
+		return false
+	}
+	switch e.reason {
+	case ibmmq.MQRCCF_CHL_STATUS_NOT_FOUND, ibmmq.MQRCCF_TOPIC_STRING_NOT_FOUND, ibmmq.MQRCCF_NONE_FOUND,
+		ibmmq.MQRC_NO_SUBSCRIPTION, ibmmq.MQRC_UNKNOWN_OBJECT_NAME:
+		return true
+	}
+	return false
+}
+
+// pcfResponse holds the parameters of a PCF response message, keyed by parameter identifier
+type pcfResponse map[int32]*ibmmq.PCFParameter
+
+// openPCFConnection connects to the queue manager, and opens the command queue and a dynamic reply queue, created
+// from the configured model queue
+func openPCFConnection(qmName string, cfg *metricsConfig, cno *ibmmq.MQCNO) (*pcfConnection, error) {
+
+	qMgr, err := ibmmq.Connx(qmName, cno)
+	if err != nil {
+		return nil, err
+	}
+	conn := pcfConnection{qMgr: qMgr, buf: make([]byte, pcfBufferSize)}
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = "SYSTEM.ADMIN.COMMAND.QUEUE"
+	conn.cmdQ, err = qMgr.Open(mqod, ibmmq.MQOO_OUTPUT|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		// #nosec G104
+		qMgr.Disc()
+		return nil, err
+	}
+
+	conn.replyQ, err = qMgr.Open(newReplyQueueOD(cfg), ibmmq.MQOO_INPUT_EXCLUSIVE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		// #nosec G104
+		conn.cmdQ.Close(0)
+		// #nosec G104
+		qMgr.Disc()
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// close closes the queues and disconnects from the queue manager
+func (conn *pcfConnection) close() {
+	// #nosec G104
+	conn.replyQ.Close(0)
+	// #nosec G104
+	conn.cmdQ.Close(0)
+	// #nosec G104
+	conn.qMgr.Disc()
+}
+
+// pcfPool is a fixed number of connections used to send PCF commands, which limits the number of inquiries that are
+// in progress at once, so that inquiring many objects does not overload a small queue manager. Each connection
+// is only used by one inquiry at a time, as its reply queue and buffer are not safe for concurrent use.
+type pcfPool struct {
+	conns chan *pcfConnection
+	all   []*pcfConnection
+}
+
+// openPCFPool opens the configured number of connections for PCF commands, and at least one, closing any which were
+// opened if one fails
+func openPCFPool(qmName string, cfg *metricsConfig, cno *ibmmq.MQCNO) (*pcfPool, error) {
+
+	var conns []*pcfConnection
+	for len(conns) < cfg.pcfConcurrency || len(conns) == 0 {
+		conn, err := openPCFConnection(qmName, cfg, cno)
+		if err != nil {
+			for _, conn := range conns {
+				conn.close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return newPCFPool(conns), nil
+}
+
+// newPCFPool returns a pool of the given connections
+func newPCFPool(conns []*pcfConnection) *pcfPool {
+	pool := pcfPool{conns: make(chan *pcfConnection, len(conns)), all: conns}
+	for _, conn := range conns {
+		pool.conns <- conn
+	}
+	return &pool
+}
+
+// close closes all of the connections, which must not be in use
+func (pool *pcfPool) close() {
+	for _, conn := range pool.all {
+		conn.close()
+	}
+}
+
+// forEach calls f with each index from zero up to count, with as many calls at once as there are connections, each
+// using its own connection. It waits for all of the calls to return, and returns the error from the lowest index
+// which failed, if any.
+func (pool *pcfPool) forEach(count int, f func(conn *pcfConnection, i int) error) error {
+
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		conn := <-pool.conns
+		wg.Add(1)
+		go func(i int, conn *pcfConnection) {
+			defer wg.Done()
+			errs[i] = f(conn, i)
+			pool.conns <- conn
+		}(i, conn)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command sends a PCF command with the given parameters, and returns the parameters of each response
+func (conn *pcfConnection) command(command int32, parameters ...[]byte) ([]pcfResponse, error) {
+
+	cfh := ibmmq.NewMQCFH()
+	cfh.Command = command
+	cfh.ParameterCount = int32(len(parameters))
+	buf := cfh.Bytes()
+	for _, parameter := range parameters {
+		buf = append(buf, parameter...)
+	}
+
+	putmqmd := ibmmq.NewMQMD()
+	putmqmd.Format = "MQADMIN"
+	putmqmd.ReplyToQ = conn.replyQ.Name
+	putmqmd.MsgType = ibmmq.MQMT_REQUEST
+	putmqmd.Report = ibmmq.MQRO_PASS_DISCARD_AND_EXPIRY
+
+	pmo := ibmmq.NewMQPMO()
+	pmo.Options = ibmmq.MQPMO_NO_SYNCPOINT | ibmmq.MQPMO_NEW_MSG_ID | ibmmq.MQPMO_NEW_CORREL_ID | ibmmq.MQPMO_FAIL_IF_QUIESCING
+
+	err := conn.cmdQ.Put(putmqmd, pmo, buf)
+	if err != nil {
+		return nil, err
+	}
+	conn.userID = strings.TrimSpace(putmqmd.UserIdentifier)
+
+	// Get each of the responses, which have their correlation ID set to the message ID of the command
+	var responses []pcfResponse
+	for {
+		getmqmd := ibmmq.NewMQMD()
+		getmqmd.CorrelId = putmqmd.MsgId
+		gmo := ibmmq.NewMQGMO()
+		gmo.Options = ibmmq.MQGMO_NO_SYNCPOINT | ibmmq.MQGMO_FAIL_IF_QUIESCING | ibmmq.MQGMO_WAIT | ibmmq.MQGMO_CONVERT
+		gmo.MatchOptions = ibmmq.MQMO_MATCH_CORREL_ID
+		gmo.WaitInterval = pcfWaitInterval
+
+		datalen, err := conn.replyQ.Get(getmqmd, gmo, conn.buf)
+		if err != nil {
+			return nil, err
+		}
+
+		cfh, response := parsePCFResponse(conn.buf[:datalen])
+		if cfh.CompCode == ibmmq.MQCC_FAILED {
+			return nil, &pcfError{command: command, reason: cfh.Reason}
+		}
+		responses = append(responses, response)
+		if cfh.Control == ibmmq.MQCFC_LAST {
+			return responses, nil
+		}
+	}
+}
+
+// parsePCFResponse returns the header and parameters of a PCF response message
+func parsePCFResponse(buf []byte) (*ibmmq.MQCFH, pcfResponse) {
+
+	cfh, offset := ibmmq.ReadPCFHeader(buf)
+	response := make(pcfResponse)
+	byteOrder := pcfByteOrder()
+
+	for i := 0; i < int(cfh.ParameterCount) && offset+8 <= len(buf); i++ {
+		// Skip parameter types which are not supported by ibmmq, such as byte strings,
+		// as it writes a message to stdout for each of them
+		parameterType := int32(byteOrder.Uint32(buf[offset:]))
+		if !isSupportedParameterType(parameterType) {
+			offset += int(byteOrder.Uint32(buf[offset+4:]))
+			continue
+		}
+
+		parameter, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+		offset += bytesRead
+		if parameter.Type == ibmmq.MQCFT_GROUP {
+			for j := 0; j < int(parameter.ParameterCount) && offset < len(buf); j++ {
+				groupParameter, bytesRead := ibmmq.ReadPCFParameter(buf[offset:])
+				offset += bytesRead
+				parameter.GroupList = append(parameter.GroupList, groupParameter)
+			}
+		}
+		response[parameter.Parameter] = parameter
+	}
+	return cfh, response
+}
+
+// isSupportedParameterType returns true if PCF parameters of the given type can be read by ibmmq
+func isSupportedParameterType(parameterType int32) bool {
+	switch parameterType {
+	case ibmmq.MQCFT_INTEGER, ibmmq.MQCFT_INTEGER_LIST, ibmmq.MQCFT_INTEGER64, ibmmq.MQCFT_INTEGER64_LIST,
+		ibmmq.MQCFT_STRING, ibmmq.MQCFT_STRING_LIST, ibmmq.MQCFT_GROUP:
+		return true
+	}
+	return false
+}
+
+// getString returns the value of a string parameter, without padding, or an empty string if it is not present
+func (response pcfResponse) getString(parameter int32) string {
+	if p, ok := response[parameter]; ok && len(p.String) > 0 {
+		return strings.TrimSpace(p.String[0])
+	}
+	return ""
+}
+
+// getStrings returns the values of a string list parameter, without padding
+func (response pcfResponse) getStrings(parameter int32) []string {
+	var values []string
+	if p, ok := response[parameter]; ok {
+		for _, value := range p.String {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// getInt returns the value of an integer parameter, or zero if it is not present
+func (response pcfResponse) getInt(parameter int32) int64 {
+	if p, ok := response[parameter]; ok && len(p.Int64Value) > 0 {
+		return p.Int64Value[0]
+	}
+	return 0
+}
+
+// stringParameter returns a PCF string parameter
+func stringParameter(parameter int32, value string) []byte {
+	p := ibmmq.PCFParameter{Type: ibmmq.MQCFT_STRING, Parameter: parameter, String: []string{value}}
+	return p.Bytes()
+}
+
+// integerParameter returns a PCF integer parameter
+func integerParameter(parameter int32, value int32) []byte {
+	p := ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER, Parameter: parameter, Int64Value: []int64{int64(value)}}
+	return p.Bytes()
+}
+
+// integerListParameter returns a PCF integer list parameter, which is not supported by ibmmq.PCFParameter
+func integerListParameter(parameter int32, values ...int32) []byte {
+
+	// The MQCFIL structure has a fixed length of 16 bytes, followed by the values
+	buf := make([]byte, 16+4*len(values))
+	byteOrder := pcfByteOrder()
+	byteOrder.PutUint32(buf[0:], uint32(ibmmq.MQCFT_INTEGER_LIST))
+	byteOrder.PutUint32(buf[4:], uint32(len(buf)))
+	byteOrder.PutUint32(buf[8:], uint32(parameter))
+	byteOrder.PutUint32(buf[12:], uint32(len(values)))
+	for i, value := range values {
+		byteOrder.PutUint32(buf[16+4*i:], uint32(value))
+	}
+	return buf
+}
+
+// pcfByteOrder returns the native byte order used for PCF structures, as used by the ibmmq package
+func pcfByteOrder() binary.ByteOrder {
+	// The first field of the header is its type, MQCFT_COMMAND, which has the value 1
+	if ibmmq.NewMQCFH().Bytes()[0] == 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
@@ -0,0 +1,95 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPCFPool_ForEach(t *testing.T) {
+
+	pool := newPCFPool([]*pcfConnection{{}, {}})
+
+	var mutex sync.Mutex
+	called := make([]bool, 6)
+	inUse := make(map[*pcfConnection]bool)
+	var running, maxRunning int32
+	err := pool.forEach(len(called), func(conn *pcfConnection, i int) error {
+		mutex.Lock()
+		if inUse[conn] {
+			t.Errorf("Expected each connection to be used by one call at a time")
+		}
+		inUse[conn] = true
+		called[i] = true
+		mutex.Unlock()
+
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		mutex.Lock()
+		inUse[conn] = false
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	for i := range called {
+		if !called[i] {
+			t.Errorf("Expected call with index %d", i)
+		}
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected calls at once=%d; actual %d", 2, maxRunning)
+	}
+	if len(pool.conns) != 2 {
+		t.Errorf("Expected idle connections=%d; actual %d", 2, len(pool.conns))
+	}
+}
+
+func TestPCFPool_ForEach_Error(t *testing.T) {
+
+	pool := newPCFPool([]*pcfConnection{{}, {}, {}})
+
+	var calls int32
+	err := pool.forEach(4, func(conn *pcfConnection, i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 1 || i == 3 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "failed 1" {
+		t.Errorf("Expected error=%s; actual %v", "failed 1", err)
+	}
+	if calls != 4 {
+		t.Errorf("Expected calls=%d; actual %d", 4, calls)
+	}
+	if len(pool.conns) != 3 {
+		t.Errorf("Expected idle connections=%d; actual %d", 3, len(pool.conns))
+	}
+}
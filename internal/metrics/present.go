@@ -0,0 +1,54 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"sort"
+)
+
+// applyAlwaysPresent marks the metrics with the given keys as always present, so that they are exported with a value of
+// 0 until they have a value, and returns a warning for each key which could not be applied. As for descriptions, a
+// published metric can be given by its metric key, or by the key used to select it. Only queue manager metrics can
+// be always present, as object metrics do not have a value until the objects they are reported for are known.
+func applyAlwaysPresent(metrics map[string]*metricData, mappingKeys map[string]string, keys []string) []string {
+
+	found := make(map[string]*metricData, len(metrics))
+	for key, metric := range metrics {
+		found[key] = metric
+		if mappingKey, ok := mappingKeys[key]; ok {
+			found[mappingKey] = metric
+		}
+	}
+
+	var warnings []string
+	for _, key := range keys {
+		metric, ok := found[key]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("Unknown metric key [%s] in %s - it is not one of the metrics gathered, which can be listed with -list-metrics", key, alwaysPresentEnv))
+			continue
+		}
+		if metric.objectType {
+			warnings = append(warnings, fmt.Sprintf("Metric key [%s] in %s is for a metric with a value for each object, so it cannot always be present", key, alwaysPresentEnv))
+			continue
+		}
+		metric.alwaysPresent = true
+	}
+	sort.Strings(warnings)
+	return warnings
+}
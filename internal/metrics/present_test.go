@@ -0,0 +1,112 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInitialiseMetrics_AlwaysPresent(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := &metricsConfig{depthQueues: "APP.*", alwaysPresent: []string{testMappingKey1, uptimeKey, "CPU/Unknown/Key", queueKeyPrefix + "Current depth"}}
+	metrics, err := initialiseMetrics(log, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	for key, expected := range map[string]bool{testKey1: true, uptimeKey: true, queueKeyPrefix + "Current depth": false, queueKeyPrefix + "High depth": false} {
+		if actual := metrics[key].alwaysPresent; actual != expected {
+			t.Errorf("Expected %s alwaysPresent=%v; actual %v", key, expected, actual)
+		}
+	}
+	for _, expected := range []string{
+		"Metrics Warning: Unknown metric key [CPU/Unknown/Key] in " + alwaysPresentEnv,
+		"Metrics Warning: Metric key [" + queueKeyPrefix + "Current depth] in " + alwaysPresentEnv + " is for a metric with a value for each object",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected warning %s; actual %s", expected, buf.String())
+		}
+	}
+}
+
+func TestCollect_AlwaysPresent(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	gauge := &metricData{name: testElement1Name, description: testElement1Description, alwaysPresent: true}
+	counter := &metricData{name: "messages_total", description: "Messages", isDelta: true, alwaysPresent: true}
+	other := &metricData{name: "other", description: "Other"}
+	metrics := map[string]*metricData{testKey1: gauge, "Counter/Key": counter, "Other/Key": other}
+	collector := newCollector("QM1", getTestConfig(), getTestLogger())
+
+	collect := func() {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		<-collector.requestChannel
+		collector.responseChannel <- metrics
+		for range ch {
+		}
+	}
+	getCount := func(vec prometheus.Collector) int {
+		ch := make(chan prometheus.Metric, 10)
+		vec.Collect(ch)
+		close(ch)
+		return len(ch)
+	}
+
+	// Metrics which are always present are collected as 0 before they have any value, even on the first collect
+	collect()
+	prometheusMetric := dto.Metric{}
+	collector.gaugeMap[testKey1].WithLabelValues("QM1").Write(&prometheusMetric)
+	if count := getCount(collector.gaugeMap[testKey1]); count != 1 || prometheusMetric.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected gauge values=%d, value=%d; actual %d, %f", 1, 0, count, prometheusMetric.GetGauge().GetValue())
+	}
+	if count := getCount(collector.counterMap["Counter/Key"]); count != 1 {
+		t.Errorf("Expected counter values=%d; actual %d", 1, count)
+	}
+	if count := getCount(collector.gaugeMap["Other/Key"]); count != 0 {
+		t.Errorf("Expected other values=%d; actual %d", 0, count)
+	}
+
+	// The values replace the zero values when they arrive
+	gauge.values, gauge.lastUpdate = map[string]float64{qmgrLabelValue: 5}, time.Now()
+	counter.values, counter.lastUpdate = map[string]float64{qmgrLabelValue: 3}, time.Now()
+	collect()
+	collector.gaugeMap[testKey1].WithLabelValues("QM1").Write(&prometheusMetric)
+	if actual := prometheusMetric.GetGauge().GetValue(); actual != 5 {
+		t.Errorf("Expected gauge value=%d; actual %f", 5, actual)
+	}
+	collector.counterMap["Counter/Key"].WithLabelValues("QM1").Write(&prometheusMetric)
+	if actual := prometheusMetric.GetCounter().GetValue(); actual != 3 {
+		t.Errorf("Expected counter value=%d; actual %f", 3, actual)
+	}
+}
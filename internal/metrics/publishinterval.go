@@ -0,0 +1,127 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+const (
+	// pcfHeaderLength is the length of the MQCFH header of a PCF message, which is followed by its parameters
+	pcfHeaderLength = 36
+
+	// maxCycleRatio is how many times shorter or longer than the publish interval the cycle of processing
+	// publications can be before it is mismatched
+	maxCycleRatio = 2
+)
+
+// getMonitorInterval returns the monitor interval of a publication of metric data, which is the time over which the
+// queue manager gathered the data it contains, or zero if the publication does not give it. The buffer may only hold
+// the start of the publication.
+func getMonitorInterval(buf []byte, byteOrder binary.ByteOrder) time.Duration {
+
+	if len(buf) < pcfHeaderLength {
+		return 0
+	}
+	offset := int(byteOrder.Uint32(buf[4:]))
+	count := int(byteOrder.Uint32(buf[32:]))
+	for i := 0; i < count && offset+8 <= len(buf); i++ {
+		parameterType := int32(byteOrder.Uint32(buf[offset:]))
+		length := int(byteOrder.Uint32(buf[offset+4:]))
+		if length < 8 {
+			return 0
+		}
+		// An MQCFIN64 parameter has 4 reserved bytes between the parameter and its value
+		if parameterType == ibmmq.MQCFT_INTEGER64 && offset+24 <= len(buf) &&
+			int32(byteOrder.Uint32(buf[offset+8:])) == ibmmq.MQIAMO64_MONITOR_INTERVAL {
+			return time.Duration(byteOrder.Uint64(buf[offset+16:])) * time.Microsecond
+		}
+		offset += length
+	}
+	return 0
+}
+
+// getCycleMismatch returns how the cycle of processing publications is mismatched with the interval at which the queue
+// manager publishes metrics, or an empty string if it is within maxCycleRatio of it
+func getCycleMismatch(cycle, publishInterval time.Duration) string {
+	if cycle*maxCycleRatio < publishInterval {
+		return "so most cycles receive no new data"
+	}
+	if cycle > publishInterval*maxCycleRatio {
+		return "so the values of several publications are merged in each cycle, which loses resolution"
+	}
+	return ""
+}
+
+// clampCycle returns the cycle of processing publications, limited to within maxCycleRatio of the publish interval
+func clampCycle(cycle, publishInterval time.Duration) time.Duration {
+	if cycle*maxCycleRatio < publishInterval {
+		return publishInterval / maxCycleRatio
+	}
+	if cycle > publishInterval*maxCycleRatio {
+		return publishInterval * maxCycleRatio
+	}
+	return cycle
+}
+
+// cycleInterval returns the time between cycles of processing publications while they arrive, which is the request
+// timeout, unless it is clamped to the detected publish interval
+func (c *Collector) cycleInterval() time.Duration {
+	publishInterval := time.Duration(atomic.LoadInt64(&c.publishInterval))
+	if c.cfg.clampInterval && publishInterval > 0 {
+		return clampCycle(c.cfg.requestTimeout, publishInterval)
+	}
+	return c.cfg.requestTimeout
+}
+
+// checkPublishInterval detects the interval at which the queue manager publishes metrics from the first publication
+// waiting on the reply queue, unless it has already been detected on this connection, and warns once if the cycle of
+// processing publications is badly mismatched with it. It is only detected when the reply queue could be opened for
+// browsing.
+func (c *Collector) checkPublishInterval() {
+
+	if atomic.LoadInt64(&c.publishInterval) != 0 {
+		return
+	}
+	publishInterval, err := browsePublishInterval(c.conn.replyQInquiry)
+	if err != nil || publishInterval <= 0 {
+		return
+	}
+	atomic.StoreInt64(&c.publishInterval, int64(publishInterval))
+	c.eventLog("publish_interval").Debugf("Metrics: Queue manager %s publishes metrics every %v", c.qmName, publishInterval)
+
+	// Without a processing cycle, the cadence is that of the requests, once two have been received
+	cycle, setting := c.cfg.requestTimeout, requestTimeoutEnv
+	if c.cfg.onDemand {
+		cycle, setting = time.Duration(atomic.LoadInt64(&c.lastCollectInterval)), "the scrape interval"
+		if cycle == 0 {
+			return
+		}
+	}
+	if mismatch := getCycleMismatch(cycle, publishInterval); mismatch != "" {
+		if c.cfg.clampInterval {
+			c.eventLog("publish_interval").Printf("Metrics Warning: Publications of metric data are processed every %v, set by %s, but queue manager %s publishes them every %v, %s - processing them every %v instead", cycle, setting, c.qmName, publishInterval, mismatch, clampCycle(cycle, publishInterval))
+		} else {
+			c.eventLog("publish_interval").Printf("Metrics Warning: Publications of metric data are processed every %v, set by %s, but queue manager %s publishes them every %v, %s", cycle, setting, c.qmName, publishInterval, mismatch)
+		}
+	}
+}
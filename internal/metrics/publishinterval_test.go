@@ -0,0 +1,136 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+// makeTestPublication returns the start of a publication of metric data, with an integer parameter followed by the
+// monitor interval
+func makeTestPublication(byteOrder binary.ByteOrder, interval time.Duration) []byte {
+	buf := make([]byte, pcfHeaderLength+16+24)
+	byteOrder.PutUint32(buf[0:], uint32(ibmmq.MQCFT_STATISTICS))
+	byteOrder.PutUint32(buf[4:], pcfHeaderLength)
+	byteOrder.PutUint32(buf[32:], 2)
+
+	offset := pcfHeaderLength
+	byteOrder.PutUint32(buf[offset:], uint32(ibmmq.MQCFT_INTEGER))
+	byteOrder.PutUint32(buf[offset+4:], 16)
+	byteOrder.PutUint32(buf[offset+8:], uint32(ibmmq.MQIAMO_MONITOR_CLASS))
+	offset += 16
+	byteOrder.PutUint32(buf[offset:], uint32(ibmmq.MQCFT_INTEGER64))
+	byteOrder.PutUint32(buf[offset+4:], 24)
+	byteOrder.PutUint32(buf[offset+8:], uint32(ibmmq.MQIAMO64_MONITOR_INTERVAL))
+	byteOrder.PutUint64(buf[offset+16:], uint64(interval/time.Microsecond))
+	return buf
+}
+
+func TestGetMonitorInterval(t *testing.T) {
+
+	for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		buf := makeTestPublication(byteOrder, 10*time.Second)
+		if actual := getMonitorInterval(buf, byteOrder); actual != 10*time.Second {
+			t.Errorf("Expected monitor interval=%v; actual %v", 10*time.Second, actual)
+		}
+		// A publication which was truncated before the monitor interval does not give it
+		if actual := getMonitorInterval(buf[:len(buf)-4], byteOrder); actual != 0 {
+			t.Errorf("Expected no monitor interval for a truncated publication; actual %v", actual)
+		}
+		if actual := getMonitorInterval(buf[:20], byteOrder); actual != 0 {
+			t.Errorf("Expected no monitor interval for a truncated header; actual %v", actual)
+		}
+	}
+}
+
+func TestClampCycle(t *testing.T) {
+
+	tests := []struct {
+		cycle    time.Duration
+		mismatch bool
+		clamped  time.Duration
+	}{
+		{10 * time.Second, false, 10 * time.Second},
+		{5 * time.Second, false, 5 * time.Second},
+		{20 * time.Second, false, 20 * time.Second},
+		{2 * time.Second, true, 5 * time.Second},
+		{60 * time.Second, true, 20 * time.Second},
+	}
+	for _, test := range tests {
+		if mismatch := getCycleMismatch(test.cycle, 10*time.Second); (mismatch != "") != test.mismatch {
+			t.Errorf("Expected mismatch=%t for cycle %v; actual '%s'", test.mismatch, test.cycle, mismatch)
+		}
+		if clamped := clampCycle(test.cycle, 10*time.Second); clamped != test.clamped {
+			t.Errorf("Expected clamped cycle=%v for cycle %v; actual %v", test.clamped, test.cycle, clamped)
+		}
+	}
+}
+
+func TestCheckPublishInterval(t *testing.T) {
+
+	var browsed int32
+	browsePublishInterval = func(replyQInquiry *replyQueueInquiry) (time.Duration, error) {
+		atomic.AddInt32(&browsed, 1)
+		return 60 * time.Second, nil
+	}
+	defer func() { browsePublishInterval = doBrowsePublishInterval }()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := getTestConfig()
+	cfg.requestTimeout = 10 * time.Second
+	c := newCollector("qmName", cfg, log)
+	c.checkPublishInterval()
+	if actual := time.Duration(atomic.LoadInt64(&c.publishInterval)); actual != 60*time.Second {
+		t.Errorf("Expected publish interval=%v; actual %v", 60*time.Second, actual)
+	}
+	if !strings.Contains(buf.String(), "publishes them every 1m0s, so most cycles receive no new data") {
+		t.Errorf("Expected warning that the cycle is mismatched; actual %s", buf.String())
+	}
+	if actual := c.cycleInterval(); actual != cfg.requestTimeout {
+		t.Errorf("Expected cycle interval=%v without clamping; actual %v", cfg.requestTimeout, actual)
+	}
+
+	// The interval is only detected once on each connection
+	c.checkPublishInterval()
+	if browsed != 1 {
+		t.Errorf("Expected the reply queue to be browsed once; actual %d", browsed)
+	}
+
+	cfg.clampInterval = true
+	if actual := c.cycleInterval(); actual != 30*time.Second {
+		t.Errorf("Expected clamped cycle interval=%v; actual %v", 30*time.Second, actual)
+	}
+
+	// Nothing is detected if the reply queue cannot be browsed
+	browsePublishInterval = func(replyQInquiry *replyQueueInquiry) (time.Duration, error) {
+		return 0, fmt.Errorf("Not open")
+	}
+	atomic.StoreInt64(&c.publishInterval, 0)
+	c.checkPublishInterval()
+	if actual := c.cycleInterval(); actual != cfg.requestTimeout {
+		t.Errorf("Expected cycle interval=%v when the publish interval is not known; actual %v", cfg.requestTimeout, actual)
+	}
+}
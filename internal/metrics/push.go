@@ -0,0 +1,88 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	// pushJobLabel and pushInstanceLabel are the grouping labels of the pushed metrics
+	pushJobLabel      = "job"
+	pushInstanceLabel = "instance"
+
+	// minPushRetryDelay is the delay before retrying after a push or export fails
+	minPushRetryDelay = time.Second
+)
+
+// pushMetrics pushes the metrics gathered by the given gatherer to the Pushgateway at each interval, until stop is
+// closed, then pushes them once more before closing done. The metrics are grouped by the configured job, and by the
+// value of the queue manager label as the instance, which is read for each push, as it changes when switching queue
+// manager.
+func (c *Collector) pushMetrics(cfg *metricsConfig, gatherer prometheus.Gatherer, stop <-chan struct{}, done chan<- struct{}) {
+	sendPeriodically(cfg.pushInterval, c.jitter.offset(cfg.pushInterval), stop, done, func() bool {
+		grouping := map[string]string{pushInstanceLabel: c.qmLabelValue()}
+		return c.push(cfg, grouping, gatherer)
+	})
+}
+
+// sendPeriodically calls send at each interval until stop is closed, then once more before closing done, so that
+// the final values are not missed if the queue manager is short-lived. The first interval is shortened by the given
+// offset, to shift the phase of the others. If send fails, it is retried with a backoff which starts at
+// minPushRetryDelay and is doubled after each failure, up to the interval.
+func sendPeriodically(interval, offset time.Duration, stop <-chan struct{}, done chan<- struct{}, send func() bool) {
+
+	defer close(done)
+	delay := interval - offset
+	retryDelay := minPushRetryDelay
+
+	for {
+		select {
+		case <-stop:
+			send()
+			return
+		case <-time.After(delay):
+		}
+
+		if send() {
+			delay, retryDelay = interval, minPushRetryDelay
+		} else {
+			delay = retryDelay
+			if delay > interval {
+				delay = interval
+			}
+			retryDelay *= 2
+		}
+	}
+}
+
+// push pushes the gathered metrics to the Pushgateway, replacing those previously pushed for the same grouping,
+// and returns whether it succeeded. Failures are logged and counted.
+func (c *Collector) push(cfg *metricsConfig, grouping map[string]string, gatherer prometheus.Gatherer) bool {
+	err := push.FromGatherer(cfg.pushJob, grouping, cfg.pushURL, gatherer)
+	if err != nil {
+		atomic.AddInt64(&c.pushFailures, 1)
+		c.log.Errorf("Metrics Error: Failed to push metrics to the Pushgateway: %v", err)
+		return false
+	}
+	return true
+}
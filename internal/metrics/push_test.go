@@ -0,0 +1,117 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushMetrics(t *testing.T) {
+
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test gauge"}))
+
+	cfg := getTestConfig()
+	cfg.pushURL, cfg.pushInterval, cfg.pushJob = server.URL, time.Hour, defaultPushJob
+	c := newCollector("QM1", cfg, getTestLogger())
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.pushMetrics(cfg, registry, stop, done)
+
+	// The final values are pushed when stopping, without waiting for the interval, grouped by the queue manager
+	// gathered from by then
+	c.setTarget(metricsTarget{qmName: "QM2"})
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the final push")
+	}
+
+	select {
+	case r := <-requests:
+		if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/"+defaultPushJob+"/instance/QM2" {
+			t.Errorf("Expected request=%s %s; actual %s %s", http.MethodPut, "/metrics/job/"+defaultPushJob+"/instance/QM2", r.Method, r.URL.Path)
+		}
+	default:
+		t.Error("Expected metrics to be pushed")
+	}
+	if failures := atomic.LoadInt64(&c.pushFailures); failures != 0 {
+		t.Errorf("Expected pushFailures=%d; actual %d", 0, failures)
+	}
+}
+
+func TestPushMetrics_Failures(t *testing.T) {
+
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := getTestConfig()
+	cfg.pushURL, cfg.pushInterval, cfg.pushJob = server.URL, 10*time.Millisecond, defaultPushJob
+	c := newCollector("QM1", cfg, getTestLogger())
+	stop, done := make(chan struct{}), make(chan struct{})
+	go c.pushMetrics(cfg, prometheus.NewRegistry(), stop, done)
+
+	// Failed pushes are retried after a delay no longer than the interval
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&c.pushFailures) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	failures := atomic.LoadInt64(&c.pushFailures)
+	if failures < 3 {
+		t.Errorf("Expected pushFailures>=%d; actual %d", 3, failures)
+	}
+	if pushes := atomic.LoadInt64(&count); pushes != failures {
+		t.Errorf("Expected pushes=%d; actual %d", failures, pushes)
+	}
+}
+
+func TestSendPeriodically_Offset(t *testing.T) {
+
+	// The first interval is shortened by the offset
+	sent := make(chan bool, 10)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go sendPeriodically(time.Hour, time.Hour-10*time.Millisecond, stop, done, func() bool {
+		sent <- true
+		return true
+	})
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the first send after the offset interval")
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,218 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+const queueKeyPrefix = "QUEUE/Status/"
+
+const queueAgeKey = "Oldest message age"
+
+// unknownMessageAge is the oldest message age of a queue which does not have queue monitoring enabled, as returned
+// by the queue manager, or of an empty queue if the ages of empty queues are omitted
+var unknownMessageAge = int64(ibmmq.MQMON_NOT_AVAILABLE)
+
+// queueStatus holds the depth of a queue, and the age of its oldest message in seconds
+type queueStatus struct {
+	name      string
+	depth     int64
+	highDepth int64
+	oldestAge int64
+}
+
+// queueMetric describes a metric derived from the depth or status of a queue
+type queueMetric struct {
+	key         string
+	name        string
+	description string
+	unit        string
+	value       func(*queueStatus) int64
+	// watermark metrics are read from the queue statistics, which are reset when they are inquired
+	watermark bool
+	// age metrics are not reported for queues with an unknown age, and the ages of the queues over the label value
+	// limit are aggregated by their maximum, rather than added together
+	age bool
+}
+
+// queueMetrics are the metrics available for each queue
+var queueMetrics = []queueMetric{
+	{"Current depth", "current_depth", "Number of messages on the queue", "", func(s *queueStatus) int64 { return s.depth }, false, false},
+	{"High depth", "high_depth", "Maximum number of messages on the queue since the previous collect", "", func(s *queueStatus) int64 { return s.highDepth }, true, false},
+	{queueAgeKey, "oldest_message_age_seconds", "Age of the oldest message on the queue, or 0 if the queue is empty", "seconds", func(s *queueStatus) int64 { return s.oldestAge }, false, true},
+}
+
+// Function used to inquire the depth of queues, which can be replaced in tests
+var inquireQueues = doInquireQueues
+
+// initialiseQueueMetrics adds the selected queue depth metrics to the metrics map
+func initialiseQueueMetrics(metrics map[string]*metricData, cfg *metricsConfig) {
+	for _, queueMetric := range queueMetrics {
+		key := queueKeyPrefix + queueMetric.key
+		addPCFMetric(metrics, cfg, key, queueMetric.name, queueMetric.description, objectPrefix, objectLabel)
+		if metric, ok := metrics[key]; ok {
+			metric.unit = queueMetric.unit
+			metric.aggregateMax = queueMetric.age
+		}
+	}
+}
+
+// updateQueueMetrics updates values for the queue depth metrics from the depth of each queue. The first high depth of
+// each queue after connecting covers the time since its statistics were last reset, which may have been long ago, so
+// it is not reported, and only starts the period covered by the next one.
+func updateQueueMetrics(metrics map[string]*metricData, statuses []queueStatus) {
+	now := time.Now()
+	for _, queueMetric := range queueMetrics {
+		metric, ok := metrics[queueKeyPrefix+queueMetric.key]
+		if !ok {
+			continue
+		}
+		metric.values = make(map[string]float64)
+		metric.lastUpdate = now
+		previous := metric.previous
+		if queueMetric.watermark {
+			metric.previous = make(map[string]float64)
+		}
+		for i := range statuses {
+			if queueMetric.age && statuses[i].oldestAge == unknownMessageAge {
+				continue
+			}
+			value := float64(queueMetric.value(&statuses[i]))
+			if queueMetric.watermark {
+				metric.previous[statuses[i].name] = value
+				if _, ok := previous[statuses[i].name]; !ok {
+					continue
+				}
+			}
+			metric.values[statuses[i].name] = value
+		}
+	}
+}
+
+// doInquireQueues returns the depth of the local queues matching the configured names, in sorted order. The high
+// depth is read by resetting the statistics of the queues, so it is the maximum depth since they were last reset. The
+// age of the oldest message is read from the status of the queues, if its metric is selected.
+func doInquireQueues(pcfConns *pcfPool, cfg *metricsConfig) ([]queueStatus, error) {
+
+	if pcfConns == nil {
+		return nil, nil
+	}
+
+	// The queues matching each name are inquired concurrently
+	patterns := strings.Split(cfg.depthQueues, ",")
+	results := make([]map[string]*queueStatus, len(patterns))
+	err := pcfConns.forEach(len(patterns), func(conn *pcfConnection, i int) error {
+		queues := make(map[string]*queueStatus)
+		results[i] = queues
+
+		responses, err := conn.command(ibmmq.MQCMD_INQUIRE_Q,
+			stringParameter(ibmmq.MQCA_Q_NAME, patterns[i]),
+			integerParameter(ibmmq.MQIA_Q_TYPE, ibmmq.MQQT_LOCAL),
+			integerListParameter(ibmmq.MQIACF_Q_ATTRS, ibmmq.MQCA_Q_NAME, ibmmq.MQIA_CURRENT_Q_DEPTH))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			name := response.getString(ibmmq.MQCA_Q_NAME)
+			queues[name] = &queueStatus{name: name, depth: response.getInt(ibmmq.MQIA_CURRENT_Q_DEPTH), oldestAge: unknownMessageAge}
+		}
+		if len(queues) == 0 {
+			return nil
+		}
+
+		// The statistics are reset after the depth is inquired, so the high depth is at least the current depth
+		responses, err = conn.command(ibmmq.MQCMD_RESET_Q_STATS,
+			stringParameter(ibmmq.MQCA_Q_NAME, patterns[i]))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			if queue, ok := queues[response.getString(ibmmq.MQCA_Q_NAME)]; ok {
+				queue.highDepth = response.getInt(ibmmq.MQIA_HIGH_Q_DEPTH)
+				if queue.highDepth < queue.depth {
+					queue.highDepth = queue.depth
+				}
+			}
+		}
+
+		if !cfg.isSelected(queueKeyPrefix + queueAgeKey) {
+			return nil
+		}
+		responses, err = conn.command(ibmmq.MQCMD_INQUIRE_Q_STATUS,
+			stringParameter(ibmmq.MQCA_Q_NAME, patterns[i]),
+			integerListParameter(ibmmq.MQIACF_Q_STATUS_ATTRS, ibmmq.MQCA_Q_NAME, ibmmq.MQIA_CURRENT_Q_DEPTH, ibmmq.MQIACF_OLDEST_MSG_AGE))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			if queue, ok := queues[response.getString(ibmmq.MQCA_Q_NAME)]; ok {
+				queue.oldestAge = getOldestMessageAge(response, cfg.omitEmptyAge)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string]*queueStatus)
+	for _, result := range results {
+		for name, queue := range result {
+			queues[name] = queue
+		}
+	}
+
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statuses := make([]queueStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, *queues[name])
+	}
+	return statuses, nil
+}
+
+// getOldestMessageAge returns the age of the oldest message from the status of a queue, or unknownMessageAge if the
+// queue manager does not return it, which is the case unless queue monitoring (MONQ) is enabled for the queue. The
+// age of an empty queue is 0, or unknown if the ages of empty queues are omitted. The depth is taken from the same
+// status, so that it is consistent with the age.
+func getOldestMessageAge(response pcfResponse, omitEmpty bool) int64 {
+	if _, ok := response[ibmmq.MQIACF_OLDEST_MSG_AGE]; !ok {
+		return unknownMessageAge
+	}
+	if omitEmpty && response.getInt(ibmmq.MQIA_CURRENT_Q_DEPTH) == 0 {
+		return unknownMessageAge
+	}
+	return response.getInt(ibmmq.MQIACF_OLDEST_MSG_AGE)
+}
@@ -0,0 +1,138 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+func TestInitialiseQueueMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseQueueMetrics(metrics, &metricsConfig{depthQueues: "APP.*"})
+
+	for _, key := range []string{queueKeyPrefix + "Current depth", queueKeyPrefix + "High depth", queueKeyPrefix + queueAgeKey} {
+		metric, ok := metrics[key]
+		if !ok {
+			t.Fatalf("Expected queue metric %s not found in map", key)
+		}
+		prefix, labels := getVecDetails(metric)
+		if prefix != objectPrefix || len(labels) != 2 || labels[0] != objectLabel || labels[1] != qmgrLabel {
+			t.Errorf("Expected prefix=%s, labels=%v; actual %s, %v", objectPrefix, []string{objectLabel, qmgrLabel}, prefix, labels)
+		}
+	}
+	if name := getFullName(namespace, metrics[queueKeyPrefix+"High depth"]); name != "ibmmq_queue_high_depth" {
+		t.Errorf("Expected name=%s; actual %s", "ibmmq_queue_high_depth", name)
+	}
+	age := metrics[queueKeyPrefix+queueAgeKey]
+	if name := getFullName(namespace, age); name != "ibmmq_queue_oldest_message_age_seconds" || age.unit != "seconds" || !age.aggregateMax {
+		t.Errorf("Expected name=%s, unit=%s, aggregateMax=%v; actual %s, %s, %v", "ibmmq_queue_oldest_message_age_seconds", "seconds", true, name, age.unit, age.aggregateMax)
+	}
+}
+
+func TestUpdateQueueMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseQueueMetrics(metrics, &metricsConfig{depthQueues: "APP.*"})
+	depth, highDepth := metrics[queueKeyPrefix+"Current depth"], metrics[queueKeyPrefix+"High depth"]
+
+	// The first high depth after connecting covers an unknown period, so only the current depth is reported
+	updateQueueMetrics(metrics, []queueStatus{{name: "APP.IN", depth: 5, highDepth: 5000}})
+	if actual := depth.values["APP.IN"]; actual != 5 {
+		t.Errorf("Expected current depth=%d; actual %f", 5, actual)
+	}
+	if len(highDepth.values) != 0 || highDepth.lastUpdate.IsZero() {
+		t.Errorf("Expected no high depth values, with the last update time set; actual %v, %v", highDepth.values, highDepth.lastUpdate)
+	}
+
+	// Later high depths are the maximum since the previous inquiry, and new queues start their own period
+	updateQueueMetrics(metrics, []queueStatus{{name: "APP.IN", depth: 2, highDepth: 40}, {name: "APP.OUT", depth: 1, highDepth: 7}})
+	if actual, ok := highDepth.values["APP.IN"]; !ok || actual != 40 || len(highDepth.values) != 1 {
+		t.Errorf("Expected high depth values=%v; actual %v", map[string]float64{"APP.IN": 40}, highDepth.values)
+	}
+	if len(depth.values) != 2 || depth.values["APP.OUT"] != 1 {
+		t.Errorf("Expected current depth values=%v; actual %v", map[string]float64{"APP.IN": 2, "APP.OUT": 1}, depth.values)
+	}
+
+	// Queues which no longer match are removed, and start a new period if they match again
+	updateQueueMetrics(metrics, []queueStatus{{name: "APP.OUT", depth: 1, highDepth: 3}})
+	updateQueueMetrics(metrics, []queueStatus{{name: "APP.IN", depth: 0, highDepth: 9}, {name: "APP.OUT", depth: 1, highDepth: 1}})
+	if _, ok := highDepth.values["APP.IN"]; ok || highDepth.values["APP.OUT"] != 1 {
+		t.Errorf("Expected high depth values=%v; actual %v", map[string]float64{"APP.OUT": 1}, highDepth.values)
+	}
+}
+
+func TestUpdateQueueMetrics_OldestMessageAge(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseQueueMetrics(metrics, &metricsConfig{depthQueues: "APP.*"})
+	age := metrics[queueKeyPrefix+queueAgeKey]
+
+	// Queues with an unknown age are not reported, while empty queues have an age of 0
+	updateQueueMetrics(metrics, []queueStatus{
+		{name: "APP.EMPTY", oldestAge: 0},
+		{name: "APP.IN", depth: 3, oldestAge: 120},
+		{name: "APP.UNMONITORED", depth: 1, oldestAge: unknownMessageAge},
+	})
+	expected := map[string]float64{"APP.EMPTY": 0, "APP.IN": 120}
+	if !reflect.DeepEqual(age.values, expected) {
+		t.Errorf("Expected ages=%v; actual %v", expected, age.values)
+	}
+	if depth := metrics[queueKeyPrefix+"Current depth"]; depth.values["APP.UNMONITORED"] != 1 {
+		t.Errorf("Expected depth=%d for a queue with an unknown age; actual %v", 1, depth.values)
+	}
+
+	// The ages of the queues over the label value limit are reported by their maximum
+	limitLabelValues(getTestLogger(), map[string]*metricData{queueKeyPrefix + queueAgeKey: age}, 1)
+	expected = map[string]float64{"APP.EMPTY": 0, otherLabelValue: 120}
+	if !reflect.DeepEqual(age.values, expected) {
+		t.Errorf("Expected ages=%v; actual %v", expected, age.values)
+	}
+}
+
+func TestGetOldestMessageAge(t *testing.T) {
+
+	status := func(depth int64, age ...int64) pcfResponse {
+		response := pcfResponse{ibmmq.MQIA_CURRENT_Q_DEPTH: {Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIA_CURRENT_Q_DEPTH, Int64Value: []int64{depth}}}
+		if len(age) > 0 {
+			response[ibmmq.MQIACF_OLDEST_MSG_AGE] = &ibmmq.PCFParameter{Type: ibmmq.MQCFT_INTEGER, Parameter: ibmmq.MQIACF_OLDEST_MSG_AGE, Int64Value: age}
+		}
+		return response
+	}
+	tests := []struct {
+		name      string
+		response  pcfResponse
+		omitEmpty bool
+		expected  int64
+	}{
+		{"Messages", status(4, 75), false, 75},
+		{"Empty", status(0, 0), false, 0},
+		{"EmptyOmitted", status(0, 0), true, unknownMessageAge},
+		{"MessagesOmitEmpty", status(4, 75), true, 75},
+		{"NotMonitored", status(4, int64(ibmmq.MQMON_NOT_AVAILABLE)), false, unknownMessageAge},
+		{"NotReturned", status(4), false, unknownMessageAge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := getOldestMessageAge(test.response, test.omitEmpty); actual != test.expected {
+				t.Errorf("Expected age=%d; actual %d", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// refreshLimiter allows at most one refresh in each interval
+type refreshLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// allow returns zero if a refresh is allowed at the given time, and records it, or otherwise the time to wait until
+// the next refresh is allowed
+func (l *refreshLimiter) allow(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if wait := l.interval - now.Sub(l.last); !l.last.IsZero() && wait > 0 {
+		return wait
+	}
+	l.last = now
+	return 0
+}
+
+// newRefreshHandler returns an HTTP handler which refreshes the metrics straight away, by processing the pending
+// publications and updating the metrics as for a Prometheus scrape, and responds once the refresh has completed.
+// Refreshes are limited to one in each interval, so that they cannot be used to overload the queue manager.
+func newRefreshHandler(c *Collector, interval time.Duration) http.HandlerFunc {
+
+	limiter := &refreshLimiter{interval: interval}
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Metrics are only refreshed by a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		if wait := limiter.allow(time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, fmt.Sprintf("Metrics can only be refreshed once every %v", interval), http.StatusTooManyRequests)
+			return
+		}
+
+		c.refresh()
+		if atomic.LoadInt32(&c.status) != 1 {
+			http.Error(w, "Metrics are not available until connected to the queue manager", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// refresh processes the pending publications and updates the metrics, discarding the collected values - the changes in
+// cumulative values are still added to their Prometheus counters, so they are reported by the next scrape
+func (c *Collector) refresh() {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	c.collect(ch, refreshRequest)
+	close(ch)
+	<-done
+}
@@ -0,0 +1,92 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshLimiter(t *testing.T) {
+
+	limiter := refreshLimiter{interval: 10 * time.Second}
+	start := time.Unix(1000, 0)
+	if wait := limiter.allow(start); wait != 0 {
+		t.Errorf("Expected the first refresh to be allowed; actual wait %v", wait)
+	}
+	if wait := limiter.allow(start.Add(4 * time.Second)); wait != 6*time.Second {
+		t.Errorf("Expected wait=%v within the interval; actual %v", 6*time.Second, wait)
+	}
+	if wait := limiter.allow(start.Add(10 * time.Second)); wait != 0 {
+		t.Errorf("Expected a refresh to be allowed after the interval; actual wait %v", wait)
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.status = 1
+	requests := make(chan metricsRequest, 1)
+	go func() {
+		for request := range c.requestChannel {
+			requests <- request
+			c.responseChannel <- map[string]*metricData{}
+		}
+	}()
+	defer close(c.requestChannel)
+	handler := newRefreshHandler(c, time.Minute)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/refresh", nil))
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "POST" {
+		t.Errorf("Expected status=%d, Allow=%s for GET; actual %d, %s", http.StatusMethodNotAllowed, "POST", recorder.Code, recorder.Header().Get("Allow"))
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/metrics/refresh", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected status=%d; actual %d", http.StatusNoContent, recorder.Code)
+	}
+	select {
+	case request := <-requests:
+		if !request.collect || !request.refresh || request.keys != nil {
+			t.Errorf("Expected a full refresh request; actual %+v", request)
+		}
+	default:
+		t.Error("Expected a request for metrics")
+	}
+
+	// A second refresh within the interval is rejected without a request for metrics
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/metrics/refresh", nil))
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected status=%d, Retry-After=%s; actual %d, %s", http.StatusTooManyRequests, "60", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	if len(requests) != 0 {
+		t.Error("Expected no request for metrics for a rejected refresh")
+	}
+
+	// A refresh while not connected reports that metrics are not available
+	c.status = 0
+	recorder = httptest.NewRecorder()
+	newRefreshHandler(c, time.Minute).ServeHTTP(recorder, httptest.NewRequest("POST", "/metrics/refresh", nil))
+	<-requests
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status=%d while not connected; actual %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
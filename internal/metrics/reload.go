@@ -0,0 +1,141 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+// Reload re-reads the metrics configuration, and reconnects to the queue manager to rebuild the metrics from the
+// metrics which it makes available, without restarting metrics gathering
+func Reload(log *logger.Logger) error {
+
+	stateMutex.Lock()
+	c := collector
+	stateMutex.Unlock()
+	if c == nil {
+		return fmt.Errorf("Metrics gathering has not started")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("Invalid metrics configuration: %v", err)
+	}
+	return c.Reload(cfg)
+}
+
+// Reload reconnects to the queue manager with the given configuration, and rebuilds the metrics map. Accumulated
+// values are removed once reconnected, as for a switch to another queue manager, and requests for metrics are not held
+// up meanwhile. Settings which determine the names and labels of the
+// registered metrics, or how metrics gathering is stopped, are kept from the current configuration. An error is
+// returned if connecting fails, in which case it is retried as usual.
+func (c *Collector) Reload(cfg *metricsConfig) error {
+
+	c.switchMutex.Lock()
+	defer c.switchMutex.Unlock()
+	c.keepRestartSettings(cfg)
+	select {
+	case c.reloadChannel <- cfg:
+	case <-c.done:
+		return fmt.Errorf("Metrics gathering has stopped")
+	}
+	err := <-c.switchResult
+
+	c.lockRequests()
+	defer c.unlockRequests()
+	for _, counterVec := range c.counterMap {
+		counterVec.Reset()
+	}
+	for _, histogram := range c.histograms {
+		histogram.reset()
+	}
+	atomic.StoreInt64(&c.countersStart, time.Now().UnixNano())
+	c.known = initialiseKnownMetrics(cfg)
+	c.staleAfter = cfg.staleAfter
+	atomic.StoreInt64(&c.collectTimeout, int64(cfg.collectTimeout))
+	c.digits = cfg.digits
+	c.firstCollect = true
+	return err
+}
+
+// keepRestartSettings copies the settings which can only be changed by restarting metrics gathering from the current
+// configuration, with a warning for each one which has changed
+func (c *Collector) keepRestartSettings(cfg *metricsConfig) {
+
+	settings := []struct {
+		env     string
+		changed bool
+	}{
+		{prefixEnv, cfg.prefix != c.cfg.prefix},
+		{labelsEnv, !reflect.DeepEqual(cfg.labels, c.cfg.labels)},
+		{podLabelsEnv, cfg.podLabels != c.cfg.podLabels},
+		{qmgrLabelNameEnv, cfg.qmLabelName != c.cfg.qmLabelName},
+		{qmgrLabelValueEnv, cfg.qmLabelValue != c.cfg.qmLabelValue},
+		{rawUnitsEnv, cfg.rawUnits != c.cfg.rawUnits},
+		{countersEnv, cfg.counters != c.cfg.counters},
+		{snakeCaseEnv, cfg.snakeCase != c.cfg.snakeCase},
+		{sizeBucketsEnv, !reflect.DeepEqual(cfg.sizeBuckets, c.cfg.sizeBuckets)},
+		{drainTimeoutEnv, cfg.drainTimeout != c.cfg.drainTimeout},
+		{listenAddressEnv, cfg.listenAddress != c.cfg.listenAddress},
+		{portEnv, cfg.port != c.cfg.port},
+		{pathEnv, cfg.path != c.cfg.path},
+		{healthPathEnv, cfg.healthPath != c.cfg.healthPath},
+		{refreshIntervalEnv, cfg.refresh != c.cfg.refresh},
+		{serverCertFileEnv, cfg.serverCertFile != c.cfg.serverCertFile},
+		{serverKeyFileEnv, cfg.serverKeyFile != c.cfg.serverKeyFile},
+		{serverCAFileEnv, cfg.serverCAFile != c.cfg.serverCAFile},
+		{pushURLEnv, cfg.pushURL != c.cfg.pushURL},
+		{pushIntervalEnv, cfg.pushInterval != c.cfg.pushInterval},
+		{pushJobEnv, cfg.pushJob != c.cfg.pushJob},
+		{otlpEndpointEnv, cfg.otlpEndpoint != c.cfg.otlpEndpoint},
+		{otlpIntervalEnv, cfg.otlpInterval != c.cfg.otlpInterval},
+		{fileEnv, cfg.file != c.cfg.file},
+		{fileIntervalEnv, cfg.fileInterval != c.cfg.fileInterval},
+		{fileFormatEnv, cfg.fileFormat != c.cfg.fileFormat},
+		{startupJitterEnv, cfg.startupJitter != c.cfg.startupJitter},
+		{logLevelEnv, cfg.logLevel != c.cfg.logLevel},
+		{targetsEnv, !reflect.DeepEqual(cfg.targets, c.cfg.targets)},
+		{targetIntervalEnv, cfg.targetInterval != c.cfg.targetInterval},
+	}
+	for _, setting := range settings {
+		if setting.changed {
+			c.log.Printf("Metrics Warning: %s cannot be changed without restarting", setting.env)
+		}
+	}
+	cfg.prefix, cfg.labels, cfg.qmLabelName, cfg.qmLabelValue = c.cfg.prefix, c.cfg.labels, c.cfg.qmLabelName, c.cfg.qmLabelValue
+	cfg.podLabels = c.cfg.podLabels
+	cfg.rawUnits, cfg.counters, cfg.snakeCase = c.cfg.rawUnits, c.cfg.counters, c.cfg.snakeCase
+	cfg.sizeBuckets, cfg.drainTimeout = c.cfg.sizeBuckets, c.cfg.drainTimeout
+	cfg.listenAddress, cfg.port, cfg.path, cfg.healthPath = c.cfg.listenAddress, c.cfg.port, c.cfg.path, c.cfg.healthPath
+	cfg.refresh = c.cfg.refresh
+	cfg.serverCertFile, cfg.serverKeyFile, cfg.serverCAFile = c.cfg.serverCertFile, c.cfg.serverKeyFile, c.cfg.serverCAFile
+	cfg.pushURL, cfg.pushInterval, cfg.pushJob = c.cfg.pushURL, c.cfg.pushInterval, c.cfg.pushJob
+	cfg.otlpEndpoint, cfg.otlpInterval = c.cfg.otlpEndpoint, c.cfg.otlpInterval
+	cfg.file, cfg.fileInterval, cfg.fileFormat = c.cfg.file, c.cfg.fileInterval, c.cfg.fileFormat
+	cfg.startupJitter, cfg.logLevel = c.cfg.startupJitter, c.cfg.logLevel
+	cfg.targets, cfg.targetInterval = c.cfg.targets, c.cfg.targetInterval
+
+	// When rotating through targets, the connection name and channel are those of the current target
+	if len(cfg.targets) > 0 {
+		cfg.connName, cfg.channel = c.cfg.connName, c.cfg.channel
+	}
+}
@@ -0,0 +1,180 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestReload(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	var ended int32
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { atomic.AddInt32(&ended, 1) })
+	defer teardownTestConnection()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), log)
+	c.Start(ctx)
+	<-c.started
+
+	c.requestChannel <- describeRequest
+	before := len(<-c.responseChannel)
+
+	// Queue metrics are only available after reloading with queues to monitor
+	cfg := getTestConfig()
+	cfg.queues = "APP.*"
+	countersStart := atomic.LoadInt64(&c.countersStart)
+	err := c.Reload(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if atomic.LoadInt32(&ended) != 1 {
+		t.Errorf("Expected the connection to be ended before reloading; actual ended %d times", atomic.LoadInt32(&ended))
+	}
+	c.requestChannel <- describeRequest
+	metrics := <-c.responseChannel
+	if _, ok := metrics[testKey2]; !ok || len(metrics) != before+1 {
+		t.Errorf("Expected metrics-size=%d, including %s; actual %d", before+1, testKey2, len(metrics))
+	}
+	if !c.firstCollect {
+		t.Error("Expected the first collect after reloading to be skipped")
+	}
+	if actual := atomic.LoadInt64(&c.countersStart); actual <= countersStart {
+		t.Errorf("Expected the counters to start after %d once reloaded; actual %d", countersStart, actual)
+	}
+
+	cancel()
+	<-c.done
+	expected := fmt.Sprintf("with %d metrics before and %d after", before, before+1)
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log to contain %s; actual %s", expected, buf.String())
+	}
+}
+
+func TestReload_Reconnecting(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	// Reloading while waiting to reconnect connects straight away
+	var connects int32
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		if atomic.AddInt32(&connects, 1) == 1 {
+			return newQMConnection(), fmt.Errorf("MQCONN: MQCC = MQCC_FAILED [2] MQRC = MQRC_Q_MGR_NOT_AVAILABLE [2059]")
+		}
+		return newQMConnection(), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	err := c.Reload(getTestConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if atomic.LoadInt32(&connects) != 2 || atomic.LoadInt32(&c.status) != 1 {
+		t.Errorf("Expected connects=%d, status=%d; actual %d, %d", 2, 1, atomic.LoadInt32(&connects), atomic.LoadInt32(&c.status))
+	}
+}
+
+func TestReload_Unlocked(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	// Reconnecting after the first connection hangs until released
+	var connects int32
+	connecting, release := make(chan struct{}), make(chan struct{})
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		if atomic.AddInt32(&connects, 1) == 2 {
+			close(connecting)
+			<-release
+		}
+		return newQMConnection(), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Reload(getTestConfig())
+	}()
+	<-connecting
+
+	// The request lock is not held while reconnecting, so other requests are not held up by the reload
+	select {
+	case c.requestLock <- struct{}{}:
+		c.unlockRequests()
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request lock not to be held while reloading")
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+}
+
+func TestKeepRestartSettings(t *testing.T) {
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	c := newCollector("qmName", &metricsConfig{prefix: "app", labels: map[string]string{"env": "test"}}, log)
+
+	cfg := &metricsConfig{prefix: "other", include: []string{testMappingKey1}}
+	c.keepRestartSettings(cfg)
+
+	if cfg.prefix != "app" || cfg.labels["env"] != "test" {
+		t.Errorf("Expected prefix=%s, labels=%v; actual %s, %v", "app", c.cfg.labels, cfg.prefix, cfg.labels)
+	}
+	if len(cfg.include) != 1 {
+		t.Errorf("Expected include patterns to be reloaded; actual %v", cfg.include)
+	}
+	for _, env := range []string{prefixEnv, labelsEnv} {
+		if !strings.Contains(buf.String(), env+" cannot be changed without restarting") {
+			t.Errorf("Expected log to contain warning for %s; actual %s", env, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), sizeBucketsEnv) {
+		t.Errorf("Expected no warning for unchanged %s; actual %s", sizeBucketsEnv, buf.String())
+	}
+}
@@ -0,0 +1,226 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+// Functions used to check the type of the model queue, and to find, inquire and browse the reply queue which the
+// published metrics are put to, which can be replaced in tests
+var (
+	checkModelQueue       = doCheckModelQueue
+	openReplyQueue        = doOpenReplyQueue
+	inquireReplyQueue     = doInquireReplyQueue
+	browsePublishInterval = doBrowsePublishInterval
+)
+
+// metricsReplyQueuePrefix is the prefix of the names of the reply queues created by the MQ metrics library, which
+// does not use the configured dynamic queue prefix
+const metricsReplyQueuePrefix = "AMQ."
+
+// browseBufferSize is the size of the buffer used to browse publications on the reply queue - only the start of a
+// publication is needed, as the monitor interval is one of its first parameters
+const browseBufferSize = 4096
+
+// replyQueueInquiry is a connection to the queue manager with the reply queue of the published metrics opened for
+// inquiry and browsing, on a connection for PCF commands
+type replyQueueInquiry struct {
+	conn   *pcfConnection
+	replyQ ibmmq.MQObject
+}
+
+// modelQueueError is returned when the model queue does not create temporary dynamic queues
+type modelQueueError struct {
+	modelQueue     string
+	definitionType int32
+}
+
+func (e *modelQueueError) Error() string {
+	if e.definitionType == ibmmq.MQQDT_PREDEFINED {
+		return fmt.Sprintf("%s is not a model queue - set %s to a model queue with DEFTYPE(TEMPDYN)", e.modelQueue, modelQueueEnv)
+	}
+	return fmt.Sprintf("Model queue %s must have DEFTYPE(TEMPDYN), so that the reply queues created from it are deleted when the metrics exporter disconnects", e.modelQueue)
+}
+
+// newReplyQueueOD returns the object descriptor used to create a reply queue from the configured model queue, named
+// with the configured prefix, if any
+func newReplyQueueOD(cfg *metricsConfig) *ibmmq.MQOD {
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = cfg.modelQueue
+	if cfg.dynamicPrefix != "" {
+		mqod.DynamicQName = cfg.dynamicPrefix + "*"
+	}
+	return mqod
+}
+
+// doCheckModelQueue connects to the queue manager, and creates a reply queue from the model queue, to check that it is
+// a temporary dynamic queue. Reply queues created from a model queue with another definition type would be left
+// behind each time the exporter reconnects. Other errors are left to be reported when connecting, as the type cannot
+// then be checked.
+func doCheckModelQueue(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error {
+
+	qMgr, err := ibmmq.Connx(qmName, newConnectOptions(connConfig))
+	if err != nil {
+		return nil
+	}
+	// #nosec G104
+	defer qMgr.Disc()
+
+	replyQ, err := qMgr.Open(newReplyQueueOD(cfg), ibmmq.MQOO_INPUT_AS_Q_DEF|ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return nil
+	}
+	// A permanent dynamic queue is only deleted if it is closed with the delete option
+	// #nosec G104
+	defer replyQ.Close(ibmmq.MQCO_DELETE_PURGE)
+
+	values, _, err := replyQ.Inq([]int32{ibmmq.MQIA_DEFINITION_TYPE}, 1, 0)
+	if err != nil || len(values) == 0 || values[0] == ibmmq.MQQDT_TEMPORARY_DYNAMIC {
+		return nil
+	}
+	return &modelQueueError{modelQueue: cfg.modelQueue, definitionType: values[0]}
+}
+
+// doOpenReplyQueue finds the reply queue which the published metrics are put to, and opens it for inquiry. The MQ
+// metrics library does not give the name of the queue it creates from the model queue, so it is found from the status
+// of the handles on the queues with its prefix, as the one open for input by this process, other than the reply queue
+// of the PCF connection used to find it. This only finds it when connected in local bindings mode, as the process of
+// a client connection is the channel process.
+func doOpenReplyQueue(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (*replyQueueInquiry, error) {
+
+	if cfg.clientMode {
+		return nil, fmt.Errorf("The reply queue of the published metrics can only be found in local bindings mode")
+	}
+	conn, err := openPCFConnection(qmName, cfg, newConnectOptions(connConfig))
+	if err != nil {
+		return nil, err
+	}
+	responses, err := conn.command(ibmmq.MQCMD_INQUIRE_Q_STATUS,
+		stringParameter(ibmmq.MQCA_Q_NAME, metricsReplyQueuePrefix+"*"),
+		integerParameter(ibmmq.MQIACF_Q_STATUS_TYPE, ibmmq.MQIACF_Q_HANDLE),
+		integerListParameter(ibmmq.MQIACF_Q_STATUS_ATTRS, ibmmq.MQCA_Q_NAME, ibmmq.MQIACF_PROCESS_ID, ibmmq.MQIACF_OPEN_INPUT_TYPE))
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	name := ""
+	for _, response := range responses {
+		handleName := response.getString(ibmmq.MQCA_Q_NAME)
+		if handleName != strings.TrimSpace(conn.replyQ.Name) && response.getInt(ibmmq.MQIACF_PROCESS_ID) == int64(os.Getpid()) &&
+			response.getInt(ibmmq.MQIACF_OPEN_INPUT_TYPE) != int64(ibmmq.MQQSO_NO) {
+			name = handleName
+			break
+		}
+	}
+	if name == "" {
+		conn.close()
+		return nil, fmt.Errorf("The reply queue of the published metrics was not found")
+	}
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q
+	mqod.ObjectName = name
+	replyQ, err := conn.qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_BROWSE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	return &replyQueueInquiry{conn: conn, replyQ: replyQ}, nil
+}
+
+// close closes the reply queue and the connection it was opened on
+func (q *replyQueueInquiry) close() {
+	// #nosec G104
+	q.replyQ.Close(0)
+	q.conn.close()
+}
+
+// doInquireReplyQueue returns the current and maximum depths of the reply queue which the published metrics are put
+// to, or an error if it was not opened for inquiry, or cannot be inquired
+func doInquireReplyQueue(replyQInquiry *replyQueueInquiry) (int64, int64, error) {
+	if replyQInquiry == nil {
+		return 0, 0, fmt.Errorf("The reply queue of the published metrics is not open for inquiry")
+	}
+	values, _, err := replyQInquiry.replyQ.Inq([]int32{ibmmq.MQIA_CURRENT_Q_DEPTH, ibmmq.MQIA_MAX_Q_DEPTH}, 2, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("The depths of the reply queue of the published metrics were not returned")
+	}
+	return int64(values[0]), int64(values[1]), nil
+}
+
+// doBrowsePublishInterval returns the interval over which the queue manager gathered the first publication on the reply
+// queue, which is the interval at which it publishes metrics, without removing the publication from the queue. It
+// returns zero if there are no publications on the queue yet, or an error if it was not opened for browsing.
+func doBrowsePublishInterval(replyQInquiry *replyQueueInquiry) (time.Duration, error) {
+	if replyQInquiry == nil {
+		return 0, fmt.Errorf("The reply queue of the published metrics is not open for browsing")
+	}
+	getmqmd := ibmmq.NewMQMD()
+	gmo := ibmmq.NewMQGMO()
+	gmo.Options = ibmmq.MQGMO_BROWSE_FIRST | ibmmq.MQGMO_NO_WAIT | ibmmq.MQGMO_ACCEPT_TRUNCATED_MSG
+	gmo.Options |= ibmmq.MQGMO_CONVERT | ibmmq.MQGMO_FAIL_IF_QUIESCING
+	buf := make([]byte, browseBufferSize)
+	datalen, err := replyQInquiry.replyQ.Get(getmqmd, gmo, buf)
+	if err != nil {
+		mqreturn, ok := err.(*ibmmq.MQReturn)
+		if ok && mqreturn.MQRC == ibmmq.MQRC_NO_MSG_AVAILABLE {
+			return 0, nil
+		}
+		if !ok || mqreturn.MQRC != ibmmq.MQRC_TRUNCATED_MSG_ACCEPTED {
+			return 0, err
+		}
+		// The length is not returned for a truncated publication, which fills the buffer
+		datalen = len(buf)
+	}
+	return getMonitorInterval(buf[:datalen], pcfByteOrder()), nil
+}
+
+// checkReplyQueue inquires the depths of the reply queue which the published metrics are put to, which are reported
+// by the exporter, or are not reported if they are not known
+func (c *Collector) checkReplyQueue() {
+	depth, maxDepth, err := inquireReplyQueue(c.conn.replyQInquiry)
+	if err != nil {
+		depth, maxDepth = -1, -1
+	}
+	atomic.StoreInt64(&c.replyQueueDepth, depth)
+	atomic.StoreInt64(&c.replyQueueMaxDepth, maxDepth)
+}
+
+// checkReplyQueueFull inquires the depths of the reply queue which the published metrics are put to before its
+// publications are processed, when it holds the most of them. Once the queue has reached its maximum depth, the queue
+// manager discards the publications it cannot put to it, without reporting an error to the exporter, so this is
+// counted as publications having been dropped.
+func (c *Collector) checkReplyQueueFull() {
+	depth, maxDepth, err := inquireReplyQueue(c.conn.replyQInquiry)
+	if err != nil || maxDepth <= 0 || depth < maxDepth {
+		return
+	}
+	c.recordDroppedPublications(maxDepth)
+}
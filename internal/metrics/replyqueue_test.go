@@ -0,0 +1,104 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestModelQueueError(t *testing.T) {
+
+	err := &modelQueueError{modelQueue: "APP.IN", definitionType: ibmmq.MQQDT_PREDEFINED}
+	if !strings.Contains(err.Error(), "APP.IN is not a model queue") {
+		t.Errorf("Expected error for a queue which is not a model queue; actual %s", err.Error())
+	}
+
+	err = &modelQueueError{modelQueue: "METRICS.MODEL", definitionType: ibmmq.MQQDT_PERMANENT_DYNAMIC}
+	if !strings.Contains(err.Error(), "must have DEFTYPE(TEMPDYN)") {
+		t.Errorf("Expected error for a permanent dynamic model queue; actual %s", err.Error())
+	}
+	if category, reason := classifyError(err); category != categoryConfiguration || reason != 0 {
+		t.Errorf("Expected category=%s, reason=%d; actual %s, %d", categoryConfiguration, 0, category, reason)
+	}
+}
+
+func TestCheckReplyQueue(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	defer func() { inquireReplyQueue = doInquireReplyQueue }()
+
+	collector := newCollector("qmName", getTestConfig(), getTestLogger())
+	atomic.StoreInt32(&collector.status, 1)
+
+	collectValues := func() map[*prometheus.Desc]*dto.Metric {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		<-collector.requestChannel
+		collector.responseChannel <- map[string]*metricData{}
+
+		values := make(map[*prometheus.Desc]*dto.Metric)
+		for metric := range ch {
+			prometheusMetric := dto.Metric{}
+			metric.Write(&prometheusMetric)
+			values[metric.Desc()] = &prometheusMetric
+		}
+		return values
+	}
+
+	inquireReplyQueue = func(replyQInquiry *replyQueueInquiry) (int64, int64, error) {
+		return 5, 5000, nil
+	}
+	collector.checkReplyQueue()
+	values := collectValues()
+	if actual := values[collector.selfDescs.replyDepth].GetGauge().GetValue(); actual != 5 {
+		t.Errorf("Expected reply queue depth=%d; actual %f", 5, actual)
+	}
+	if actual := values[collector.selfDescs.replyMaxDepth].GetGauge().GetValue(); actual != 5000 {
+		t.Errorf("Expected reply queue max depth=%d; actual %f", 5000, actual)
+	}
+
+	// The depths are not reported while disconnected
+	atomic.StoreInt32(&collector.status, 0)
+	values = collectValues()
+	if _, ok := values[collector.selfDescs.replyDepth]; ok {
+		t.Errorf("Expected reply queue depth not to be reported while disconnected")
+	}
+
+	// Nor if the reply queue cannot be inquired
+	atomic.StoreInt32(&collector.status, 1)
+	inquireReplyQueue = func(replyQInquiry *replyQueueInquiry) (int64, int64, error) {
+		return 0, 0, fmt.Errorf("Not open")
+	}
+	collector.checkReplyQueue()
+	values = collectValues()
+	if _, ok := values[collector.selfDescs.replyDepth]; ok {
+		t.Errorf("Expected reply queue depth not to be reported when it cannot be inquired")
+	}
+	if _, ok := values[collector.selfDescs.replyMaxDepth]; ok {
+		t.Errorf("Expected reply queue max depth not to be reported when it cannot be inquired")
+	}
+}
@@ -0,0 +1,40 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"math"
+	"strconv"
+)
+
+// maxSignificantDigits is the most significant digits which can be given, as a float64 value has no more
+const maxSignificantDigits = 17
+
+// roundValue rounds the value to the number of significant digits, or returns it unchanged if digits is zero.
+// Rounding to significant digits, rather than decimal places, keeps small values which are not zero from being
+// rounded to zero, and values which are not finite are unchanged.
+func roundValue(value float64, digits int) float64 {
+	if digits == 0 || value == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', digits, 64), 64)
+	if err != nil {
+		return value
+	}
+	return rounded
+}
@@ -0,0 +1,95 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRoundValue(t *testing.T) {
+
+	tests := []struct {
+		value    float64
+		digits   int
+		expected float64
+	}{
+		{0.30000000000000004, 0, 0.30000000000000004},
+		{0.30000000000000004, 6, 0.3},
+		{123456.789, 4, 123500},
+		{0.000012345678, 3, 0.0000123},
+		{-2.71828, 2, -2.7},
+		{0, 3, 0},
+	}
+	for _, test := range tests {
+		if actual := roundValue(test.value, test.digits); actual != test.expected {
+			t.Errorf("Expected value=%v for %v to %d digits; actual %v", test.expected, test.value, test.digits, actual)
+		}
+	}
+
+	// Small values which are not zero are never rounded to zero, and values which are not finite are unchanged
+	if actual := roundValue(1e-300, 1); actual == 0 {
+		t.Errorf("Expected a small value not to be rounded to zero; actual %v", actual)
+	}
+	if actual := roundValue(math.Inf(1), 3); !math.IsInf(actual, 1) {
+		t.Errorf("Expected value=%v; actual %v", math.Inf(1), actual)
+	}
+	if actual := roundValue(math.NaN(), 3); !math.IsNaN(actual) {
+		t.Errorf("Expected value=%v; actual %v", math.NaN(), actual)
+	}
+}
+
+func TestCollect_SignificantDigits(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := getTestConfig()
+	cfg.digits = 3
+	collector := newCollector("qmName", cfg, getTestLogger())
+	collector.gaugeMap[testKey1] = createGaugeVec(namespace, nil, qmgrLabel, &metricData{name: testElement1Name, description: testElement1Description})
+	collector.firstCollect = false
+
+	metric := &metricData{
+		name:       testElement1Name,
+		values:     map[string]float64{qmgrLabelValue: 1.23456789},
+		lastUpdate: time.Now(),
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- map[string]*metricData{testKey1: metric}
+	for range ch {
+	}
+
+	// The gauge is rounded, but the value in the metrics map is not
+	prometheusMetric := dto.Metric{}
+	collector.gaugeMap[testKey1].WithLabelValues("qmName").Write(&prometheusMetric)
+	if actual := prometheusMetric.GetGauge().GetValue(); actual != 1.23 {
+		t.Errorf("Expected value=%v; actual %v", 1.23, actual)
+	}
+	if actual := metric.values[qmgrLabelValue]; actual != 1.23456789 {
+		t.Errorf("Expected stored value=%v; actual %v", 1.23456789, actual)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// readScales reads the factors which the values of metrics are multiplied by when they are exposed from a JSON file,
+// which maps metric keys to factors, in the format {"DISK/Log/Log - bytes in use": 1024}
+func readScales(name, file string) (map[string]float64, error) {
+
+	if file == "" {
+		return nil, nil
+	}
+	// #nosec G304 - the file is given by the configuration
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", name, err)
+	}
+	var scales map[string]float64
+	err = json.Unmarshal(buf, &scales)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid JSON map of metric keys to scale factors: %v", name, err)
+	}
+	for key, factor := range scales {
+		if strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%s contains an empty metric key", name)
+		}
+		if factor <= 0 || math.IsInf(factor, 0) || math.IsNaN(factor) {
+			return nil, fmt.Errorf("%s must only contain positive, finite scale factors: '%s' has %v", name, key, factor)
+		}
+	}
+	return scales, nil
+}
+
+// applyScales sets the scale factors of the metrics which have one, and returns the number of factors which were
+// applied. As for descriptions, a published metric can be given by its metric key, or by the key used to select it.
+func applyScales(metrics map[string]*metricData, mappingKeys map[string]string, scales map[string]float64) int {
+
+	applied := 0
+	for key, metric := range metrics {
+		factor, ok := scales[key]
+		if !ok {
+			factor, ok = scales[mappingKeys[key]]
+		}
+		if ok {
+			metric.factor = factor
+			applied++
+		}
+	}
+	return applied
+}
+
+// exposedValue returns a value of the metric as it is exposed, multiplied by its scale factor, if any. The values in
+// the metrics map are not scaled.
+func (metric *metricData) exposedValue(value float64) float64 {
+	if metric.factor == 0 {
+		return value
+	}
+	return value * metric.factor
+}
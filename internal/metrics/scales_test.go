@@ -0,0 +1,115 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestReadScales(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "scales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	files := map[string]bool{
+		`{"CPU/SystemSummary/CPU load - five minute average": 1024}`:  true,
+		`{"CPU/SystemSummary/CPU load - five minute average": 0.001}`: true,
+		`{"CPU/SystemSummary/CPU load - five minute average": 0}`:     false,
+		`{"CPU/SystemSummary/CPU load - five minute average": -1}`:    false,
+		`{"CPU/SystemSummary/CPU load - five minute average": 1e999}`: false,
+		`{"CPU/SystemSummary/CPU load - five minute average": "2"}`:   false,
+		`{"": 2}`: false,
+	}
+	for content, valid := range files {
+		err = ioutil.WriteFile(file.Name(), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scales, err := readScales(scalesFileEnv, file.Name())
+		if valid && err != nil {
+			t.Errorf("Unexpected error %s for %s", err.Error(), content)
+		} else if !valid && err == nil {
+			t.Errorf("Expected error for %s", content)
+		}
+		if valid && len(scales) != 1 {
+			t.Errorf("Expected %d scale factor; actual %v", 1, scales)
+		}
+	}
+
+	_, err = readScales(scalesFileEnv, file.Name()+".missing")
+	if err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestInitialiseMetrics_Scales(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	cfg := &metricsConfig{scales: map[string]float64{
+		testMappingKey1: 1024,
+		"Unknown/Key":   2,
+	}}
+	metrics, err := initialiseMetrics(getTestLogger(), cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	updateMetrics(metrics)
+
+	metric := metrics[testKey1]
+	if actual := metric.exposedValue(2); actual != 2048 {
+		t.Errorf("Expected exposed value=%d; actual %f", 2048, actual)
+	}
+	if actual := metrics[uptimeKey].exposedValue(2); actual != 2 {
+		t.Errorf("Expected unscaled exposed value=%d; actual %f", 2, actual)
+	}
+
+	// Internal values are not scaled, but the values collected and in the snapshot are
+	if actual := metric.values[qmgrLabelValue]; actual != 1 {
+		t.Errorf("Expected internal value=%d; actual %f", 1, actual)
+	}
+	if actual := makeSnapshot("QM1", namespace, metrics, 0)[testKey1].Values["QM1"]; actual != 1024 {
+		t.Errorf("Expected snapshot value=%d; actual %f", 1024, actual)
+	}
+
+	collector := newCollector("QM1", getTestConfig(), getTestLogger())
+	collector.firstCollect = false
+	metric.lastUpdate = time.Now()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	<-collector.requestChannel
+	collector.responseChannel <- map[string]*metricData{testKey1: metric}
+	for range ch {
+	}
+	prometheusMetric := dto.Metric{}
+	collector.gaugeMap[testKey1].WithLabelValues("QM1").Write(&prometheusMetric)
+	if actual := prometheusMetric.GetGauge().GetValue(); actual != 1024 {
+		t.Errorf("Expected collected value=%d; actual %f", 1024, actual)
+	}
+}
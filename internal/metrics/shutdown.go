@@ -0,0 +1,68 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"context"
+)
+
+// Shutdown stops metrics gathering gracefully. The publications waiting on the reply queue are processed, then flush
+// is called while still connected to the queue manager, so that a final push or scrape of the metrics includes them,
+// and the connection is closed once it returns. The requests made by flush, and any scrape in progress, are responded
+// to until then. flush is always called, even if processing has already stopped, in which case its requests are
+// responded to with no metrics. Shutdown returns once processing has stopped, or with an error if the context is
+// done first, in which case the caller should cancel processing.
+func (c *Collector) Shutdown(ctx context.Context, flush func()) error {
+
+	select {
+	case c.shutdownChannel <- flush:
+	case <-c.done:
+		flush()
+		return nil
+	case <-ctx.Done():
+		flush()
+		return ctx.Err()
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushMetrics calls flush, and responds to requests until it returns or the context is cancelled - it is only used
+// by processMetrics. The flush runs separately, as the requests it makes are responded to by processMetrics.
+func (c *Collector) flushMetrics(ctx context.Context, flush func(), respond func(metricsRequest)) {
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		flush()
+	}()
+	for {
+		select {
+		case request := <-c.requestChannel:
+			respond(request)
+		case <-finished:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+func TestShutdown(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// A publication arrives on the reply queue once stopping has been requested
+	var stopping, ended int32
+	started := make(chan bool, 1)
+	teardownTestConnection := setupTestConnection(func() error {
+		if atomic.LoadInt32(&stopping) == 0 {
+			select {
+			case started <- true:
+			default:
+			}
+			return nil
+		}
+		mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values[qmgrLabelValue] = 5
+		return nil
+	}, func() {
+		atomic.AddInt32(&ended, 1)
+	})
+	defer teardownTestConnection()
+
+	cfg := getTestConfig()
+	cfg.drainTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	<-started
+	atomic.StoreInt32(&stopping, 1)
+
+	// The flush collects the metrics as a final push would
+	var flushed bool
+	var endedAtFlush int32
+	var value float64
+	err := c.Shutdown(ctx, func() {
+		c.lockRequests()
+		defer c.unlockRequests()
+		metrics := c.request(collectRequest)
+		flushed, endedAtFlush = true, atomic.LoadInt32(&ended)
+		if metric, ok := metrics[testKey1]; ok {
+			value = metric.values[qmgrLabelValue]
+		}
+	})
+	if err != nil {
+		t.Fatalf("Expected shutdown to succeed; actual error %v", err)
+	}
+	if !flushed {
+		t.Fatal("Expected the metrics to be flushed")
+	}
+	if endedAtFlush != 0 {
+		t.Errorf("Expected the connection to be open when flushing; actual ended %d times", endedAtFlush)
+	}
+	if value != 5 {
+		t.Errorf("Expected flushed value=%v; actual %v", 5, value)
+	}
+	if actual := atomic.LoadInt32(&ended); actual != 1 {
+		t.Errorf("Expected ended=%d; actual %d", 1, actual)
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("Expected processing to have stopped after shutting down")
+	}
+
+	// Requests once processing has stopped are responded to with no metrics, rather than waiting forever
+	response := make(chan map[string]*metricData, 1)
+	go func() {
+		c.lockRequests()
+		defer c.unlockRequests()
+		response <- c.request(collectRequest)
+	}()
+	select {
+	case metrics := <-response:
+		if len(metrics) != 0 {
+			t.Errorf("Expected no metrics after shutting down; actual %d", len(metrics))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request after shutting down was not responded to")
+	}
+}
+
+func TestShutdown_Stopped(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	<-c.started
+	cancel()
+	<-c.done
+
+	// The flush is still called, for example to push the status metrics, when processing has already stopped
+	var flushed bool
+	err := c.Shutdown(context.Background(), func() {
+		c.lockRequests()
+		defer c.unlockRequests()
+		flushed = len(c.request(collectRequest)) == 0
+	})
+	if err != nil {
+		t.Fatalf("Expected shutdown to succeed; actual error %v", err)
+	}
+	if !flushed {
+		t.Error("Expected the metrics to be flushed with no metrics")
+	}
+}
+
+func TestShutdown_Timeout(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	<-c.started
+
+	// The flush does not return before the timeout
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelTimeout()
+	release := make(chan bool)
+	err := c.Shutdown(timeout, func() { <-release })
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected error=%v; actual %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+	<-c.done
+}
@@ -0,0 +1,143 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+// metricSnapshot is the JSON representation of a metric, as served by the snapshot handler
+type metricSnapshot struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Values      map[string]float64 `json:"values"`
+}
+
+// newSnapshotHandler returns an HTTP handler which serves the current metric values as JSON, keyed by metric key.
+// The values are those from the most recent collect request, so that they are consistent with Prometheus scrapes.
+// If the request gives one or more key parameters, only the metrics with those keys are refreshed and served.
+func newSnapshotHandler(c *Collector) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		request := describeRequest
+		if keys := r.URL.Query()["key"]; len(keys) > 0 {
+			request = metricsRequest{collect: true, keys: make(map[string]bool, len(keys))}
+			for _, key := range keys {
+				request.keys[key] = true
+			}
+		}
+
+		timeout := time.Duration(atomic.LoadInt64(&c.collectTimeout))
+		response, ok := c.requestWithin(request, timeout)
+		if !ok {
+			http.Error(w, fmt.Sprintf("The metrics were not available within %v", timeout), http.StatusServiceUnavailable)
+			return
+		}
+		if request.keys != nil {
+			response = selectMetrics(response, request.keys)
+		}
+		snapshot := makeSnapshot(c.qmLabelValue(), c.namespace, response, c.digits)
+		c.unlockRequests()
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// makeSnapshot copies the metric details, so that the metrics map is not accessed after the request has completed,
+// with the values rounded to the number of significant digits, if it is not zero
+func makeSnapshot(qmName, metricNamespace string, metrics map[string]*metricData, digits int) map[string]metricSnapshot {
+
+	snapshot := make(map[string]metricSnapshot, len(metrics))
+	for key, metric := range metrics {
+		values := make(map[string]float64, len(metric.values))
+		for label, value := range metric.values {
+			// Queue manager metrics are reported against the queue manager label value, as they are in Prometheus
+			if label == qmgrLabelValue {
+				label = qmName
+			}
+			values[label] = roundValue(metric.exposedValue(value), digits)
+		}
+		snapshot[key] = metricSnapshot{
+			Name:        getFullName(metricNamespace, metric),
+			Description: metric.description,
+			Values:      values,
+		}
+	}
+	return snapshot
+}
+
+// metricSample is the JSON representation of a single metric value, as logged for debugging
+type metricSample struct {
+	Key   string  `json:"key"`
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+}
+
+// logSamples logs each metric value as a line of JSON at debug level, sorted by key and label, so that the values
+// gathered can be compared with those reported by the queue manager without scraping them
+func logSamples(log *logger.Logger, qmName string, metrics map[string]*metricData) {
+
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		metric := metrics[key]
+		labels := make([]string, 0, len(metric.values))
+		for label := range metric.values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			sample := metricSample{Key: key, Label: label, Value: metric.exposedValue(metric.values[label])}
+			if label == qmgrLabelValue {
+				sample.Label = qmName
+			}
+			// Values which are not finite cannot be represented in JSON, and are not exposed
+			line, err := json.Marshal(sample)
+			if err != nil {
+				continue
+			}
+			log.Debug(string(line))
+		}
+	}
+}
+
+// selectMetrics returns the metrics with the given keys
+func selectMetrics(metrics map[string]*metricData, keys map[string]bool) map[string]*metricData {
+	selected := make(map[string]*metricData, len(keys))
+	for key := range keys {
+		if metric, ok := metrics[key]; ok {
+			selected[key] = metric
+		}
+	}
+	return selected
+}
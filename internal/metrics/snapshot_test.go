@@ -0,0 +1,179 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+
+	metrics := map[string]*metricData{
+		testKey1: {
+			name:        testElement1Name,
+			description: testElement1Description,
+			values:      map[string]float64{qmgrLabelValue: 3},
+		},
+		"Class/Type/Queue depth": {
+			name:        "depth",
+			description: "Queue depth",
+			objectType:  true,
+			values:      map[string]float64{"APP.IN": 5},
+		},
+	}
+
+	c := newCollector("qmName", &metricsConfig{}, getTestLogger())
+	go func() {
+		request := <-c.requestChannel
+		if request.collect {
+			t.Errorf("Received unexpected collect request")
+		}
+		c.responseChannel <- metrics
+	}()
+
+	recorder := httptest.NewRecorder()
+	newSnapshotHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/json", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected Content-Type=%s; actual %s", "application/json", contentType)
+	}
+	snapshot := map[string]metricSnapshot{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &snapshot)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(snapshot) != 2 {
+		t.Fatalf("Expected %d metrics; actual %d", 2, len(snapshot))
+	}
+
+	qmgrMetric := snapshot[testKey1]
+	if qmgrMetric.Name != "ibmmq_qmgr_"+testElement1Name || qmgrMetric.Description != testElement1Description {
+		t.Errorf("Expected name=%s, description=%s; actual %s, %s", "ibmmq_qmgr_"+testElement1Name, testElement1Description, qmgrMetric.Name, qmgrMetric.Description)
+	}
+	if qmgrMetric.Values["qmName"] != 3 {
+		t.Errorf("Expected value=%d for label qmName; actual %v", 3, qmgrMetric.Values)
+	}
+	queueMetric := snapshot["Class/Type/Queue depth"]
+	if queueMetric.Name != "ibmmq_queue_depth" || queueMetric.Values["APP.IN"] != 5 {
+		t.Errorf("Expected name=%s, APP.IN=%d; actual %s, %v", "ibmmq_queue_depth", 5, queueMetric.Name, queueMetric.Values)
+	}
+}
+
+func TestSnapshotHandler_Keys(t *testing.T) {
+
+	metrics := map[string]*metricData{
+		testKey1: {name: testElement1Name, description: testElement1Description},
+		testKey2: {name: testElement2Name, description: testElement2Description},
+	}
+
+	c := newCollector("qmName", &metricsConfig{}, getTestLogger())
+	go func() {
+		request := <-c.requestChannel
+		if !request.collect || len(request.keys) != 2 || !request.keys[testKey1] || !request.keys["Unknown/Key"] {
+			t.Errorf("Expected collect request for keys %v; actual %+v", []string{testKey1, "Unknown/Key"}, request)
+		}
+		c.responseChannel <- metrics
+	}()
+
+	recorder := httptest.NewRecorder()
+	query := url.Values{"key": []string{testKey1, "Unknown/Key"}}.Encode()
+	newSnapshotHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/json?"+query, nil))
+
+	snapshot := map[string]metricSnapshot{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &snapshot)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if _, ok := snapshot[testKey1]; len(snapshot) != 1 || !ok {
+		t.Errorf("Expected only metric %s; actual %v", testKey1, snapshot)
+	}
+}
+
+func TestSnapshotHandler_Timeout(t *testing.T) {
+
+	cfg := getTestConfig()
+	cfg.collectTimeout = 50 * time.Millisecond
+	c := newCollector("qmName", cfg, getTestLogger())
+
+	// Another request holds the request lock, so the snapshot is not served rather than waiting for it
+	c.lockRequests()
+	defer c.unlockRequests()
+	recorder := httptest.NewRecorder()
+	newSnapshotHandler(c).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics/json", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status=%d; actual %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
+
+func TestLogSamples(t *testing.T) {
+
+	metrics := map[string]*metricData{
+		testKey1: {
+			name:   testElement1Name,
+			values: map[string]float64{qmgrLabelValue: 3},
+		},
+		"Class/Type/Queue depth": {
+			name:       "depth",
+			objectType: true,
+			values:     map[string]float64{"APP.OUT": 7, "APP.IN": 5, "APP.BAD": math.NaN()},
+		},
+	}
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, true, false, "test")
+	logSamples(log, "QM1", metrics)
+
+	var samples []metricSample
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var sample metricSample
+		err := json.Unmarshal([]byte(line[strings.Index(line, "{"):]), &sample)
+		if err != nil {
+			t.Fatalf("Expected a JSON sample in line '%s'; actual error %v", line, err)
+		}
+		samples = append(samples, sample)
+	}
+	expected := []metricSample{
+		{"Class/Type/Queue depth", "APP.IN", 5},
+		{"Class/Type/Queue depth", "APP.OUT", 7},
+		{testKey1, "QM1", 3},
+	}
+	if len(samples) != len(expected) {
+		t.Fatalf("Expected samples=%v; actual %v", expected, samples)
+	}
+	for i := range expected {
+		if samples[i] != expected[i] {
+			t.Errorf("Expected sample=%v; actual %v", expected[i], samples[i])
+		}
+	}
+
+	// Nothing is logged unless debug logging is enabled
+	buf.Reset()
+	log, _ = logger.NewLogger(&buf, false, false, "test")
+	logSamples(log, "QM1", metrics)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no samples to be logged without debug logging; actual %s", buf.String())
+	}
+}
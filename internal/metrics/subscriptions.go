@@ -0,0 +1,80 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"strings"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+// Function used to inquire the subscriptions held for the exporter, which can be replaced in tests
+var inquireHeldSubscriptions = doInquireHeldSubscriptions
+
+// doInquireHeldSubscriptions connects to the queue manager, and returns the number of subscriptions to each topic of
+// its published metrics which the queue manager holds for the user that the exporter connects as. These are the
+// non-durable subscriptions made by the API, so they include those of any earlier connection which was not closed,
+// and of other applications running as the same user. The subscriptions to the metadata topics, which are closed
+// once the metrics are discovered, are left out.
+func doInquireHeldSubscriptions(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (map[string]int, error) {
+
+	conn, err := openPCFConnection(qmName, cfg, newConnectOptions(connConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	responses, err := conn.command(ibmmq.MQCMD_INQUIRE_SUBSCRIPTION,
+		stringParameter(ibmmq.MQCACF_SUB_NAME, "*"),
+		integerParameter(ibmmq.MQIACF_DURABLE_SUBSCRIPTION, ibmmq.MQSUB_DURABLE_NO),
+		integerParameter(ibmmq.MQIACF_SUB_TYPE, ibmmq.MQSUBTYPE_API))
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	// The user that the exporter connects as is the user identifier which the queue manager set in the context of the
+	// command message
+	return parseHeldSubscriptions(responses, qmName, conn.userID), nil
+}
+
+// parseHeldSubscriptions returns the number of subscriptions to each topic of the published metrics of the queue manager
+// which are held for the user, from the responses to the inquiry of its subscriptions
+func parseHeldSubscriptions(responses []pcfResponse, qmName, userID string) map[string]int {
+	prefix := "$SYS/MQ/INFO/QMGR/" + qmName + "/Monitor/"
+	subscriptions := make(map[string]int)
+	for _, response := range responses {
+		topic := response.getString(ibmmq.MQCA_TOPIC_STRING)
+		if response.getString(ibmmq.MQCACF_SUB_USER_ID) != userID || !strings.HasPrefix(topic, prefix) ||
+			strings.HasPrefix(topic, prefix+"METADATA/") {
+			continue
+		}
+		subscriptions[topic]++
+	}
+	return subscriptions
+}
+
+// countHeldSubscriptions returns the total number of subscriptions held for the exporter, from the number held for each
+// topic
+func countHeldSubscriptions(subscriptions map[string]int) int {
+	count := 0
+	for _, n := range subscriptions {
+		count += n
+	}
+	return count
+}
@@ -0,0 +1,55 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+func TestParseHeldSubscriptions(t *testing.T) {
+
+	subscription := func(topic, user string) pcfResponse {
+		return pcfResponse{
+			ibmmq.MQCA_TOPIC_STRING:  {Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCA_TOPIC_STRING, String: []string{topic}},
+			ibmmq.MQCACF_SUB_USER_ID: {Type: ibmmq.MQCFT_STRING, Parameter: ibmmq.MQCACF_SUB_USER_ID, String: []string{user + "      "}},
+		}
+	}
+	responses := []pcfResponse{
+		subscription("$SYS/MQ/INFO/QMGR/QM1/Monitor/CPU/SystemSummary", "mqm"),
+		subscription("$SYS/MQ/INFO/QMGR/QM1/Monitor/CPU/SystemSummary", "mqm"),
+		subscription("$SYS/MQ/INFO/QMGR/QM1/Monitor/STATQ/APP.IN/GET", "mqm"),
+		// Subscriptions of other users, to other queue managers' metrics, and to the metadata are left out
+		subscription("$SYS/MQ/INFO/QMGR/QM1/Monitor/CPU/SystemSummary", "app"),
+		subscription("$SYS/MQ/INFO/QMGR/QM2/Monitor/CPU/SystemSummary", "mqm"),
+		subscription("$SYS/MQ/INFO/QMGR/QM1/Monitor/METADATA/CLASSES", "mqm"),
+		subscription("APP/PRICES", "mqm"),
+	}
+
+	actual := parseHeldSubscriptions(responses, "QM1", "mqm")
+	expected := map[string]int{
+		"$SYS/MQ/INFO/QMGR/QM1/Monitor/CPU/SystemSummary": 2,
+		"$SYS/MQ/INFO/QMGR/QM1/Monitor/STATQ/APP.IN/GET":  1,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected subscriptions=%v; actual %v", expected, actual)
+	}
+	if count := countHeldSubscriptions(actual); count != 3 {
+		t.Errorf("Expected count=%d; actual %d", 3, count)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// validChannelName matches valid MQ channel names
+var validChannelName = regexp.MustCompile("^[a-zA-Z0-9._/%]{1,20}$")
+
+// metricsTarget is a queue manager to gather metrics from, with the connection name and channel used to connect to
+// it in client mode. The connection name and channel are empty when switching to a queue manager on the same host.
+type metricsTarget struct {
+	qmName   string
+	connName string
+	channel  string
+}
+
+// String returns the target in the format used by the environment variable
+func (t metricsTarget) String() string {
+	return t.qmName + "/" + t.connName + "/" + t.channel
+}
+
+// getTargets returns the queue managers to rotate metrics gathering through, from a semicolon-separated list in the
+// environment variable, each given as QMNAME/CONNAME or QMNAME/CONNAME/CHANNEL. Semicolons are used as the connection
+// name may be a comma-separated list, for a multi-instance queue manager. The queue manager names must be unique, as
+// they are the only label which distinguishes the metrics of each target.
+func getTargets(name string) ([]metricsTarget, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return nil, nil
+	}
+	var targets []metricsTarget
+	names := make(map[string]bool)
+	for _, field := range strings.Split(value, ";") {
+		field = strings.TrimSpace(field)
+		parts := strings.Split(field, "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%s must be a semicolon-separated list of targets, each given as QMNAME/CONNAME or QMNAME/CONNAME/CHANNEL: '%s'", name, field)
+		}
+		// Queue manager names have the same rules as queue names
+		t := metricsTarget{qmName: strings.TrimSpace(parts[0]), channel: defaultChannel}
+		if !validQueueName.MatchString(t.qmName) {
+			return nil, fmt.Errorf("Invalid queue manager name in %s: '%s'", name, t.qmName)
+		}
+		if names[t.qmName] {
+			return nil, fmt.Errorf("%s must not contain queue manager %s more than once", name, t.qmName)
+		}
+		names[t.qmName] = true
+
+		var connections []connection
+		for _, connName := range strings.Split(parts[1], ",") {
+			connName = strings.TrimSpace(connName)
+			c, err := parseConnection(connName)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid connection name in %s for queue manager %s: '%s': %v", name, t.qmName, connName, err)
+			}
+			connections = append(connections, c)
+		}
+		t.connName = formatConnName(connections)
+
+		if len(parts) == 3 {
+			t.channel = strings.TrimSpace(parts[2])
+			if !validChannelName.MatchString(t.channel) {
+				return nil, fmt.Errorf("Invalid channel name in %s for queue manager %s: '%s'", name, t.qmName, t.channel)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// withTarget returns a copy of the configuration which connects to the target, or the configuration itself if the
+// target does not have a connection name
+func (cfg *metricsConfig) withTarget(t metricsTarget) *metricsConfig {
+	if t.connName == "" {
+		return cfg
+	}
+	copied := *cfg
+	copied.connName, copied.channel = t.connName, t.channel
+	return &copied
+}
+
+// rotateTargets switches metrics gathering to the next of the targets at each interval, starting from the first,
+// until the context is cancelled or metrics gathering stops. The metrics served at any time are those of the current
+// target - the targets are gathered from in turn rather than at once, as there can only be one connection to a queue
+// manager for published metrics.
+func (c *Collector) rotateTargets(ctx context.Context, targets []metricsTarget, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; ; {
+		select {
+		case <-ticker.C:
+			i = (i + 1) % len(targets)
+			err := c.switchTarget(targets[i])
+			if err != nil {
+				c.log.Errorf("Metrics Error: Failed to switch metrics gathering to queue manager %s: %v", targets[i].qmName, err)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetTargets(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		expected []metricsTarget
+		valid    bool
+	}{
+		{"", nil, true},
+		{"QM1/host1", []metricsTarget{{"QM1", "host1", defaultChannel}}, true},
+		{"QM1/host1(1415)/APP.SVRCONN; QM2/host2a(1414), host2b(1414)", []metricsTarget{
+			{"QM1", "host1(1415)", "APP.SVRCONN"},
+			{"QM2", "host2a(1414),host2b(1414)", defaultChannel},
+		}, true},
+		{"QM1", nil, false},
+		{"QM1/host1/APP.SVRCONN/extra", nil, false},
+		{"QM-1/host1", nil, false},
+		{"QM1/host1(port)", nil, false},
+		{"QM1/host1/THIS.CHANNEL.NAME.IS.TOO.LONG", nil, false},
+		{"QM1/host1;QM1/host2", nil, false},
+		{"QM1/host1;", nil, false},
+	}
+	for _, test := range tests {
+		teardownTestEnv := setupTestEnv(map[string]string{targetsEnv: test.value})
+		targets, err := getTargets(targetsEnv)
+		teardownTestEnv()
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error for '%s': %v", test.value, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Expected error for '%s'", test.value)
+		} else if !reflect.DeepEqual(targets, test.expected) {
+			t.Errorf("Expected targets=%v for '%s'; actual %v", test.expected, test.value, targets)
+		}
+	}
+}
+
+func TestLoadConfig_Targets(t *testing.T) {
+
+	cfg, err := loadConfigWithEnv(map[string]string{
+		clientModeEnv: "true",
+		targetsEnv:    "QM1/host1(1415)/APP.SVRCONN;QM2/host2",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if len(cfg.targets) != 2 || cfg.connName != "host1(1415)" || cfg.channel != "APP.SVRCONN" {
+		t.Errorf("Expected 2 targets, connName=%s, channel=%s; actual %d, %s, %s", "host1(1415)", "APP.SVRCONN", len(cfg.targets), cfg.connName, cfg.channel)
+	}
+	if cfg.targetInterval != defaultTargetInterval*time.Second {
+		t.Errorf("Expected targetInterval=%v; actual %v", defaultTargetInterval*time.Second, cfg.targetInterval)
+	}
+
+	_, err = loadConfigWithEnv(map[string]string{targetsEnv: "QM1/host1"})
+	if err == nil {
+		t.Errorf("Expected error for %s without %s", targetsEnv, clientModeEnv)
+	}
+	_, err = loadConfigWithEnv(map[string]string{clientModeEnv: "true", targetsEnv: "QM1/host1", connNameEnv: "host2"})
+	if err == nil {
+		t.Errorf("Expected error for %s with %s", targetsEnv, connNameEnv)
+	}
+	_, err = loadConfigWithEnv(map[string]string{clientModeEnv: "true", targetsEnv: "QM1/host1", targetIntervalEnv: "0"})
+	if err == nil {
+		t.Errorf("Expected error for %s=%s", targetIntervalEnv, "0")
+	}
+}
+
+func TestRotateTargets(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	var mutex sync.Mutex
+	var connected []metricsTarget
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		connected = append(connected, metricsTarget{qmName, cfg.connName, cfg.channel})
+		return newQMConnection(), nil
+	}
+
+	targets := []metricsTarget{{"QM1", "host1", "APP.SVRCONN"}, {"QM2", "host2", defaultChannel}}
+	cfg := getTestConfig()
+	cfg.clientMode, cfg.targets = true, targets
+	cfg.connName, cfg.channel = targets[0].connName, targets[0].channel
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("QM1", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	select {
+	case <-c.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive start signal from processMetrics")
+	}
+	go c.rotateTargets(ctx, targets, 20*time.Millisecond)
+
+	expected := []metricsTarget{targets[0], targets[1], targets[0]}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		mutex.Lock()
+		count := len(connected)
+		mutex.Unlock()
+		if count >= len(expected) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(connected) < len(expected) || !reflect.DeepEqual(connected[:len(expected)], expected) {
+		t.Errorf("Expected connections=%v; actual %v", expected, connected)
+	}
+}
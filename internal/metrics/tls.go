@@ -0,0 +1,218 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// channelTable is a JSON client channel definition table, which is used instead of MQSERVER for TLS
+// connections, as MQSERVER cannot specify TLS parameters
+type channelTable struct {
+	Channel []channelTableEntry `json:"channel"`
+}
+
+type channelTableEntry struct {
+	Name                 string               `json:"name"`
+	Type                 string               `json:"type"`
+	ClientConnection     clientConnection     `json:"clientConnection"`
+	TransmissionSecurity transmissionSecurity `json:"transmissionSecurity"`
+}
+
+type clientConnection struct {
+	Connection   []connection `json:"connection"`
+	QueueManager string       `json:"queueManager"`
+}
+
+type connection struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+}
+
+type transmissionSecurity struct {
+	CipherSpecification string `json:"cipherSpecification"`
+	CertificateLabel    string `json:"certificateLabel,omitempty"`
+	CertificatePeerName string `json:"certificatePeerName,omitempty"`
+}
+
+// usesTLS returns true if the client connection to the queue manager uses TLS
+func (cfg *metricsConfig) usesTLS() bool {
+	return cfg.cipher != ""
+}
+
+// checkKeyRepository returns an error if the key database of the key repository cannot be read.
+// It is checked on each connect, as the MQ client only reports that the connection failed.
+func (cfg *metricsConfig) checkKeyRepository() error {
+	file, err := os.Open(cfg.keyRepository + ".kdb")
+	if err != nil {
+		return fmt.Errorf("Failed to read metrics key repository %s: %v", cfg.keyRepository, err)
+	}
+	// #nosec G104
+	file.Close()
+	return nil
+}
+
+// connections returns the hosts and ports of the connection name, which is a comma-separated list of connections
+// for failover, each in the format host(port), [address](port) or host
+func (cfg *metricsConfig) connections() ([]connection, error) {
+	var connections []connection
+	for _, name := range strings.Split(cfg.connName, ",") {
+		name = strings.TrimSpace(name)
+		c, err := parseConnection(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid connection name in %s: '%s': %v", connNameEnv, name, err)
+		}
+		connections = append(connections, c)
+	}
+	return connections, nil
+}
+
+// parseConnection parses a single connection name, where the host is a host name, or an IPv4 or IPv6 address.
+// IPv6 addresses may be enclosed in brackets, as they are in URLs. The port is optional, and defaults to 1414.
+func parseConnection(name string) (connection, error) {
+
+	if name == "" {
+		return connection{}, fmt.Errorf("empty connection name")
+	}
+	host, port := name, ""
+	if open := strings.LastIndex(name, "("); open >= 0 {
+		if !strings.HasSuffix(name, ")") {
+			return connection{}, fmt.Errorf("the port must be followed by ')'")
+		}
+		host, port = name[:open], name[open+1:len(name)-1]
+	} else if strings.Contains(name, ")") {
+		return connection{}, fmt.Errorf("the port must be preceded by '('")
+	}
+
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return connection{}, fmt.Errorf("the IPv6 address must be followed by ']'")
+		}
+		host = host[1 : len(host)-1]
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return connection{}, fmt.Errorf("only an IPv6 address can be enclosed in brackets")
+		}
+	} else if net.ParseIP(host) == nil && !validHostName.MatchString(host) {
+		return connection{}, fmt.Errorf("not a valid host name or IP address")
+	}
+
+	c := connection{Host: host}
+	if port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return connection{}, fmt.Errorf("the port must be a number from 1 to 65535")
+		}
+		c.Port = p
+	}
+	return c, nil
+}
+
+// formatConnName returns a connection name for the connections, in the format used by the MQ client, where IPv6
+// addresses are not enclosed in brackets
+func formatConnName(connections []connection) string {
+	names := make([]string, len(connections))
+	for i, c := range connections {
+		names[i] = c.Host
+		if c.Port != 0 {
+			names[i] += "(" + strconv.Itoa(c.Port) + ")"
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// clientChannelTable returns the client channel definition table for a TLS connection to the queue manager
+func (cfg *metricsConfig) clientChannelTable(qmName string) ([]byte, error) {
+	connections, err := cfg.connections()
+	if err != nil {
+		return nil, err
+	}
+	table := channelTable{
+		Channel: []channelTableEntry{{
+			Name: cfg.channel,
+			Type: "clientConnection",
+			ClientConnection: clientConnection{
+				Connection:   connections,
+				QueueManager: qmName,
+			},
+			TransmissionSecurity: transmissionSecurity{
+				CipherSpecification: cfg.cipher,
+				CertificateLabel:    cfg.certLabel,
+				CertificatePeerName: cfg.peerName,
+			},
+		}},
+	}
+	return json.Marshal(table)
+}
+
+// writeClientChannelTable writes the client channel definition table to a temporary file, and returns its path
+func writeClientChannelTable(qmName string, cfg *metricsConfig) (string, error) {
+	table, err := cfg.clientChannelTable(qmName)
+	if err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile("", "metrics-ccdt-*.json")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create client channel table: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(table)
+	if err != nil {
+		// #nosec G104
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Failed to write client channel table: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// serverTLSConfig returns the TLS configuration of the metrics server, or nil if it serves plain HTTP. Client
+// certificates are required, and verified against the CA certificates, if a CA file is given.
+func (cfg *metricsConfig) serverTLSConfig() (*tls.Config, error) {
+	if cfg.serverCertFile == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.serverCertFile, cfg.serverKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load metrics server certificate from %s and %s: %v", cfg.serverCertFile, cfg.serverKeyFile, err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.serverCAFile != "" {
+		// #nosec G304 - the file is given by the configuration
+		buf, err := ioutil.ReadFile(cfg.serverCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read metrics server CA certificates from %s: %v", cfg.serverCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("Failed to read metrics server CA certificates from %s: no PEM certificates found", cfg.serverCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,228 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckKeyRepository(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "key.kdb"), []byte{}, 0600)
+
+	cfg := metricsConfig{keyRepository: filepath.Join(dir, "key")}
+	err = cfg.checkKeyRepository()
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+
+	cfg = metricsConfig{keyRepository: filepath.Join(dir, "missing")}
+	err = cfg.checkKeyRepository()
+	if err == nil {
+		t.Error("Expected error when key repository does not exist")
+	}
+}
+
+func TestConnections(t *testing.T) {
+
+	connNames := map[string][]connection{
+		"mqhost1(1414), mqhost2(1415),mqhost3":   {{"mqhost1", 1414}, {"mqhost2", 1415}, {"mqhost3", 0}},
+		"10.0.0.1(1414)":                         {{"10.0.0.1", 1414}},
+		"[2001:db8::1](1414)":                    {{"2001:db8::1", 1414}},
+		"[::1]":                                  {{"::1", 0}},
+		"2001:db8::1(1414)":                      {{"2001:db8::1", 1414}},
+		"mq-1.example.com(1414),[fe80::1](1415)": {{"mq-1.example.com", 1414}, {"fe80::1", 1415}},
+	}
+	for connName, expected := range connNames {
+		cfg := metricsConfig{connName: connName}
+		connections, err := cfg.connections()
+		if err != nil {
+			t.Errorf("Unexpected error %s for connection name %s", err.Error(), connName)
+			continue
+		}
+		if !reflect.DeepEqual(connections, expected) {
+			t.Errorf("Expected connections=%v for connection name %s; actual %v", expected, connName, connections)
+		}
+	}
+
+	for _, connName := range []string{
+		"mqhost(port)",
+		"mqhost(1414",
+		"mqhost1414)",
+		"(1414)",
+		"mqhost(0)",
+		"mqhost(65536)",
+		"mqhost(1414),",
+		"mq_host(1414)",
+		"[2001:db8::1(1414)",
+		"[10.0.0.1](1414)",
+		"[mqhost](1414)",
+	} {
+		cfg := metricsConfig{connName: connName}
+		_, err := cfg.connections()
+		if err == nil {
+			t.Errorf("Expected error for connection name %s", connName)
+		}
+	}
+}
+
+func TestFormatConnName(t *testing.T) {
+	connections := []connection{{"mqhost", 1414}, {"2001:db8::1", 1415}, {"10.0.0.1", 0}}
+	expected := "mqhost(1414),2001:db8::1(1415),10.0.0.1"
+	if actual := formatConnName(connections); actual != expected {
+		t.Errorf("Expected connection name=%s; actual %s", expected, actual)
+	}
+}
+
+func TestClientChannelTable(t *testing.T) {
+
+	cfg := metricsConfig{
+		connName: "mqhost(1414)",
+		channel:  "METRICS.SVRCONN",
+		cipher:   "ANY_TLS12",
+		peerName: "CN=mqhost",
+	}
+	table, err := cfg.clientChannelTable("QM1")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	expected := `{"channel":[{"name":"METRICS.SVRCONN","type":"clientConnection",` +
+		`"clientConnection":{"connection":[{"host":"mqhost","port":1414}],"queueManager":"QM1"},` +
+		`"transmissionSecurity":{"cipherSpecification":"ANY_TLS12","certificatePeerName":"CN=mqhost"}}]}`
+	if string(table) != expected {
+		t.Errorf("Expected channel table=%s; actual %s", expected, table)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	cfg := metricsConfig{}
+	tlsConfig, err := cfg.serverTLSConfig()
+	if err != nil || tlsConfig != nil {
+		t.Errorf("Expected no TLS configuration without a certificate; actual %v, %v", tlsConfig, err)
+	}
+
+	invalid := []metricsConfig{
+		{serverCertFile: filepath.Join(dir, "missing.pem"), serverKeyFile: keyFile},
+		{serverCertFile: certFile, serverKeyFile: certFile},
+		{serverCertFile: certFile, serverKeyFile: keyFile, serverCAFile: filepath.Join(dir, "missing.pem")},
+		{serverCertFile: certFile, serverKeyFile: keyFile, serverCAFile: keyFile},
+	}
+	for _, cfg := range invalid {
+		_, err = cfg.serverTLSConfig()
+		if err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+
+	// The certificate is self-signed, so it is used by the server and the client, and as the CA
+	cfg = metricsConfig{serverCertFile: certFile, serverKeyFile: keyFile, serverCAFile: certFile}
+	tlsConfig, err = cfg.serverTLSConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, clientCertificates := range [][]tls.Certificate{{certificate}, nil} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      tlsConfig.ClientCAs,
+			Certificates: clientCertificates,
+		}}}
+		response, err := client.Get(server.URL)
+		if clientCertificates != nil && err != nil {
+			t.Errorf("Unexpected error %s with a client certificate", err.Error())
+		} else if clientCertificates == nil && err == nil {
+			t.Errorf("Expected error without a client certificate")
+		}
+		if err == nil {
+			response.Body.Close()
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for the local host, and its key, and returns their paths
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "metrics"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,317 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+)
+
+const (
+	topicPrefix           = "topic"
+	topicLabel            = "topic"
+	topicKeyPrefix        = "TOPIC/Status/"
+	subscriptionPrefix    = "subscription"
+	subscriptionLabel     = "subscription"
+	subscriptionKeyPrefix = "SUBSCRIPTION/Status/"
+)
+
+// topicStatus holds the status of a topic string
+type topicStatus struct {
+	topicString string
+	subscribers int64
+	publishers  int64
+	published   int64
+}
+
+// subscriptionStatus holds the status of a subscription
+type subscriptionStatus struct {
+	name     string
+	messages int64
+	backlog  int64
+}
+
+// topicMetric describes a metric derived from the status of a topic
+type topicMetric struct {
+	key         string
+	name        string
+	description string
+	value       func(*topicStatus) int64
+}
+
+// subscriptionMetric describes a metric derived from the status of a subscription
+type subscriptionMetric struct {
+	key         string
+	name        string
+	description string
+	value       func(*subscriptionStatus) int64
+}
+
+// topicMetrics are the metrics available for each topic string
+var topicMetrics = []topicMetric{
+	{"Subscribers", "subscribers", "Number of subscribers to the topic string", func(s *topicStatus) int64 { return s.subscribers }},
+	{"Publishers", "publishers", "Number of applications publishing on the topic string", func(s *topicStatus) int64 { return s.publishers }},
+	{"Messages published", "published_messages", "Number of messages published on the topic string by the current publishers", func(s *topicStatus) int64 { return s.published }},
+}
+
+// subscriptionMetrics are the metrics available for each subscription
+var subscriptionMetrics = []subscriptionMetric{
+	{"Messages", "messages", "Number of messages put to the destination of the subscription", func(s *subscriptionStatus) int64 { return s.messages }},
+	{"Backlog", "backlog_messages", "Number of messages waiting on the destination queue of the subscription", func(s *subscriptionStatus) int64 { return s.backlog }},
+}
+
+// Functions used to inquire the status of topics and subscriptions, which can be replaced in tests
+var (
+	inquireTopics        = doInquireTopics
+	inquireSubscriptions = doInquireSubscriptions
+)
+
+// initialiseTopicMetrics adds the selected topic and subscription metrics to the metrics map
+func initialiseTopicMetrics(metrics map[string]*metricData, cfg *metricsConfig) {
+	if len(cfg.topics) > 0 {
+		for _, topicMetric := range topicMetrics {
+			addPCFMetric(metrics, cfg, topicKeyPrefix+topicMetric.key, topicMetric.name, topicMetric.description, topicPrefix, topicLabel)
+		}
+	}
+	if cfg.subscriptions != "" {
+		for _, subscriptionMetric := range subscriptionMetrics {
+			addPCFMetric(metrics, cfg, subscriptionKeyPrefix+subscriptionMetric.key, subscriptionMetric.name, subscriptionMetric.description, subscriptionPrefix, subscriptionLabel)
+		}
+	}
+}
+
+// addPCFMetric adds a metric for objects with a single label to the metrics map, if it is selected
+func addPCFMetric(metrics map[string]*metricData, cfg *metricsConfig, key, name, description, prefix, label string) {
+	if !cfg.isSelected(key) {
+		return
+	}
+	metrics[key] = &metricData{
+		name:         name,
+		description:  description,
+		objectType:   true,
+		objectPrefix: prefix,
+		objectLabels: []string{label},
+	}
+}
+
+// updateTopicMetrics updates values for the topic metrics from the status of each topic string
+func updateTopicMetrics(metrics map[string]*metricData, statuses []topicStatus) {
+	now := time.Now()
+	for _, topicMetric := range topicMetrics {
+		metric, ok := metrics[topicKeyPrefix+topicMetric.key]
+		if !ok {
+			continue
+		}
+		metric.values = make(map[string]float64)
+		metric.lastUpdate = now
+		for i := range statuses {
+			metric.values[statuses[i].topicString] = float64(topicMetric.value(&statuses[i]))
+		}
+	}
+}
+
+// updateSubscriptionMetrics updates values for the subscription metrics from the status of each subscription
+func updateSubscriptionMetrics(metrics map[string]*metricData, statuses []subscriptionStatus) {
+	now := time.Now()
+	for _, subscriptionMetric := range subscriptionMetrics {
+		metric, ok := metrics[subscriptionKeyPrefix+subscriptionMetric.key]
+		if !ok {
+			continue
+		}
+		metric.values = make(map[string]float64)
+		metric.lastUpdate = now
+		for i := range statuses {
+			metric.values[statuses[i].name] = float64(subscriptionMetric.value(&statuses[i]))
+		}
+	}
+}
+
+// doInquireTopics returns the status of the topic strings matching the configured topic strings.
+// At most cfg.maxTopics topic strings are returned, in sorted order, to limit the number of metrics.
+func doInquireTopics(pcfConns *pcfPool, cfg *metricsConfig) ([]topicStatus, error) {
+
+	if pcfConns == nil {
+		return nil, nil
+	}
+
+	// The status of each topic string is inquired concurrently
+	results := make([]map[string]*topicStatus, len(cfg.topics))
+	err := pcfConns.forEach(len(cfg.topics), func(conn *pcfConnection, i int) error {
+		topics := make(map[string]*topicStatus)
+		results[i] = topics
+
+		responses, err := conn.command(ibmmq.MQCMD_INQUIRE_TOPIC_STATUS,
+			stringParameter(ibmmq.MQCA_TOPIC_STRING, cfg.topics[i]))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			name := response.getString(ibmmq.MQCA_TOPIC_STRING)
+			topics[name] = &topicStatus{
+				topicString: name,
+				subscribers: response.getInt(ibmmq.MQIA_SUB_COUNT),
+				publishers:  response.getInt(ibmmq.MQIA_PUB_COUNT),
+			}
+		}
+
+		// The number of messages published is returned for each publisher
+		responses, err = conn.command(ibmmq.MQCMD_INQUIRE_TOPIC_STATUS,
+			stringParameter(ibmmq.MQCA_TOPIC_STRING, cfg.topics[i]),
+			integerParameter(ibmmq.MQIACF_TOPIC_STATUS_TYPE, ibmmq.MQIACF_TOPIC_PUB))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			if topic, ok := topics[response.getString(ibmmq.MQCA_TOPIC_STRING)]; ok {
+				topic.published += response.getInt(ibmmq.MQIACF_PUBLISH_COUNT)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make(map[string]*topicStatus)
+	for _, result := range results {
+		for name, topic := range result {
+			topics[name] = topic
+		}
+	}
+
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > cfg.maxTopics {
+		names = names[:cfg.maxTopics]
+	}
+	statuses := make([]topicStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, *topics[name])
+	}
+	return statuses, nil
+}
+
+// doInquireSubscriptions returns the status of the subscriptions matching the configured names.
+// At most cfg.maxTopics subscriptions are returned, in sorted order, to limit the number of metrics.
+func doInquireSubscriptions(pcfConns *pcfPool, cfg *metricsConfig) ([]subscriptionStatus, error) {
+
+	if pcfConns == nil {
+		return nil, nil
+	}
+
+	// The subscriptions matching each name are inquired concurrently
+	patterns := strings.Split(cfg.subscriptions, ",")
+	results := make([]map[string]*subscriptionStatus, len(patterns))
+	destinations := make([]map[string]string, len(patterns))
+	err := pcfConns.forEach(len(patterns), func(conn *pcfConnection, i int) error {
+		results[i] = make(map[string]*subscriptionStatus)
+		destinations[i] = make(map[string]string)
+
+		responses, err := conn.command(ibmmq.MQCMD_INQUIRE_SUBSCRIPTION,
+			stringParameter(ibmmq.MQCACF_SUB_NAME, patterns[i]))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			name := response.getString(ibmmq.MQCACF_SUB_NAME)
+			results[i][name] = &subscriptionStatus{name: name}
+			// The backlog is only available for destination queues on this queue manager
+			destinationQMgr := response.getString(ibmmq.MQCACF_DESTINATION_Q_MGR)
+			if destinationQMgr == "" || destinationQMgr == conn.qMgr.Name {
+				destinations[i][name] = response.getString(ibmmq.MQCACF_DESTINATION)
+			}
+		}
+
+		responses, err = conn.command(ibmmq.MQCMD_INQUIRE_SUB_STATUS,
+			stringParameter(ibmmq.MQCACF_SUB_NAME, patterns[i]))
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, response := range responses {
+			if subscription, ok := results[i][response.getString(ibmmq.MQCACF_SUB_NAME)]; ok {
+				subscription.messages = response.getInt(ibmmq.MQIACF_MESSAGE_COUNT)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make(map[string]*subscriptionStatus)
+	destinationQueues := make(map[string]string)
+	for i := range results {
+		for name, subscription := range results[i] {
+			subscriptions[name] = subscription
+			destinationQueues[name] = destinations[i][name]
+		}
+	}
+
+	names := make([]string, 0, len(subscriptions))
+	for name := range subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > cfg.maxTopics {
+		names = names[:cfg.maxTopics]
+	}
+
+	// The backlog of each subscription is inquired concurrently from the depth of its destination queue
+	err = pcfConns.forEach(len(names), func(conn *pcfConnection, i int) error {
+		if destinationQueues[names[i]] == "" {
+			return nil
+		}
+		responses, err := conn.command(ibmmq.MQCMD_INQUIRE_Q,
+			stringParameter(ibmmq.MQCA_Q_NAME, destinationQueues[names[i]]),
+			integerListParameter(ibmmq.MQIACF_Q_ATTRS, ibmmq.MQIA_CURRENT_Q_DEPTH))
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		for _, response := range responses {
+			subscriptions[names[i]].backlog = response.getInt(ibmmq.MQIA_CURRENT_Q_DEPTH)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]subscriptionStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, *subscriptions[name])
+	}
+	return statuses, nil
+}
@@ -0,0 +1,67 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"testing"
+)
+
+func TestInitialiseTopicMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseTopicMetrics(metrics, &metricsConfig{topics: []string{"price/#"}})
+	if len(metrics) != len(topicMetrics) {
+		t.Errorf("Expected %d topic metrics; actual %d", len(topicMetrics), len(metrics))
+	}
+
+	metrics = make(map[string]*metricData)
+	initialiseTopicMetrics(metrics, &metricsConfig{subscriptions: "APP.*"})
+	if len(metrics) != len(subscriptionMetrics) {
+		t.Fatalf("Expected %d subscription metrics; actual %d", len(subscriptionMetrics), len(metrics))
+	}
+	prefix, labels := getVecDetails(metrics[subscriptionKeyPrefix+"Backlog"])
+	if prefix != subscriptionPrefix || len(labels) != 2 || labels[0] != subscriptionLabel {
+		t.Errorf("Expected prefix=%s, labels=%v; actual %s, %v", subscriptionPrefix, []string{subscriptionLabel, qmgrLabel}, prefix, labels)
+	}
+
+	metrics = make(map[string]*metricData)
+	initialiseTopicMetrics(metrics, &metricsConfig{})
+	if len(metrics) != 0 {
+		t.Errorf("Expected no metrics when topics and subscriptions are not configured; actual %d", len(metrics))
+	}
+}
+
+func TestUpdateTopicMetrics(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseTopicMetrics(metrics, &metricsConfig{topics: []string{"price/#"}, subscriptions: "APP.*"})
+
+	updateTopicMetrics(metrics, []topicStatus{{topicString: "price/fruit|veg", subscribers: 2, publishers: 1, published: 10}})
+	updateSubscriptionMetrics(metrics, []subscriptionStatus{{name: "APP.SUB", messages: 10, backlog: 4}})
+
+	if actual := metrics[topicKeyPrefix+"Subscribers"].values["price/fruit|veg"]; actual != 2 {
+		t.Errorf("Expected subscribers=%d; actual %f", 2, actual)
+	}
+	if actual := metrics[topicKeyPrefix+"Messages published"].values["price/fruit|veg"]; actual != 10 {
+		t.Errorf("Expected published messages=%d; actual %f", 10, actual)
+	}
+	if actual := metrics[subscriptionKeyPrefix+"Backlog"].values["APP.SUB"]; actual != 4 {
+		t.Errorf("Expected backlog=%d; actual %f", 4, actual)
+	}
+	if metrics[subscriptionKeyPrefix+"Messages"].lastUpdate.IsZero() {
+		t.Error("Expected last update time to be set")
+	}
+}
@@ -18,8 +18,15 @@ limitations under the License.
 package metrics
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ibm-messaging/mq-container/pkg/logger"
@@ -29,199 +36,1567 @@ import (
 
 const (
 	qmgrLabelValue = mqmetric.QMgrMapKey
-	requestTimeout = 10
+
+	// labelSeparator joins the label values of objects which have more than one label
+	// - it is not valid in MQ object names
+	labelSeparator = "|"
+
+	// otherLabelValue is the label value of the aggregated values of an object metric, for the objects over the limit
+	// on the number of label values
+	otherLabelValue = "other"
+
+	// queueClassName is the name of the class of metrics published for each queue
+	queueClassName = "STATQ"
+
+	// drainInterval is the time to wait for further publications when processing pending publications before stopping
+	drainInterval = 100 * time.Millisecond
+
+	// idleCyclesBeforeBackoff is the number of consecutive cycles in which no publications arrive, after which the
+	// adaptive cadence doubles the time between cycles
+	idleCyclesBeforeBackoff = 3
+)
+
+// Fields added to the messages logged in JSON format, so that they can be filtered without parsing the message
+const (
+	componentField = "component"
+	componentName  = "metrics"
+	eventField     = "event"
+	qmgrField      = "qmgr"
+	categoryField  = "category"
+	reasonField    = "reason"
 )
 
+// responseTimeout is the time to wait for a requester to receive the response to a request, after which it is assumed
+// to have gone away, and the response is dropped - it can be replaced in tests
+var responseTimeout = 5 * time.Second
+
+// dropWarningInterval is the minimum time between warnings that publications have been dropped, so that a reply queue
+// which keeps overflowing does not flood the log - it can be replaced in tests
+var dropWarningInterval = time.Minute
+
+// errorSummaryInterval is the minimum time between summaries of an error which keeps causing reconnects, which is only
+// logged in full when it first occurs, so that an extended outage does not flood the log - it can be replaced in tests
+var errorSummaryInterval = 5 * time.Minute
+
+// Functions used to access the queue manager, which can be replaced in tests
 var (
-	startChannel    = make(chan bool)
-	stopChannel     = make(chan bool, 2)
-	requestChannel  = make(chan bool)
-	responseChannel = make(chan map[string]*metricData)
+	connectQueueManager = doConnect
+	initConnection      = mqmetric.InitConnectionStats
+	discoverMetrics     = mqmetric.DiscoverAndSubscribe
+	processPublications = mqmetric.ProcessPublications
+	endConnection       = doEndConnection
+	checkAuthorities    = doCheckAuthorities
 )
 
+// qmConnection is what is opened and inquired when connecting to the queue manager, other than the connection of the
+// MQ metrics library, which is returned by doConnect - including what had been opened when connecting fails - and
+// closed by endConnection
+type qmConnection struct {
+	// pcfConns are the connections used for PCF commands, if any metrics require them
+	pcfConns *pcfPool
+	// cmdQInquiry is the command queue, opened to inquire whether the command server is running, or nil if it could
+	// not be opened for inquiry
+	cmdQInquiry *commandQueueInquiry
+	// replyQInquiry is the reply queue which the published metrics are put to, opened for inquiry and browsing, or nil
+	// if it could not be found or opened
+	replyQInquiry *replyQueueInquiry
+	// startTime is the time when the queue manager started, or zero if it is not known
+	startTime time.Time
+	// commandLevel is the command level of the queue manager, or unknownCommandLevel, and mqVersion its version, such
+	// as 9.2.0.0, or empty if it is not known
+	commandLevel int32
+	mqVersion    string
+	// subscriptions is the number of subscriptions to each topic of the published metrics which the queue manager holds
+	// for the exporter's user, or nil if they could not be inquired
+	subscriptions map[string]int
+	// discoveryError is the error from discovering and subscribing to metrics, if the metrics of some classes can still
+	// be gathered
+	discoveryError error
+}
+
+// newQMConnection returns the state of a connection to the queue manager before anything has been opened or inquired
+func newQMConnection() *qmConnection {
+	return &qmConnection{commandLevel: unknownCommandLevel}
+}
+
 type metricData struct {
 	name        string
 	description string
-	objectType  bool
-	values      map[string]float64
-	isDelta     bool
+	// objectType is set for metrics with a value for each object, such as those published on object topics containing
+	// %s, rather than for the queue manager itself. They are named with objectPrefix and labelled by objectLabels, or by
+	// default with the queue prefix and label.
+	objectType   bool
+	objectPrefix string
+	objectLabels []string
+	values       map[string]float64
+	isDelta      bool
+	unit         string
+	rawUnits     bool
+	cumulative   bool
+	previous     map[string]float64
+	scale        unitScale
+	lastUpdate   time.Time
+	limited      bool
+	// aggregateMax reports the maximum of the values over the label value limit, rather than their sum, for metrics
+	// such as ages where a sum is not meaningful
+	aggregateMax bool
+	// nonFiniteZero reports values which normalise to NaN or Inf as 0, rather than skipping them, and nonFinite is set
+	// once such a value has been found, so that it is only logged once
+	nonFiniteZero bool
+	nonFinite     bool
+	// factor multiplies the values when they are exposed, or is zero if they are exposed as they are
+	factor float64
+	// alwaysPresent metrics are exported with a value of 0 while they have no value, rather than being left out
+	alwaysPresent bool
 }
 
-// processMetrics processes publications of metric data and handles describe/collect/stop requests
-func processMetrics(log *logger.Logger, qmName string) {
+// unitScale converts values published by the queue manager to base units, by multiplying and then dividing them,
+// so that the results are the same as from mqmetric.Normalise. The zero value leaves values unchanged.
+type unitScale struct {
+	multiplier float64
+	divisor    float64
+}
+
+// metricsRequest is a describe or collect request for the metrics map. A collect request updates the metric values
+// first - only those with the given keys, if keys is not nil - after processing the pending publications if refresh
+// is set.
+type metricsRequest struct {
+	collect bool
+	refresh bool
+	keys    map[string]bool
+}
+
+var (
+	describeRequest = metricsRequest{}
+	collectRequest  = metricsRequest{collect: true}
+	refreshRequest  = metricsRequest{collect: true, refresh: true}
+)
+
+// Start starts processing metrics for the queue manager in a new goroutine, until the context is cancelled
+func (c *Collector) Start(ctx context.Context) {
+	go func() {
+		c.err = c.processMetrics(ctx)
+		close(c.done)
+	}()
+}
+
+// signalStarted signals that the collector has connected to the queue manager - only the first call has any effect
+func (c *Collector) signalStarted() {
+	c.startOnce.Do(func() {
+		close(c.started)
+	})
+}
+
+// SwitchQueueManager stops gathering metrics for the current queue manager, and connects to the named queue manager.
+// Requests for metrics are not held up while connecting, and those which time out meanwhile are served the values
+// collected before the switch. An error is returned if connecting to the new queue manager fails, in which case it is
+// retried as usual.
+func (c *Collector) SwitchQueueManager(qmName string) error {
+	return c.switchTarget(metricsTarget{qmName: qmName})
+}
+
+// switchTarget switches metrics gathering to the target queue manager, as for SwitchQueueManager, connecting with
+// the connection name and channel of the target if it has them
+func (c *Collector) switchTarget(t metricsTarget) error {
+
+	c.switchMutex.Lock()
+	defer c.switchMutex.Unlock()
+	if t.qmName == c.getQMName() {
+		return nil
+	}
+	select {
+	case c.switchChannel <- t:
+	case <-c.done:
+		return fmt.Errorf("Metrics gathering has stopped")
+	}
+	err := <-c.switchResult
+
+	// The counters and histograms of the previous queue manager are kept, as they are told apart by the qmgr label, so
+	// that they carry on from their last values when it is gathered from again, as when rotating through targets. If
+	// the label value is fixed, they are removed, and the next collect skipped as on startup, so that the counts of
+	// different queue managers are not added together.
+	c.lockRequests()
+	defer c.unlockRequests()
+	c.statusGauge.Reset()
+	if c.qmAlias != "" {
+		for _, counterVec := range c.counterMap {
+			counterVec.Reset()
+		}
+		for _, histogram := range c.histograms {
+			histogram.reset()
+		}
+		atomic.StoreInt64(&c.countersStart, time.Now().UnixNano())
+		c.firstCollect = true
+	}
+	return err
+}
+
+// processMetrics processes publications of metric data and handles describe/collect requests,
+// until the context is cancelled. An error is returned if the maximum number of consecutive
+// attempts to connect to the queue manager is exceeded.
+func (c *Collector) processMetrics(ctx context.Context) error {
 
 	var err error
-	var firstConnect = true
-	var metrics map[string]*metricData
+	var failedConnects = 0
+	var reconnecting = false
+	var reloading = false
+	var reloadedFrom = 0
+	var metrics, lastKnown map[string]*metricData
+	var offset time.Duration
+
+	// Wait before connecting for the first time, if startup jitter is enabled
+	started, switching := c.waitToStart(ctx)
+	if !started {
+		c.eventLog("stop").Println("Stopping metrics gathering")
+		return nil
+	}
+	reconnect := newBackoff(c.cfg.reconnectDelay, c.cfg.reconnectMax)
 
 	for {
-		// Connect to queue manager and discover available metrics
-		err = doConnect(qmName)
-		if err == nil {
-			if firstConnect {
-				firstConnect = false
-				startChannel <- true
+		// Connect to queue manager and discover available metrics - unless it is running locally but not as the active
+		// instance, which is checked again after the request timeout, without counting as a failed attempt
+		err = c.checkRole()
+		inactive := err != nil
+		if !inactive {
+			err = c.connect(ctx, lastKnown)
+		}
+		if err != nil && ctx.Err() != nil {
+			c.eventLog("stop").Println("Stopping metrics gathering")
+			if c.pendingConnect == nil {
+				c.endConnection()
 			}
+			return nil
+		} else if err != nil && !inactive {
+			failedConnects++
+		} else if err == nil {
+			failedConnects = 0
+			reconnect.reset()
+			lastKnown = nil
+			atomic.StoreInt64(&c.servingLastKnown, 0)
+			c.clearError()
+			c.signalStarted()
+			// The metrics map is rebuilt from the metrics discovered on this connection, as the queue manager
+			// may have restarted with different metrics or queues
 			// #nosec G104
-			metrics, _ = initialiseMetrics(log)
+			metrics, _ = initialiseMetrics(c.log, c.cfg)
+			c.checkPublishedMetrics()
+			c.checkSubscriptions()
+			c.checkMetricClasses()
+			c.checkExpectedMetrics()
+			c.commandServer = commandServerUnknown
+			c.checkCommandServer()
+			offset = c.jitter.offset(c.cfg.requestTimeout)
+			atomic.StoreInt64(&c.processInterval, 0)
+			atomic.StoreInt64(&c.publishInterval, 0)
+			if reconnecting {
+				c.eventLog("reconnect").Printf("Metrics: Reconnected to queue manager %s, and resubscribed to %d metric types", c.qmName, countMetricTypes())
+				reconnecting = false
+			}
+			atomic.StoreInt32(&c.status, 1)
+		}
+		if reloading {
+			c.eventLog("reload").Printf("Metrics: Reloaded configuration for queue manager %s, with %d metrics before and %d after", c.qmName, reloadedFrom, len(metrics))
+			reloading = false
+		}
+		if switching {
+			c.switchResult <- err
+			switching = false
 		}
 
-		// Now loop until something goes wrong
-		for err == nil {
+		// Now loop until something goes wrong, or we switch to another queue manager
+		for err == nil && !switching {
 
-			// Process publications of metric data
+			// Process publications of metric data, unless they are only processed when metrics are collected
+			// - the first cycle after connecting is shortened by any jitter offset, to shift the phase of later cycles
 			// TODO: If we have a large number of metrics to process, then we could be blocked from responding to stop requests
-			err = mqmetric.ProcessPublications()
+			var timeout <-chan time.Time
+			if !c.cfg.onDemand {
+				err = c.processPublicationsWithin(metrics)
+				timeout = time.After(c.nextProcessInterval() - offset)
+				offset = 0
+			}
 
-			// Handle describe/collect/stop requests
+			// Handle describe/collect requests
 			if err == nil {
 				select {
-				case collect := <-requestChannel:
-					if collect {
-						updateMetrics(metrics)
-					}
-					responseChannel <- metrics
-				case <-stopChannel:
-					log.Println("Stopping metrics gathering")
-					mqmetric.EndConnection()
-					return
-				case <-time.After(requestTimeout * time.Second):
-					log.Debugf("Metrics: No requests received within timeout period (%d seconds)", requestTimeout)
+				case request := <-c.requestChannel:
+					err = c.handleRequest(request, metrics)
+				case t := <-c.switchChannel:
+					c.eventLog("switch").Printf("Switching metrics gathering from queue manager %s to %s", c.qmName, t.qmName)
+					c.endConnection()
+					atomic.StoreInt32(&c.status, 0)
+					c.setTarget(t)
+					switching = true
+				case cfg := <-c.reloadChannel:
+					c.eventLog("reload").Printf("Reloading metrics configuration for queue manager %s", c.qmName)
+					c.endConnection()
+					atomic.StoreInt32(&c.status, 0)
+					c.cfg = cfg
+					reconnect = newBackoff(cfg.reconnectDelay, cfg.reconnectMax)
+					reloadedFrom = len(metrics)
+					reloading = true
+					switching = true
+				case <-ctx.Done():
+					c.eventLog("stop").Println("Stopping metrics gathering")
+					c.drainPublications(metrics)
+					c.endConnection()
+					return nil
+				case flush := <-c.shutdownChannel:
+					// The final metrics are flushed after the pending publications are processed, and before
+					// the connection is closed - requests are responded to with no metrics after an error
+					c.eventLog("stop").Println("Stopping metrics gathering")
+					c.drainPublications(metrics)
+					c.flushMetrics(ctx, flush, func(request metricsRequest) {
+						if metrics == nil {
+							c.respond(map[string]*metricData{})
+						} else if c.handleRequest(request, metrics) != nil {
+							metrics = nil
+						}
+					})
+					c.endConnection()
+					return nil
+				case <-timeout:
+					c.eventLog("request_timeout").Debugf("Metrics: No requests received within timeout period (%v)", c.cfg.requestTimeout)
+				}
+			}
+		}
+		if switching {
+			// Connect to the new queue manager straight away
+			metrics, lastKnown = nil, nil
+			atomic.StoreInt64(&c.servingLastKnown, 0)
+			reconnecting = false
+			failedConnects = 0
+			reconnect.reset()
+			continue
+		}
+		atomic.StoreInt32(&c.status, 0)
+		logged := false
+		if !inactive {
+			atomic.AddInt64(&c.reconnectCount, 1)
+			c.recordError(err)
+			category, reason := classifyError(err)
+			logged = c.logError(err, category, reason)
+
+			// Close the connection, and its subscriptions - the metrics map is not used again, as it may
+			// include metrics which are not available after reconnecting, but its last values may be served
+			// until then. A connection which timed out is closed when the attempt ends.
+			if c.pendingConnect == nil {
+				c.endConnection()
+			}
+			if c.cfg.lastKnown && metrics != nil {
+				lastKnown = getLastKnown(metrics)
+				atomic.StoreInt64(&c.servingLastKnown, 1)
+			}
+			metrics = nil
+			reconnecting = true
+
+			// Give up if the connection keeps failing, for example because the configuration is wrong
+			// - or straight away if connecting failed with an error which reconnecting will not fix
+			if c.cfg.maxConnects > 0 && failedConnects > 0 && (failedConnects >= c.cfg.maxConnects || !category.recoverable()) {
+				return fmt.Errorf("Failed to connect to queue manager %s after %d attempts [category=%s reason=%d]", c.qmName, failedConnects, category, reason)
+			}
+		}
+
+		// Handle stop requests, and respond to requests with no metrics, or the last-known metrics, until we are
+		// reconnected - so that the queue manager status is still reported
+		response := lastKnown
+		if response == nil {
+			response = map[string]*metricData{}
+		}
+		delay := c.cfg.requestTimeout
+		if !inactive {
+			delay = reconnect.next()
+		}
+		c.eventLog("retry").Debugf("Metrics: Waiting %v before reconnecting", delay)
+		retry := time.After(delay)
+		for waiting := true; waiting; {
+			select {
+			case <-c.requestChannel:
+				c.respond(response)
+			case t := <-c.switchChannel:
+				c.eventLog("switch").Printf("Switching metrics gathering from queue manager %s to %s", c.qmName, t.qmName)
+				c.setTarget(t)
+				lastKnown = nil
+				atomic.StoreInt64(&c.servingLastKnown, 0)
+				switching = true
+				reconnecting = false
+				failedConnects = 0
+				reconnect.reset()
+				waiting = false
+			case cfg := <-c.reloadChannel:
+				c.eventLog("reload").Printf("Reloading metrics configuration for queue manager %s", c.qmName)
+				c.cfg = cfg
+				reconnect = newBackoff(cfg.reconnectDelay, cfg.reconnectMax)
+				lastKnown = nil
+				atomic.StoreInt64(&c.servingLastKnown, 0)
+				reloadedFrom = 0
+				reloading = true
+				switching = true
+				reconnecting = false
+				failedConnects = 0
+				waiting = false
+			case <-ctx.Done():
+				c.eventLog("stop").Println("Stopping metrics gathering")
+				return nil
+			case flush := <-c.shutdownChannel:
+				c.eventLog("stop").Println("Stopping metrics gathering")
+				c.flushMetrics(ctx, flush, func(request metricsRequest) { c.respond(response) })
+				return nil
+			case <-retry:
+				if logged {
+					c.eventLog("retry").Println("Retrying metrics gathering")
+				} else if !inactive {
+					c.eventLog("retry").Debugf("Metrics: Retrying metrics gathering")
 				}
+				waiting = false
 			}
 		}
-		log.Errorf("Metrics Error: %s", err.Error())
+	}
+}
 
-		// Close the connection
-		mqmetric.EndConnection()
+// connect connects to the queue manager and discovers available metrics, giving up if this takes longer than any
+// connect timeout or the context is cancelled. The MQ calls cannot be interrupted, so an attempt which is given up
+// carries on in the background, and closes anything it opened when it ends. The next attempt waits for it to end,
+// as there can only be one connection to the queue manager. Requests are responded to with the last-known metrics
+// while connecting, if there are any, so that they are not held up by a slow connection.
+func (c *Collector) connect(ctx context.Context, lastKnown map[string]*metricData) error {
 
-		// Handle stop requests
+	qmName, cfg := c.qmName, c.cfg
+	var timeout <-chan time.Time
+	if cfg.connectTimeout > 0 {
+		timer := time.NewTimer(cfg.connectTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	if c.pendingConnect != nil {
 		select {
-		case <-stopChannel:
-			log.Println("Stopping metrics gathering")
+		case <-c.pendingConnect:
+			c.pendingConnect = nil
+		case <-timeout:
+			return fmt.Errorf("Timed out after %v waiting for a previous attempt to connect to queue manager %s to end", cfg.connectTimeout, qmName)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var mutex sync.Mutex
+	abandoned := false
+	result := make(chan connectResult, 1)
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		conn, err := connectQueueManager(qmName, cfg)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if abandoned {
+			c.log.WithFields(map[string]interface{}{eventField: "connect_abandoned", qmgrField: qmName}).Printf("Metrics: Closing connection to queue manager %s, which completed after it was given up", qmName)
+			endConnection(conn)
 			return
-		case <-time.After(requestTimeout * time.Second):
-			log.Println("Retrying metrics gathering")
+		}
+		result <- connectResult{conn, err}
+	}()
+
+	var requests chan metricsRequest
+	if lastKnown != nil {
+		requests = c.requestChannel
+	}
+	var reason error
+	for reason == nil {
+		select {
+		case r := <-result:
+			c.conn = r.conn
+			return r.err
+		case <-requests:
+			c.respond(lastKnown)
+		case <-timeout:
+			reason = fmt.Errorf("Timed out after %v connecting to queue manager %s and subscribing to metrics", cfg.connectTimeout, qmName)
+		case <-ctx.Done():
+			reason = ctx.Err()
 		}
 	}
+
+	// The attempt may have ended while giving up
+	mutex.Lock()
+	defer mutex.Unlock()
+	select {
+	case r := <-result:
+		c.conn = r.conn
+		return r.err
+	default:
+	}
+	abandoned = true
+	c.pendingConnect = ended
+	return reason
 }
 
-// doConnect connects to the queue manager and discovers available metrics
-func doConnect(qmName string) error {
+// connectResult is the result of an attempt to connect to the queue manager
+type connectResult struct {
+	conn *qmConnection
+	err  error
+}
+
+// doConnect connects to the queue manager and discovers available metrics, and returns what it opened and inquired,
+// which is closed by endConnection, whether or not connecting succeeded
+func doConnect(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+
+	conn := newQMConnection()
+	user, password, err := cfg.credentials()
+	if err != nil {
+		return conn, err
+	}
 
 	// Set connection configuration
 	var connConfig mqmetric.ConnectionConfig
-	connConfig.ClientMode = false
-	connConfig.UserId = ""
-	connConfig.Password = ""
+	connConfig.ClientMode = cfg.clientMode
+	connConfig.UserId = user
+	connConfig.Password = password
+
+	// The MQ client picks up the channel definition from the MQSERVER environment variable, or for TLS
+	// connections from a client channel definition table, as MQSERVER cannot specify TLS parameters.
+	// They are only set for the duration of the connect, so that they aren't inherited by other MQ commands.
+	if cfg.clientMode && cfg.usesTLS() {
+		err = cfg.checkKeyRepository()
+		if err != nil {
+			return conn, err
+		}
+		var table string
+		table, err = writeClientChannelTable(qmName, cfg)
+		if err != nil {
+			return conn, err
+		}
+		// #nosec G104
+		defer os.Remove(table)
+		defer setEnv("MQSERVER", "")()
+		defer setEnv("MQCCDTURL", "file://"+table)()
+		defer setEnv("MQSSLKEYR", cfg.keyRepository)()
+	} else if cfg.clientMode {
+		defer setEnv("MQSERVER", cfg.clientChannelDefinition())()
+	}
+
+	// Check that the user has the authorities needed to gather metrics, so that any which are missing can be reported
+	err = checkAuthorities(qmName, cfg, &connConfig)
+	if err != nil {
+		return conn, err
+	}
+
+	// Check that the reply queues created from the model queue are deleted when the exporter disconnects
+	err = checkModelQueue(qmName, cfg, &connConfig)
+	if err != nil {
+		return conn, err
+	}
 
 	// Connect to the queue manager - open the command and dynamic reply queues
-	err := mqmetric.InitConnectionStats(qmName, "SYSTEM.DEFAULT.MODEL.QUEUE", "", &connConfig)
+	err = initConnection(qmName, cfg.modelQueue, "", &connConfig)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to queue manager %s: %v", qmName, err)
+		return conn, fmt.Errorf("Failed to connect to queue manager %s: %v", qmName, err)
 	}
 
 	// Discover available metrics for the queue manager and subscribe to them
-	err = mqmetric.DiscoverAndSubscribe("", true, "")
-	if err != nil {
-		return fmt.Errorf("Failed to discover and subscribe to metrics: %v", err)
+	// - the queue list is expanded to the names of matching local queues
+	// - if only some classes of metrics fail, the others are still gathered, and the error is reported after connecting
+	discoveryErr := discoverMetrics(cfg.queues, true, "")
+
+	// Inquire the command level and version of the queue manager, which are reported by the information metric
+	// - metrics are still gathered if they cannot be inquired, and the command level is then unknown
+	// #nosec G104
+	conn.commandLevel, conn.mqVersion, _ = inquireVersion(qmName, &connConfig)
+
+	// Open the command queue for inquiry, to check whether the command server is running
+	// - the command server metric is not reported if it cannot be opened, for example without +inq authority
+	// #nosec G104
+	conn.cmdQInquiry, _ = openCommandQueue(qmName, &connConfig)
+
+	// Inquire the start time of the queue manager, which is reported by the uptime metric, open the reply queue of
+	// the published metrics for inquiry, to report its depth, and inquire the subscriptions which the queue manager
+	// holds for the exporter, to report how many there are and which classes are subscribed to
+	// - they are not reported if they cannot be inquired, for example without +dsp authority
+	// - they are not inquired while the command server is stopped, as the PCF commands would wait for a reply
+	if inquireCommandServer(conn.cmdQInquiry) != commandServerStopped {
+		// #nosec G104
+		conn.startTime, _ = inquireStartTime(qmName, cfg, &connConfig)
+		// #nosec G104
+		conn.replyQInquiry, _ = openReplyQueue(qmName, cfg, &connConfig)
+		// #nosec G104
+		conn.subscriptions, _ = inquireHeldSubscriptions(qmName, cfg, &connConfig)
+	}
+
+	if discoveryErr != nil && len(getInactiveClasses(conn.subscriptions)) == len(mqmetric.Metrics.Classes) {
+		return conn, fmt.Errorf("Failed to discover and subscribe to metrics: %v", discoveryErr)
+	}
+	conn.discoveryError = discoveryErr
+
+	// Open separate connections for PCF commands, used for metrics which are not published
+	if cfg.usesPCF() {
+		conn.pcfConns, err = openPCFPool(qmName, cfg, newConnectOptions(&connConfig))
+		if err != nil {
+			return conn, fmt.Errorf("Failed to open connections for PCF commands to queue manager %s: %v", qmName, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// setEnv sets an environment variable, or unsets it if the value is empty, and returns a function
+// which restores its previous value
+func setEnv(name, value string) func() {
+	previous, wasSet := os.LookupEnv(name)
+	if value == "" {
+		// #nosec G104
+		os.Unsetenv(name)
+	} else {
+		// #nosec G104
+		os.Setenv(name, value)
+	}
+	return func() {
+		if wasSet {
+			// #nosec G104
+			os.Setenv(name, previous)
+		} else {
+			// #nosec G104
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// newConnectOptions returns the options for a connection to the queue manager, matching those used by mqmetric
+func newConnectOptions(connConfig *mqmetric.ConnectionConfig) *ibmmq.MQCNO {
+
+	cno := ibmmq.NewMQCNO()
+	if connConfig.ClientMode {
+		cno.Options = ibmmq.MQCNO_CLIENT_BINDING
+	} else {
+		cno.Options = ibmmq.MQCNO_LOCAL_BINDING
+	}
+	cno.Options |= ibmmq.MQCNO_HANDLE_SHARE_BLOCK
+
+	if connConfig.UserId != "" {
+		csp := ibmmq.NewMQCSP()
+		csp.UserId = connConfig.UserId
+		csp.Password = connConfig.Password
+		cno.SecurityParms = csp
+	}
+	return cno
+}
+
+// timeProcessPublications processes publications of metric data, and records the time taken
+func (c *Collector) timeProcessPublications() error {
+	var before publicationState
+	if c.cfg.maxIdle > 0 {
+		before = getPublicationState()
+	}
+	start := time.Now()
+	c.checkPublishInterval()
+	c.checkReplyQueueFull()
+	err := processPublications()
+	duration := int64(time.Since(start))
+	if c.cfg.maxIdle > 0 && getPublicationState() != before {
+		c.received = true
+	}
+	atomic.StoreInt64(&c.lastProcessDuration, duration)
+	atomic.AddInt64(&c.processDuration, duration)
+	atomic.AddInt64(&c.processCount, 1)
+	return err
+}
+
+// processPublicationsWithin processes publications of metric data, as timeProcessPublications does, but once it has
+// taken longer than the processing cap, requests are answered while processing continues, so that scrapes are not
+// blocked by a burst of publications, such as after the queue manager restarts. The metrics cannot be updated until
+// processing has finished, so full collect requests are answered without a metrics map, for the metrics of the last
+// collect to be served again, and other requests with the metrics as they are. No publications are lost, as those
+// still being processed are included in the next collect.
+func (c *Collector) processPublicationsWithin(metrics map[string]*metricData) error {
+
+	if c.cfg.maxProcessTime <= 0 {
+		return c.timeProcessPublications()
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- c.timeProcessPublications()
+	}()
+	timer := time.NewTimer(c.cfg.maxProcessTime)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+	}
+
+	atomic.AddInt64(&c.cappedCycles, 1)
+	c.eventLog("process_capped").Debugf("Metrics: Answering requests with the last metrics, as processing publications has taken longer than %v", c.cfg.maxProcessTime)
+	for {
+		select {
+		case err := <-result:
+			return err
+		case request := <-c.requestChannel:
+			if request.collect && request.keys == nil {
+				c.respond(nil)
+			} else {
+				c.respond(metrics)
+			}
+		}
+	}
+}
+
+// nextProcessInterval returns the time to wait for a request before processing publications again, which is the
+// cycle interval, unless the adaptive cadence is enabled. It then doubles after each idleCyclesBeforeBackoff
+// consecutive cycles in which no publications arrived, up to the maximum idle interval, and returns to the cycle
+// interval as soon as publications arrive again.
+func (c *Collector) nextProcessInterval() time.Duration {
+	interval := time.Duration(atomic.LoadInt64(&c.processInterval))
+	if c.cfg.maxIdle <= 0 || c.received || interval == 0 {
+		c.idleCycles = 0
+		interval = c.cycleInterval()
+	} else {
+		c.idleCycles++
+		if c.idleCycles >= idleCyclesBeforeBackoff {
+			c.idleCycles = 0
+			interval *= 2
+			if interval > c.cfg.maxIdle {
+				interval = c.cfg.maxIdle
+			}
+		}
+	}
+	c.received = false
+	atomic.StoreInt64(&c.processInterval, int64(interval))
+	return interval
+}
+
+// recordDroppedPublications counts the reply queue being found full, which means that publications have been dropped,
+// and logs a warning with the number of times since the last warning, at most once in each dropWarningInterval
+func (c *Collector) recordDroppedPublications(maxDepth int64) {
+	dropped := atomic.AddInt64(&c.droppedPublications, 1)
+	if !c.lastDropWarning.IsZero() && time.Since(c.lastDropWarning) < dropWarningInterval {
+		return
 	}
+	c.eventLog("dropped_publications").Printf("Metrics Warning: The reply queue of the published metrics from queue manager %s was full, with %d messages, %d times since the last warning, so publications of metric data were dropped and there may be gaps in the metrics", c.qmName, maxDepth, dropped-c.droppedAtWarning)
+	c.lastDropWarning, c.droppedAtWarning = time.Now(), dropped
+}
+
+// logError logs an error which causes a reconnect when it first occurs, or when it has changed from the last one. An
+// error which keeps recurring is then only logged as a summary of the number of times it occurred, at most once in
+// each errorSummaryInterval. It returns true if the error was logged.
+func (c *Collector) logError(err error, category errorCategory, reason int32) bool {
+	log := c.eventLog("error").WithFields(map[string]interface{}{categoryField: category, reasonField: reason})
+	message := err.Error()
+	if message != c.lastError {
+		if c.errorCount > c.loggedErrorCount {
+			c.eventLog("error").Errorf("Metrics Error: The previous error occurred %d more times before it changed: %s", c.errorCount-c.loggedErrorCount, c.lastError)
+		}
+		log.Errorf("Metrics Error [category=%s reason=%d]: %s", category, reason, message)
+		c.lastError, c.firstErrorTime, c.lastErrorLog = message, time.Now(), time.Now()
+		c.errorCount, c.loggedErrorCount = 1, 1
+		return true
+	}
+	c.errorCount++
+	if time.Since(c.lastErrorLog) < errorSummaryInterval {
+		return false
+	}
+	log.Errorf("Metrics Error [category=%s reason=%d]: The same error occurred %d times in the last %v: %s", category, reason, c.errorCount-c.loggedErrorCount, time.Since(c.lastErrorLog).Round(time.Second), message)
+	c.lastErrorLog, c.loggedErrorCount = time.Now(), c.errorCount
+	return true
+}
+
+// clearError logs that the last error which caused a reconnect has cleared, with the number of times it occurred, so
+// that the next error is logged in full
+func (c *Collector) clearError() {
+	if c.lastError == "" {
+		return
+	}
+	c.eventLog("error_cleared").Printf("Metrics: The error has cleared, after occurring %d times in %v: %s", c.errorCount, time.Since(c.firstErrorTime).Round(time.Second), c.lastError)
+	c.lastError, c.errorCount, c.loggedErrorCount = "", 0, 0
+}
+
+// countMetricTypes returns the number of types of metric discovered on the current connection, each of which
+// has its own subscriptions
+func countMetricTypes() int {
+	count := 0
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		count += len(metricClass.Types)
+	}
+	return count
+}
+
+// countPublishedMetrics returns the number of metrics discovered on the current connection, which the queue manager
+// publishes whether or not they are selected
+func countPublishedMetrics() int {
+	count := 0
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		for _, metricType := range metricClass.Types {
+			count += len(metricType.Elements)
+		}
+	}
+	return count
+}
+
+// checkPublishedMetrics records the number of metrics which the queue manager publishes, which is reported by the
+// exporter, and logs a warning if there are none, as otherwise only the metrics which are not published are served
+// with no explanation
+func (c *Collector) checkPublishedMetrics() {
+	published := countPublishedMetrics()
+	atomic.StoreInt64(&c.publishedMetrics, int64(published))
+	if published == 0 {
+		c.eventLog("no_metrics").Printf("Metrics Warning: Queue manager %s does not publish any metrics. Resource usage metrics are only published from MQ 9.0.1, and while publish/subscribe is enabled with PSMODE(ENABLED)", c.qmName)
+	}
+}
 
+// checkSubscriptions records and logs the number of subscriptions which the queue manager holds for the exporter
+// after connecting, which is reported by the exporter, so that a count which keeps increasing across reconnects can be
+// noticed. It is recorded as -1 if the subscriptions could not be inquired.
+func (c *Collector) checkSubscriptions() {
+	if c.conn.subscriptions == nil {
+		atomic.StoreInt64(&c.subscriptions, -1)
+		c.eventLog("subscriptions").Printf("Metrics: Could not inquire the subscriptions to published metrics held for queue manager %s", c.qmName)
+		return
+	}
+	subscriptions := countHeldSubscriptions(c.conn.subscriptions)
+	atomic.StoreInt64(&c.subscriptions, int64(subscriptions))
+	c.eventLog("subscriptions").Printf("Metrics: Holding %d subscriptions to published metrics for queue manager %s", subscriptions, c.qmName)
+}
+
+// isActiveClass returns whether the metrics of a class can be gathered, as its types and their elements were discovered,
+// and each of its types is subscribed to, according to the subscriptions which the queue manager holds for the
+// exporter. Object types have no subscriptions while no objects match, so they are not required to have any, and
+// only the elements are required if the subscriptions could not be inquired.
+func isActiveClass(metricClass *mqmetric.MonClass, subscriptions map[string]int) bool {
+	if len(metricClass.Types) == 0 {
+		return false
+	}
+	for _, metricType := range metricClass.Types {
+		if len(metricType.Elements) == 0 {
+			return false
+		}
+		if subscriptions != nil && !strings.Contains(metricType.ObjectTopic, "%s") && subscriptions[metricType.ObjectTopic] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// getInactiveClasses returns the sorted names of the metric classes discovered on the current connection whose metrics
+// cannot be gathered
+func getInactiveClasses(subscriptions map[string]int) []string {
+	var names []string
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		if !isActiveClass(metricClass, subscriptions) {
+			names = append(names, metricClass.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkMetricClasses records the number of metric classes discovered after connecting, and the number whose metrics can
+// be gathered, which are reported by the exporter. As the other classes are still gathered, any error discovering or
+// subscribing to them is logged and recorded here, along with the classes which cannot be gathered.
+func (c *Collector) checkMetricClasses() {
+	discovered := len(mqmetric.Metrics.Classes)
+	inactive := getInactiveClasses(c.conn.subscriptions)
+	atomic.StoreInt64(&c.discoveredClasses, int64(discovered))
+	atomic.StoreInt64(&c.activeClasses, int64(discovered-len(inactive)))
+	if c.conn.discoveryError != nil {
+		c.eventLog("partial_discovery").Printf("Metrics Warning: Failed to discover and subscribe to some metrics for queue manager %s: %v", c.qmName, c.conn.discoveryError)
+		c.recordError(c.conn.discoveryError)
+	}
+	if len(inactive) > 0 {
+		c.eventLog("partial_discovery").Printf("Metrics Warning: Gathering metrics of %d of %d classes for queue manager %s, as the classes %s could not be discovered or subscribed to", discovered-len(inactive), discovered, c.qmName, strings.Join(inactive, ", "))
+	}
+}
+
+// handleRequest responds to a describe or collect request with the metrics map, after updating it for a collect request.
+// An error is returned if processing publications fails, in which case the response has no metrics, as while reconnecting.
+func (c *Collector) handleRequest(request metricsRequest, metrics map[string]*metricData) error {
+
+	start := time.Now()
+	// While the adaptive cadence has backed off, the publications are processed for each collect request as well, so
+	// that the metrics are no older than without it
+	backedOff := time.Duration(atomic.LoadInt64(&c.processInterval)) > c.cfg.requestTimeout
+	if request.collect && (c.cfg.onDemand || backedOff || request.refresh) {
+		// Process the publications received since the last collect request
+		err := c.timeProcessPublications()
+		if err != nil {
+			c.respond(map[string]*metricData{})
+			return err
+		}
+	}
+	if request.collect && request.keys != nil {
+		// Metrics gathered using PCF commands are only updated by full requests, as the changes in
+		// cumulative values would otherwise be missed by their Prometheus counters
+		c.logNonFinite(updateSelectedMetrics(metrics, request.keys))
+		updateContainerMetrics(metrics)
+		updateInfoMetric(metrics, c.conn.commandLevel, c.conn.mqVersion)
+		updateUptimeMetric(metrics, c.conn.startTime)
+		updateCommandServerMetric(metrics, c.commandServer)
+	} else if request.collect {
+		c.logNonFinite(updateMetrics(metrics))
+		updateContainerMetrics(metrics)
+		updateInfoMetric(metrics, c.conn.commandLevel, c.conn.mqVersion)
+		updateUptimeMetric(metrics, c.conn.startTime)
+		c.checkCommandServer()
+		updateCommandServerMetric(metrics, c.commandServer)
+		c.checkReplyQueue()
+		updateClassMetric(metrics, c.cfg, c.conn.subscriptions)
+		c.updatePCFMetrics(metrics)
+		atomic.StoreInt64(&c.lastCollectDuration, int64(time.Since(start)))
+	}
+	if request.collect {
+		limitLabelValues(c.log, metrics, c.cfg.maxLabelValues)
+	}
+	if request.collect && request.keys == nil && c.cfg.logSamples {
+		logSamples(c.log, c.qmLabelValue(), metrics)
+	}
+
+	// A collect only succeeds once its response is received, as a requester which has timed out does not report it
+	// - requests for selected metrics are not counted, as they do not update all of them
+	updated := time.Now()
+	if c.respond(metrics) && request.collect && request.keys == nil {
+		c.recordCollect(updated)
+	}
 	return nil
 }
 
+// recordCollect records the time at which the metrics were updated for a successful collect request, and the time
+// since the previous one
+func (c *Collector) recordCollect(t time.Time) {
+	if previous := atomic.SwapInt64(&c.lastCollectTime, t.UnixNano()); previous != 0 {
+		atomic.StoreInt64(&c.lastCollectInterval, t.UnixNano()-previous)
+	}
+}
+
+// logNonFinite logs a warning for each of the metrics with the given keys, which have values that normalise to NaN
+// or Inf
+func (c *Collector) logNonFinite(keys []string) {
+	for _, key := range keys {
+		if c.cfg.nonFiniteZero {
+			c.eventLog("non_finite").Printf("Metrics Warning: Metric [%s] has a value which is not finite after converting it to base units, so it is reported as 0", key)
+		} else {
+			c.eventLog("non_finite").Printf("Metrics Warning: Metric [%s] has a value which is not finite after converting it to base units, so it is skipped", key)
+		}
+	}
+}
+
+// setTarget changes the queue manager metrics are gathered from, and the configuration, to the target
+func (c *Collector) setTarget(t metricsTarget) {
+	c.targetMutex.Lock()
+	defer c.targetMutex.Unlock()
+	c.qmName, c.cfg = t.qmName, c.cfg.withTarget(t)
+}
+
+// eventLog returns the logger for a message about an event in metrics gathering for the current queue manager, which
+// adds the event and queue manager name to the message when it is logged in JSON format
+func (c *Collector) eventLog(event string) *logger.Logger {
+	return c.log.WithFields(map[string]interface{}{eventField: event, qmgrField: c.getQMName()})
+}
+
+// respond sends the response to a request, unless the requester does not receive it within the response timeout,
+// in which case it is dropped so that processing carries on. It returns true if the response was received.
+func (c *Collector) respond(metrics map[string]*metricData) bool {
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+	select {
+	case c.responseChannel <- metrics:
+		return true
+	case <-timeout.C:
+		c.eventLog("dropped_response").Printf("Metrics Warning: Dropped the response to a request for metrics, as the requester did not receive it within %v", responseTimeout)
+		return false
+	}
+}
+
+// getLastKnown returns the metrics to serve while reconnecting, which are those last served. The values of delta
+// metrics have already been added to their counters, so they are cleared, and the counters are served unchanged.
+func getLastKnown(metrics map[string]*metricData) map[string]*metricData {
+	for _, metric := range metrics {
+		if metric.isDelta {
+			metric.values = make(map[string]float64)
+		}
+	}
+	return metrics
+}
+
+// drainPublications processes the publications already waiting on the reply queue before stopping, so that requests
+// for metrics made while stopping include them. Publications are processed until a pass receives no more data, or the
+// drain timeout passes. It is only called when stopping while connected, so is skipped after a fatal error.
+func (c *Collector) drainPublications(metrics map[string]*metricData) {
+
+	if c.cfg.drainTimeout <= 0 {
+		return
+	}
+	c.eventLog("drain").Debugf("Metrics: Processing pending publications for up to %v", c.cfg.drainTimeout)
+	deadline := time.After(c.cfg.drainTimeout)
+	for {
+		before := getPublicationState()
+		err := c.timeProcessPublications()
+		if err != nil {
+			c.eventLog("drain").Debugf("Metrics: Stopped processing pending publications: %v", err)
+			return
+		}
+		if getPublicationState() == before {
+			return
+		}
+
+		// Respond to requests while waiting for any further publications
+		select {
+		case request := <-c.requestChannel:
+			err = c.handleRequest(request, metrics)
+			if err != nil {
+				return
+			}
+		case <-time.After(drainInterval):
+		case <-deadline:
+			c.eventLog("drain").Debugf("Metrics: Timed out processing pending publications")
+			return
+		}
+	}
+}
+
+// publicationState summarises the publication data which has not yet been used to update the metrics
+type publicationState struct {
+	values int
+	total  int64
+}
+
+// getPublicationState returns the current publication state. Processing publications adds values or changes existing
+// ones, which changes the state, apart from the unlikely case of new values summing to the same total.
+func getPublicationState() publicationState {
+	var state publicationState
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		for _, metricType := range metricClass.Types {
+			for _, metricElement := range metricType.Elements {
+				if metricElement == nil {
+					continue
+				}
+				state.values += len(metricElement.Values)
+				for _, value := range metricElement.Values {
+					state.total += value
+				}
+			}
+		}
+	}
+	return state
+}
+
+// doEndConnection closes the connections to the queue manager
+func doEndConnection(conn *qmConnection) {
+	if conn.pcfConns != nil {
+		conn.pcfConns.close()
+		conn.pcfConns = nil
+	}
+	if conn.cmdQInquiry != nil {
+		conn.cmdQInquiry.close()
+		conn.cmdQInquiry = nil
+	}
+	if conn.replyQInquiry != nil {
+		conn.replyQInquiry.close()
+		conn.replyQInquiry = nil
+	}
+	mqmetric.EndConnection()
+}
+
+// endConnection closes the current connection to the queue manager, after which nothing is known about it
+func (c *Collector) endConnection() {
+	endConnection(c.conn)
+	c.conn = newQMConnection()
+}
+
 // initialiseMetrics sets initial details for all available metrics
-func initialiseMetrics(log *logger.Logger) (map[string]*metricData, error) {
+func initialiseMetrics(log *logger.Logger, cfg *metricsConfig) (map[string]*metricData, error) {
 
 	metrics := make(map[string]*metricData)
+	mappingKeys := make(map[string]string)
 	validMetrics := true
 	metricNamesMap := generateMetricNamesMap()
 
+	// Disabled classes are still subscribed to, as mqmetric subscribes to every class it discovers, and its
+	// subscriptions cannot be closed individually - their publications are discarded when processed
+	var classNames, enabledClasses []string
 	for _, metricClass := range mqmetric.Metrics.Classes {
+		classNames = append(classNames, metricClass.Name)
+		if !cfg.isClassSelected(metricClass.Name) {
+			log.Debugf("Metrics: Skipping metric class, class is not enabled [%s]", metricClass.Name)
+			continue
+		}
+		enabledClasses = append(enabledClasses, metricClass.Name)
+
 		for _, metricType := range metricClass.Types {
-			if !strings.Contains(metricType.ObjectTopic, "%s") {
-				for _, metricElement := range metricType.Elements {
 
-					// Get unique metric key
-					key := makeKey(metricElement)
+			// Object topics (containing %s) provide metrics for each of the monitored queues
+			objectType := strings.Contains(metricType.ObjectTopic, "%s")
+			if objectType && cfg.queues == "" {
+				log.Debugf("Metrics: Skipping object topic, no queues are monitored [%s] with elements %v", metricType.ObjectTopic, getElementDescriptions(metricType))
+				continue
+			}
 
-					// Get metric name from mapping
-					if metricLookup, found := metricNamesMap[key]; found {
+			for _, metricElement := range metricType.Elements {
 
-						// Check if metric is enabled
-						if metricLookup.enabled {
+				// Get unique metric key, and the key used to identify the metric in the mapping
+				key := makeKey(metricElement)
+				mappingKey := makeMappingKey(metricElement)
 
-							// Check if metric is a delta type
-							isDelta := false
-							if metricElement.Datatype == ibmmq.MQIAMO_MONITOR_DELTA {
-								isDelta = true
-							}
+				// Elements with a broken parent chain cannot be identified, so they are reported rather than used
+				// - the keys are also checked when metrics are updated, so that such elements are skipped there too
+				if key == "" || mappingKey == "" {
+					log.Errorf("Metrics Error: Skipping metric, the metric element is not linked to its type and class [%s/%s]", metricType.ObjectTopic, getElementDescription(metricElement))
+					continue
+				}
 
-							// Set metric details
-							metric := metricData{
-								name:        metricLookup.name,
-								description: metricElement.Description,
-								isDelta:     isDelta,
-							}
+				// Check if metric is selected by the include/exclude patterns
+				if !cfg.isSelected(mappingKey) {
+					log.Debugf("Metrics: Skipping metric, metric is not selected for key [%s]", mappingKey)
+					continue
+				}
 
-							// Add metric
-							if _, exists := metrics[key]; !exists {
-								metrics[key] = &metric
-							} else {
-								log.Errorf("Metrics Error: Found duplicate metric key [%s]", key)
-								validMetrics = false
-							}
-						} else {
-							log.Debugf("Metrics: Skipping metric, metric is not enabled for key [%s]", key)
-						}
-					} else {
-						log.Errorf("Metrics Error: Skipping metric, unexpected key [%s]", key)
-						validMetrics = false
-					}
+				// Get metric name from mapping
+				lookup, found := metricNamesMap[mappingKey]
+				if !found && objectType {
+					// Object metrics without a defined mapping use the name generated by mqmetric
+					lookup, found = metricLookup{metricElement.MetricName, true}, true
+				}
+				if !found {
+					log.Errorf("Metrics Error: Skipping metric, unexpected key [%s]", mappingKey)
+					validMetrics = false
+					continue
+				}
+
+				// Check if metric is enabled
+				if !lookup.enabled {
+					log.Debugf("Metrics: Skipping metric, metric is not enabled for key [%s]", mappingKey)
+					continue
+				}
+
+				// Check if metric is a delta type
+				isDelta := false
+				if metricElement.Datatype == ibmmq.MQIAMO_MONITOR_DELTA {
+					isDelta = true
+				}
+
+				// Set metric details
+				metric := metricData{
+					name:          lookup.name,
+					description:   metricElement.Description,
+					objectType:    objectType,
+					isDelta:       isDelta,
+					unit:          getUnit(metricElement.Datatype),
+					rawUnits:      cfg.rawUnits,
+					scale:         getUnitScale(metricElement.Datatype),
+					nonFiniteZero: cfg.nonFiniteZero,
+				}
+				if cfg.rawUnits {
+					metric.unit = getRawUnit(metricElement.Datatype)
+				}
+
+				// Add metric
+				if _, exists := metrics[key]; !exists {
+					metrics[key] = &metric
+					mappingKeys[key] = mappingKey
+				} else {
+					log.Errorf("Metrics Error: Found duplicate metric key [%s]", key)
+					validMetrics = false
 				}
 			}
 		}
 	}
 
+	checkClassNames(log, cfg, classNames)
+	sort.Strings(enabledClasses)
+	log.Printf("Metrics: Enabled metric classes: %s", strings.Join(enabledClasses, ", "))
+
+	initialiseInfoMetric(metrics, cfg)
+	initialiseUptimeMetric(metrics, cfg)
+	initialiseCommandServerMetric(metrics, cfg)
+	initialiseClassMetric(metrics, cfg)
+	initialiseContainerMetrics(metrics, cfg)
+	if cfg.channels != "" {
+		initialiseChannelMetrics(metrics, cfg)
+	}
+	initialiseTopicMetrics(metrics, cfg)
+	if cfg.depthQueues != "" {
+		initialiseQueueMetrics(metrics, cfg)
+	}
+
+	if len(cfg.descriptions) > 0 {
+		applied := applyDescriptions(metrics, mappingKeys, cfg.descriptions)
+		log.Printf("Metrics: Applied %d of %d metric description overrides", applied, len(cfg.descriptions))
+	}
+	if len(cfg.scales) > 0 {
+		applied := applyScales(metrics, mappingKeys, cfg.scales)
+		log.Printf("Metrics: Applied %d of %d metric scale factors", applied, len(cfg.scales))
+	}
+	for _, warning := range applyAlwaysPresent(metrics, mappingKeys, cfg.alwaysPresent) {
+		log.Printf("Metrics Warning: %s", warning)
+	}
+
+	if cfg.snakeCase {
+		for _, collision := range sanitiseMetricNames(cfg.metricNamespace(), metrics) {
+			log.Printf("Metrics Warning: %s", collision)
+		}
+	}
+
 	if !validMetrics {
 		return metrics, fmt.Errorf("Invalid metrics data")
 	}
 	return metrics, nil
 }
 
+// checkClassNames logs a warning for each configured metric class name which is not published by the queue manager,
+// as otherwise a misspelt name would silently gather no metrics
+func checkClassNames(log *logger.Logger, cfg *metricsConfig, classNames []string) {
+	sort.Strings(classNames)
+	valid := make(map[string]bool)
+	for _, className := range classNames {
+		valid[strings.ToUpper(className)] = true
+	}
+	for _, env := range []struct {
+		name    string
+		classes []string
+	}{{classesEnv, cfg.classes}, {excludeClassesEnv, cfg.excludeClasses}} {
+		for _, className := range env.classes {
+			if !valid[className] {
+				log.Printf("Metrics Warning: Unknown metric class %s in %s - valid class names are %s", className, env.name, strings.Join(classNames, ", "))
+			}
+		}
+	}
+}
+
+// initialiseKnownMetrics returns the metrics which are expected to be available, without connecting to the queue
+// manager. Published metrics are taken from the mapping of metric names, so their help text does not include units.
+func initialiseKnownMetrics(cfg *metricsConfig) map[string]*metricData {
+
+	metrics := make(map[string]*metricData)
+	for mappingKey, lookup := range generateMetricNamesMap() {
+		// Mapping keys are made up of the class, type and description of the metric
+		parts := strings.SplitN(mappingKey, "/", 3)
+		objectType := parts[0] == queueClassName
+		if !lookup.enabled || !cfg.isSelected(mappingKey) || !cfg.isClassSelected(parts[0]) || (objectType && cfg.queues == "") {
+			continue
+		}
+		metrics[mappingKey] = &metricData{
+			name:        lookup.name,
+			description: parts[2],
+			objectType:  objectType,
+			isDelta:     strings.HasSuffix(lookup.name, "_total"),
+		}
+	}
+
+	initialiseInfoMetric(metrics, cfg)
+	initialiseUptimeMetric(metrics, cfg)
+	initialiseCommandServerMetric(metrics, cfg)
+	initialiseClassMetric(metrics, cfg)
+	initialiseContainerMetrics(metrics, cfg)
+	if cfg.channels != "" {
+		initialiseChannelMetrics(metrics, cfg)
+	}
+	initialiseTopicMetrics(metrics, cfg)
+	if cfg.depthQueues != "" {
+		initialiseQueueMetrics(metrics, cfg)
+	}
+	applyDescriptions(metrics, nil, cfg.descriptions)
+
+	// Collisions are logged when the published metrics are initialised
+	if cfg.snakeCase {
+		sanitiseMetricNames(cfg.metricNamespace(), metrics)
+	}
+	return metrics
+}
+
 // updateMetrics updates values for all available metrics
-func updateMetrics(metrics map[string]*metricData) {
+func updateMetrics(metrics map[string]*metricData) []string {
+	return updateSelectedMetrics(metrics, nil)
+}
 
+// updateSelectedMetrics updates values for the metrics published by the queue manager with the given keys, or all
+// of them if keys is nil. When only some metrics are updated, the cached publication data is kept, so that it is
+// also used by the next full update - otherwise the values of delta metrics would be missed by their counters.
+// Values which normalise to NaN or Inf are not meaningful, so they are skipped or reported as 0, and the sorted keys
+// of the metrics with the first such values are returned, so that they can be logged.
+func updateSelectedMetrics(metrics map[string]*metricData, keys map[string]bool) []string {
+
+	var nonFinite []string
 	for _, metricClass := range mqmetric.Metrics.Classes {
 		for _, metricType := range metricClass.Types {
-			if !strings.Contains(metricType.ObjectTopic, "%s") {
-				for _, metricElement := range metricType.Elements {
-
-					// Unexpected metric elements (with no defined mapping) are handled in 'initialiseMetrics'
-					// - if any exist, they are logged as errors and skipped (they are not added to the metrics map)
-					// Therefore we can ignore handling any unexpected metric elements found here
-					// - this avoids us logging excessive errors, as this function is called frequently
-					metric, ok := metrics[makeKey(metricElement)]
-					if ok {
-						// Clear existing metric values
-						metric.values = make(map[string]float64)
-
-						// Update metric with cached values of publication data
-						for label, value := range metricElement.Values {
-							normalisedValue := mqmetric.Normalise(metricElement, label, value)
-							metric.values[label] = normalisedValue
+			for _, metricElement := range metricType.Elements {
+
+				key := makeKey(metricElement)
+				if key == "" || keys != nil && !keys[key] {
+					continue
+				}
+
+				// Unexpected metric elements (with no defined mapping) are handled in 'initialiseMetrics'
+				// - if any exist, they are logged as errors and skipped (they are not added to the metrics map)
+				// Therefore we can ignore handling any unexpected metric elements found here
+				// - this avoids us logging excessive errors, as this function is called frequently
+				metric, ok := metrics[key]
+				if ok && len(metricElement.Values) > 0 {
+					// Replace existing metric values with cached values of publication data
+					// - values are keyed by queue name for object metrics
+					// - the scale to base units depends only on the datatype, so is found when the metrics are initialised
+					metric.values = make(map[string]float64, len(metricElement.Values))
+					metric.lastUpdate = time.Now()
+					for label, value := range metricElement.Values {
+						if metric.rawUnits {
+							metric.values[label] = float64(value)
+							continue
+						}
+						normalised := metric.scale.normalise(value)
+						if math.IsNaN(normalised) || math.IsInf(normalised, 0) {
+							if !metric.nonFinite {
+								metric.nonFinite = true
+								nonFinite = append(nonFinite, key)
+							}
+							if !metric.nonFiniteZero {
+								continue
+							}
+							normalised = 0
 						}
+						metric.values[label] = normalised
 					}
+				} else if ok && metric.isDelta {
+					// Values of delta metrics are added to their counters on each collect,
+					// so are only kept until the next cycle
+					metric.values = make(map[string]float64)
+				}
+				// Other metrics retain their last values until new publication data is received,
+				// or they become stale
 
-					// Reset cached values of publication data for this metric
+				// Reset cached values of publication data for this metric
+				if keys == nil {
 					metricElement.Values = make(map[string]int64)
 				}
 			}
 		}
 	}
+	sort.Strings(nonFinite)
+	return nonFinite
+}
+
+// limitLabelValues aggregates the values of each object metric over the given maximum number of label values, so that
+// they are reported with the label value 'other' - the first values in sorted order keep their own label values. A
+// warning is logged the first time the values of a metric are aggregated. A maximum of zero means there is no limit.
+func limitLabelValues(log *logger.Logger, metrics map[string]*metricData, maxValues int) {
+
+	if maxValues == 0 {
+		return
+	}
+	for _, metric := range metrics {
+		if !metric.objectType {
+			continue
+		}
+		otherLabel := getOtherLabel(metric)
+
+		// Values which have already been aggregated are left in the 'other' value
+		labels := make([]string, 0, len(metric.values))
+		for label := range metric.values {
+			if label != otherLabel {
+				labels = append(labels, label)
+			}
+		}
+		if len(labels) <= maxValues {
+			continue
+		}
+		sort.Strings(labels)
+		for _, label := range labels[maxValues:] {
+			if !metric.aggregateMax {
+				metric.values[otherLabel] += metric.values[label]
+			} else if other, ok := metric.values[otherLabel]; !ok || metric.values[label] > other {
+				metric.values[otherLabel] = metric.values[label]
+			}
+			delete(metric.values, label)
+		}
+		if !metric.limited {
+			metric.limited = true
+			log.Printf("Metrics Warning: Metric %s has more than %d label values, so the values for %d objects are reported with the label value %s", metric.name, maxValues, len(labels)-maxValues, otherLabelValue)
+		}
+	}
+}
+
+// getOtherLabel returns the label of the aggregated values of an object metric, which has the label value 'other'
+// for each of the labels of the object
+func getOtherLabel(metric *metricData) string {
+	count := 1
+	if metric.objectLabels != nil {
+		count = len(metric.objectLabels)
+	}
+	values := make([]string, count)
+	for i := range values {
+		values[i] = otherLabelValue
+	}
+	return strings.Join(values, labelSeparator)
+}
+
+// getUnit returns the unit of a metric with the given datatype, after its values have been normalised
+func getUnit(datatype int32) string {
+	switch datatype {
+	case ibmmq.MQIAMO_MONITOR_PERCENT, ibmmq.MQIAMO_MONITOR_HUNDREDTHS:
+		return "percentage"
+	case ibmmq.MQIAMO_MONITOR_MB, ibmmq.MQIAMO_MONITOR_GB:
+		return "bytes"
+	case ibmmq.MQIAMO_MONITOR_MICROSEC:
+		return "seconds"
+	}
+	return ""
+}
+
+// getUnitScale returns the scale which converts values of a metric with the given datatype to base units,
+// as done by mqmetric.Normalise
+func getUnitScale(datatype int32) unitScale {
+	switch datatype {
+	case ibmmq.MQIAMO_MONITOR_PERCENT, ibmmq.MQIAMO_MONITOR_HUNDREDTHS:
+		return unitScale{multiplier: 1, divisor: 100}
+	case ibmmq.MQIAMO_MONITOR_MB:
+		return unitScale{multiplier: 1024 * 1024, divisor: 1}
+	case ibmmq.MQIAMO_MONITOR_GB:
+		return unitScale{multiplier: 1024 * 1024 * 1024, divisor: 1}
+	case ibmmq.MQIAMO_MONITOR_MICROSEC:
+		return unitScale{multiplier: 1, divisor: 1000000}
+	}
+	return unitScale{}
+}
+
+// normalise returns a published value in base units. As in mqmetric.Normalise, negative values are
+// treated as zero, as they are not meaningful and are assumed to be caused by errors in the queue manager.
+func (s unitScale) normalise(value int64) float64 {
+	f := float64(value)
+	if f < 0 {
+		return 0
+	}
+	if s.divisor == 0 {
+		return f
+	}
+	return f * s.multiplier / s.divisor
+}
+
+// getRawUnit returns the unit of a metric with the given datatype, as it is published by the queue manager
+func getRawUnit(datatype int32) string {
+	switch datatype {
+	case ibmmq.MQIAMO_MONITOR_PERCENT, ibmmq.MQIAMO_MONITOR_HUNDREDTHS:
+		return "hundredths"
+	case ibmmq.MQIAMO_MONITOR_MB:
+		return "megabytes"
+	case ibmmq.MQIAMO_MONITOR_GB:
+		return "gigabytes"
+	case ibmmq.MQIAMO_MONITOR_MICROSEC:
+		return "microseconds"
+	}
+	return ""
+}
+
+// updatePCFMetrics updates values for the metrics gathered using PCF commands, and records the time taken
+// - each kind of object is inquired concurrently, sharing the PCF connections, which limit the inquiries in progress
+// - failures are logged, so that the published metrics are still updated
+func (c *Collector) updatePCFMetrics(metrics map[string]*metricData) {
+
+	if !c.cfg.usesPCF() {
+		return
+	}
+	if c.commandServer == commandServerStopped {
+		// The inquiries would wait for replies which are never sent, so the metrics are left without values
+		clearChannelCounters(metrics)
+		atomic.StoreInt64(&c.lastPCFDuration, 0)
+		return
+	}
+	start := time.Now()
+
+	var channels []channelStatus
+	var topics []topicStatus
+	var subscriptions []subscriptionStatus
+	var queues []queueStatus
+	var channelErr, topicErr, subscriptionErr, queueErr error
+	var wg sync.WaitGroup
+	inquire := func(configured bool, f func()) {
+		if configured {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f()
+			}()
+		}
+	}
+	pool := c.conn.pcfConns
+	inquire(c.cfg.channels != "", func() { channels, channelErr = inquireChannels(pool, c.cfg) })
+	inquire(len(c.cfg.topics) > 0, func() { topics, topicErr = inquireTopics(pool, c.cfg) })
+	inquire(c.cfg.subscriptions != "", func() { subscriptions, subscriptionErr = inquireSubscriptions(pool, c.cfg) })
+	inquire(c.cfg.depthQueues != "", func() { queues, queueErr = inquireQueues(pool, c.cfg) })
+	wg.Wait()
+	atomic.StoreInt64(&c.lastPCFDuration, int64(time.Since(start)))
+
+	if c.cfg.channels != "" {
+		if channelErr != nil {
+			c.recordError(channelErr)
+			c.eventLog("pcf_error").Errorf("Metrics Error: Failed to inquire channel status: %v", channelErr)
+			clearChannelCounters(metrics)
+		} else {
+			updateChannelMetrics(metrics, channels)
+		}
+	}
+
+	if len(c.cfg.topics) > 0 {
+		if topicErr != nil {
+			c.recordError(topicErr)
+			c.eventLog("pcf_error").Errorf("Metrics Error: Failed to inquire topic status: %v", topicErr)
+		} else {
+			updateTopicMetrics(metrics, topics)
+		}
+	}
+
+	if c.cfg.subscriptions != "" {
+		if subscriptionErr != nil {
+			c.recordError(subscriptionErr)
+			c.eventLog("pcf_error").Errorf("Metrics Error: Failed to inquire subscription status: %v", subscriptionErr)
+		} else {
+			updateSubscriptionMetrics(metrics, subscriptions)
+		}
+	}
+
+	if c.cfg.depthQueues != "" {
+		if queueErr != nil {
+			c.recordError(queueErr)
+			c.eventLog("pcf_error").Errorf("Metrics Error: Failed to inquire queue depths: %v", queueErr)
+		} else {
+			updateQueueMetrics(metrics, queues)
+		}
+	}
+}
+
+// recordError records the time of the latest error, and counts errors by MQ reason code, which are reported by the
+// exporter - errors without a reason code are not counted
+func (c *Collector) recordError(err error) {
+	atomic.StoreInt64(&c.lastErrorTime, time.Now().UnixNano())
+	if reason := reasonCode(err); reason != 0 {
+		c.errorCounter.WithLabelValues(strconv.Itoa(int(reason)), c.qmLabelValue()).Inc()
+	}
+}
+
+// getElementDescriptions returns the sorted descriptions of the elements of a metric type
+func getElementDescriptions(metricType *mqmetric.MonType) []string {
+	descriptions := make([]string, 0, len(metricType.Elements))
+	for _, metricElement := range metricType.Elements {
+		descriptions = append(descriptions, getElementDescription(metricElement))
+	}
+	sort.Strings(descriptions)
+	return descriptions
 }
 
-// makeKey builds a unique key for each metric
+// makeKey builds a unique key for each metric, or returns an empty string if the element is not linked to its type
+// - the topic identifies the class and type of the metric, as type names are not unique across topics
 func makeKey(metricElement *mqmetric.MonElement) string {
+	if metricElement == nil || metricElement.Parent == nil {
+		return ""
+	}
+	return metricElement.Parent.ObjectTopic + "/" + metricElement.Description
+}
+
+// makeMappingKey builds the key used to look up the name of a metric, and to select it for publishing, or returns an
+// empty string if the element is not linked to its type and class
+func makeMappingKey(metricElement *mqmetric.MonElement) string {
+	if metricElement == nil || metricElement.Parent == nil || metricElement.Parent.Parent == nil {
+		return ""
+	}
 	return metricElement.Parent.Parent.Name + "/" + metricElement.Parent.Name + "/" + metricElement.Description
 }
+
+// getElementDescription returns the description of a metric element, which may be missing from the discovered metrics
+func getElementDescription(metricElement *mqmetric.MonElement) string {
+	if metricElement == nil {
+		return ""
+	}
+	return metricElement.Description
+}
@@ -16,11 +16,25 @@ limitations under the License.
 package metrics
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ibm-messaging/mq-container/pkg/logger"
+	"github.com/ibm-messaging/mq-golang/ibmmq"
 	"github.com/ibm-messaging/mq-golang/mqmetric"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const (
@@ -30,102 +44,1914 @@ const (
 	testElement2Name        = "cpu_load_fifteen_minute_average_percentage"
 	testElement1Description = "CPU load - five minute average"
 	testElement2Description = "CPU load - fifteen minute average"
-	testKey1                = testClassName + "/" + testTypeName + "/" + testElement1Description
-	testKey2                = testClassName + "/" + testTypeName + "/" + testElement2Description
+	testTopic1              = "ObjectTopic"
+	testTopic2              = "%s"
+	testKey1                = testTopic1 + "/" + testElement1Description
+	testKey2                = testTopic2 + "/" + testElement2Description
+	testMappingKey1         = testClassName + "/" + testTypeName + "/" + testElement1Description
+	testCommandLevel        = 915
+	testMQVersion           = "9.1.5.0"
 )
 
+// testStartTime is the start time of the queue manager returned by the test connection
+var testStartTime = time.Date(2020, time.May, 12, 10, 15, 30, 0, time.Local)
+
+// staticMetrics is the number of metrics which are available without being published by the queue manager
+// - the container, queue manager information, uptime, command server and class metrics
+var staticMetrics = len(containerMetrics) + 4
+
 func TestInitialiseMetrics(t *testing.T) {
 
 	teardownTestCase := setupTestCase(false)
 	defer teardownTestCase()
 
-	metrics, err := initialiseMetrics(getTestLogger())
-	metric, ok := metrics[testKey1]
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{})
+	metric, ok := metrics[testKey1]
+
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	if !ok {
+		t.Error("Expected metric not found in map")
+	} else {
+		if metric.name != testElement1Name {
+			t.Errorf("Expected name=%s; actual %s", testElement1Name, metric.name)
+		}
+		if metric.description != testElement1Description {
+			t.Errorf("Expected description=%s; actual %s", testElement1Description, metric.description)
+		}
+		if metric.objectType != false {
+			t.Errorf("Expected objectType=%v; actual %v", false, metric.objectType)
+		}
+		if len(metric.values) != 0 {
+			t.Errorf("Expected values-size=%d; actual %d", 0, len(metric.values))
+		}
+	}
+	_, ok = metrics[testKey2]
+	if ok {
+		t.Errorf("Unexpected metric found in map, %%s object topics should be ignored")
+	}
+
+	if len(metrics) != 1+staticMetrics {
+		t.Errorf("Map contains unexpected metrics, map size=%d", len(metrics))
+	}
+}
+
+func TestInitialiseMetrics_QueueMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{queues: "APP.*"})
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	if len(metrics) != 2+staticMetrics {
+		t.Errorf("Expected metrics-size=%d; actual %d", 2+staticMetrics, len(metrics))
+	}
+	metric, ok := metrics[testKey2]
+	if !ok {
+		t.Fatal("Expected object metric not found in map")
+	}
+	if !metric.objectType {
+		t.Errorf("Expected objectType=%v; actual %v", true, metric.objectType)
+	}
+	if metrics[testKey1].objectType {
+		t.Errorf("Expected objectType=%v; actual %v", false, metrics[testKey1].objectType)
+	}
+
+	// Object metrics without a defined mapping use the name generated by mqmetric
+	mqmetric.Metrics.Classes[0].Types[1].Elements[0].Description = "New Metric"
+	metrics, err = initialiseMetrics(getTestLogger(), &metricsConfig{queues: "APP.*"})
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	metric, ok = metrics[testTopic2+"/New Metric"]
+	if !ok {
+		t.Fatal("Expected unmapped object metric not found in map")
+	}
+	if metric.name != "Element2Name" {
+		t.Errorf("Expected name=%s; actual %s", "Element2Name", metric.name)
+	}
+}
+
+func TestInitialiseMetrics_Excluded(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{exclude: []string{testClassName + "/*/*"}})
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	if len(metrics) != staticMetrics {
+		t.Errorf("Expected excluded metrics to be skipped, map size=%d", len(metrics))
+	}
+}
+
+func TestInitialiseMetrics_Classes(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{excludeClasses: []string{testClassName}})
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	if len(metrics) != staticMetrics {
+		t.Errorf("Expected metrics of disabled class to be skipped, map size=%d", len(metrics))
+	}
+
+	// Misspelt class names are reported with the valid class names
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	metrics, _ = initialiseMetrics(log, &metricsConfig{classes: []string{testClassName, "CPUU"}})
+	if len(metrics) == 0 {
+		t.Error("Expected metrics of enabled class")
+	}
+	expected := "Unknown metric class CPUU in " + classesEnv + " - valid class names are " + testClassName
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log to contain %s; actual %s", expected, buf.String())
+	}
+}
+
+func TestInitialiseMetrics_SkippedObjectTopics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// Object topics which are skipped are logged with their elements in debug mode
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, true, false, "test")
+	initialiseMetrics(log, &metricsConfig{})
+	expected := "Skipping object topic, no queues are monitored [" + testTopic2 + "] with elements [" + testElement2Description + "]"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log to contain %s; actual %s", expected, buf.String())
+	}
+
+	buf.Reset()
+	initialiseMetrics(log, &metricsConfig{queues: "APP.*"})
+	if strings.Contains(buf.String(), "Skipping object topic") {
+		t.Errorf("Expected object topics not to be skipped when monitoring queues; actual %s", buf.String())
+	}
+}
+
+func TestInitialiseMetrics_UnexpectedKey(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	mqmetric.Metrics.Classes[0].Types[0].Elements[0].Description = "New Metric"
+	_, err := initialiseMetrics(getTestLogger(), &metricsConfig{})
+
+	if err == nil {
+		t.Error("Expected skipping metric error")
+	}
+}
+
+func TestInitialiseMetrics_DuplicateKeys(t *testing.T) {
+
+	teardownTestCase := setupTestCase(true)
+	defer teardownTestCase()
+
+	_, err := initialiseMetrics(getTestLogger(), &metricsConfig{})
+
+	if err == nil {
+		t.Error("Expected duplicate keys error")
+	}
+}
+
+func TestInitialiseMetrics_SameTypeName(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// Add an element with the same class, type and description as element 1, published on a different topic
+	metricType := new(mqmetric.MonType)
+	metricType.Name = testTypeName
+	metricType.ObjectTopic = "OtherTopic"
+	metricType.Parent = mqmetric.Metrics.Classes[0]
+	metricElement := new(mqmetric.MonElement)
+	metricElement.Description = testElement1Description
+	metricElement.Parent = metricType
+	metricType.Elements = map[int]*mqmetric.MonElement{0: metricElement}
+	mqmetric.Metrics.Classes[0].Types[2] = metricType
+
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	otherKey := makeKey(metricElement)
+	if otherKey == testKey1 {
+		t.Fatalf("Expected distinct keys; actual %s", otherKey)
+	}
+	for _, key := range []string{testKey1, otherKey} {
+		if _, ok := metrics[key]; !ok {
+			t.Errorf("No metric found for key %s", key)
+		}
+	}
+}
+
+func TestInitialiseMetrics_BrokenParent(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// Add an element with no type, an element whose type has no class, and a missing element
+	orphanType := &mqmetric.MonType{Name: testTypeName, ObjectTopic: "OrphanTopic"}
+	orphanType.Elements = map[int]*mqmetric.MonElement{
+		0: {Description: "Orphan Element", Parent: orphanType, Values: map[string]int64{qmgrLabelValue: 1}},
+	}
+	mqmetric.Metrics.Classes[0].Types[2] = orphanType
+	mqmetric.Metrics.Classes[0].Types[0].Elements[1] = &mqmetric.MonElement{Description: "Unlinked Element", Values: map[string]int64{qmgrLabelValue: 1}}
+	mqmetric.Metrics.Classes[0].Types[0].Elements[2] = nil
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	metrics, err := initialiseMetrics(log, &metricsConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if _, ok := metrics[testKey1]; !ok {
+		t.Errorf("No metric found for key %s", testKey1)
+	}
+	if len(metrics) != 1+staticMetrics {
+		t.Errorf("Expected metrics=%d; actual %d", 1+staticMetrics, len(metrics))
+	}
+	for _, expected := range []string{"[OrphanTopic/Orphan Element]", "[" + testTopic1 + "/Unlinked Element]", "[" + testTopic1 + "/]"} {
+		if !strings.Contains(buf.String(), "Skipping metric, the metric element is not linked to its type and class "+expected) {
+			t.Errorf("Expected error for element %s; actual %s", expected, buf.String())
+		}
+	}
+
+	// The elements are skipped when the metrics are updated and checked, rather than causing a panic
+	updateMetrics(metrics)
+	if value := metrics[testKey1].values[qmgrLabelValue]; value != 1 {
+		t.Errorf("Expected value=%v; actual %v", 1, value)
+	}
+	if collisions := findKeyCollisions(&metricsConfig{}); len(collisions) != 0 {
+		t.Errorf("Expected no collisions; actual %v", collisions)
+	}
+	getPublicationState()
+}
+
+func TestUpdateMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	metrics, _ := initialiseMetrics(getTestLogger(), &metricsConfig{})
+	updateMetrics(metrics)
+
+	metric, _ := metrics[testKey1]
+	actual, ok := metric.values[qmgrLabelValue]
+
+	if !ok {
+		t.Error("No metric values found for queue manager label")
+	} else {
+		if actual != float64(1) {
+			t.Errorf("Expected metric value=%f; actual %f", float64(1), actual)
+		}
+		if len(metric.values) != 1 {
+			t.Errorf("Expected values-size=%d; actual %d", 1, len(metric.values))
+		}
+	}
+
+	if len(mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values) != 0 {
+		t.Error("Unexpected cached value; publication data should have been reset")
+	}
+	if metric.lastUpdate.IsZero() {
+		t.Error("Expected last update time to be set when publication data is received")
+	}
+	lastUpdate := metric.lastUpdate
+
+	updateMetrics(metrics)
+
+	if metric.values[qmgrLabelValue] != float64(1) {
+		t.Errorf("Expected metric value=%f to be retained; actual %f", float64(1), metric.values[qmgrLabelValue])
+	}
+	if metric.lastUpdate != lastUpdate {
+		t.Error("Expected last update time to be unchanged when no publication data is received")
+	}
+}
+
+func TestUpdateMetrics_NonFinite(t *testing.T) {
+
+	for _, zero := range []bool{false, true} {
+		teardownTestCase := setupTestCase(false)
+		metricElement := mqmetric.Metrics.Classes[0].Types[0].Elements[0]
+
+		metrics, _ := initialiseMetrics(getTestLogger(), &metricsConfig{nonFiniteZero: zero})
+		metric := metrics[testKey1]
+		metric.scale = unitScale{multiplier: math.MaxFloat64, divisor: 0.5}
+
+		nonFinite := updateMetrics(metrics)
+		if len(nonFinite) != 1 || nonFinite[0] != testKey1 {
+			t.Errorf("Expected non-finite keys=%v; actual %v", []string{testKey1}, nonFinite)
+		}
+		value, ok := metric.values[qmgrLabelValue]
+		if zero && (!ok || value != 0) {
+			t.Errorf("Expected metric value=%f; actual %f, %v", float64(0), value, ok)
+		}
+		if !zero && ok {
+			t.Errorf("Expected non-finite value to be skipped; actual %f", value)
+		}
+
+		// The metric is only returned the first time it has a value which is not finite
+		metricElement.Values[qmgrLabelValue] = 2
+		nonFinite = updateMetrics(metrics)
+		if len(nonFinite) != 0 {
+			t.Errorf("Expected non-finite keys=%v; actual %v", []string{}, nonFinite)
+		}
+		teardownTestCase()
+	}
+}
+
+func TestUpdateSelectedMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	metricElement := mqmetric.Metrics.Classes[0].Types[0].Elements[0]
+	metricElement.Datatype = ibmmq.MQIAMO_MONITOR_DELTA
+
+	metrics, _ := initialiseMetrics(getTestLogger(), &metricsConfig{})
+	updateSelectedMetrics(metrics, map[string]bool{"Other/Key": true})
+	if len(metrics[testKey1].values) != 0 {
+		t.Errorf("Expected metric not to be updated; actual values %v", metrics[testKey1].values)
+	}
+
+	updateSelectedMetrics(metrics, map[string]bool{testKey1: true})
+	if actual := metrics[testKey1].values[qmgrLabelValue]; actual != 1 {
+		t.Errorf("Expected metric value=%d; actual %f", 1, actual)
+	}
+
+	// The publication data is kept for the next full update, so that delta values are not lost
+	if len(metricElement.Values) != 1 {
+		t.Errorf("Expected publication data to be kept; actual %v", metricElement.Values)
+	}
+	updateMetrics(metrics)
+	if actual := metrics[testKey1].values[qmgrLabelValue]; actual != 1 {
+		t.Errorf("Expected metric value=%d after full update; actual %f", 1, actual)
+	}
+	if len(metricElement.Values) != 0 {
+		t.Errorf("Expected publication data to be reset by full update; actual %v", metricElement.Values)
+	}
+}
+
+func TestUpdateMetrics_PartialPublication(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// Add a second class, which publishes a delta metric
+	deltaClass := new(mqmetric.MonClass)
+	deltaType := new(mqmetric.MonType)
+	deltaElement := new(mqmetric.MonElement)
+	deltaClass.Name = "DISK"
+	deltaType.Name = "Log"
+	deltaType.ObjectTopic = "DeltaTopic"
+	deltaElement.Description = "Log write data"
+	deltaElement.Values = map[string]int64{qmgrLabelValue: 5}
+	deltaElement.Parent = deltaType
+	deltaType.Parent = deltaClass
+	deltaType.Elements = map[int]*mqmetric.MonElement{0: deltaElement}
+	deltaClass.Types = map[int]*mqmetric.MonType{0: deltaType}
+	mqmetric.Metrics.Classes[1] = deltaClass
+
+	gaugeMetric := &metricData{name: testElement1Name}
+	deltaMetric := &metricData{name: "log_written_bytes_total", isDelta: true}
+	metrics := map[string]*metricData{
+		testKey1:                    gaugeMetric,
+		"DeltaTopic/Log write data": deltaMetric,
+	}
+	updateMetrics(metrics)
+
+	// Simulate a cycle where only the second class publishes
+	deltaElement.Values[qmgrLabelValue] = 3
+	updateMetrics(metrics)
+
+	if gaugeMetric.values[qmgrLabelValue] != float64(1) {
+		t.Errorf("Expected metric value=%f to be retained; actual %f", float64(1), gaugeMetric.values[qmgrLabelValue])
+	}
+	if deltaMetric.values[qmgrLabelValue] != float64(3) {
+		t.Errorf("Expected delta metric value=%f; actual %f", float64(3), deltaMetric.values[qmgrLabelValue])
+	}
+
+	// Delta values must not be added to their counters again in a cycle with no publication
+	updateMetrics(metrics)
+
+	if len(deltaMetric.values) != 0 {
+		t.Errorf("Expected delta metric values to be cleared; actual %v", deltaMetric.values)
+	}
+	if gaugeMetric.values[qmgrLabelValue] != float64(1) {
+		t.Errorf("Expected metric value=%f to be retained; actual %f", float64(1), gaugeMetric.values[qmgrLabelValue])
+	}
+}
+
+func TestLimitLabelValues(t *testing.T) {
+
+	queueMetric := &metricData{name: "depth", objectType: true, values: map[string]float64{"APP.A": 1, "APP.B": 2, "APP.C": 3, "APP.D": 4}}
+	channelMetric := &metricData{
+		name:         "messages",
+		objectType:   true,
+		objectLabels: []string{channelLabel, connNameLabel},
+		values:       map[string]float64{"APP.SVRCONN|10.0.0.1": 5, "APP.SVRCONN|10.0.0.2": 6},
+	}
+	qmgrMetric := &metricData{name: testElement1Name, values: map[string]float64{qmgrLabelValue: 7}}
+	metrics := map[string]*metricData{"Queue/Depth": queueMetric, "Channel/Messages": channelMetric, testKey1: qmgrMetric}
+
+	limitLabelValues(getTestLogger(), metrics, 2)
+	expected := map[string]float64{"APP.A": 1, "APP.B": 2, otherLabelValue: 7}
+	if !reflect.DeepEqual(queueMetric.values, expected) || !queueMetric.limited {
+		t.Errorf("Expected values=%v, limited=%v; actual %v, %v", expected, true, queueMetric.values, queueMetric.limited)
+	}
+	if len(channelMetric.values) != 2 || channelMetric.limited {
+		t.Errorf("Expected channel values=%d, limited=%v; actual %v, %v", 2, false, channelMetric.values, channelMetric.limited)
+	}
+	if qmgrMetric.values[qmgrLabelValue] != 7 {
+		t.Errorf("Expected queue manager value=%v; actual %v", 7, qmgrMetric.values)
+	}
+
+	// Values already aggregated are unchanged, while new values are added to them
+	limitLabelValues(getTestLogger(), metrics, 2)
+	if !reflect.DeepEqual(queueMetric.values, expected) {
+		t.Errorf("Expected values=%v; actual %v", expected, queueMetric.values)
+	}
+	queueMetric.values["APP.E"] = 5
+	channelMetric.values["APP.SVRCONN|10.0.0.3"] = 8
+	limitLabelValues(getTestLogger(), metrics, 2)
+	expected[otherLabelValue] = 12
+	if !reflect.DeepEqual(queueMetric.values, expected) {
+		t.Errorf("Expected values=%v; actual %v", expected, queueMetric.values)
+	}
+	expectedChannel := map[string]float64{"APP.SVRCONN|10.0.0.1": 5, "APP.SVRCONN|10.0.0.2": 6, "other|other": 8}
+	if !reflect.DeepEqual(channelMetric.values, expectedChannel) {
+		t.Errorf("Expected channel values=%v; actual %v", expectedChannel, channelMetric.values)
+	}
+
+	// There is no limit by default
+	queueMetric.values["APP.F"] = 6
+	limitLabelValues(getTestLogger(), metrics, 0)
+	if len(queueMetric.values) != 4 {
+		t.Errorf("Expected values=%d; actual %v", 4, queueMetric.values)
+	}
+}
+
+func TestProcessMetrics_Cancel(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	ended := make(chan bool, 1)
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { ended <- true })
+	defer teardownTestConnection()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+
+	select {
+	case <-c.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive start signal from processMetrics")
+	}
+
+	// Cancel while waiting for a request - this should not wait for the request timeout
+	cancel()
+
+	select {
+	case <-c.done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("processMetrics did not stop after the context was cancelled")
+	}
+	select {
+	case <-ended:
+	default:
+		t.Error("Expected the connection to be ended when processMetrics stopped")
+	}
+}
+
+func TestProcessMetrics_Started(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{"FirstConnect", 0},
+		{"Reconnect", 1},
+		{"SeveralReconnects", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Publications fail the given number of times, so that the collector reconnects after starting
+			failures := test.failures
+			processed := make(chan bool)
+			teardownTestConnection := setupTestConnection(func() error {
+				if failures > 0 {
+					failures--
+					return fmt.Errorf("connection broken")
+				}
+				select {
+				case processed <- true:
+				default:
+				}
+				return nil
+			}, func() {})
+			defer teardownTestConnection()
+
+			cfg := getTestConfig()
+			cfg.requestTimeout = time.Millisecond
+			cfg.reconnectDelay = time.Millisecond
+			cfg.reconnectMax = time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+			c := newCollector("qmName", cfg, getTestLogger())
+			c.Start(ctx)
+			defer func() {
+				cancel()
+				<-c.done
+			}()
+
+			select {
+			case <-c.started:
+			case <-time.After(1 * time.Second):
+				t.Fatal("Did not receive start signal from processMetrics")
+			}
+			select {
+			case <-processed:
+			case <-time.After(1 * time.Second):
+				t.Fatal("Publications were not processed after reconnecting")
+			}
+		})
+	}
+}
+
+func TestProcessMetrics_Resubscribe(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// The connection breaks the first time publications are processed, and the queue manager publishes
+	// different metrics after restarting
+	var connects, ended int32
+	reconnected := make(chan bool, 1)
+	teardownTestConnection := setupTestConnection(func() error {
+		if atomic.LoadInt32(&connects) == 1 {
+			return fmt.Errorf("MQGET: MQCC = MQCC_FAILED [2] MQRC = MQRC_CONNECTION_BROKEN [2009]")
+		}
+		return nil
+	}, func() { atomic.AddInt32(&ended, 1) })
+	defer teardownTestConnection()
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		if atomic.AddInt32(&connects, 1) == 2 {
+			metricType := mqmetric.Metrics.Classes[0].Types[1]
+			metricType.Elements[1] = &mqmetric.MonElement{Parent: metricType, MetricName: "Restarted", Description: "Restarted element", Values: make(map[string]int64)}
+			delete(mqmetric.Metrics.Classes[0].Types[0].Elements, 0)
+			reconnected <- true
+		}
+		return newQMConnection(), nil
+	}
+
+	cfg := getTestConfig()
+	cfg.reconnectDelay = time.Millisecond
+	cfg.reconnectMax = time.Millisecond
+	cfg.queues = "APP.*"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	select {
+	case <-reconnected:
+	case <-time.After(1 * time.Second):
+		t.Fatal("processMetrics did not reconnect after the connection broke")
+	}
+	if atomic.LoadInt32(&ended) != 1 {
+		t.Errorf("Expected the broken connection to be ended before reconnecting; actual ended %d times", atomic.LoadInt32(&ended))
+	}
+
+	// The metrics map is rebuilt from the metrics discovered after reconnecting
+	c.requestChannel <- describeRequest
+	metrics := <-c.responseChannel
+	if _, ok := metrics[testTopic2+"/Restarted element"]; !ok {
+		t.Error("Expected the metric discovered after reconnecting to be in the metrics map")
+	}
+	if _, ok := metrics[testKey1]; ok {
+		t.Error("Expected the metric from before reconnecting not to be in the metrics map")
+	}
+	if atomic.LoadInt64(&c.reconnectCount) != 1 || atomic.LoadInt32(&c.status) != 1 {
+		t.Errorf("Expected reconnects=%d, status=%d; actual %d, %d", 1, 1, atomic.LoadInt64(&c.reconnectCount), atomic.LoadInt32(&c.status))
+	}
+}
+
+func TestProcessMetrics_ConnectTimeout(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	var connects, ended int32
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { atomic.AddInt32(&ended, 1) })
+	defer teardownTestConnection()
+
+	// The first attempt to connect hangs until released, and each attempt opens its own connection
+	release := make(chan struct{})
+	opened := []*qmConnection{newQMConnection(), newQMConnection()}
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		n := atomic.AddInt32(&connects, 1)
+		if n == 1 {
+			<-release
+		}
+		return opened[n-1], nil
+	}
+	var endedConn *qmConnection
+	endConnection = func(conn *qmConnection) {
+		endedConn = conn
+		atomic.AddInt32(&ended, 1)
+	}
+
+	cfg := getTestConfig()
+	cfg.connectTimeout = 20 * time.Millisecond
+	cfg.reconnectDelay = time.Millisecond
+	cfg.reconnectMax = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+
+	// Further attempts wait for the hung attempt to end, rather than connecting alongside it
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&c.reconnectCount) == 0 {
+		t.Error("Expected the connect to time out, and be retried")
+	}
+	if atomic.LoadInt32(&connects) != 1 || atomic.LoadInt32(&ended) != 0 {
+		t.Errorf("Expected connects=%d, ended=%d while the first attempt hangs; actual %d, %d", 1, 0, atomic.LoadInt32(&connects), atomic.LoadInt32(&ended))
+	}
+
+	// The connection opened by the hung attempt is closed when it completes, before connecting again
+	close(release)
+	select {
+	case <-c.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("processMetrics did not connect after the hung attempt ended")
+	}
+	if atomic.LoadInt32(&connects) != 2 || atomic.LoadInt32(&ended) != 1 {
+		t.Errorf("Expected connects=%d, ended=%d; actual %d, %d", 2, 1, atomic.LoadInt32(&connects), atomic.LoadInt32(&ended))
+	}
+	// Only that connection is closed, and the collector keeps the one it connected with
+	c.requestChannel <- describeRequest
+	<-c.responseChannel
+	if endedConn != opened[0] || c.conn != opened[1] {
+		t.Errorf("Expected the hung attempt's connection to be closed, and the next one kept")
+	}
+}
+
+func TestProcessMetrics_ConnectCancelled(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	ended := make(chan bool, 1)
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { ended <- true })
+	defer teardownTestConnection()
+
+	release := make(chan struct{})
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		<-release
+		return newQMConnection(), nil
+	}
+
+	// Stopping does not wait for an attempt to connect which hangs
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	cancel()
+	select {
+	case <-c.done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("processMetrics did not stop while connecting")
+	}
+	if c.err != nil {
+		t.Errorf("Unexpected error %s", c.err.Error())
+	}
+
+	close(release)
+	select {
+	case <-ended:
+	case <-time.After(1 * time.Second):
+		t.Error("Expected the connection to be closed when the attempt completed")
+	}
+}
+
+func TestProcessMetrics_AbandonedRequest(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	responseTimeout = 20 * time.Millisecond
+	defer func() { responseTimeout = 5 * time.Second }()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", getTestConfig(), log)
+	c.Start(ctx)
+	<-c.started
+
+	// The requester goes away without receiving the response
+	c.requestChannel <- collectRequest
+
+	// Later requests are still answered
+	select {
+	case c.requestChannel <- describeRequest:
+	case <-time.After(1 * time.Second):
+		t.Fatal("processMetrics did not receive a request after a response was abandoned")
+	}
+	if metrics := <-c.responseChannel; len(metrics) == 0 {
+		t.Error("Expected metrics in the response to a later request")
+	}
+
+	cancel()
+	<-c.done
+	if !strings.Contains(buf.String(), "Dropped the response to a request for metrics") {
+		t.Errorf("Expected log to contain a warning for the dropped response; actual %s", buf.String())
+	}
+	if c.lastCollectTime != 0 {
+		t.Errorf("Expected no successful collect to be recorded for the dropped response; actual %d", c.lastCollectTime)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, true, "test")
+	cfg := getTestConfig()
+	c := newCollector("QM1", cfg, cfg.logger(log))
+	c.eventLog("reconnect").Printf("Metrics: Reconnected to queue manager %s", c.qmName)
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("Expected a JSON log entry; actual %s", buf.String())
+	}
+	expected := map[string]interface{}{componentField: componentName, eventField: "reconnect", qmgrField: "QM1", "message": "Metrics: Reconnected to queue manager QM1"}
+	for name, value := range expected {
+		if entry[name] != value {
+			t.Errorf("Expected %s=%v; actual %v", name, value, entry[name])
+		}
+	}
+}
+
+func TestRecordCollect(t *testing.T) {
+
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	first := time.Unix(1000, 0)
+	c.recordCollect(first)
+	if c.lastCollectTime != first.UnixNano() || c.lastCollectInterval != 0 {
+		t.Errorf("Expected lastCollectTime=%d, lastCollectInterval=%d; actual %d, %d", first.UnixNano(), 0, c.lastCollectTime, c.lastCollectInterval)
+	}
+	c.recordCollect(first.Add(15 * time.Second))
+	if c.lastCollectTime != first.Add(15*time.Second).UnixNano() || c.lastCollectInterval != int64(15*time.Second) {
+		t.Errorf("Expected lastCollectTime=%d, lastCollectInterval=%d; actual %d, %d", first.Add(15*time.Second).UnixNano(), int64(15*time.Second), c.lastCollectTime, c.lastCollectInterval)
+	}
+}
+
+func TestHandleRequest_SelectedKeys(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	metrics, _ := initialiseMetrics(c.log, c.cfg)
+
+	// A request for selected metrics does not count as a collect, as only those metrics are updated
+	handled := make(chan error)
+	go func() {
+		handled <- c.handleRequest(metricsRequest{collect: true, keys: map[string]bool{testKey1: true}}, metrics)
+	}()
+	<-c.responseChannel
+	<-handled
+	if actual := atomic.LoadInt64(&c.lastCollectTime); actual != 0 {
+		t.Errorf("Expected lastCollectTime=%d after a request for selected metrics; actual %d", 0, actual)
+	}
+}
+
+func TestCheckPublishedMetrics(t *testing.T) {
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	c := newCollector("qmName", getTestConfig(), log)
+
+	cleanTestMetrics()
+	c.checkPublishedMetrics()
+	if actual := atomic.LoadInt64(&c.publishedMetrics); actual != 0 || !strings.Contains(buf.String(), "does not publish any metrics") {
+		t.Errorf("Expected published metrics=%d with a warning; actual %d, %s", 0, actual, buf.String())
+	}
+
+	buf.Reset()
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	c.checkPublishedMetrics()
+	if actual := atomic.LoadInt64(&c.publishedMetrics); actual != 2 || buf.Len() != 0 {
+		t.Errorf("Expected published metrics=%d without a warning; actual %d, %s", 2, actual, buf.String())
+	}
+}
+
+func TestCheckSubscriptions(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "QM1")
+	c := newCollector("QM1", getTestConfig(), log)
+
+	// Each subscription held by the queue manager is counted, including several to the same topic
+	c.conn.subscriptions = map[string]int{testTopic1: 1, "Topic/APP.IN": 2}
+	c.checkSubscriptions()
+	if actual := atomic.LoadInt64(&c.subscriptions); actual != 3 {
+		t.Errorf("Expected subscriptions=%d; actual %d", 3, actual)
+	}
+	expected := "Holding 3 subscriptions to published metrics for queue manager QM1"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log message containing '%s'; actual %s", expected, buf.String())
+	}
+
+	// The count is not known if the subscriptions could not be inquired
+	c.conn.subscriptions = nil
+	c.checkSubscriptions()
+	if actual := atomic.LoadInt64(&c.subscriptions); actual != -1 {
+		t.Errorf("Expected subscriptions=%d; actual %d", -1, actual)
+	}
+	expected = "Could not inquire the subscriptions to published metrics held for queue manager QM1"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected log message containing '%s'; actual %s", expected, buf.String())
+	}
+}
+
+func TestCheckMetricClasses(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "QM1")
+	c := newCollector("QM1", getTestConfig(), log)
+
+	// A class is gathered if each of its types has elements, and queue manager types are subscribed to
+	c.conn.subscriptions = map[string]int{testTopic1: 1}
+	c.checkMetricClasses()
+	if active, discovered := atomic.LoadInt64(&c.activeClasses), atomic.LoadInt64(&c.discoveredClasses); active != 1 || discovered != 1 || buf.Len() != 0 {
+		t.Errorf("Expected active classes=%d of %d without a warning; actual %d of %d, %s", 1, 1, active, discovered, buf.String())
+	}
+
+	// Other classes are still gathered if some cannot be discovered or subscribed to
+	mqmetric.Metrics.Classes[1] = &mqmetric.MonClass{Name: "STATMQI"}
+	mqmetric.Metrics.Classes[2] = &mqmetric.MonClass{Name: "DISK", Types: map[int]*mqmetric.MonType{0: {ObjectTopic: "$SYS/MQ/INFO/QMGR/QM1/Monitor/DISK/Log"}}}
+	mqmetric.Metrics.Classes[2].Types[0].Elements = map[int]*mqmetric.MonElement{0: {}}
+	c.conn.discoveryError = fmt.Errorf("Error subscribing to $SYS/MQ/INFO/QMGR/QM1/Monitor/DISK/Log")
+	c.checkMetricClasses()
+	if active, discovered := atomic.LoadInt64(&c.activeClasses), atomic.LoadInt64(&c.discoveredClasses); active != 1 || discovered != 3 {
+		t.Errorf("Expected active classes=%d of %d; actual %d of %d", 1, 3, active, discovered)
+	}
+	for _, expected := range []string{"Error subscribing to $SYS/MQ/INFO/QMGR/QM1/Monitor/DISK/Log", "Gathering metrics of 1 of 3 classes for queue manager QM1, as the classes DISK, STATMQI could not be discovered or subscribed to"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected log message containing '%s'; actual %s", expected, buf.String())
+		}
+	}
+	if atomic.LoadInt64(&c.lastErrorTime) == 0 {
+		t.Error("Expected the discovery error to be recorded")
+	}
+
+	// Only the elements are required if the subscriptions could not be inquired
+	c.conn.subscriptions = nil
+	c.checkMetricClasses()
+	if active := atomic.LoadInt64(&c.activeClasses); active != 2 {
+		t.Errorf("Expected active classes=%d; actual %d", 2, active)
+	}
+}
+
+func TestTimeProcessPublications(t *testing.T) {
+
+	teardownTestConnection := setupTestConnection(func() error {
+		time.Sleep(10 * time.Millisecond)
+		return fmt.Errorf("connection broken")
+	}, func() {})
+	defer teardownTestConnection()
+
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	for i := 0; i < 2; i++ {
+		err := c.timeProcessPublications()
+		if err == nil {
+			t.Error("Expected error from processing publications")
+		}
+	}
+	if c.processCount != 2 {
+		t.Errorf("Expected processCount=%d; actual %d", 2, c.processCount)
+	}
+	last, total := time.Duration(c.lastProcessDuration), time.Duration(c.processDuration)
+	if last < 10*time.Millisecond || total < last+10*time.Millisecond {
+		t.Errorf("Expected lastProcessDuration of at least %v, and processDuration to include both calls; actual %v, %v", 10*time.Millisecond, last, total)
+	}
+}
+
+func TestProcessPublicationsWithin(t *testing.T) {
+
+	release := make(chan struct{})
+	teardownTestConnection := setupTestConnection(func() error {
+		<-release
+		return nil
+	}, func() {})
+	defer teardownTestConnection()
+
+	cfg := getTestConfig()
+	cfg.maxProcessTime = 10 * time.Millisecond
+	c := newCollector("qmName", cfg, getTestLogger())
+	metrics := map[string]*metricData{testKey1: {name: testElement1Name}}
+	result := make(chan error)
+	go func() {
+		result <- c.processPublicationsWithin(metrics)
+	}()
+
+	// Once processing has taken longer than the cap, collect requests are answered without a metrics map, and other
+	// requests with the metrics as they are
+	response, ok := c.requestWithin(collectRequest, time.Second)
+	if !ok || response != nil {
+		t.Errorf("Expected a collect response without a metrics map; actual %v, %t", response, ok)
+	}
+	if ok {
+		c.unlockRequests()
+	}
+	response, ok = c.requestWithin(describeRequest, time.Second)
+	if !ok || len(response) != 1 {
+		t.Errorf("Expected a describe response with %d metrics; actual %v, %t", 1, response, ok)
+	}
+	if ok {
+		c.unlockRequests()
+	}
+	if actual := atomic.LoadInt64(&c.cappedCycles); actual != 1 {
+		t.Errorf("Expected cappedCycles=%d; actual %d", 1, actual)
+	}
+
+	// Processing still finishes, so that no publications are lost
+	close(release)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Unexpected error %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for processing to finish")
+	}
+	if c.processCount != 1 {
+		t.Errorf("Expected processCount=%d; actual %d", 1, c.processCount)
+	}
+
+	// Processing which finishes within the cap is not counted
+	err := c.processPublicationsWithin(metrics)
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+	if actual := atomic.LoadInt64(&c.cappedCycles); actual != 1 {
+		t.Errorf("Expected cappedCycles=%d; actual %d", 1, actual)
+	}
+}
+
+func TestProcessMetrics_Drain(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	tests := []struct {
+		name          string
+		drainTimeout  time.Duration
+		publications  int32
+		expectedCalls int32
+	}{
+		{"Disabled", 0, 2, 0},
+		{"UntilNoData", time.Hour, 2, 3},
+		{"Timeout", 250 * time.Millisecond, math.MaxInt32, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// After stopping is requested, each call to process publications receives new data, until the
+			// given number of publications have been received
+			var stopping, calls int32
+			started := make(chan bool, 1)
+			teardownTestConnection := setupTestConnection(func() error {
+				if atomic.LoadInt32(&stopping) == 0 {
+					select {
+					case started <- true:
+					default:
+					}
+					return nil
+				}
+				if atomic.AddInt32(&calls, 1) <= test.publications {
+					mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values[qmgrLabelValue]++
+				}
+				return nil
+			}, func() {})
+			defer teardownTestConnection()
+
+			cfg := getTestConfig()
+			cfg.drainTimeout = test.drainTimeout
+
+			ctx, cancel := context.WithCancel(context.Background())
+			c := newCollector("qmName", cfg, getTestLogger())
+			c.Start(ctx)
+			<-started
+			atomic.StoreInt32(&stopping, 1)
+			cancel()
+
+			select {
+			case <-c.done:
+			case <-time.After(1 * time.Second):
+				t.Fatal("processMetrics did not stop after the context was cancelled")
+			}
+			count := atomic.LoadInt32(&calls)
+			if test.expectedCalls >= 0 && count != test.expectedCalls {
+				t.Errorf("Expected calls=%d; actual %d", test.expectedCalls, count)
+			}
+			if test.expectedCalls < 0 && count < 2 {
+				t.Errorf("Expected publications to be processed until the timeout; actual calls %d", count)
+			}
+		})
+	}
+}
+
+func TestProcessMetrics_OnDemand(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var processed int32
+	teardownTestConnection := setupTestConnection(func() error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}, func() {})
+	defer teardownTestConnection()
+
+	cfg := getTestConfig()
+	cfg.onDemand = true
+	cfg.requestTimeout = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	// Publications are not processed while idle, or for describe requests
+	time.Sleep(50 * time.Millisecond)
+	c.requestChannel <- describeRequest
+	<-c.responseChannel
+	if count := atomic.LoadInt32(&processed); count != 0 {
+		t.Errorf("Expected no publications to be processed before a collect request; actual %d", count)
+	}
+
+	c.requestChannel <- collectRequest
+	metrics := <-c.responseChannel
+	if count := atomic.LoadInt32(&processed); count != 1 {
+		t.Errorf("Expected publications to be processed once for a collect request; actual %d", count)
+	}
+	if actual := metrics[testKey1].values[qmgrLabelValue]; actual != 1 {
+		t.Errorf("Expected metric value=%d; actual %f", 1, actual)
+	}
+}
+
+func TestProcessMetrics_RefreshRequest(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var processed int32
+	teardownTestConnection := setupTestConnection(func() error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}, func() {})
+	defer teardownTestConnection()
+
+	// The request timeout is long, so publications are only processed again before a request by a refresh
+	cfg := getTestConfig()
+	cfg.requestTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	c.requestChannel <- describeRequest
+	<-c.responseChannel
+	before := atomic.LoadInt32(&processed)
+	c.requestChannel <- refreshRequest
+	<-c.responseChannel
+	if count := atomic.LoadInt32(&processed); count <= before {
+		t.Errorf("Expected publications to be processed for a refresh request; actual %d times, %d before", count, before)
+	}
+}
+
+func TestNextProcessInterval(t *testing.T) {
+
+	cfg := getTestConfig()
+	cfg.requestTimeout = 10 * time.Second
+	cfg.maxIdle = 30 * time.Second
+	c := newCollector("qmName", cfg, getTestLogger())
+
+	// The interval doubles after each three cycles without publications, up to the maximum, and returns to the request
+	// timeout when publications arrive
+	steps := []struct {
+		received bool
+		expected time.Duration
+	}{
+		{false, 10 * time.Second},
+		{false, 10 * time.Second},
+		{false, 10 * time.Second},
+		{false, 20 * time.Second},
+		{false, 20 * time.Second},
+		{false, 20 * time.Second},
+		{false, 30 * time.Second},
+		{false, 30 * time.Second},
+		{false, 30 * time.Second},
+		{false, 30 * time.Second},
+		{true, 10 * time.Second},
+		{false, 10 * time.Second},
+	}
+	for i, step := range steps {
+		c.received = step.received
+		if actual := c.nextProcessInterval(); actual != step.expected {
+			t.Errorf("Expected interval=%v for cycle %d; actual %v", step.expected, i, actual)
+		}
+	}
+
+	// Without a maximum idle interval, the cadence does not change
+	c.cfg = getTestConfig()
+	c.cfg.requestTimeout = 10 * time.Second
+	for i := 0; i < 2*idleCyclesBeforeBackoff; i++ {
+		if actual := c.nextProcessInterval(); actual != 10*time.Second {
+			t.Errorf("Expected interval=%v for cycle %d; actual %v", 10*time.Second, i, actual)
+		}
+	}
+}
+
+func TestProcessMetrics_AdaptiveCadence(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var processed int32
+	teardownTestConnection := setupTestConnection(func() error {
+		atomic.AddInt32(&processed, 1)
+		return nil
+	}, func() {})
+	defer teardownTestConnection()
+
+	cfg := getTestConfig()
+	cfg.requestTimeout = 5 * time.Millisecond
+	cfg.maxIdle = 10 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	// No publications arrive, so the cadence backs off
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if time.Duration(atomic.LoadInt64(&c.processInterval)) >= 200*time.Millisecond {
+			break
+		}
+	}
+	if interval := time.Duration(atomic.LoadInt64(&c.processInterval)); interval < 200*time.Millisecond {
+		t.Fatalf("Expected the process interval to back off while idle; actual %v", interval)
+	}
+
+	// A collect request still processes the latest publications straight away
+	before := atomic.LoadInt32(&processed)
+	c.requestChannel <- collectRequest
+	<-c.responseChannel
+	if count := atomic.LoadInt32(&processed); count == before {
+		t.Error("Expected publications to be processed for a collect request while backed off")
+	}
+}
+
+func TestCollector_Independent(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collectors := []*Collector{
+		newCollector("qm1", getTestConfig(), getTestLogger()),
+		newCollector("qm2", getTestConfig(), getTestLogger()),
+	}
+	for _, c := range collectors {
+		c.Start(ctx)
+	}
+	defer func() {
+		cancel()
+		for _, c := range collectors {
+			<-c.done
+		}
+	}()
+
+	// Each collector answers the requests sent on its own channel
+	for _, c := range collectors {
+		select {
+		case <-c.started:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Did not receive start signal from collector for %s", c.qmName)
+		}
+		c.requestChannel <- describeRequest
+		if metrics := <-c.responseChannel; len(metrics) == 0 {
+			t.Errorf("Expected metrics from collector for %s", c.qmName)
+		}
+	}
+
+	collectors[0].recordError(fmt.Errorf("connection refused"))
+	if atomic.LoadInt64(&collectors[1].lastErrorTime) != 0 {
+		t.Error("Expected an error recorded by one collector not to affect another")
+	}
+}
+
+func TestCollector_SwitchQueueManager(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	ends := 0
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() { ends++ })
+	defer teardownTestConnection()
+	var connected []string
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		connected = append(connected, qmName)
+		if qmName == "QMBAD" {
+			return newQMConnection(), fmt.Errorf("connection refused")
+		}
+		return newQMConnection(), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qm1", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	select {
+	case <-c.started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive start signal from processMetrics")
+	}
 
+	err := c.SwitchQueueManager("qm2")
 	if err != nil {
-		t.Errorf("Unexpected error %s", err.Error())
+		t.Fatalf("Expected no error switching queue manager; actual %v", err)
 	}
-	if !ok {
-		t.Error("Expected metric not found in map")
-	} else {
-		if metric.name != testElement1Name {
-			t.Errorf("Expected name=%s; actual %s", testElement1Name, metric.name)
+	if len(connected) != 2 || connected[1] != "qm2" || ends != 1 {
+		t.Errorf("Expected connections=%v, ends=%d; actual %v, %d", []string{"qm1", "qm2"}, 1, connected, ends)
+	}
+	c.requestChannel <- describeRequest
+	if metrics := <-c.responseChannel; len(metrics) == 0 || c.qmName != "qm2" {
+		t.Errorf("Expected metrics for qm2; actual %d metrics for %s", len(metrics), c.qmName)
+	}
+
+	// A failed switch is reported, and connecting is retried as after any other error
+	err = c.SwitchQueueManager("QMBAD")
+	if err == nil {
+		t.Error("Expected an error switching to a queue manager which cannot be connected to")
+	}
+	c.requestChannel <- describeRequest
+	if metrics := <-c.responseChannel; len(metrics) != 0 {
+		t.Errorf("Expected no metrics while reconnecting; actual %d", len(metrics))
+	}
+
+	// Switching while waiting to reconnect connects straight away
+	err = c.SwitchQueueManager("qm1")
+	if err != nil || c.qmName != "qm1" {
+		t.Errorf("Expected no error switching back to qm1; actual %v for %s", err, c.qmName)
+	}
+}
+
+func TestCollector_SwitchQueueManager_Unlocked(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	// Connecting to the new queue manager hangs until released
+	connecting, release := make(chan struct{}), make(chan struct{})
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		if qmName == "qm2" {
+			close(connecting)
+			<-release
 		}
-		if metric.description != testElement1Description {
-			t.Errorf("Expected description=%s; actual %s", testElement1Description, metric.description)
+		return newQMConnection(), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qm1", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.SwitchQueueManager("qm2")
+	}()
+	<-connecting
+
+	// The request lock is not held while connecting, so other requests are not held up by the switch
+	select {
+	case c.requestLock <- struct{}{}:
+		c.unlockRequests()
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request lock not to be held while switching")
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("Expected no error switching queue manager; actual %v", err)
+	}
+}
+
+func TestCollector_SwitchQueueManager_KeepsCounters(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+
+	for _, qmAlias := range []string{"", "QMALIAS"} {
+		cfg := getTestConfig()
+		cfg.qmLabelValue = qmAlias
+		ctx, cancel := context.WithCancel(context.Background())
+		c := newCollector("qm1", cfg, getTestLogger())
+		c.Start(ctx)
+		<-c.started
+		c.firstCollect = false
+		counterVec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total"}, []string{qmgrLabel})
+		counterVec.WithLabelValues("qm1").Add(5)
+		c.counterMap["test"] = counterVec
+
+		if err := c.SwitchQueueManager("qm2"); err != nil {
+			t.Errorf("Expected no error switching queue manager; actual %v", err)
 		}
-		if metric.objectType != false {
-			t.Errorf("Expected objectType=%v; actual %v", false, metric.objectType)
+		// The counters of the previous queue manager carry on, unless they cannot be told apart by the qmgr label
+		prometheusMetric := dto.Metric{}
+		counterVec.WithLabelValues("qm1").Write(&prometheusMetric)
+		expected := float64(5)
+		if qmAlias != "" {
+			expected = 0
 		}
-		if len(metric.values) != 0 {
-			t.Errorf("Expected values-size=%d; actual %d", 0, len(metric.values))
+		if actual := prometheusMetric.GetCounter().GetValue(); actual != expected {
+			t.Errorf("Expected counter=%v for qmgr label value %q; actual %v", expected, qmAlias, actual)
+		}
+		if c.firstCollect != (qmAlias != "") {
+			t.Errorf("Expected firstCollect=%t for qmgr label value %q; actual %t", qmAlias != "", qmAlias, c.firstCollect)
 		}
+		cancel()
+		<-c.done
 	}
-	_, ok = metrics[testKey2]
-	if ok {
-		t.Errorf("Unexpected metric found in map, %%s object topics should be ignored")
+}
+
+func TestProcessMetrics_LastKnown(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	// The connection breaks the second time publications are processed, after the first collect, and reconnecting
+	// hangs until released
+	var processes, connects int32
+	teardownTestConnection := setupTestConnection(func() error {
+		if atomic.AddInt32(&processes, 1) == 2 {
+			return fmt.Errorf("MQGET: MQCC = MQCC_FAILED [2] MQRC = MQRC_CONNECTION_BROKEN [2009]")
+		}
+		return nil
+	}, func() {})
+	defer teardownTestConnection()
+	release := make(chan struct{})
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		if atomic.AddInt32(&connects, 1) == 2 {
+			<-release
+		}
+		return newQMConnection(), nil
 	}
 
-	if len(metrics) != 1 {
-		t.Errorf("Map contains unexpected metrics, map size=%d", len(metrics))
+	cfg := getTestConfig()
+	cfg.reconnectDelay = time.Millisecond
+	cfg.reconnectMax = time.Millisecond
+	cfg.lastKnown = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.Start(ctx)
+	defer func() {
+		cancel()
+		<-c.done
+	}()
+	<-c.started
+
+	c.requestChannel <- collectRequest
+	if metrics := <-c.responseChannel; metrics[testKey1].values[qmgrLabelValue] != 1 {
+		t.Fatalf("Expected value=%d; actual %v", 1, metrics[testKey1].values)
+	}
+	for i := 0; atomic.LoadInt32(&connects) < 2; i++ {
+		if i == 100 {
+			t.Fatal("processMetrics did not reconnect after the connection broke")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Requests made while reconnecting are answered straight away with the last-known metrics
+	c.requestChannel <- collectRequest
+	select {
+	case metrics := <-c.responseChannel:
+		if metric, ok := metrics[testKey1]; !ok || metric.values[qmgrLabelValue] != 1 {
+			t.Errorf("Expected the last-known metrics while reconnecting; actual %v", metrics)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive response while reconnecting")
+	}
+	if atomic.LoadInt32(&c.status) != 0 || atomic.LoadInt64(&c.servingLastKnown) != 1 {
+		t.Errorf("Expected status=%d, servingLastKnown=%d; actual %d, %d", 0, 1, atomic.LoadInt32(&c.status), atomic.LoadInt64(&c.servingLastKnown))
+	}
+
+	// The metrics discovered after reconnecting replace the last-known metrics
+	close(release)
+	for i := 0; atomic.LoadInt32(&c.status) == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.requestChannel <- describeRequest
+	<-c.responseChannel
+	if atomic.LoadInt32(&c.status) != 1 || atomic.LoadInt64(&c.servingLastKnown) != 0 {
+		t.Errorf("Expected status=%d, servingLastKnown=%d; actual %d, %d", 1, 0, atomic.LoadInt32(&c.status), atomic.LoadInt64(&c.servingLastKnown))
 	}
 }
 
-func TestInitialiseMetrics_UnexpectedKey(t *testing.T) {
+func TestProcessMetrics_Disconnected(t *testing.T) {
 
 	teardownTestCase := setupTestCase(false)
 	defer teardownTestCase()
 
-	mqmetric.Metrics.Classes[0].Types[0].Elements[0].Description = "New Metric"
-	_, err := initialiseMetrics(getTestLogger())
+	teardownTestConnection := setupTestConnection(func() error { return fmt.Errorf("connection broken") }, func() {})
+	defer teardownTestConnection()
 
-	if err == nil {
-		t.Error("Expected skipping metric error")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newCollector("qmName", getTestConfig(), getTestLogger())
+	c.Start(ctx)
+	<-c.started
+
+	// Requests are still answered while waiting to reconnect
+	c.requestChannel <- collectRequest
+	select {
+	case metrics := <-c.responseChannel:
+		if len(metrics) != 0 {
+			t.Errorf("Expected no metrics while disconnected; actual %d", len(metrics))
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Did not receive response while disconnected")
+	}
+	if status := atomic.LoadInt32(&c.status); status != 0 {
+		t.Errorf("Expected queue manager status=%d; actual %d", 0, status)
+	}
+	if count := atomic.LoadInt64(&c.reconnectCount); count < 1 {
+		t.Errorf("Expected reconnects to be at least %d; actual %d", 1, count)
+	}
+	if atomic.LoadInt64(&c.lastErrorTime) == 0 {
+		t.Error("Expected the time of the last error to be recorded")
 	}
 }
 
-func TestInitialiseMetrics_DuplicateKeys(t *testing.T) {
+func TestProcessMetrics_MaxConnects(t *testing.T) {
 
-	teardownTestCase := setupTestCase(true)
-	defer teardownTestCase()
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	connects := 0
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		connects++
+		return newQMConnection(), fmt.Errorf("unknown queue manager")
+	}
 
-	_, err := initialiseMetrics(getTestLogger())
+	cfg := getTestConfig()
+	cfg.reconnectDelay = time.Millisecond
+	cfg.reconnectMax = time.Millisecond
+	cfg.maxConnects = 3
 
-	if err == nil {
-		t.Error("Expected duplicate keys error")
+	done := make(chan error)
+	go func() {
+		done <- newCollector("qmName", cfg, getTestLogger()).processMetrics(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected error after the maximum number of connection attempts")
+		}
+		if connects != 3 {
+			t.Errorf("Expected connection attempts=%d; actual %d", 3, connects)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Metrics processing did not stop after the maximum number of connection attempts")
 	}
 }
 
-func TestUpdateMetrics(t *testing.T) {
+func TestProcessMetrics_NotRecoverable(t *testing.T) {
+
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	connects := 0
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) {
+		connects++
+		return newQMConnection(), fmt.Errorf("Failed to connect to queue manager %s: MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_NOT_AUTHORIZED [2035]", qmName)
+	}
+
+	cfg := getTestConfig()
+	cfg.maxConnects = 3
+
+	done := make(chan error)
+	go func() {
+		done <- newCollector("qmName", cfg, getTestLogger()).processMetrics(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "category=authorization") {
+			t.Errorf("Expected authorization error; actual %v", err)
+		}
+		if connects != 1 {
+			t.Errorf("Expected connection attempts=%d; actual %d", 1, connects)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Metrics processing did not stop after an authorization error")
+	}
+}
+
+func TestGetUnitScale(t *testing.T) {
+
+	datatypes := []int32{ibmmq.MQIAMO_MONITOR_UNIT, ibmmq.MQIAMO_MONITOR_DELTA, ibmmq.MQIAMO_MONITOR_HUNDREDTHS, ibmmq.MQIAMO_MONITOR_KB,
+		ibmmq.MQIAMO_MONITOR_PERCENT, ibmmq.MQIAMO_MONITOR_MICROSEC, ibmmq.MQIAMO_MONITOR_MB, ibmmq.MQIAMO_MONITOR_GB}
+	for _, datatype := range datatypes {
+		scale := getUnitScale(datatype)
+		for _, value := range []int64{-5, 0, 7, 1500000, 123456789} {
+			expected := mqmetric.Normalise(&mqmetric.MonElement{Datatype: datatype}, qmgrLabelValue, value)
+			if actual := scale.normalise(value); actual != expected {
+				t.Errorf("Expected value=%v for datatype %d and value %d; actual %v", expected, datatype, value, actual)
+			}
+		}
+	}
+}
+
+func TestGetUnit(t *testing.T) {
+
+	units := map[int32]string{
+		ibmmq.MQIAMO_MONITOR_PERCENT:  "percentage",
+		ibmmq.MQIAMO_MONITOR_MB:       "bytes",
+		ibmmq.MQIAMO_MONITOR_MICROSEC: "seconds",
+		ibmmq.MQIAMO_MONITOR_DELTA:    "",
+	}
+	for datatype, expected := range units {
+		if actual := getUnit(datatype); actual != expected {
+			t.Errorf("Expected unit=%s for datatype %d; actual %s", expected, datatype, actual)
+		}
+	}
+}
+
+func TestGetRawUnit(t *testing.T) {
+
+	units := map[int32]string{
+		ibmmq.MQIAMO_MONITOR_PERCENT:  "hundredths",
+		ibmmq.MQIAMO_MONITOR_GB:       "gigabytes",
+		ibmmq.MQIAMO_MONITOR_MICROSEC: "microseconds",
+		ibmmq.MQIAMO_MONITOR_DELTA:    "",
+	}
+	for datatype, expected := range units {
+		if actual := getRawUnit(datatype); actual != expected {
+			t.Errorf("Expected unit=%s for datatype %d; actual %s", expected, datatype, actual)
+		}
+	}
+}
+
+func TestUpdateMetrics_RawUnits(t *testing.T) {
 
 	teardownTestCase := setupTestCase(false)
 	defer teardownTestCase()
+	mqmetric.Metrics.Classes[0].Types[0].Elements[0].Datatype = ibmmq.MQIAMO_MONITOR_MICROSEC
+	mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values[qmgrLabelValue] = 1500000
 
-	metrics, _ := initialiseMetrics(getTestLogger())
+	metrics, _ := initialiseMetrics(getTestLogger(), &metricsConfig{rawUnits: true})
 	updateMetrics(metrics)
 
-	metric, _ := metrics[testKey1]
-	actual, ok := metric.values[qmgrLabelValue]
+	metric := metrics[testKey1]
+	if metric.unit != "microseconds" {
+		t.Errorf("Expected unit=%s; actual %s", "microseconds", metric.unit)
+	}
+	if actual := metric.values[qmgrLabelValue]; actual != 1500000 {
+		t.Errorf("Expected metric value=%d; actual %f", 1500000, actual)
+	}
 
-	if !ok {
-		t.Error("No metric values found for queue manager label")
-	} else {
-		if actual != float64(1) {
-			t.Errorf("Expected metric value=%f; actual %f", float64(1), actual)
+	mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values[qmgrLabelValue] = 1500000
+	metrics, _ = initialiseMetrics(getTestLogger(), &metricsConfig{})
+	updateMetrics(metrics)
+
+	metric = metrics[testKey1]
+	if metric.unit != "seconds" {
+		t.Errorf("Expected unit=%s; actual %s", "seconds", metric.unit)
+	}
+	if actual := metric.values[qmgrLabelValue]; actual != 1.5 {
+		t.Errorf("Expected metric value=%f; actual %f", 1.5, actual)
+	}
+}
+
+func TestDoConnect(t *testing.T) {
+
+	var connected, mqserver, queues, modelQueue string
+	teardownTestConnect := setupTestConnect(func(qmName, replyQ, statsQ string, cc *mqmetric.ConnectionConfig) error {
+		connected, mqserver, modelQueue = qmName, os.Getenv("MQSERVER"), replyQ
+		return nil
+	}, func(queueList string, checkQueueList bool, metaPrefix string) error {
+		queues = queueList
+		return nil
+	})
+	defer teardownTestConnect()
+	defer setEnv("MQSERVER", "")()
+
+	cfg := &metricsConfig{clientMode: true, connName: "mq.example.com(1414)", channel: "APP.SVRCONN", queues: "APP.*", modelQueue: "METRICS.MODEL"}
+	conn, err := doConnect("QM1", cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if connected != "QM1" || mqserver != "APP.SVRCONN/TCP/mq.example.com(1414)" || queues != "APP.*" {
+		t.Errorf("Expected queue manager=%s, MQSERVER=%s, queues=%s; actual %s, %s, %s", "QM1", "APP.SVRCONN/TCP/mq.example.com(1414)", "APP.*", connected, mqserver, queues)
+	}
+	if modelQueue != "METRICS.MODEL" {
+		t.Errorf("Expected reply queue to be created from model queue %s; actual %s", "METRICS.MODEL", modelQueue)
+	}
+	if conn.commandLevel != testCommandLevel || conn.mqVersion != testMQVersion || !conn.startTime.Equal(testStartTime) {
+		t.Errorf("Expected command level=%d, version=%s, start time=%v; actual %d, %s, %v", testCommandLevel, testMQVersion, testStartTime, conn.commandLevel, conn.mqVersion, conn.startTime)
+	}
+	if value, ok := os.LookupEnv("MQSERVER"); ok {
+		t.Errorf("Expected MQSERVER to be unset after connecting; actual %s", value)
+	}
+
+	// Missing authorities are reported without connecting
+	connected = ""
+	checkAuthorities = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error {
+		return &authorityError{qmName: qmName, missing: []authority{{"queue", cfg.modelQueue, "+get"}}}
+	}
+	_, err = doConnect("QM1", cfg)
+	if category, _ := classifyError(err); err == nil || category != categoryAuthorization || connected != "" {
+		t.Errorf("Expected authorization error before connecting; actual %v, connected to %s", err, connected)
+	}
+	checkAuthorities = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error { return nil }
+
+	// A model queue which would leave reply queues behind is a configuration error, reported before connecting
+	checkModelQueue = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error {
+		return &modelQueueError{modelQueue: cfg.modelQueue, definitionType: ibmmq.MQQDT_PERMANENT_DYNAMIC}
+	}
+	_, err = doConnect("QM1", cfg)
+	if category, _ := classifyError(err); err == nil || category != categoryConfiguration || connected != "" {
+		t.Errorf("Expected configuration error before connecting; actual %v, connected to %s", err, connected)
+	}
+	checkModelQueue = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error { return nil }
+
+	// The reason code of a failed connection can still be classified
+	initConnection = func(qmName, replyQ, statsQ string, cc *mqmetric.ConnectionConfig) error {
+		return fmt.Errorf("MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_Q_MGR_NAME_ERROR [2058]")
+	}
+	_, err = doConnect("QM1", cfg)
+	if category, _ := classifyError(err); err == nil || category != categoryConfiguration {
+		t.Errorf("Expected configuration error; actual %v", err)
+	}
+
+	initConnection = func(qmName, replyQ, statsQ string, cc *mqmetric.ConnectionConfig) error { return nil }
+	discoverMetrics = func(queueList string, checkQueueList bool, metaPrefix string) error {
+		cleanTestMetrics()
+		return fmt.Errorf("No queues matching 'APP.*' exist")
+	}
+	conn, err = doConnect("QM1", cfg)
+	if err == nil || !strings.Contains(err.Error(), "Failed to discover and subscribe to metrics") || conn.discoveryError != nil {
+		t.Errorf("Expected discovery error; actual %v, %v", err, conn.discoveryError)
+	}
+
+	// The connection succeeds if the metrics of some classes can still be gathered, as the queue manager holds
+	// subscriptions to them
+	discoverMetrics = func(queueList string, checkQueueList bool, metaPrefix string) error {
+		populateTestMetrics(1, false)
+		mqmetric.Metrics.Classes[1] = &mqmetric.MonClass{Name: "DISK"}
+		return fmt.Errorf("Error subscribing to DISK")
+	}
+	defer cleanTestMetrics()
+	inquireHeldSubscriptions = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (map[string]int, error) {
+		return map[string]int{testTopic1: 1}, nil
+	}
+	conn, err = doConnect("QM1", cfg)
+	if err != nil || conn.discoveryError == nil || countHeldSubscriptions(conn.subscriptions) != 1 {
+		t.Errorf("Expected connection with a discovery error and 1 subscription; actual %v, %v, %v", err, conn.discoveryError, conn.subscriptions)
+	}
+
+	// The connection fails if the queue manager holds no subscriptions to any of the classes
+	inquireHeldSubscriptions = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (map[string]int, error) {
+		return map[string]int{}, nil
+	}
+	_, err = doConnect("QM1", cfg)
+	if err == nil || !strings.Contains(err.Error(), "Failed to discover and subscribe to metrics") {
+		t.Errorf("Expected discovery error; actual %v", err)
+	}
+}
+
+func TestDoConnect_TLS(t *testing.T) {
+
+	var table string
+	var tableErr error
+	teardownTestConnect := setupTestConnect(func(qmName, replyQ, statsQ string, cc *mqmetric.ConnectionConfig) error {
+		table = strings.TrimPrefix(os.Getenv("MQCCDTURL"), "file://")
+		_, tableErr = os.Stat(table)
+		return nil
+	}, func(queueList string, checkQueueList bool, metaPrefix string) error { return nil })
+	defer teardownTestConnect()
+
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyRepository := filepath.Join(dir, "key")
+	err = ioutil.WriteFile(keyRepository+".kdb", []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &metricsConfig{clientMode: true, connName: "mq.example.com(1414)", channel: "APP.SVRCONN", cipher: "ANY_TLS12", keyRepository: keyRepository}
+	_, err = doConnect("QM1", cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if table == "" || tableErr != nil {
+		t.Errorf("Expected client channel table to exist while connecting; actual %s, %v", table, tableErr)
+	}
+	if _, err = os.Stat(table); !os.IsNotExist(err) {
+		t.Errorf("Expected client channel table %s to be removed after connecting", table)
+	}
+}
+
+func TestTimeProcessPublications_Dropped(t *testing.T) {
+
+	teardownTestConnection := setupTestConnection(func() error { return nil }, func() {})
+	defer teardownTestConnection()
+	defer func() { inquireReplyQueue = doInquireReplyQueue }()
+
+	// The reply queue is full before the publications are processed
+	depth := int64(5000)
+	inquireReplyQueue = func(replyQInquiry *replyQueueInquiry) (int64, int64, error) {
+		return depth, 5000, nil
+	}
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	c := newCollector("qmName", getTestConfig(), log)
+	for i := 0; i < 3; i++ {
+		if err := c.timeProcessPublications(); err != nil {
+			t.Errorf("Unexpected error %v", err)
 		}
-		if len(metric.values) != 1 {
-			t.Errorf("Expected values-size=%d; actual %d", 1, len(metric.values))
+	}
+	if c.droppedPublications != 3 {
+		t.Errorf("Expected dropped publications=%d; actual %d", 3, c.droppedPublications)
+	}
+	if count := strings.Count(buf.String(), "was full"); count != 1 {
+		t.Errorf("Expected one warning within the warning interval; actual %d in %s", count, buf.String())
+	}
+
+	// The next warning gives the number of times since the last one
+	dropWarningInterval = 0
+	defer func() { dropWarningInterval = time.Minute }()
+	c.timeProcessPublications()
+	if !strings.Contains(buf.String(), "was full, with 5000 messages, 3 times since the last warning") {
+		t.Errorf("Expected warning with the number of times since the last warning; actual %s", buf.String())
+	}
+
+	// Nothing is counted while the queue is not full, or its depths are not known
+	depth = 4999
+	c.timeProcessPublications()
+	inquireReplyQueue = func(replyQInquiry *replyQueueInquiry) (int64, int64, error) {
+		return 0, 0, fmt.Errorf("Not open")
+	}
+	c.timeProcessPublications()
+	if c.droppedPublications != 4 {
+		t.Errorf("Expected dropped publications=%d; actual %d", 4, c.droppedPublications)
+	}
+}
+
+func TestLogError(t *testing.T) {
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	c := newCollector("qmName", getTestConfig(), log)
+
+	connErr := fmt.Errorf("Cannot access queue manager. Error: MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_Q_MGR_NOT_AVAILABLE [2059]")
+	category, reason := classifyError(connErr)
+	for i, expected := range []bool{true, false, false} {
+		if logged := c.logError(connErr, category, reason); logged != expected {
+			t.Errorf("Expected logged=%t for occurrence %d; actual %t", expected, i+1, logged)
 		}
 	}
+	if count := strings.Count(buf.String(), "Metrics Error ["); count != 1 {
+		t.Errorf("Expected a repeated error to be logged once within the summary interval; actual %d in %s", count, buf.String())
+	}
 
-	if len(mqmetric.Metrics.Classes[0].Types[0].Elements[0].Values) != 0 {
-		t.Error("Unexpected cached value; publication data should have been reset")
+	// A different error is logged straight away, after the number of times the previous one was not logged
+	authErr := fmt.Errorf("Cannot access queue manager. Error: MQCONNX: MQCC = MQCC_FAILED [2] MQRC = MQRC_NOT_AUTHORIZED [2035]")
+	category, reason = classifyError(authErr)
+	if !c.logError(authErr, category, reason) {
+		t.Errorf("Expected a changed error to be logged")
+	}
+	if !strings.Contains(buf.String(), "The previous error occurred 2 more times before it changed") {
+		t.Errorf("Expected the number of times the previous error occurred; actual %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Metrics Error [category=authorization reason=2035]") {
+		t.Errorf("Expected the changed error to be logged in full; actual %s", buf.String())
 	}
 
-	updateMetrics(metrics)
+	// A summary is logged once the summary interval has passed
+	errorSummaryInterval = 0
+	defer func() { errorSummaryInterval = 5 * time.Minute }()
+	c.logError(authErr, category, reason)
+	if !strings.Contains(buf.String(), "The same error occurred 1 times in the last") {
+		t.Errorf("Expected a summary of the repeated error; actual %s", buf.String())
+	}
+
+	c.clearError()
+	if !strings.Contains(buf.String(), "The error has cleared, after occurring 2 times") {
+		t.Errorf("Expected the error to be logged as cleared; actual %s", buf.String())
+	}
+	buf.Reset()
+	c.clearError()
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be logged when there is no error to clear; actual %s", buf.String())
+	}
+	if !c.logError(authErr, category, reason) {
+		t.Errorf("Expected the error to be logged in full again after it cleared")
+	}
+}
+
+func TestUpdatePCFMetrics(t *testing.T) {
+
+	inquireChannels = func(pcfConns *pcfPool, cfg *metricsConfig) ([]channelStatus, error) {
+		time.Sleep(50 * time.Millisecond)
+		return nil, fmt.Errorf("Failed")
+	}
+	inquireQueues = func(pcfConns *pcfPool, cfg *metricsConfig) ([]queueStatus, error) {
+		time.Sleep(50 * time.Millisecond)
+		return []queueStatus{{name: "APP.IN", depth: 5}}, nil
+	}
+	defer func() {
+		inquireChannels = doInquireChannels
+		inquireQueues = doInquireQueues
+	}()
 
-	if len(metric.values) != 0 {
-		t.Errorf("Unexpected metric value; data should have been cleared")
+	cfg := getTestConfig()
+	cfg.channels, cfg.depthQueues = "APP.*", "APP.*"
+	metrics := make(map[string]*metricData)
+	initialiseChannelMetrics(metrics, cfg)
+	initialiseQueueMetrics(metrics, cfg)
+	c := newCollector("qmName", cfg, getTestLogger())
+	c.updatePCFMetrics(metrics)
+
+	// The queues are still updated when the channels cannot be inquired
+	if actual := metrics[queueKeyPrefix+"Current depth"].values["APP.IN"]; actual != 5 {
+		t.Errorf("Expected current depth=%d; actual %f", 5, actual)
+	}
+	if c.lastErrorTime == 0 {
+		t.Errorf("Expected the failure to inquire the channels to be recorded")
+	}
+	if duration := time.Duration(c.lastPCFDuration); duration < 50*time.Millisecond {
+		t.Errorf("Expected PCF duration of at least %v; actual %v", 50*time.Millisecond, duration)
 	}
 }
 
@@ -141,6 +1967,18 @@ func TestMakeKey(t *testing.T) {
 	}
 }
 
+func TestMakeMappingKey(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	expected := testMappingKey1
+	actual := makeMappingKey(mqmetric.Metrics.Classes[0].Types[0].Elements[0])
+	if actual != expected {
+		t.Errorf("Expected value=%s; actual %s", expected, actual)
+	}
+}
+
 func setupTestCase(duplicateKey bool) func() {
 	populateTestMetrics(1, duplicateKey)
 	return func() {
@@ -148,6 +1986,46 @@ func setupTestCase(duplicateKey bool) func() {
 	}
 }
 
+// BenchmarkUpdateMetrics updates a wide set of queue metrics, with values for many queues
+func BenchmarkUpdateMetrics(b *testing.B) {
+
+	const elements, queues = 40, 2500
+	datatypes := []int32{ibmmq.MQIAMO_MONITOR_UNIT, ibmmq.MQIAMO_MONITOR_DELTA, ibmmq.MQIAMO_MONITOR_PERCENT, ibmmq.MQIAMO_MONITOR_MICROSEC, ibmmq.MQIAMO_MONITOR_MB}
+
+	metricClass := &mqmetric.MonClass{Name: queueClassName}
+	metricType := &mqmetric.MonType{Name: "GET", ObjectTopic: "Topic/%s/GET", Parent: metricClass, Elements: make(map[int]*mqmetric.MonElement)}
+	metricClass.Types = map[int]*mqmetric.MonType{0: metricType}
+	values := make(map[int]map[string]int64, elements)
+	for i := 0; i < elements; i++ {
+		values[i] = make(map[string]int64, queues)
+		for q := 0; q < queues; q++ {
+			values[i][fmt.Sprintf("APP.QUEUE.%d", q)] = int64(i*q + 1)
+		}
+		metricType.Elements[i] = &mqmetric.MonElement{
+			Parent:      metricType,
+			MetricName:  fmt.Sprintf("element_%d", i),
+			Description: fmt.Sprintf("Element %d", i),
+			Datatype:    datatypes[i%len(datatypes)],
+		}
+	}
+	mqmetric.Metrics.Classes = map[int]*mqmetric.MonClass{0: metricClass}
+	defer cleanTestMetrics()
+
+	metrics, err := initialiseMetrics(getTestLogger(), &metricsConfig{queues: "APP.*"})
+	if err != nil {
+		b.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		// Publication data is reset by each update, so is replaced for the next one
+		for i, metricElement := range metricType.Elements {
+			metricElement.Values = values[i]
+		}
+		updateMetrics(metrics)
+	}
+}
+
 func populateTestMetrics(testValue int, duplicateKey bool) {
 
 	metricClass := new(mqmetric.MonClass)
@@ -166,8 +2044,8 @@ func populateTestMetrics(testValue int, duplicateKey bool) {
 	metricElement2.MetricName = "Element2Name"
 	metricElement2.Description = testElement2Description
 	metricElement2.Values = make(map[string]int64)
-	metricType1.ObjectTopic = "ObjectTopic"
-	metricType2.ObjectTopic = "%s"
+	metricType1.ObjectTopic = testTopic1
+	metricType2.ObjectTopic = testTopic2
 	metricElement1.Parent = metricType1
 	metricElement2.Parent = metricType2
 	metricType1.Parent = metricClass
@@ -187,10 +2065,63 @@ func populateTestMetrics(testValue int, duplicateKey bool) {
 	mqmetric.Metrics.Classes[0] = metricClass
 }
 
+// setupTestConnection replaces the functions used to access the queue manager
+func setupTestConnection(processFunc func() error, endFunc func()) func() {
+	connectQueueManager = func(qmName string, cfg *metricsConfig) (*qmConnection, error) { return newQMConnection(), nil }
+	processPublications = processFunc
+	endConnection = func(conn *qmConnection) { endFunc() }
+	inquireRole = func(qmName string) (int32, error) { return haRoleActive, nil }
+	return func() {
+		connectQueueManager = doConnect
+		processPublications = mqmetric.ProcessPublications
+		endConnection = doEndConnection
+		inquireRole = doInquireRole
+	}
+}
+
+// setupTestConnect replaces the calls to mqmetric made by doConnect
+func setupTestConnect(initFunc func(string, string, string, *mqmetric.ConnectionConfig) error, discoverFunc func(string, bool, string) error) func() {
+	initConnection = initFunc
+	discoverMetrics = discoverFunc
+	inquireVersion = func(qmName string, connConfig *mqmetric.ConnectionConfig) (int32, string, error) {
+		return testCommandLevel, testMQVersion, nil
+	}
+	checkAuthorities = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error { return nil }
+	checkModelQueue = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) error { return nil }
+	openCommandQueue = func(qmName string, connConfig *mqmetric.ConnectionConfig) (*commandQueueInquiry, error) {
+		return nil, fmt.Errorf("Not authorized")
+	}
+	inquireStartTime = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (time.Time, error) {
+		return testStartTime, nil
+	}
+	openReplyQueue = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (*replyQueueInquiry, error) {
+		return nil, fmt.Errorf("Not found")
+	}
+	inquireHeldSubscriptions = func(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (map[string]int, error) {
+		return nil, fmt.Errorf("Not authorized")
+	}
+	return func() {
+		initConnection = mqmetric.InitConnectionStats
+		discoverMetrics = mqmetric.DiscoverAndSubscribe
+		checkAuthorities = doCheckAuthorities
+		checkModelQueue = doCheckModelQueue
+		openCommandQueue = doOpenCommandQueue
+		inquireVersion = doInquireVersion
+		inquireStartTime = doInquireStartTime
+		openReplyQueue = doOpenReplyQueue
+		inquireHeldSubscriptions = doInquireHeldSubscriptions
+	}
+}
+
 func cleanTestMetrics() {
 	mqmetric.Metrics.Classes = make(map[int]*mqmetric.MonClass)
 }
 
+// getTestConfig returns a configuration with long timeouts, so that tests control the processing loop
+func getTestConfig() *metricsConfig {
+	return &metricsConfig{requestTimeout: time.Hour, reconnectDelay: time.Hour, reconnectMax: time.Hour, staleAfter: time.Hour}
+}
+
 func getTestLogger() *logger.Logger {
 	log, _ := logger.NewLogger(os.Stdout, false, false, "test")
 	return log
@@ -0,0 +1,97 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+const (
+	uptimeKey         = "QMGR/Info/Uptime"
+	uptimeName        = "uptime_seconds"
+	uptimeDescription = "Time since the queue manager started"
+	// startTimeLayout is the layout of the start date and time of the queue manager, in its local time
+	startTimeLayout = "2006-01-02 15.04.05"
+)
+
+// Function used to inquire the start time of the queue manager, which can be replaced in tests
+var inquireStartTime = doInquireStartTime
+
+// doInquireStartTime connects to the queue manager, and returns the time when it started
+func doInquireStartTime(qmName string, cfg *metricsConfig, connConfig *mqmetric.ConnectionConfig) (time.Time, error) {
+
+	conn, err := openPCFConnection(qmName, cfg, newConnectOptions(connConfig))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.close()
+
+	responses, err := conn.command(ibmmq.MQCMD_INQUIRE_Q_MGR_STATUS)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(responses) == 0 {
+		return time.Time{}, fmt.Errorf("No status returned for queue manager %s", qmName)
+	}
+	return parseStartTime(responses[0].getString(ibmmq.MQCACF_Q_MGR_START_DATE), responses[0].getString(ibmmq.MQCACF_Q_MGR_START_TIME))
+}
+
+// parseStartTime returns the start time of the queue manager, from the date and time in its status. They are in the
+// local time of the queue manager, which is assumed to be the same as that of the metrics exporter.
+func parseStartTime(date, startTime string) (time.Time, error) {
+	t, err := time.ParseInLocation(startTimeLayout, date+" "+startTime, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid queue manager start time '%s %s': %v", date, startTime, err)
+	}
+	return t, nil
+}
+
+// initialiseUptimeMetric adds the queue manager uptime metric to the metrics map, if it is selected
+func initialiseUptimeMetric(metrics map[string]*metricData, cfg *metricsConfig) {
+	if !cfg.isSelected(uptimeKey) {
+		return
+	}
+	metrics[uptimeKey] = &metricData{
+		name:        uptimeName,
+		description: uptimeDescription,
+		unit:        "seconds",
+	}
+}
+
+// updateUptimeMetric updates the value of the queue manager uptime metric from the start time of the queue manager,
+// which is inquired each time the exporter connects, so that it is the uptime of the queue manager and not the exporter.
+// It has no value if the start time is zero, as it is not known.
+func updateUptimeMetric(metrics map[string]*metricData, startTime time.Time) {
+	metric, ok := metrics[uptimeKey]
+	if !ok {
+		return
+	}
+	metric.values = make(map[string]float64)
+	metric.lastUpdate = time.Now()
+	if !startTime.IsZero() {
+		uptime := time.Since(startTime).Seconds()
+		if uptime < 0 {
+			uptime = 0
+		}
+		metric.values[qmgrLabelValue] = uptime
+	}
+}
@@ -0,0 +1,67 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStartTime(t *testing.T) {
+
+	actual, err := parseStartTime("2020-05-12", "10.15.30")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if expected := time.Date(2020, time.May, 12, 10, 15, 30, 0, time.Local); !actual.Equal(expected) {
+		t.Errorf("Expected start time=%v; actual %v", expected, actual)
+	}
+
+	_, err = parseStartTime("", "")
+	if err == nil {
+		t.Error("Expected error for empty start time")
+	}
+}
+
+func TestUptimeMetric(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseUptimeMetric(metrics, &metricsConfig{})
+	metric, ok := metrics[uptimeKey]
+	if !ok {
+		t.Fatal("Expected uptime metric to be initialised")
+	}
+	if getFullName(namespace, metric) != "ibmmq_qmgr_uptime_seconds" || metric.isDelta {
+		t.Errorf("Expected gauge named %s; actual %s", "ibmmq_qmgr_uptime_seconds", getFullName(namespace, metric))
+	}
+
+	// The uptime is not reported until the start time of the queue manager is known
+	updateUptimeMetric(metrics, time.Time{})
+	if len(metric.values) != 0 {
+		t.Errorf("Expected no value while the start time is unknown; actual %v", metric.values)
+	}
+
+	updateUptimeMetric(metrics, time.Now().Add(-time.Hour))
+	if actual := metric.values[qmgrLabelValue]; actual < 3600 || actual > 3660 {
+		t.Errorf("Expected uptime=%d; actual %f", 3600, actual)
+	}
+
+	metrics = make(map[string]*metricData)
+	initialiseUptimeMetric(metrics, &metricsConfig{exclude: []string{uptimeKey}})
+	if len(metrics) != 0 {
+		t.Errorf("Expected uptime metric to be excluded; actual %v", metrics)
+	}
+}
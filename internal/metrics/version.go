@@ -0,0 +1,218 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics contains code to provide metrics for the queue manager
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ibm-messaging/mq-golang/ibmmq"
+	"github.com/ibm-messaging/mq-golang/mqmetric"
+)
+
+const (
+	infoKey              = "QMGR/Info/Command level"
+	infoName             = "info"
+	infoDescription      = "Information about the queue manager and metrics exporter, with a value of 1"
+	commandLevelLabel    = "command_level"
+	mqVersionLabel       = "mq_version"
+	exporterVersionLabel = "exporter_version"
+	unknownCommandLevel  = 0
+)
+
+// Function used to inquire the command level and version of the queue manager, which can be replaced in tests
+var inquireVersion = doInquireVersion
+
+// exporterVersion is the version of the metrics exporter, which is set when the image is built
+var exporterVersion = "Not specified"
+
+// doInquireVersion connects to the queue manager, and returns its command level and version. Queue managers which
+// cannot report their version only return their command level, with an empty version.
+func doInquireVersion(qmName string, connConfig *mqmetric.ConnectionConfig) (int32, string, error) {
+
+	qMgr, err := ibmmq.Connx(qmName, newConnectOptions(connConfig))
+	if err != nil {
+		return unknownCommandLevel, "", err
+	}
+	// #nosec G104
+	defer qMgr.Disc()
+
+	mqod := ibmmq.NewMQOD()
+	mqod.ObjectType = ibmmq.MQOT_Q_MGR
+	object, err := qMgr.Open(mqod, ibmmq.MQOO_INQUIRE|ibmmq.MQOO_FAIL_IF_QUIESCING)
+	if err != nil {
+		return unknownCommandLevel, "", err
+	}
+	// #nosec G104
+	defer object.Close(0)
+
+	values, version, err := object.Inq([]int32{ibmmq.MQIA_COMMAND_LEVEL, ibmmq.MQCA_VERSION}, 1, int(ibmmq.MQ_VERSION_LENGTH))
+	if reasonCode(err) == ibmmq.MQRC_SELECTOR_ERROR {
+		values, _, err = object.Inq([]int32{ibmmq.MQIA_COMMAND_LEVEL}, 1, 0)
+		version = nil
+	}
+	if err != nil {
+		return unknownCommandLevel, "", err
+	}
+	return values[0], formatMQVersion(string(version)), nil
+}
+
+// formatMQVersion returns the version of the queue manager, given in the form VVRRMMFF, as dotted numbers
+// without leading zeros, such as 9.2.0.0, or an empty string if it is not in that form
+func formatMQVersion(version string) string {
+	if len(version) != int(ibmmq.MQ_VERSION_LENGTH) {
+		return ""
+	}
+	parts := make([]string, 0, 4)
+	for i := 0; i < len(version); i += 2 {
+		part, err := strconv.Atoi(version[i : i+2])
+		if err != nil {
+			return ""
+		}
+		parts = append(parts, strconv.Itoa(part))
+	}
+	return strings.Join(parts, ".")
+}
+
+// initialiseInfoMetric adds the queue manager information metric to the metrics map, if it is selected.
+// It has the command level and version of the queue manager, and the version of the metrics exporter, as labels,
+// so that changes in capability across upgrades, and unexpected downgrades, can be detected.
+func initialiseInfoMetric(metrics map[string]*metricData, cfg *metricsConfig) {
+	if !cfg.isSelected(infoKey) {
+		return
+	}
+	metrics[infoKey] = &metricData{
+		name:         infoName,
+		description:  infoDescription,
+		objectType:   true,
+		objectPrefix: qmgrPrefix,
+		objectLabels: []string{commandLevelLabel, mqVersionLabel, exporterVersionLabel},
+	}
+}
+
+// updateInfoMetric updates the value of the queue manager information metric from the command level and versions. It
+// has no value if the command level is unknownCommandLevel.
+func updateInfoMetric(metrics map[string]*metricData, commandLevel int32, mqVersion string) {
+	metric, ok := metrics[infoKey]
+	if !ok {
+		return
+	}
+	metric.values = make(map[string]float64)
+	metric.lastUpdate = time.Now()
+	if commandLevel != unknownCommandLevel {
+		label := strings.Join([]string{strconv.Itoa(int(commandLevel)), mqVersion, exporterVersion}, labelSeparator)
+		metric.values[label] = 1
+	}
+}
+
+// checkExpectedMetrics logs a warning for each expected metric which the queue manager does not publish at its
+// command level. Each missing metric is only reported once, rather than on every reconnect.
+func (c *Collector) checkExpectedMetrics() {
+
+	if len(c.cfg.expected) == 0 {
+		return
+	}
+	commandLevel := c.conn.commandLevel
+	if commandLevel == unknownCommandLevel {
+		c.log.Printf("Metrics Warning: Cannot check the expected metrics, as the command level of queue manager %s is not known", c.qmName)
+		return
+	}
+
+	var published []string
+	for _, metricClass := range mqmetric.Metrics.Classes {
+		for _, metricType := range metricClass.Types {
+			for _, metricElement := range metricType.Elements {
+				if mappingKey := makeMappingKey(metricElement); mappingKey != "" {
+					published = append(published, mappingKey)
+				}
+			}
+		}
+	}
+
+	for _, pattern := range c.cfg.expectedMetrics(commandLevel) {
+		if c.missing[pattern] || matchesAnyKey(pattern, published) {
+			continue
+		}
+		c.missing[pattern] = true
+		c.log.Printf("Metrics Warning: Expected metric %s is not published by queue manager %s at command level %d", pattern, c.qmName, commandLevel)
+	}
+}
+
+// matchesAnyKey returns true if the pattern matches any of the keys
+func matchesAnyKey(pattern string, keys []string) bool {
+	for _, key := range keys {
+		// #nosec G104 - patterns are validated when the configuration is loaded
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedMetrics returns the sorted key patterns of the metrics which are expected at the command level,
+// which are those expected at the command level or any earlier level
+func (cfg *metricsConfig) expectedMetrics(level int32) []string {
+	var patterns []string
+	for expectedLevel, levelPatterns := range cfg.expected {
+		if expectedLevel <= level {
+			patterns = append(patterns, levelPatterns...)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// readExpectedMetrics reads the metric key patterns expected at each command level from a JSON file,
+// in the format {"915": ["STATMQI/PUT/*"]}
+func readExpectedMetrics(name, file string) (map[int32][]string, error) {
+
+	if file == "" {
+		return nil, nil
+	}
+	// #nosec G304 - the file is given by the configuration
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", name, err)
+	}
+	var levels map[string][]string
+	err = json.Unmarshal(buf, &levels)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid JSON map of command levels to metric key patterns: %v", name, err)
+	}
+
+	expected := make(map[int32][]string)
+	for level, patterns := range levels {
+		value, err := strconv.ParseInt(level, 10, 32)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("%s contains an invalid command level: '%s'", name, level)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+				return nil, fmt.Errorf("%s contains an invalid metric key pattern: '%s'", name, pattern)
+			}
+		}
+		expected[int32(value)] = patterns
+	}
+	return expected, nil
+}
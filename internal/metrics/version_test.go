@@ -0,0 +1,139 @@
+/*
+© Copyright IBM Corporation 2020
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ibm-messaging/mq-container/pkg/logger"
+)
+
+func TestUpdateInfoMetric(t *testing.T) {
+
+	metrics := make(map[string]*metricData)
+	initialiseInfoMetric(metrics, &metricsConfig{})
+	metric, ok := metrics[infoKey]
+	if !ok {
+		t.Fatal("Expected information metric not found in map")
+	}
+	if name := getFullName(namespace, metric); name != "ibmmq_qmgr_info" {
+		t.Errorf("Expected name=%s; actual %s", "ibmmq_qmgr_info", name)
+	}
+
+	updateInfoMetric(metrics, unknownCommandLevel, "")
+	if len(metric.values) != 0 {
+		t.Errorf("Expected no values while the command level is unknown; actual %v", metric.values)
+	}
+
+	updateInfoMetric(metrics, testCommandLevel, testMQVersion)
+	label := "915|9.1.5.0|" + exporterVersion
+	if actual, ok := metric.values[label]; !ok || actual != 1 {
+		t.Errorf("Expected value=%d for label %s; actual %v", 1, label, metric.values)
+	}
+	expected := []string{"915", "9.1.5.0", exporterVersion, "QM1"}
+	if values, _ := getLabelValues(label, "QM1", len(metric.objectLabels)+1); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected label values=%v; actual %v", expected, values)
+	}
+}
+
+func TestFormatMQVersion(t *testing.T) {
+
+	versions := map[string]string{
+		"09020000": "9.2.0.0",
+		"09010500": "9.1.5.0",
+		"09000011": "9.0.0.11",
+		"0902":     "",
+		"0902000X": "",
+	}
+	for version, expected := range versions {
+		if actual := formatMQVersion(version); actual != expected {
+			t.Errorf("Expected version=%s for %s; actual %s", expected, version, actual)
+		}
+	}
+}
+
+func TestReadExpectedMetrics(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "expected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	files := map[string]bool{
+		`{"900": ["CPU/*/*"], "915": ["STATMQI/PUT/*", "DISK/Log/Log - write latency"]}`: true,
+		`{"9.1.5": ["CPU/*/*"]}`: false,
+		`{"900": ["CPU/[/*"]}`:   false,
+		`["CPU/*/*"]`:            false,
+	}
+	for content, valid := range files {
+		err = ioutil.WriteFile(file.Name(), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := metricsConfig{}
+		cfg.expected, err = readExpectedMetrics(expectedFileEnv, file.Name())
+		if valid && err != nil {
+			t.Errorf("Unexpected error %s for %s", err.Error(), content)
+		} else if !valid && err == nil {
+			t.Errorf("Expected error for %s", content)
+		}
+		if valid {
+			if expected := cfg.expectedMetrics(900); len(expected) != 1 {
+				t.Errorf("Expected 1 metric at command level 900; actual %v", expected)
+			}
+			if expected := cfg.expectedMetrics(920); len(expected) != 3 {
+				t.Errorf("Expected 3 metrics at command level 920; actual %v", expected)
+			}
+		}
+	}
+}
+
+func TestCheckExpectedMetrics(t *testing.T) {
+
+	teardownTestCase := setupTestCase(false)
+	defer teardownTestCase()
+
+	var buf bytes.Buffer
+	log, _ := logger.NewLogger(&buf, false, false, "test")
+	cfg := getTestConfig()
+	cfg.expected = map[int32][]string{
+		900:  {testClassName + "/*/*", "DISK/Log/*"},
+		1000: {"STATAPP/*/*"},
+	}
+	c := newCollector("qmName", cfg, log)
+	c.conn.commandLevel = testCommandLevel
+
+	c.checkExpectedMetrics()
+	if !strings.Contains(buf.String(), "Expected metric DISK/Log/* is not published") {
+		t.Errorf("Expected warning for missing metric; actual %s", buf.String())
+	}
+	if strings.Contains(buf.String(), testClassName+"/*/*") || strings.Contains(buf.String(), "STATAPP") {
+		t.Errorf("Expected warnings only for missing metrics at command level %d; actual %s", testCommandLevel, buf.String())
+	}
+
+	// Missing metrics are only reported once
+	buf.Reset()
+	c.checkExpectedMetrics()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no repeated warnings; actual %s", buf.String())
+	}
+}
@@ -36,7 +36,8 @@ const errorLevel string = "ERROR"
 
 // A Logger is used to log messages to stdout
 type Logger struct {
-	mutex       sync.Mutex
+	// mutex is shared with the loggers returned by WithDebug, as they use the same writer
+	mutex       *sync.Mutex
 	writer      io.Writer
 	debug       bool
 	json        bool
@@ -45,6 +46,8 @@ type Logger struct {
 	serverName  string
 	host        string
 	userName    string
+	// fields are added to each message logged in JSON format
+	fields map[string]interface{}
 }
 
 // NewLogger creates a new logger
@@ -62,7 +65,7 @@ func NewLogger(writer io.Writer, debug bool, json bool, serverName string) (*Log
 		userName = user.Username
 	}
 	return &Logger{
-		mutex:       sync.Mutex{},
+		mutex:       &sync.Mutex{},
 		writer:      writer,
 		debug:       debug,
 		json:        json,
@@ -74,6 +77,29 @@ func NewLogger(writer io.Writer, debug bool, json bool, serverName string) (*Log
 	}, nil
 }
 
+// WithDebug returns a logger which writes to the same writer, with debug logging enabled or disabled, so that
+// a component can log at a different level from the rest of the process
+func (l *Logger) WithDebug(debug bool) *Logger {
+	copied := *l
+	copied.debug = debug
+	return &copied
+}
+
+// WithFields returns a logger which writes to the same writer, and adds the given fields to each message logged in
+// JSON format, as well as the fields of this logger. Fields with the same names as the standard fields are ignored.
+// Messages logged as text are unchanged, so the fields must not be needed to understand them.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	copied := *l
+	copied.fields = make(map[string]interface{}, len(l.fields)+len(fields))
+	for name, value := range l.fields {
+		copied.fields[name] = value
+	}
+	for name, value := range fields {
+		copied.fields[name] = value
+	}
+	return &copied
+}
+
 func (l *Logger) format(entry map[string]interface{}) (string, error) {
 	if l.json {
 		b, err := json.Marshal(entry)
@@ -100,6 +126,11 @@ func (l *Logger) log(level string, msg string) {
 		"ibm_userName":    l.userName,
 		"type":            "mq_containerlog",
 	}
+	for name, value := range l.fields {
+		if _, ok := entry[name]; !ok {
+			entry[name] = value
+		}
+	}
 	s, err := l.format(entry)
 	l.mutex.Lock()
 	if err != nil {
@@ -53,3 +53,45 @@ func TestSimpleLogger(t *testing.T) {
 		t.Errorf("Expected log output to contain %v; got %v", s, buf.String())
 	}
 }
+
+func TestWithDebug(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := NewLogger(buf, false, false, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithDebug(true).Debug("Shown")
+	l.Debug("Hidden")
+	l.WithDebug(true).WithDebug(false).Debug("Also hidden")
+	if !strings.Contains(buf.String(), "Shown") || strings.Contains(buf.String(), "hidden") || strings.Contains(buf.String(), "Hidden") {
+		t.Errorf("Expected log output to contain only the debug message from the debug logger; got %v", buf.String())
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := NewLogger(buf, false, true, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithFields(map[string]interface{}{"component": "test", "loglevel": "WRONG"}).WithFields(map[string]interface{}{"event": "start"}).Print("Hello world")
+	var e map[string]interface{}
+	err = json.Unmarshal(buf.Bytes(), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["component"] != "test" || e["event"] != "start" || e["loglevel"] != "INFO" {
+		t.Errorf("Expected JSON to contain component=test, event=start, loglevel=INFO; got %v", buf.String())
+	}
+
+	// Messages logged as text do not include the fields
+	buf.Reset()
+	l, err = NewLogger(buf, false, false, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WithFields(map[string]interface{}{"component": "test"}).Print("Hello world")
+	if strings.Contains(buf.String(), "component") || !strings.Contains(buf.String(), "Hello world") {
+		t.Errorf("Expected log output to contain only the message; got %v", buf.String())
+	}
+}
@@ -72,7 +72,13 @@ func TestMetricNames(t *testing.T) {
 
 	// Now actually get the metrics (after waiting for some to become available)
 	metrics := getMetrics(t, port)
-	names := metricNames()
+	names := []string{}
+	for _, name := range metricNames() {
+		names = append(names, "ibmmq_qmgr_"+name)
+	}
+	for _, name := range exporterMetricNames() {
+		names = append(names, "ibmmq_exporter_"+name)
+	}
 	if len(metrics) != len(names) {
 		t.Errorf("Expected %d metrics to be returned, received %d", len(names), len(metrics))
 	}
@@ -81,7 +87,7 @@ func TestMetricNames(t *testing.T) {
 	for _, metric := range metrics {
 		ok := false
 		for _, name := range names {
-			if metric.Key == "ibmmq_qmgr_"+name {
+			if metric.Key == name {
 				ok = true
 				break
 			}
@@ -254,6 +254,24 @@ func metricNames() []string {
 		"failed_mqcb_total",
 		"commit_total",
 		"rollback_total",
+		// Queue manager status and publication age, reported by the metrics exporter
+		"status",
+		"publication_age_seconds",
+		// Queue manager information, with the command level as a label
+		"info",
 	}
 	return names
 }
+
+func exporterMetricNames() []string {
+	return []string{
+		"goroutines",
+		"reconnects_total",
+		"last_error_timestamp_seconds",
+		"collect_duration_seconds",
+		"process_publications_duration_seconds",
+		// A summary without quantiles, reported as a sum and count
+		"process_publications_seconds_sum",
+		"process_publications_seconds_count",
+	}
+}